@@ -1,7 +1,7 @@
 /*
 Healthcheck is a simple program that sends an HTTP request to the local host (self) to a configured port number.
 It's used in environment where you need a simple probe for health checks (e.g., an empty container in docker).
-The probe URL is http://localhost:3000/liveness . Only the port can be changed.
+The probe URL is http://localhost:3000/liveness (or /readiness, see -probe). Only the port can be changed.
 
 Usage:
 
@@ -12,35 +12,57 @@ The flags are:
 	-port <1-65535>
 		Change the port where the request is sent.
 
+	-probe <liveness|readiness>
+		The probe to check: liveness (default) checks that the server is up, readiness also checks that the database
+		and the media storage are usable and that the server is not shutting down.
+
+	-timeout <duration>
+		Maximum time to wait for the response (default 5s), e.g. 500ms or 2s.
+
 Return values (exit codes):
 
 	0
 		The request was successful (HTTP 200 or HTTP 204)
 
 	> 0
-		The request was not successful (connection error or unexpected HTTP status code)
+		The request was not successful (connection error, timeout or unexpected HTTP status code)
 */
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"time"
 )
 
 func main() {
 	var port = flag.Int("port", 3000, "HTTP port for healthcheck")
+	var probe = flag.String("probe", "liveness", "probe to check: liveness or readiness")
+	var timeout = flag.Duration("timeout", 5*time.Second, "maximum time to wait for the response")
 
 	flag.Parse()
 
-	res, err := http.Get(fmt.Sprintf("http://localhost:%d/liveness", *port))
+	if *probe != "liveness" && *probe != "readiness" {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown probe %q: use liveness or readiness\n", *probe)
+		os.Exit(2)
+	}
+
+	client := http.Client{Timeout: *timeout}
+	res, err := client.Get(fmt.Sprintf("http://localhost:%d/%s", *port, *probe))
 	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	} else if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		// Il corpo di /readiness riporta quali controlli sono falliti
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
 		_ = res.Body.Close()
 		_, _ = fmt.Fprintln(os.Stderr, "Healthcheck request not OK: ", res.Status)
+		if len(body) > 0 {
+			_, _ = fmt.Fprintln(os.Stderr, string(body))
+		}
 		os.Exit(1)
 	}
 	_ = res.Body.Close()
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/albyma98/WASAText/service/api"
+	"github.com/albyma98/WASAText/service/backup"
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// command è un comando di wasatext-admin
+type command struct {
+	name string
+	args string
+	help string
+	run  func(ctx context.Context, db database.Admin, args []string) error
+
+	// offline, se presente, sostituisce run per i comandi che lavorano sul file SQLite senza aprirlo come database
+	offline func(ctx context.Context, filename string, args []string) error
+}
+
+// commands sono i comandi disponibili. Sono assegnati in init perché parseArgs, usata dai comandi, li consulta per
+// stampare l'uso.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"users", "[prefix]", "list users, optionally by username prefix", listUsers, nil},
+		{"rename-user", "<user> <new username>", "change the username of a user", renameUser, nil},
+		{"delete-user", "-yes <user>", "delete a user with their messages and direct conversations", deleteUser, nil},
+		{"conversations", "[-user <user>]", "list all conversations, or those of a user", listConversations, nil},
+		{"members", "<conversation id>", "list the members of a conversation", listMembers, nil},
+		{"remove-member", "-yes <conversation id> <user>", "remove a member from a group", removeMember, nil},
+		{"purge-messages", "-yes -before <date>", "delete the messages sent before date", purgeMessages, nil},
+		{"stats", "", "print instance statistics", printStats, nil},
+		{"backup", "[-media <dir>] <dir>", "write a backup archive into dir", backupDatabase, nil},
+		{"restore", "[-media <dir>] -yes <archive>", "check and restore a backup archive", nil, restoreBackup},
+	}
+}
+
+// usernameRx sono i caratteri ammessi negli username, come nell'API
+var usernameRx = regexp.MustCompile(`^[a-zA-Z0-9_]{3,16}$`)
+
+// parseArgs legge i flag e gli argomenti di un comando, che deve avere esattamente nargs argomenti (o al più nargs, se
+// optional è true). In caso di errore stampa l'uso del comando e restituisce errUsage.
+func parseArgs(fs *flag.FlagSet, args []string, nargs int, optional bool) error {
+	fs.Usage = func() {
+		for _, c := range commands {
+			if c.name == fs.Name() {
+				_, _ = fmt.Fprintf(fs.Output(), "Usage: wasatext-admin %s %s\n", c.name, c.args)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > nargs || (!optional && fs.NArg() < nargs) {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// confirmed controlla che un comando distruttivo sia stato chiamato con -yes
+func confirmed(yes bool, what string) error {
+	if !yes {
+		return fmt.Errorf("this would %s: run again with -yes to confirm", what)
+	}
+	return nil
+}
+
+// findUser cerca un utente per UUID o, se non esiste, per username
+func findUser(ctx context.Context, db database.Admin, user string) (database.User, error) {
+	u, err := db.GetUserByUUID(ctx, user)
+	if errors.Is(err, database.ErrNotFound) {
+		u, err = db.GetUserByUsername(ctx, user)
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		return u, fmt.Errorf("user %q not found", user)
+	}
+	return u, err
+}
+
+// parseConversationID legge l'id di una conversazione e controlla che esista
+func parseConversationID(ctx context.Context, db database.Admin, s string) (database.Conversation, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return database.Conversation{}, fmt.Errorf("invalid conversation id %q", s)
+	}
+	conv, err := db.GetConversationByID(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return conv, fmt.Errorf("conversation %d not found", id)
+	}
+	return conv, err
+}
+
+// newTable restituisce un writer che allinea in colonne le righe separate da tabulazioni; va chiuso con Flush
+func newTable(header string) *tabwriter.Writer {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, header)
+	return tw
+}
+
+func listUsers(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("users", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 1, true); err != nil {
+		return err
+	}
+
+	var users []database.User
+	var err error
+	if fs.NArg() == 1 {
+		users, err = db.SearchUsersByPrefix(ctx, fs.Arg(0))
+	} else {
+		users, err = db.GetAllUsers(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	tw := newTable("UUID\tUSERNAME\tPHOTO")
+	for _, u := range users {
+		photo := ""
+		if u.PhotoUrl != nil {
+			photo = *u.PhotoUrl
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", u.UUID, u.Username, photo)
+	}
+	return tw.Flush()
+}
+
+func renameUser(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("rename-user", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 2, false); err != nil {
+		return err
+	}
+	newName := fs.Arg(1)
+	if !usernameRx.MatchString(newName) {
+		return fmt.Errorf("invalid username %q: use 3 to 16 letters, digits or underscores", newName)
+	}
+
+	u, err := findUser(ctx, db, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = db.SetUserName(ctx, u.UUID, newName)
+	if errors.Is(err, database.ErrConflict) {
+		return fmt.Errorf("username %q is already in use", newName)
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("renamed %s from %s to %s\n", u.UUID, u.Username, newName)
+	return nil
+}
+
+func deleteUser(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("delete-user", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm the deletion")
+	if err := parseArgs(fs, args, 1, false); err != nil {
+		return err
+	}
+
+	u, err := findUser(ctx, db, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := confirmed(*yes, fmt.Sprintf("delete user %s (%s)", u.Username, u.UUID)); err != nil {
+		return err
+	}
+	if err := db.DeleteUser(ctx, u.UUID); err != nil {
+		return err
+	}
+	fmt.Printf("deleted user %s (%s)\n", u.Username, u.UUID)
+	return nil
+}
+
+func listConversations(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("conversations", flag.ContinueOnError)
+	user := fs.String("user", "", "only the conversations of this user")
+	if err := parseArgs(fs, args, 0, false); err != nil {
+		return err
+	}
+
+	var conversations []database.Conversation
+	if *user != "" {
+		u, err := findUser(ctx, db, *user)
+		if err != nil {
+			return err
+		}
+		if conversations, err = db.GetConversationsByUser(ctx, u.UUID); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if conversations, err = db.GetAllConversations(ctx); err != nil {
+			return err
+		}
+	}
+
+	tw := newTable("ID\tTYPE\tNAME\tMEMBERS\tCREATED\tLAST MESSAGE")
+	for _, c := range conversations {
+		members, err := db.GetMembersByConversation(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		kind, name := "group", ""
+		if c.IsDirect {
+			kind = "direct"
+		} else if c.GroupName != nil {
+			name = *c.GroupName
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", c.ID, kind, name, len(members), c.TimestampCreated, c.TimestampLastMessage)
+	}
+	return tw.Flush()
+}
+
+func listMembers(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("members", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 1, false); err != nil {
+		return err
+	}
+	conv, err := parseConversationID(ctx, db, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	members, err := db.GetMembersByConversation(ctx, conv.ID)
+	if err != nil {
+		return err
+	}
+
+	tw := newTable("UUID\tUSERNAME\tADMIN\tJOINED")
+	for _, uuid := range members {
+		u, err := db.GetUserByUUID(ctx, uuid)
+		if err != nil {
+			return err
+		}
+		admin, err := db.IsAdmin(ctx, uuid, conv.ID)
+		if err != nil {
+			return err
+		}
+		joined, err := db.GetJoinedAt(ctx, uuid, conv.ID)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%t\t%s\n", u.UUID, u.Username, admin, joined)
+	}
+	return tw.Flush()
+}
+
+func removeMember(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("remove-member", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm the removal")
+	if err := parseArgs(fs, args, 2, false); err != nil {
+		return err
+	}
+	conv, err := parseConversationID(ctx, db, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if conv.IsDirect {
+		return fmt.Errorf("conversation %d is a direct conversation: delete one of the users instead", conv.ID)
+	}
+	u, err := findUser(ctx, db, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err := confirmed(*yes, fmt.Sprintf("remove %s from conversation %d", u.Username, conv.ID)); err != nil {
+		return err
+	}
+
+	err = db.ForceRemoveMember(ctx, u.UUID, conv.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("%s is not a member of conversation %d", u.Username, conv.ID)
+	} else if err != nil {
+		return err
+	}
+	// Come quando esce l'ultimo membro dall'API, il gruppo rimasto vuoto viene eliminato
+	if err := db.DeleteConversationIfEmpty(ctx, conv.ID); err != nil {
+		return err
+	}
+	fmt.Printf("removed %s from conversation %d\n", u.Username, conv.ID)
+	return nil
+}
+
+func purgeMessages(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("purge-messages", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "confirm the deletion")
+	beforeFlag := fs.String("before", "", "delete the messages sent before this date (YYYY-MM-DD or RFC 3339)")
+	if err := parseArgs(fs, args, 0, false); err != nil {
+		return err
+	}
+	if *beforeFlag == "" {
+		fs.Usage()
+		return errUsage
+	}
+	before, err := time.ParseInLocation("2006-01-02", *beforeFlag, time.Local)
+	if err != nil {
+		if before, err = time.Parse(time.RFC3339, *beforeFlag); err != nil {
+			return fmt.Errorf("invalid date %q: use YYYY-MM-DD or RFC 3339", *beforeFlag)
+		}
+	}
+	if err := confirmed(*yes, "delete all messages sent before "+before.Format(time.RFC3339)); err != nil {
+		return err
+	}
+
+	n, err := db.PurgeMessagesBefore(ctx, before)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted %d messages\n", n)
+	return nil
+}
+
+func printStats(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 0, false); err != nil {
+		return err
+	}
+	s, err := db.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	current, latest, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "users\t%d\n", s.Users)
+	_, _ = fmt.Fprintf(tw, "direct conversations\t%d\n", s.DirectConversations)
+	_, _ = fmt.Fprintf(tw, "groups\t%d\n", s.Groups)
+	_, _ = fmt.Fprintf(tw, "messages\t%d\n", s.Messages)
+	_, _ = fmt.Fprintf(tw, "reactions\t%d\n", s.Reactions)
+	_, _ = fmt.Fprintf(tw, "pending join requests\t%d\n", s.PendingJoinRequests)
+	_, _ = fmt.Fprintf(tw, "first message\t%s\n", s.FirstMessage)
+	_, _ = fmt.Fprintf(tw, "last message\t%s\n", s.LastMessage)
+	_, _ = fmt.Fprintf(tw, "schema version\t%d (latest %d)\n", current, latest)
+	return tw.Flush()
+}
+
+func backupDatabase(ctx context.Context, db database.Admin, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	media := fs.String("media", api.MediaDir, "directory of the uploaded photos")
+	if err := parseArgs(fs, args, 1, false); err != nil {
+		return err
+	}
+
+	path, m, err := backup.CreateFile(ctx, db, *media, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("written %s (%d files, schema version %d)\n", path, len(m.Files), m.SchemaVersion)
+	return nil
+}
+
+func restoreBackup(ctx context.Context, filename string, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	media := fs.String("media", api.MediaDir, "directory of the uploaded photos")
+	yes := fs.Bool("yes", false, "confirm the restore")
+	if err := parseArgs(fs, args, 1, false); err != nil {
+		return err
+	}
+
+	if !*yes {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		m, err := backup.Verify(f, "")
+		if err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+		fmt.Printf("%s is valid: created %s, %d files, schema version %d\n", fs.Arg(0),
+			m.CreatedAt.Local().Format(time.RFC3339), len(m.Files), m.SchemaVersion)
+		return confirmed(false, fmt.Sprintf("replace %s and copy the photos into %s", filename, *media))
+	}
+
+	m, err := backup.Restore(ctx, fs.Arg(0), filename, *media)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %s (created %s, %d files) into %s\n", fs.Arg(0), m.CreatedAt.Local().Format(time.RFC3339),
+		len(m.Files), filename)
+	return nil
+}
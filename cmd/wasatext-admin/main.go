@@ -0,0 +1,150 @@
+/*
+Wasatext-admin is a command line tool for operating a WASAText instance. It works directly on the database used by
+`webapi`, applying the schema migrations if needed. With SQLite it's better to stop the server first: only one of the
+two programs can write to the database file at a time.
+
+Usage:
+
+	wasatext-admin [flags] <command> [command flags] [arguments]
+
+The flags are:
+
+	-driver <sqlite3|postgres>
+		The database driver (default $CFG_DB_DRIVER, or sqlite3).
+
+	-db <path>
+		The SQLite database file (default $CFG_DB_FILENAME, or service/db/wasatext.db).
+
+	-dsn <dsn>
+		The PostgreSQL data source name (default $CFG_DB_DSN).
+
+The commands are:
+
+	users [prefix]
+		List all users, or those whose username starts with prefix.
+
+	rename-user <user> <new username>
+		Change the username of a user.
+
+	delete-user -yes <user>
+		Delete a user with their messages, reactions and direct conversations.
+
+	conversations [-user <user>]
+		List all conversations, or those of a user.
+
+	members <conversation id>
+		List the members of a conversation.
+
+	remove-member -yes <conversation id> <user>
+		Remove a member from a group, as if kicked by an administrator.
+
+	purge-messages -yes -before <date>
+		Delete the messages sent before date (YYYY-MM-DD, local time, or RFC 3339).
+
+	stats
+		Print instance statistics.
+
+	backup [-media <dir>] <dir>
+		Write a backup archive of the database and of the uploaded photos into dir. SQLite only; it can run while the
+		server is serving requests.
+
+	restore [-media <dir>] -yes <archive>
+		Check a backup archive and restore it, replacing the database file and copying the photos. SQLite only; stop
+		the server first. Without -yes the archive is only checked.
+
+Users can be given either by UUID or by username. Destructive commands do nothing without -yes.
+
+Return values (exit codes):
+
+	0
+		The command ended successfully
+
+	1
+		The command failed
+
+	2
+		Invalid flags, command or arguments
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// errUsage indica che il comando è stato chiamato con argomenti non validi; l'uso corretto è già stato stampato
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:]); errors.Is(err, errUsage) {
+		os.Exit(2)
+	} else if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("wasatext-admin", flag.ContinueOnError)
+	driver := fs.String("driver", envOr("CFG_DB_DRIVER", "sqlite3"), "database driver: sqlite3 or postgres")
+	filename := fs.String("db", envOr("CFG_DB_FILENAME", "service/db/wasatext.db"), "SQLite database file")
+	dsn := fs.String("dsn", os.Getenv("CFG_DB_DSN"), "PostgreSQL data source name")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "Usage: wasatext-admin [flags] <command> [command flags] [arguments]")
+		_, _ = fmt.Fprintln(fs.Output(), "\nCommands:")
+		for _, c := range commands {
+			_, _ = fmt.Fprintf(fs.Output(), "  %-16s %-34s %s\n", c.name, c.args, c.help)
+		}
+		_, _ = fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		_, _ = fmt.Fprintf(fs.Output(), "unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+
+	if cmd.offline != nil {
+		if *driver != "sqlite3" {
+			return fmt.Errorf("%s is supported only with the sqlite3 driver", cmd.name)
+		}
+		return cmd.offline(context.Background(), *filename, fs.Args()[1:])
+	}
+
+	db, closeDB, err := openDatabase(*driver, *filename, *dsn)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = closeDB() }()
+
+	return cmd.run(context.Background(), db, fs.Args()[1:])
+}
+
+// envOr restituisce la variabile d'ambiente name, o def se non è impostata. Le variabili sono quelle di webapi, così
+// nello stesso ambiente i due programmi usano lo stesso database.
+func envOr(name string, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
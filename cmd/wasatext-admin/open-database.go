@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/albyma98/WASAText/service/database"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openDatabase apre il database di webapi, applicando le migrazioni dello schema come all'avvio del server, e ne
+// restituisce le operazioni di amministrazione. La funzione restituita chiude la connessione e va chiamata dal
+// chiamante.
+func openDatabase(driver string, filename string, dsn string) (database.Admin, func() error, error) {
+	var source string
+	var newAppDatabase func(*sql.DB) (database.AppDatabase, error)
+	switch driver {
+	case "sqlite3":
+		// _foreign_keys attiva le FOREIGN KEY su tutte le connessioni del pool: le eliminazioni si affidano a
+		// ON DELETE CASCADE. Con mode=rw il file deve esistere, così un percorso sbagliato non crea un database vuoto.
+		source = "file:" + filename + "?mode=rw&_foreign_keys=on"
+		newAppDatabase = database.New
+	case "postgres":
+		if dsn == "" {
+			return nil, nil, fmt.Errorf("-dsn is required with the postgres driver")
+		}
+		source = dsn
+		newAppDatabase = database.NewPostgres
+	default:
+		return nil, nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+
+	dbconn, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening %s: %w", driver, err)
+	}
+	db, err := newAppDatabase(dbconn)
+	if err != nil {
+		_ = dbconn.Close()
+		return nil, nil, fmt.Errorf("creating AppDatabase: %w", err)
+	}
+	admin, ok := db.(database.Admin)
+	if !ok {
+		_ = dbconn.Close()
+		return nil, nil, fmt.Errorf("the %s database does not support administration", driver)
+	}
+	return admin, dbconn.Close, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/albyma98/WASAText/service/api"
+	"github.com/albyma98/WASAText/service/backup"
+	"github.com/albyma98/WASAText/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// startBackups avvia i backup periodici configurati nella sezione Backup, se Backup.Dir non è vuota. La funzione
+// restituita li ferma, attendendo la fine di quello in corso, e va chiamata prima di chiudere il database.
+func startBackups(cfg WebAPIConfiguration, db database.AppDatabase, logger logrus.FieldLogger) (func(), error) {
+	if cfg.Backup.Dir == "" {
+		return func() {}, nil
+	}
+	admin, ok := db.(database.Admin)
+	if !ok || cfg.DB.Driver != "sqlite3" {
+		return nil, fmt.Errorf("backups are supported only with the sqlite3 driver, not %q", cfg.DB.Driver)
+	}
+	if cfg.Backup.Interval <= 0 {
+		return nil, fmt.Errorf("invalid Backup.Interval %s", cfg.Backup.Interval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := backup.Schedule(ctx, admin, backup.Options{
+		Dir:      cfg.Backup.Dir,
+		Interval: cfg.Backup.Interval,
+		Keep:     cfg.Backup.Keep,
+		MediaDir: api.MediaDir,
+	}, logger)
+	logger.Infof("backups every %s in %s", cfg.Backup.Interval, cfg.Backup.Dir)
+
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
@@ -14,8 +14,19 @@ func applyCORSHandler(h http.Handler) http.Handler {
 		handlers.AllowedHeaders([]string{
 			"Content-Type",
 			"Authorization",
+			"X-Request-ID",
+			"traceparent",
 		}),
 		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT"}),
+		// Gli header di deprecazione dei percorsi senza prefisso di versione, l'ID della richiesta e lo span che l'ha
+		// servita devono essere leggibili dalla web UI
+		handlers.ExposedHeaders([]string{
+			"Deprecation",
+			"Sunset",
+			"Link",
+			"X-Request-ID",
+			"traceresponse",
+		}),
 		// Do not modify the CORS origin and max age, they are used in the evaluation.
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.MaxAge(1),
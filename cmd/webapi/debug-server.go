@@ -0,0 +1,48 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// startTime è il momento di avvio del processo, per la variabile uptime_seconds
+var startTime = time.Now()
+
+func init() {
+	// Statistiche del runtime, oltre a memstats e cmdline già pubblicate da expvar
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("uptime_seconds", expvar.Func(func() interface{} {
+		return int64(time.Since(startTime).Seconds())
+	}))
+}
+
+// newDebugServer crea il server di debug, in ascolto su Web.DebugHost: espone le variabili di expvar su /debug/vars
+// (contatori delle richieste e delle query, statistiche del runtime), le metriche dell'API nel formato di Prometheus su
+// /metrics e il profiler su /debug/pprof/. Non va esposto all'esterno: il profiler rivela dettagli interni e può
+// rallentare il processo.
+func newDebugServer(cfg WebAPIConfiguration, metrics http.Handler) *http.Server {
+	// Un mux dedicato, invece di http.DefaultServeMux su cui net/http/pprof si registra da solo, così il profiler non
+	// finisce per errore sul server dell'API
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// Niente WriteTimeout: /debug/pprof/profile e /debug/pprof/trace rispondono dopo il tempo di campionamento
+	// richiesto (30 secondi di default)
+	return &http.Server{
+		Addr:              cfg.Web.DebugHost,
+		Handler:           mux,
+		ReadTimeout:       cfg.Web.ReadTimeout,
+		ReadHeaderTimeout: cfg.Web.ReadTimeout,
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/albyma98/WASAText/service/api"
 	"github.com/ardanlabs/conf"
 	"gopkg.in/yaml.v2"
 )
@@ -18,15 +19,99 @@ type WebAPIConfiguration struct {
 		Path string `conf:"default:/conf/config.yml"`
 	}
 	Web struct {
-		APIHost         string        `conf:"default:0.0.0.0:3000"`
-		DebugHost       string        `conf:"default:0.0.0.0:4000"`
+		APIHost string `conf:"default:0.0.0.0:3000"`
+
+		// DebugHost è l'indirizzo del server di debug (expvar e pprof, vedi newDebugServer). Vuoto per non avviarlo.
+		// Di default ascolta solo su localhost: il profiler non va raggiunto dall'esterno.
+		DebugHost string `conf:"default:127.0.0.1:4000"`
+
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
+		RequestTimeout  time.Duration `conf:"default:4s"`
+
+		// ValidateAPI confronta richieste e risposte con doc/api.yaml (vedi api.Config.ValidateAPI)
+		ValidateAPI bool `conf:"default:false"`
+
+		// LegacySunset è la data (AAAA-MM-GG) da cui i percorsi senza prefisso /v1 non saranno più serviti, vedi
+		// api.Config.LegacySunset. Vuota per non annunciarla.
+		LegacySunset string `conf:"default:2027-04-30"`
+
+		// BehindProxy indica che il server è dietro un reverse proxy: l'IP del client, usato nel log e nei limiti di
+		// richieste, viene letto da X-Forwarded-For e l'ID della richiesta da X-Request-ID (vedi api.Config.BehindProxy)
+		BehindProxy bool `conf:"default:false"`
 	}
+
+	// Debug equivale a Log.Level "debug", ed è mantenuto per le configurazioni esistenti
 	Debug bool
-	DB    struct {
+
+	Log struct {
+		// Level è il livello minimo dei messaggi: "debug", "info", "warn" o "error"
+		Level string `conf:"default:info"`
+
+		// JSON scrive ogni messaggio come oggetto JSON invece che in testo
+		JSON bool `conf:"default:false"`
+
+		// Destination è dove viene scritto il log: "stdout", "stderr" o "file" (il file in File)
+		Destination string `conf:"default:stdout"`
+		File        string `conf:"default:/tmp/wasatext.log"`
+
+		// CombinedToStdout, con Destination "file", scrive il log anche su stdout
+		CombinedToStdout bool `conf:"default:false"`
+
+		// MaxSizeMB è la dimensione oltre la quale il file di log viene ruotato; ne vengono tenuti MaxBackups precedenti
+		// (File.1 il più recente). Zero disattiva la rotazione.
+		MaxSizeMB  int `conf:"default:100"`
+		MaxBackups int `conf:"default:5"`
+
+		// MethodName aggiunge a ogni messaggio la funzione e il file che lo hanno scritto
+		MethodName bool `conf:"default:false"`
+	}
+	// RateLimit sono i budget di richieste per client (utente e IP) dei gruppi di route limitati, nel formato
+	// "N/durata" (N richieste subito, poi altre N in ogni durata), vedi api.RateLimits. Vuoto o "0" per non limitare.
+	RateLimit struct {
+		Session  string `conf:"default:10/1m"`
+		Messages string `conf:"default:60/1m"`
+		Uploads  string `conf:"default:10/1m"`
+		Search   string `conf:"default:60/1m"`
+
+		// IPFactor è quante volte il budget per IP delle route autenticate supera quello per utente, vedi
+		// api.RateLimits.IPFactor
+		IPFactor int `conf:"default:10"`
+	}
+	// Tracing configura l'esportazione degli span delle richieste e delle chiamate al database
+	Tracing struct {
+		// Exporter è "none" (tracing disattivato), "stdout" (uno span JSON per riga) o "otlp" (OTLP su HTTP verso
+		// Endpoint, ad esempio un OpenTelemetry Collector)
+		Exporter    string        `conf:"default:none"`
+		Endpoint    string        `conf:"default:http://localhost:4318/v1/traces"`
+		ServiceName string        `conf:"default:wasatext"`
+		Timeout     time.Duration `conf:"default:5s"`
+	}
+	DB struct {
+		// Driver è il database da usare: "sqlite3" (file locale in Filename), "postgres" (server indicato da DSN) o
+		// "memory" (in memoria, vuoto a ogni avvio)
+		Driver   string `conf:"default:sqlite3"`
 		Filename string `conf:"default:service/db/wasatext.db"`
+		DSN      string `conf:"mask"`
+	}
+	// Backup configura i backup periodici del database e delle foto (vedi service/backup). Sono disponibili solo con il
+	// driver sqlite3; con PostgreSQL si usa pg_dump.
+	Backup struct {
+		// Dir è la cartella in cui vengono salvati gli archivi. Vuota per non fare backup periodici.
+		Dir      string        `conf:""`
+		Interval time.Duration `conf:"default:24h"`
+
+		// Keep è il numero di archivi conservati: dopo ogni backup i più vecchi vengono eliminati. Zero li conserva tutti.
+		Keep int `conf:"default:7"`
+	}
+	// Export configura le esportazioni dei dati richieste dagli utenti (vedi api.Config.ExportDir)
+	Export struct {
+		// Dir è la cartella in cui vengono preparati gli archivi. Vuota per usare la cartella temporanea di sistema.
+		Dir string `conf:""`
+
+		// TTL è il tempo per cui un archivio resta scaricabile prima di essere eliminato
+		TTL time.Duration `conf:"default:24h"`
 	}
 }
 
@@ -69,3 +154,25 @@ func loadConfiguration() (WebAPIConfiguration, error) {
 
 	return cfg, nil
 }
+
+// parseRateLimits converte i budget della sezione RateLimit
+func parseRateLimits(cfg WebAPIConfiguration) (api.RateLimits, error) {
+	limits := api.RateLimits{IPFactor: cfg.RateLimit.IPFactor}
+	for _, l := range []struct {
+		name  string
+		value string
+		dst   *api.RateLimit
+	}{
+		{"RateLimit.Session", cfg.RateLimit.Session, &limits.Session},
+		{"RateLimit.Messages", cfg.RateLimit.Messages, &limits.Messages},
+		{"RateLimit.Uploads", cfg.RateLimit.Uploads, &limits.Uploads},
+		{"RateLimit.Search", cfg.RateLimit.Search, &limits.Search},
+	} {
+		limit, err := api.ParseRateLimit(l.value)
+		if err != nil {
+			return limits, fmt.Errorf("parsing %s: %w", l.name, err)
+		}
+		*l.dst = limit
+	}
+	return limits, nil
+}
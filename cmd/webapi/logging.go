@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newLogger crea il logger configurato nella sezione Log. La funzione restituita chiude il file di log, se usato, e va
+// chiamata dal chiamante.
+func newLogger(cfg WebAPIConfiguration) (*logrus.Logger, func() error, error) {
+	logger := logrus.New()
+
+	level, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing Log.Level: %w", err)
+	}
+	if cfg.Debug {
+		level = logrus.DebugLevel
+	}
+	logger.SetLevel(level)
+
+	if cfg.Log.JSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	logger.SetReportCaller(cfg.Log.MethodName)
+
+	closeLog := func() error { return nil }
+	switch cfg.Log.Destination {
+	case "stdout":
+		logger.SetOutput(os.Stdout)
+	case "stderr":
+		logger.SetOutput(os.Stderr)
+	case "file":
+		f, err := openRotatingFile(cfg.Log.File, int64(cfg.Log.MaxSizeMB)<<20, cfg.Log.MaxBackups)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening the log file: %w", err)
+		}
+		closeLog = f.Close
+		if cfg.Log.CombinedToStdout {
+			logger.SetOutput(io.MultiWriter(f, os.Stdout))
+		} else {
+			logger.SetOutput(f)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown log destination %q", cfg.Log.Destination)
+	}
+
+	return logger, closeLog, nil
+}
+
+// rotatingFile è un file di log che, superata la dimensione massima, viene rinominato in path.1 (spostando i
+// precedenti in path.2, path.3, ...) e sostituito da un file vuoto. Oltre maxBackups i file più vecchi vengono
+// eliminati.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openRotatingFile apre in append il file di log in path. Con maxSize zero il file non viene mai ruotato.
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+// Write scrive p nel file, ruotandolo prima se p gli farebbe superare la dimensione massima
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate sposta il file corrente tra i backup e ne apre uno nuovo
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+
+	if rf.maxBackups > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+		for i := rf.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// Close chiude il file
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
@@ -2,7 +2,8 @@
 Webapi is the executable for the main web server.
 It builds a web server around APIs from `service/api`.
 Webapi connects to external resources needed (database) and starts two web servers: the API web server, and the debug.
-Everything is served via the API web server, except debug variables (/debug/vars) and profiler infos (pprof).
+Everything is served via the API web server, except debug variables (/debug/vars), Prometheus metrics (/metrics) and
+profiler infos (pprof).
 
 Usage:
 
@@ -25,7 +26,6 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -33,13 +33,11 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/albyma98/WASAText/service/api"
-	"github.com/albyma98/WASAText/service/database"
 	"github.com/albyma98/WASAText/service/globaltime"
 	"github.com/ardanlabs/conf"
-	_ "github.com/mattn/go-sqlite3"
-	"github.com/sirupsen/logrus"
 )
 
 // main is the program entry point. The only purpose of this function is to call run() and set the exit code if there is
@@ -56,9 +54,9 @@ func main() {
 // * creates and configure the logger
 // * connects to any external resources (like databases, authenticators, etc.)
 // * creates an instance of the service/api package
-// * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers)
+// * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers) and the debug server
 // * waits for any termination event: SIGTERM signal (UNIX), non-recoverable server error, etc.
-// * closes the principal web server
+// * closes the principal web server and the debug server
 func run() error {
 	rand.Seed(globaltime.Now().UnixNano())
 	// Load Configuration and defaults
@@ -71,32 +69,34 @@ func run() error {
 	}
 
 	// Init logging
-	logger := logrus.New()
-	logger.SetOutput(os.Stdout)
-	if cfg.Debug {
-		logger.SetLevel(logrus.DebugLevel)
-	} else {
-		logger.SetLevel(logrus.InfoLevel)
+	logger, closeLog, err := newLogger(cfg)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		_ = closeLog()
+	}()
 
 	logger.Infof("application initializing")
 
 	// Start Database
-	logger.Println("initializing database support")
-	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
+	logger.Printf("initializing database support (%s)", cfg.DB.Driver)
+	db, closeDB, err := openDatabase(cfg)
 	if err != nil {
-		logger.WithError(err).Error("error opening SQLite DB")
-		return fmt.Errorf("opening SQLite: %w", err)
+		logger.WithError(err).Error("error opening the database")
+		return err
 	}
 	defer func() {
 		logger.Debug("database stopping")
-		_ = dbconn.Close()
+		_ = closeDB()
 	}()
-	db, err := database.New(dbconn)
+
+	stopBackups, err := startBackups(cfg, db, logger)
 	if err != nil {
-		logger.WithError(err).Error("error creating AppDatabase")
-		return fmt.Errorf("creating AppDatabase: %w", err)
+		logger.WithError(err).Error("error starting the backups")
+		return err
 	}
+	defer stopBackups()
 
 	// Start (main) API server
 	logger.Info("initializing API server")
@@ -110,10 +110,43 @@ func run() error {
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
 
+	var legacySunset time.Time
+	if cfg.Web.LegacySunset != "" {
+		legacySunset, err = time.Parse("2006-01-02", cfg.Web.LegacySunset)
+		if err != nil {
+			return fmt.Errorf("parsing Web.LegacySunset: %w", err)
+		}
+	}
+
+	rateLimits, err := parseRateLimits(cfg)
+	if err != nil {
+		return err
+	}
+
+	tracer, err := newTracer(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Tracing.Timeout)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			logger.WithError(err).Warning("error flushing the pending trace spans")
+		}
+	}()
+
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:         logger,
+		Database:       db,
+		RequestTimeout: cfg.Web.RequestTimeout,
+		ValidateAPI:    cfg.Web.ValidateAPI,
+		LegacySunset:   legacySunset,
+		BehindProxy:    cfg.Web.BehindProxy,
+		RateLimits:     rateLimits,
+		Tracer:         tracer,
+		ExportDir:      cfg.Export.Dir,
+		ExportTTL:      cfg.Export.TTL,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -130,7 +163,7 @@ func run() error {
 	// Apply CORS policy
 	router = applyCORSHandler(router)
 
-	staticHandler := http.StripPrefix("/webui/public/", http.FileServer(http.Dir("./webui/public")))
+	staticHandler := http.StripPrefix("/webui/public/", http.FileServer(http.Dir(api.MediaDir)))
 	// Usa ServeMux per gestire API e statici insieme
 	mux := http.NewServeMux()
 	mux.Handle("/webui/public/", staticHandler) // Immagini accessibili da /webui/public/*.png
@@ -150,6 +183,17 @@ func run() error {
 		logger.Infof("stopping API server")
 	}()
 
+	// Il server di debug (expvar, metriche e pprof) ha un proprio listener; con Web.DebugHost vuoto non viene avviato
+	var debugserver *http.Server
+	if cfg.Web.DebugHost != "" {
+		debugserver = newDebugServer(cfg, apirouter.MetricsHandler())
+		go func() {
+			logger.Infof("debug server listening on %s", debugserver.Addr)
+			serverErrors <- debugserver.ListenAndServe()
+			logger.Infof("stopping debug server")
+		}()
+	}
+
 	// Waiting for shutdown signal or POSIX signals
 	select {
 	case err := <-serverErrors:
@@ -176,6 +220,15 @@ func run() error {
 			err = apiserver.Close()
 		}
 
+		// Il server di debug si ferma con lo stesso tempo massimo. Un suo errore non rende fallito lo spegnimento: le
+		// richieste dell'API sono già state completate.
+		if debugserver != nil {
+			if dErr := debugserver.Shutdown(ctx); dErr != nil {
+				logger.WithError(dErr).Warning("error during graceful shutdown of debug server")
+				_ = debugserver.Close()
+			}
+		}
+
 		// Log the status of this shutdown.
 
 		if err != nil {
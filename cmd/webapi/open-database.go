@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/albyma98/WASAText/service/database"
+	"github.com/albyma98/WASAText/service/database/memdb"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openDatabase apre la connessione al database scelto con DB.Driver e crea l'AppDatabase corrispondente, applicando le
+// migrazioni dello schema. La funzione restituita chiude la connessione e va chiamata dal chiamante.
+func openDatabase(cfg WebAPIConfiguration) (database.AppDatabase, func() error, error) {
+	var source string
+	var newAppDatabase func(*sql.DB) (database.AppDatabase, error)
+	switch cfg.DB.Driver {
+	case "memory":
+		// Database in memoria, vuoto a ogni avvio: utile per demo e prove
+		return memdb.New(), func() error { return nil }, nil
+	case "sqlite3":
+		// _foreign_keys attiva le FOREIGN KEY su tutte le connessioni del pool: le eliminazioni si affidano a
+		// ON DELETE CASCADE e SET NULL
+		source = "file:" + cfg.DB.Filename + "?_foreign_keys=on"
+		newAppDatabase = database.New
+	case "postgres":
+		if cfg.DB.DSN == "" {
+			return nil, nil, fmt.Errorf("DB.DSN is required with the postgres driver")
+		}
+		source = cfg.DB.DSN
+		newAppDatabase = database.NewPostgres
+	default:
+		return nil, nil, fmt.Errorf("unknown database driver %q", cfg.DB.Driver)
+	}
+
+	dbconn, err := sql.Open(cfg.DB.Driver, source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening %s: %w", cfg.DB.Driver, err)
+	}
+	db, err := newAppDatabase(dbconn)
+	if err != nil {
+		_ = dbconn.Close()
+		return nil, nil, fmt.Errorf("creating AppDatabase: %w", err)
+	}
+	return db, dbconn.Close, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/albyma98/WASAText/service/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// newTracer crea il tracer configurato nella sezione Tracing, oppure nil se il tracing è disattivato. Il tracer va
+// fermato con Shutdown, che invia gli span rimasti.
+func newTracer(cfg WebAPIConfiguration, logger logrus.FieldLogger) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.Tracing.Exporter {
+	case "none", "":
+		return nil, nil
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Tracing.Exporter)
+	}
+
+	tracer := tracing.New(cfg.Tracing.ServiceName, exporter)
+	tracer.OnError = func(err error) {
+		logger.WithError(err).Warning("tracing export failed")
+	}
+	return tracer, nil
+}
@@ -0,0 +1,9 @@
+// Package doc contains the OpenAPI specification of the API, for embedding
+package doc
+
+import _ "embed"
+
+// APISpec è la specifica OpenAPI dell'API (api.yaml)
+//
+//go:embed api.yaml
+var APISpec []byte
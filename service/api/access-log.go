@@ -0,0 +1,80 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/albyma98/WASAText/service/api/reqcontext"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// requestIDHeader è l'header con l'ID della richiesta: viene sempre inviato nella risposta e, dietro un reverse proxy,
+// ne viene riusato il valore aggiunto dal proxy alla richiesta
+const requestIDHeader = "X-Request-ID"
+
+// redactedHeaders sono gli header il cui valore non viene mai scritto nel log: contengono credenziali (il token Bearer
+// è l'UUID dell'utente, che basta per agire al suo posto)
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// userLogID restituisce l'identificativo con cui l'utente compare nel log: l'UUID è anche il token di accesso, quindi
+// viene scritto solo un prefisso del suo hash SHA-256, sufficiente a correlare le richieste dello stesso utente
+func userLogID(uuidUser string) string {
+	sum := sha256.Sum256([]byte(uuidUser))
+	return hex.EncodeToString(sum[:8])
+}
+
+// requestID restituisce l'ID della richiesta: dietro un reverse proxy (Config.BehindProxy) quello ricevuto nell'header
+// X-Request-ID se è un UUID valido, altrimenti uno nuovo. Senza proxy l'header è scritto dal client e viene ignorato,
+// come X-Forwarded-For in clientIP; gli ID non validi sono scartati per non scrivere nel log valori arbitrari.
+func (rt *_router) requestID(r *http.Request) (uuid.UUID, error) {
+	if upstream := r.Header.Get(requestIDHeader); rt.behindProxy && upstream != "" {
+		if id, err := uuid.FromString(upstream); err == nil && id != uuid.Nil {
+			return id, nil
+		}
+	}
+	return uuid.NewV4()
+}
+
+// redactHeaders restituisce una copia di h in cui il valore degli header in redactedHeaders è nascosto
+func redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for name := range out {
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			out[name] = []string{"[REDACTED]"}
+		}
+	}
+	return out
+}
+
+// logAccess scrive la riga dell'access log per una richiesta servita da wrap, iniziata in start. Gli header della
+// richiesta, senza credenziali, sono inclusi solo con il livello debug.
+func logAccess(ctx reqcontext.RequestContext, r *http.Request, sw *statusWriter, start time.Time) {
+	status := sw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	entry := ctx.Logger.WithFields(logrus.Fields{
+		"method":  r.Method,
+		"route":   routePath(r),
+		"path":    r.URL.Path,
+		"status":  status,
+		"latency": time.Since(start).String(),
+		"bytes":   sw.size,
+	})
+	if ctx.UserUUID != "" {
+		entry = entry.WithField("user", userLogID(ctx.UserUUID))
+	}
+	if entry.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		entry = entry.WithField("headers", redactHeaders(r.Header))
+	}
+	entry.Info("request served")
+}
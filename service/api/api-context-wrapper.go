@@ -1,10 +1,11 @@
 package api
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/albyma98/WASAText/service/api/reqcontext"
-	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
@@ -15,13 +16,32 @@ type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Param
 
 // wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
 func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	if rt.validateAPI {
+		fn = rt.validateSpec(fn)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		reqUUID, err := uuid.NewV4()
+		// Ogni richiesta viene contata e misurata, con lo status della risposta, per le metriche di /metrics
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+		defer func() {
+			rt.metrics.observeRequest(r, sw.status, start)
+		}()
+
+		// Span della richiesta, per il tracing
+		r, span := rt.startRequestSpan(w, r)
+		defer func() {
+			endRequestSpan(span, sw.status)
+		}()
+
+		reqUUID, err := rt.requestID(r)
 		if err != nil {
 			rt.baseLogger.WithError(err).Error("can't generate a request UUID")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set(requestIDHeader, reqUUID.String())
 
 		var ctx = reqcontext.RequestContext{
 			ReqUUID: reqUUID,
@@ -30,8 +50,25 @@ func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Re
 		// Logger con info sulla richiesta
 		ctx.Logger = rt.baseLogger.WithFields(logrus.Fields{
 			"reqid":     ctx.ReqUUID.String(),
-			"remote-ip": r.RemoteAddr,
+			"remote-ip": rt.clientIP(r),
 		})
+		if sc := span.SpanContext(); sc.IsValid() {
+			ctx.Logger = ctx.Logger.WithField("traceid", sc.TraceID.String())
+		}
+		span.SetAttribute("http.request_id", ctx.ReqUUID.String())
+
+		// Access log, scritto a fine richiesta: legge ctx alla fine per includere l'utente autenticato
+		defer func() {
+			logAccess(ctx, r, sw, start)
+		}()
+
+		// Il context della richiesta (annullato se il client si disconnette) arriva fino alle query del DB; in più
+		// ogni richiesta ha un tempo massimo, scaduto il quale le query in corso vengono interrotte
+		if rt.requestTimeout > 0 {
+			reqCtx, cancel := context.WithTimeout(r.Context(), rt.requestTimeout)
+			defer cancel()
+			r = r.WithContext(reqCtx)
+		}
 
 		// Autenticazione via Bearer
 		authHeader := r.Header.Get("Authorization")
@@ -39,14 +76,16 @@ func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Re
 			userUUID := authHeader[7:]
 
 			// Verifica se utente esiste nel DB
-			exists, err := rt.db.UserExists(userUUID)
+			exists, err := rt.db.UserExists(r.Context(), userUUID)
 			if err != nil {
-				ctx.Logger.WithError(err).Error("errore durante controllo UUID utente")
-				w.WriteHeader(http.StatusInternalServerError)
+				sendInternalError(w, ctx, err, "Can't check the user token")
 				return
 			}
 			if exists {
 				ctx.UserUUID = userUUID
+				rt.metrics.userSeen(userUUID)
+				// L'UUID è il token di accesso: agli exporter arriva solo il suo hash, come nel log
+				span.SetAttribute("enduser.id", userLogID(userUUID))
 			}
 		}
 
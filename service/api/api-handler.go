@@ -2,48 +2,87 @@ package api
 
 import (
 	"net/http"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 // Handler returns an instance of httprouter.Router that handle APIs registered here
 func (rt *_router) Handler() http.Handler {
 	// Register routes
-	rt.router.GET("/", rt.getHelloWorld)
-	rt.router.GET("/context", rt.wrap(rt.getContextReply))
+	rt.handle(http.MethodGet, "/", rt.getHelloWorld)
+	rt.handle(http.MethodGet, "/context", rt.wrap(rt.getContextReply))
 
 	// Special routes
-	rt.router.GET("/liveness", rt.liveness)
+	rt.handle(http.MethodGet, "/liveness", rt.liveness)
+	rt.handle(http.MethodGet, "/readiness", rt.readiness)
+	rt.handle(http.MethodGet, "/openapi.yaml", rt.getOpenAPISpec)
+
+	// API: ogni versione è servita sotto il proprio prefisso. La versione 1 risponde anche ai percorsi senza prefisso,
+	// usati dai client precedenti al versionamento, come alias deprecati (vedi mount).
+	rt.mount(apiV1, rt.routesV1)
+
+	return rt.router
+}
 
+// routesV1 registra le route della versione 1 dell'API, descritta in doc/api.yaml
+func (rt *_router) routesV1(v *versionRoutes) {
 	// Auth
-	rt.router.POST("/session", rt.doLogin)
+	v.handle(http.MethodPost, "/session", rt.wrap(rt.rateLimit(rt.limiters.session, rt.doLogin)))
 
 	// User
-	rt.router.GET("/user/me", rt.wrap(rt.getMyUserInfo))
-	rt.router.PUT("/user/me/username", rt.wrap(rt.setMyUserName))
-	rt.router.PUT("/user/me/photo", rt.wrap(rt.setMyPhoto))
-	rt.router.GET("/user/all", rt.wrap(rt.getAllUsers))
-	rt.router.GET("/user", rt.wrap(rt.searchUsers))
+	v.handle(http.MethodGet, "/user/me", rt.wrap(rt.requireAuth(rt.getMyUserInfo)))
+	v.handle(http.MethodPut, "/user/me/username", rt.wrap(rt.requireAuth(rt.setMyUserName)))
+	v.handle(http.MethodPut, "/user/me/photo", rt.wrap(rt.requireAuth(rt.rateLimit(rt.limiters.uploads, rt.setMyPhoto))))
+	v.handle(http.MethodGet, "/user/all", rt.wrap(rt.requireAuth(rt.getAllUsers)))
+	v.handle(http.MethodGet, "/user", rt.wrap(rt.requireAuth(rt.rateLimit(rt.limiters.search, rt.searchUsers))))
+	v.handle(http.MethodGet, "/user/me/join-requests", rt.wrap(rt.requireAuth(rt.getMyJoinRequests)))
+	v.handle(http.MethodPost, "/user/me/export", rt.wrap(rt.requireAuth(rt.requestExport)))
+	v.handle(http.MethodGet, "/user/me/export", rt.wrap(rt.requireAuth(rt.getExport)))
+	v.handle(http.MethodGet, "/user/me/export/archive", rt.wrap(rt.requireAuth(rt.downloadExport)))
 
 	// Conversation
-	rt.router.GET("/conversations", rt.wrap(rt.getMyConversations))
-	rt.router.POST("/conversations", rt.wrap(rt.createConversation))
-	rt.router.GET("/conversations/:id", rt.wrap(rt.getConversation))
-	rt.router.PUT("/conversations/:id/name", rt.wrap(rt.setGroupName))
-	rt.router.PUT("/conversations/:id/photo", rt.wrap(rt.setGroupPhoto))
-	rt.router.POST("/conversations/:id/members", rt.wrap(rt.addToGroup))
-	rt.router.GET("/conversations/:id/members", rt.wrap(rt.getGroupMembers))
-	rt.router.DELETE("/conversations/:id/members/me", rt.wrap(rt.leaveGroup))
+	v.handle(http.MethodGet, "/conversations", rt.wrap(rt.requireAuth(rt.getMyConversations)))
+	v.handle(http.MethodPost, "/conversations", rt.wrap(rt.requireAuth(rt.createConversation)))
+	v.handle(http.MethodGet, "/conversations/:id", rt.wrap(rt.requireAuth(rt.requireConversationReader(rt.getConversation))))
+	v.handle(http.MethodPut, "/conversations/:id/name", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.setGroupName)))))
+	v.handle(http.MethodPut, "/conversations/:id/photo", rt.wrap(rt.requireAuth(rt.rateLimit(rt.limiters.uploads, rt.requireConversationMember(rt.requireGroup(rt.setGroupPhoto))))))
+	v.handle(http.MethodPut, "/conversations/:id/history-visibility", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.requireGroupAdmin(rt.setGroupHistoryVisibility))))))
+	v.handle(http.MethodPost, "/conversations/:id/members", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.addToGroup)))))
+	v.handle(http.MethodGet, "/conversations/:id/members", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.getGroupMembers))))
+	v.handle(http.MethodDelete, "/conversations/:id/members/:uuid", rt.wrap(rt.requireAuth(rt.requireConversationReader(rt.requireGroup(rt.removeMember)))))
+
+	// Join request
+	v.handle(http.MethodGet, "/groups", rt.wrap(rt.requireAuth(rt.rateLimit(rt.limiters.search, rt.searchGroups))))
+	v.handle(http.MethodPut, "/conversations/:id/approval", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.requireGroupAdmin(rt.setGroupApproval))))))
+	v.handle(http.MethodPost, "/conversations/:id/join-requests", rt.wrap(rt.requireAuth(rt.requireGroup(rt.requestToJoin))))
+	v.handle(http.MethodGet, "/conversations/:id/join-requests", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.getJoinRequests)))))
+	v.handle(http.MethodPut, "/conversations/:id/join-requests/:uuid", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.requireGroupAdmin(rt.decideJoinRequest))))))
+	v.handle(http.MethodPost, "/conversations/:id/invite", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.requireGroupAdmin(rt.createGroupInvite))))))
+	v.handle(http.MethodGet, "/conversations/:id/invite", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.requireGroupAdmin(rt.getGroupInvite))))))
+	v.handle(http.MethodDelete, "/conversations/:id/invite", rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.requireGroupAdmin(rt.deleteGroupInvite))))))
 
 	// Message
-	rt.router.POST("/conversations/:id/messages", rt.wrap(rt.sendMessage))
-	rt.router.DELETE("/messages/:id", rt.wrap(rt.deleteMessage))
-	rt.router.POST("/messages/:id/forward", rt.wrap(rt.forwardMessage))
+	v.handle(http.MethodPost, "/conversations/:id/messages", rt.wrap(rt.requireAuth(rt.rateLimit(rt.limiters.messages, rt.requireConversationMember(rt.sendMessage)))))
+	v.handle(http.MethodGet, "/conversations/:id/messages", rt.wrap(rt.requireAuth(rt.requireConversationReader(rt.searchMessages))))
+	v.handle(http.MethodDelete, "/messages/:id", rt.wrap(rt.requireAuth(rt.requireMessageAccess(rt.deleteMessage))))
+	v.handle(http.MethodPost, "/messages/:id/forward", rt.wrap(rt.requireAuth(rt.rateLimit(rt.limiters.messages, rt.requireMessageAccess(rt.forwardMessage)))))
 
 	// Reaction
-	rt.router.POST("/messages/:id/reactions", rt.wrap(rt.commentMessage))
-	rt.router.DELETE("/messages/:id/reactions/me", rt.wrap(rt.uncommentMessage))
+	v.handle(http.MethodPost, "/messages/:id/reactions", rt.wrap(rt.requireAuth(rt.requireMessageAccess(rt.commentMessage))))
+	v.handle(http.MethodDelete, "/messages/:id/reactions/me", rt.wrap(rt.requireAuth(rt.requireMessageAccess(rt.uncommentMessage))))
 
 	// Status
-	rt.router.PUT("/messages/:id/status", rt.wrap(rt.updateMessageStatus))
+	v.handle(http.MethodPut, "/messages/:id/status", rt.wrap(rt.requireAuth(rt.requireMessageAccess(rt.updateMessageStatus))))
+}
 
-	return rt.router
+// route è una route registrata con handle
+type route struct {
+	method string
+	path   string
+}
+
+// handle registra la route nel router e la aggiunge all'elenco rt.routes
+func (rt *_router) handle(method string, path string, handle httprouter.Handle) {
+	rt.router.Handle(method, path, counted(method, path, handle))
+	rt.routes = append(rt.routes, route{method: method, path: path})
 }
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/albyma98/WASAText/service/api/reqcontext"
+	"github.com/albyma98/WASAText/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// I middleware qui sotto si compongono dentro rt.wrap, ad esempio:
+//
+//	rt.wrap(rt.requireAuth(rt.requireConversationMember(rt.requireGroup(rt.setGroupName))))
+//
+// Ognuno esegue un controllo e, se la richiesta è valida, aggiunge al reqcontext.RequestContext le entità caricate
+// prima di chiamare l'handler successivo; altrimenti risponde con l'errore e interrompe la catena. Quelli che leggono
+// una conversazione o un messaggio presuppongono requireAuth.
+
+// requireAuth accetta solo le richieste con un token valido
+func (rt *_router) requireAuth(next httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		if ctx.UserUUID == "" {
+			sendError(w, ctx, http.StatusUnauthorized, "Missing or invalid token")
+			return
+		}
+		next(w, r, ps, ctx)
+	}
+}
+
+// requireConversationMember carica la conversazione `:id` e accetta solo i suoi membri attuali
+func (rt *_router) requireConversationMember(next httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		conv, ok := rt.loadConversation(w, r, ps, ctx)
+		if !ok {
+			return
+		}
+
+		isMember, err := rt.db.IsMember(r.Context(), ctx.UserUUID, conv.ID)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Can't check membership")
+			return
+		}
+		if !isMember {
+			sendError(w, ctx, http.StatusForbidden, "You are not a member of the conversation")
+			return
+		}
+
+		window, err := rt.db.GetHistoryWindow(r.Context(), ctx.UserUUID, conv.ID)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Can't access the conversation")
+			return
+		}
+
+		ctx.Conversation = &conv
+		ctx.History = window
+		next(w, r, ps, ctx)
+	}
+}
+
+// requireConversationReader carica la conversazione `:id` e accetta chi ne può leggere la cronologia: i membri e gli
+// ex membri usciti o rimossi dal gruppo (fino a quel momento, vedi ctx.History)
+func (rt *_router) requireConversationReader(next httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		conv, ok := rt.loadConversation(w, r, ps, ctx)
+		if !ok {
+			return
+		}
+
+		window, err := rt.db.GetHistoryWindow(r.Context(), ctx.UserUUID, conv.ID)
+		if errors.Is(err, database.ErrNotFound) {
+			sendError(w, ctx, http.StatusForbidden, "Access to the conversation denied")
+			return
+		} else if err != nil {
+			sendInternalError(w, ctx, err, "Can't access the conversation")
+			return
+		}
+
+		ctx.Conversation = &conv
+		ctx.History = window
+		next(w, r, ps, ctx)
+	}
+}
+
+// requireGroup accetta solo le conversazioni di gruppo. Se nessun middleware precedente ha caricato la conversazione
+// `:id` la carica, senza controllare che l'utente ne faccia parte.
+func (rt *_router) requireGroup(next httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		if ctx.Conversation == nil {
+			conv, ok := rt.loadConversation(w, r, ps, ctx)
+			if !ok {
+				return
+			}
+			ctx.Conversation = &conv
+		}
+
+		if ctx.Conversation.IsDirect {
+			sendError(w, ctx, http.StatusBadRequest, "The operation is only allowed on groups")
+			return
+		}
+		next(w, r, ps, ctx)
+	}
+}
+
+// requireGroupAdmin accetta solo gli amministratori del gruppo. Va dopo requireConversationMember e requireGroup.
+func (rt *_router) requireGroupAdmin(next httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		isAdmin, err := rt.db.IsAdmin(r.Context(), ctx.UserUUID, ctx.Conversation.ID)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Can't check membership")
+			return
+		}
+		if !isAdmin {
+			sendError(w, ctx, http.StatusForbidden, "Only group admins can do this")
+			return
+		}
+		next(w, r, ps, ctx)
+	}
+}
+
+// requireMessageAccess carica il messaggio `:id` e la sua conversazione e accetta solo i membri attuali della
+// conversazione che possono vedere il messaggio secondo la politica di cronologia del gruppo. Agli altri utenti il
+// messaggio risulta inesistente.
+func (rt *_router) requireMessageAccess(next httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		msgID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+		if err != nil || msgID <= 0 {
+			sendError(w, ctx, http.StatusBadRequest, "Invalid message ID")
+			return
+		}
+
+		msg, err := rt.db.GetMessageByID(r.Context(), msgID)
+		if err != nil {
+			sendDBError(w, ctx, err, "Message not found")
+			return
+		}
+
+		isMember, err := rt.db.IsMember(r.Context(), ctx.UserUUID, msg.IDConversation)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Can't check membership")
+			return
+		}
+		if !isMember {
+			sendError(w, ctx, http.StatusNotFound, "Message not found")
+			return
+		}
+
+		window, err := rt.db.GetHistoryWindow(r.Context(), ctx.UserUUID, msg.IDConversation)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Can't access the conversation")
+			return
+		}
+		if !window.Contains(msg.Timestamp) {
+			sendError(w, ctx, http.StatusNotFound, "Message not found")
+			return
+		}
+
+		conv, err := rt.db.GetConversationByID(r.Context(), msg.IDConversation)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Can't access the conversation")
+			return
+		}
+
+		ctx.Message = &msg
+		ctx.Conversation = &conv
+		ctx.History = window
+		next(w, r, ps, ctx)
+	}
+}
+
+// loadConversation legge la conversazione `:id`; in caso di errore risponde al client e ritorna false
+func (rt *_router) loadConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (database.Conversation, bool) {
+	convID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		sendError(w, ctx, http.StatusBadRequest, "Invalid conversation ID")
+		return database.Conversation{}, false
+	}
+
+	conv, err := rt.db.GetConversationByID(r.Context(), convID)
+	if err != nil {
+		sendDBError(w, ctx, err, "Conversation not found")
+		return database.Conversation{}, false
+	}
+	return conv, true
+}
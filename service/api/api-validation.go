@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/albyma98/WASAText/doc"
+	"github.com/albyma98/WASAText/service/api/reqcontext"
+	"github.com/julienschmidt/httprouter"
+)
+
+// getOpenAPISpec restituisce la specifica OpenAPI inclusa nell'eseguibile
+func (rt *_router) getOpenAPISpec(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(doc.APISpec)
+}
+
+// validateSpec confronta richiesta e risposta con l'operazione corrispondente della specifica OpenAPI. Se la richiesta
+// non la rispetta risponde 400 con l'elenco dei problemi in `details`, senza chiamare l'handler; se non la rispetta la
+// risposta, la invia comunque e segnala i problemi nel log. Le route che non compaiono nella specifica non vengono
+// controllate.
+//
+// Il corpo della richiesta viene letto per intero in memoria: la validazione è pensata per sviluppo e test.
+func (rt *_router) validateSpec(next httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		op, params := rt.spec.Find(r.Method, r.URL.Path)
+		if op == nil {
+			next(w, r, ps, ctx)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			sendError(w, ctx, http.StatusBadRequest, "Can't read the request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if problems := op.ValidateRequest(r, params, body); len(problems) > 0 {
+			sendErrorDetails(w, ctx, http.StatusBadRequest, "The request doesn't match the API specification", problems)
+			return
+		}
+
+		rec := &teeResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r, ps, ctx)
+
+		if problems := op.ValidateResponse(rec.status, w.Header(), rec.body.Bytes()); len(problems) > 0 {
+			ctx.Logger.WithField("problems", problems).Warnf("the response of %s doesn't match the API specification", op.ID)
+		}
+	}
+}
+
+// teeResponseWriter inoltra la risposta e ne tiene una copia di status e corpo
+type teeResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *teeResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *teeResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// apiVersion è una versione dell'API, servita sotto un proprio prefisso
+type apiVersion struct {
+	// prefix è il prefisso dei percorsi della versione, ad esempio "/v1"
+	prefix string
+
+	// legacy indica che le route sono servite anche senza prefisso, come alias deprecati. Vale solo per la versione 1:
+	// i percorsi senza prefisso esistevano prima del versionamento e i client che li usano devono continuare a
+	// funzionare fino alla data di dismissione (Config.LegacySunset).
+	legacy bool
+}
+
+var apiV1 = apiVersion{prefix: "/v1", legacy: true}
+
+// legacyDeprecatedSince è il momento da cui i percorsi senza prefisso di versione sono deprecati, annunciato
+// nell'header Deprecation
+var legacyDeprecatedSince = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// versionRoutes raccoglie le route di una versione prima che mount le registri. Registrare di nuovo una route già
+// presente ne sostituisce l'handler: una nuova versione può partire dalle route della precedente e cambiare solo quelle
+// con modifiche incompatibili (ad esempio una nuova forma della risposta):
+//
+//	func (rt *_router) routesV2(v *versionRoutes) {
+//		rt.routesV1(v)
+//		v.handle(http.MethodGet, "/conversations", rt.wrap(rt.requireAuth(rt.getMyConversationsV2)))
+//	}
+//
+// e in Handler:
+//
+//	rt.mount(apiVersion{prefix: "/v2"}, rt.routesV2)
+type versionRoutes struct {
+	routes  []route
+	handles map[route]httprouter.Handle
+}
+
+// handle aggiunge la route alla versione, o ne sostituisce l'handler se è già presente
+func (v *versionRoutes) handle(method string, path string, handle httprouter.Handle) {
+	r := route{method: method, path: path}
+	if _, ok := v.handles[r]; !ok {
+		v.routes = append(v.routes, r)
+	}
+	v.handles[r] = handle
+}
+
+// mount registra sotto il prefisso di version le route aggiunte da register. Per la versione legacy registra anche gli
+// alias senza prefisso, che rispondono come la route originale aggiungendo gli header di deprecazione.
+func (rt *_router) mount(version apiVersion, register func(v *versionRoutes)) {
+	v := &versionRoutes{handles: make(map[route]httprouter.Handle)}
+	register(v)
+
+	for _, r := range v.routes {
+		rt.handle(r.method, version.prefix+r.path, v.handles[r])
+		if version.legacy {
+			rt.router.Handle(r.method, r.path, counted(r.method, r.path, rt.deprecated(version.prefix, v.handles[r])))
+			rt.aliases = append(rt.aliases, r)
+		}
+	}
+}
+
+// deprecated aggiunge alla risposta di un alias senza prefisso gli header che ne annunciano la dismissione: Deprecation
+// (RFC 9745), Sunset (RFC 8594) se la data è configurata, e Link con il percorso da usare al suo posto.
+func (rt *_router) deprecated(prefix string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecatedSince.Unix()))
+		if !rt.legacySunset.IsZero() {
+			w.Header().Set("Sunset", rt.legacySunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", prefix, r.URL.Path))
+		next(w, r, ps)
+	}
+}
@@ -38,9 +38,15 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/albyma98/WASAText/doc"
+	"github.com/albyma98/WASAText/service/api/openapi"
 	"github.com/albyma98/WASAText/service/database"
+	"github.com/albyma98/WASAText/service/tracing"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
@@ -52,13 +58,54 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// RequestTimeout is the maximum time a request can spend querying the database. Zero means no limit (queries are
+	// still canceled when the client disconnects).
+	RequestTimeout time.Duration
+
+	// ValidateAPI attiva il controllo di richieste e risposte rispetto alla specifica OpenAPI (doc/api.yaml): le
+	// richieste che non la rispettano ricevono 400, le risposte che non la rispettano vengono segnalate nel log.
+	ValidateAPI bool
+
+	// LegacySunset è la data da cui i percorsi senza prefisso di versione (ad esempio /session invece di /v1/session)
+	// non saranno più serviti, annunciata nell'header Sunset delle loro risposte. Se è zero l'header non viene inviato.
+	LegacySunset time.Time
+
+	// BehindProxy indica che il server riceve le richieste da un reverse proxy: l'IP del client viene letto
+	// dall'header X-Forwarded-For invece che dall'indirizzo della connessione (vedi clientIP), e l'ID della richiesta
+	// aggiunto dal proxy in X-Request-ID viene riusato (vedi requestID)
+	BehindProxy bool
+
+	// RateLimits sono i budget di richieste delle route più costose o soggette ad abusi; i gruppi con valore zero non
+	// sono limitati
+	RateLimits RateLimits
+
+	// Tracer registra uno span per ogni richiesta e per ogni chiamata al database. Se nil il tracing è disattivato.
+	Tracer *tracing.Tracer
+
+	// ExportDir è la cartella in cui vengono preparati gli archivi delle esportazioni dei dati (POST /user/me/export).
+	// Se vuota viene usata wasatext-exports nella cartella temporanea di sistema. Gli archivi presenti all'avvio sono
+	// eliminati.
+	ExportDir string
+
+	// ExportTTL è il tempo per cui un'esportazione terminata resta disponibile, dopo il quale l'archivio viene
+	// eliminato. Se è zero vale 24 ore.
+	ExportTTL time.Duration
 }
 
+// MediaDir è la cartella in cui vengono salvate le foto caricate, servita da cmd/webapi sotto /webui/public/ e inclusa
+// nei backup
+const MediaDir = "./webui/public"
+
 // Router is the package API interface representing an API handler builder
 type Router interface {
 	// Handler returns an HTTP handler for APIs provided in this package
 	Handler() http.Handler
 
+	// MetricsHandler restituisce l'handler delle metriche del router nel formato di Prometheus. Non fa parte di
+	// Handler: va servito su un indirizzo non pubblico, come il server di debug di cmd/webapi.
+	MetricsHandler() http.Handler
+
 	// Close terminates any resource used in the package
 	Close() error
 }
@@ -79,11 +126,39 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	// La specifica inclusa nell'eseguibile è servita su /openapi.yaml e, se richiesto, usata per la validazione
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		return nil, fmt.Errorf("loading the OpenAPI specification: %w", err)
+	}
+
+	exports, err := newExports(cfg.ExportDir, cfg.ExportTTL)
+	if err != nil {
+		return nil, fmt.Errorf("preparing the export directory: %w", err)
+	}
+
+	rt := &_router{
+		router:         router,
+		baseLogger:     cfg.Logger,
+		metrics:        newAPIMetrics(),
+		tracer:         cfg.Tracer,
+		requestTimeout: cfg.RequestTimeout,
+		spec:           spec,
+		validateAPI:    cfg.ValidateAPI,
+		legacySunset:   cfg.LegacySunset,
+		behindProxy:    cfg.BehindProxy,
+		limiters:       newRateLimiters(cfg.RateLimits),
+		exports:        exports,
+		stop:           make(chan struct{}),
+	}
+	// Le chiamate al database vengono misurate per /metrics e tracciate, vedi observeDB
+	rt.db = database.Instrument(cfg.Database, rt.observeDB)
+
+	if len(rt.limiters.all()) > 0 {
+		go rt.cleanupRateLimiters(rt.stop)
+	}
+	go rt.cleanupExports(rt.stop)
+	return rt, nil
 }
 
 type _router struct {
@@ -94,4 +169,44 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	// requestTimeout is the deadline given to each request context, see Config.RequestTimeout
+	requestTimeout time.Duration
+
+	// routes sono le route registrate da Handler, nell'ordine di registrazione
+	routes []route
+
+	// aliases sono le route della versione 1 servite anche senza prefisso, come alias deprecati (vedi mount)
+	aliases []route
+
+	// legacySunset è la data di dismissione degli alias, vedi Config.LegacySunset
+	legacySunset time.Time
+
+	// spec è la specifica OpenAPI dell'API
+	spec *openapi.Spec
+
+	// validateAPI attiva validateSpec per tutte le route, vedi Config.ValidateAPI
+	validateAPI bool
+
+	// metrics sono le metriche servite da MetricsHandler
+	metrics *apiMetrics
+
+	// tracer registra gli span, vedi Config.Tracer
+	tracer *tracing.Tracer
+
+	// behindProxy, vedi Config.BehindProxy
+	behindProxy bool
+
+	// limiters sono i limitatori delle richieste, vedi Config.RateLimits
+	limiters rateLimiters
+
+	// exports sono le esportazioni dei dati degli utenti, vedi Config.ExportDir
+	exports *exports
+
+	// closing vale 1 dopo Close (accesso con sync/atomic), vedi readiness
+	closing int32
+
+	// stop viene chiuso da Close per fermare le goroutine in background
+	stop      chan struct{}
+	closeOnce sync.Once
 }
@@ -5,13 +5,14 @@ import (
 	"net/http"
 	"regexp"
 
-	"github.com/albyma98/WASAText/service/database"
+	"github.com/albyma98/WASAText/service/api/dto"
+	"github.com/albyma98/WASAText/service/api/reqcontext"
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
 )
 
 // Handler per POST /session (login o registrazione)
-func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var req struct {
@@ -20,31 +21,31 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.
 
 	// Decodifica JSON
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"Malformed request"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Malformed request")
 		return
 	}
 
 	// Validazione sintattica secondo OpenAPI
 	if len(req.Username) < 3 || len(req.Username) > 16 {
-		http.Error(w, `{"error":"Username must be between 3 and 16 characters"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Username must be between 3 and 16 characters")
 		return
 	}
 	if !regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString(req.Username) {
-		http.Error(w, `{"error":"Username can only contain letters, digits and underscores"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Username can only contain letters, digits and underscores")
 		return
 	}
 
 	// Cerca utente esistente
-	users, err := rt.db.SearchUsersByPrefix(req.Username)
+	users, err := rt.db.SearchUsersByPrefix(r.Context(), req.Username)
 	if err != nil {
-		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Database error")
 		return
 	}
 	for _, u := range users {
 		if u.Username == req.Username {
 			w.WriteHeader(http.StatusOK) // 200
-			if err := json.NewEncoder(w).Encode(u); err != nil {
-				http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+			if err := json.NewEncoder(w).Encode(dto.FromUser(u)); err != nil {
+				ctx.Logger.WithError(err).Error("can't encode the response")
 				return
 			}
 			return
@@ -54,24 +55,23 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.
 	// Utente non esiste → creazione
 	newUUID, err := uuid.NewV4()
 	if err != nil {
-		http.Error(w, `{"error":"Failed to generate UUID"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Failed to generate UUID")
 		return
 	}
-	err = rt.db.CreateUser(newUUID.String(), req.Username, "")
+	err = rt.db.CreateUser(r.Context(), newUUID.String(), req.Username, "")
 	if err != nil {
-		http.Error(w, `{"error":"Unable to create user"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Unable to create user")
 		return
 	}
 
-	user := database.User{
+	user := dto.User{
 		UUID:     newUUID.String(),
 		Username: req.Username,
-		PhotoUrl: nil,
 	}
 
 	w.WriteHeader(http.StatusCreated) // 201
 	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 }
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP restituisce l'IP del client che ha inviato r. Dietro un reverse proxy (Config.BehindProxy) è l'ultimo
+// indirizzo di X-Forwarded-For, cioè quello aggiunto dal proxy: gli indirizzi precedenti sono scritti dal client e
+// non sono affidabili. Si presume quindi un solo proxy davanti al server.
+func (rt *_router) clientIP(r *http.Request) string {
+	if rt.behindProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndexByte(last, ','); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := net.ParseIP(strings.TrimSpace(last)); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
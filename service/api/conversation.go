@@ -2,15 +2,15 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"regexp"
-	"strconv"
 	"time"
 
+	"github.com/albyma98/WASAText/service/api/dto"
 	"github.com/albyma98/WASAText/service/api/reqcontext"
 	"github.com/albyma98/WASAText/service/database"
 	"github.com/julienschmidt/httprouter"
@@ -19,66 +19,57 @@ import (
 func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	convs, err := rt.db.GetConversationsByUser(ctx.UserUUID)
+	convs, err := rt.db.GetConversationsByUser(r.Context(), ctx.UserUUID)
 	if err != nil {
-		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Database error")
 		return
 	}
 
-	type ResponseConversation struct {
-		ID                   int64   `json:"id"`
-		IsDirect             bool    `json:"isDirect"`
-		GroupName            *string `json:"groupName"`
-		GroupPhoto           *string `json:"groupPhoto"`
-		TimestampCreated     string  `json:"timestampCreated"`
-		TimestampLastMessage string  `json:"timestampLastMessage"`
-
-		PeerUsername    *string `json:"peerUsername,omitempty"`
-		PeerPhoto       *string `json:"peerPhoto,omitempty"`
-		LastMessageText *string `json:"lastMessageText,omitempty"`
-		LastMessageType *string `json:"lastMessageType,omitempty"`
-	}
-
-	var output []ResponseConversation
+	output := dto.ConversationList{Conversations: []dto.ConversationSummary{}}
 
 	for _, c := range convs {
-		item := ResponseConversation{
-			ID:                   c.ID,
-			IsDirect:             c.IsDirect,
-			GroupName:            c.GroupName,
-			GroupPhoto:           c.GroupPhoto,
-			TimestampCreated:     c.TimestampCreated,
-			TimestampLastMessage: c.TimestampLastMessage,
-		}
-
-		// 1. Ottieni l'ultimo messaggio (se esiste)
-		if lastMsg, err := rt.db.GetLastMessage(c.ID); err == nil {
-			item.LastMessageText = &lastMsg.Content
-			item.LastMessageType = &lastMsg.Type
+		item := dto.ConversationSummary{Conversation: dto.FromConversation(c)}
+
+		// 1. Ottieni l'ultimo messaggio (se esiste e se è visibile all'utente)
+		if lastMsg, err := rt.db.GetLastMessage(r.Context(), c.ID); err == nil {
+			window, err := rt.db.GetHistoryWindow(r.Context(), ctx.UserUUID, c.ID)
+			if err == nil && window.Contains(lastMsg.Timestamp) {
+				item.LastMessageText = &lastMsg.Content
+				item.LastMessageType = &lastMsg.Type
+			}
 		}
 
 		// 2. Se è diretta, ottieni info dell'altro utente
 		if c.IsDirect {
-			if peer, err := rt.db.GetPeerData(c.ID, ctx.UserUUID); err == nil {
+			if peer, err := rt.db.GetPeerData(r.Context(), c.ID, ctx.UserUUID); err == nil {
 				item.PeerUsername = &peer.Username
 				item.PeerPhoto = peer.PhotoUrl
 			}
 		}
 
-		output = append(output, item)
+		output.Conversations = append(output.Conversations, item)
 	}
 
-	errs := json.NewEncoder(w).Encode(map[string]interface{}{
-		"conversations": output,
-	})
-	if errs != nil {
-		log.Printf("Errore nella codifica JSON: %v", errs)
-		http.Error(w, "Errore interno del server", http.StatusInternalServerError)
+	// Gruppi da cui l'utente è uscito o è stato rimosso: restano consultabili fino a quel momento
+	formerConvs, err := rt.db.GetFormerConversationsByUser(r.Context(), ctx.UserUUID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Database error")
+		return
+	}
+	for _, c := range formerConvs {
+		removedAt, err := rt.db.GetRemovedAt(r.Context(), ctx.UserUUID, c.ID)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Database error")
+			return
+		}
+		// Per un ex membro l'ultimo messaggio visibile è al più quello del momento della rimozione
+		item := dto.ConversationSummary{Conversation: dto.FromConversation(c), RemovedAt: &removedAt}
+		item.TimestampLastMessage = removedAt
+		output.Conversations = append(output.Conversations, item)
+	}
+
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 }
@@ -86,11 +77,6 @@ func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, _
 func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o non valido"}`, http.StatusUnauthorized)
-		return
-	}
-
 	var body struct {
 		IsDirect   bool     `json:"isDirect"`
 		GroupName  *string  `json:"groupName"`
@@ -99,34 +85,53 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, `{"error":"Corpo della richiesta non valido"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if body.IsDirect {
 		// Validazione: deve esserci un solo membro e groupName/groupPhoto devono essere null
 		if len(body.Members) != 1 || body.GroupName != nil || body.GroupPhoto != nil {
-			http.Error(w, `{"error":"Parametri non validi per conversazione diretta"}`, http.StatusBadRequest)
+			sendError(w, ctx, http.StatusBadRequest, "Invalid parameters for a direct conversation")
 			return
 		}
 
-		// Controlla se già esiste
-		_, err := rt.db.GetDirectConversationBetween(ctx.UserUUID, body.Members[0])
-		if err == nil {
-			// Se non dà errore è perché la conversazione esiste già
-			http.Error(w, `{"error":"Conversazione già esistente"}`, http.StatusConflict)
+		// Il destinatario deve essere un utente esistente diverso dall’utente autenticato
+		members, invalid, err := rt.resolveMembers(r.Context(), body.Members)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Database error")
+			return
+		}
+		if len(invalid) == 0 && members[0] == ctx.UserUUID {
+			invalid = append(invalid, memberError{Member: body.Members[0], Reason: memberSelf})
+		}
+		if len(invalid) > 0 {
+			sendErrorDetails(w, ctx, http.StatusBadRequest, "Invalid members", invalid)
 			return
 		}
+		peer := members[0]
+
+		// Controllo e creazione avvengono nella stessa transazione, così due richieste contemporanee non possono creare
+		// due chat dirette tra gli stessi utenti
+		var conv database.Conversation
+		err = rt.db.WithTx(r.Context(), func(tx database.AppDatabase) error {
+			if _, err := tx.GetDirectConversationBetween(r.Context(), ctx.UserUUID, peer); err == nil {
+				return &database.Error{Kind: database.ErrConflict, Msg: "direct conversation already exists"}
+			} else if !errors.Is(err, database.ErrNotFound) {
+				return err
+			}
 
-		conv, err := rt.db.CreateDirectConversation(ctx.UserUUID, body.Members[0])
+			conv, err = tx.CreateDirectConversation(r.Context(), ctx.UserUUID, peer)
+			return err
+		})
 		if err != nil {
-			http.Error(w, `{"error":"Errore nella creazione della conversazione"}`, http.StatusInternalServerError)
+			sendDBError(w, ctx, err, "Conversation already exists")
 			return
 		}
 
 		w.WriteHeader(http.StatusCreated)
-		if err := json.NewEncoder(w).Encode(conv); err != nil {
-			http.Error(w, `{"error":" errore nella codifica di risposta"}`, http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(dto.FromConversation(conv)); err != nil {
+			ctx.Logger.WithError(err).Error("can't encode the response")
 			return
 		}
 		return
@@ -134,33 +139,31 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _
 	} else {
 		// Validazione parametri gruppo
 		if body.GroupName == nil || len(*body.GroupName) < 3 || len(body.Members) < 1 {
-			http.Error(w, `{"error":"Dati gruppo non validi"}`, http.StatusBadRequest)
+			sendError(w, ctx, http.StatusBadRequest, "Invalid group data")
 			return
 		}
 
-		// Crea conversazione di gruppo (solo il creatore viene aggiunto, gli altri saranno aggiunti separatamente)
-		conv, err := rt.db.CreateGroupConversation(ctx.UserUUID, body.GroupName, body.GroupPhoto)
+		// Tutti i membri indicati devono essere utenti esistenti
+		members, invalid, err := rt.resolveMembers(r.Context(), body.Members)
 		if err != nil {
-			http.Error(w, `{"error":"Errore nella creazione del gruppo"}`, http.StatusInternalServerError)
+			sendInternalError(w, ctx, err, "Database error")
+			return
+		}
+		if len(invalid) > 0 {
+			sendErrorDetails(w, ctx, http.StatusBadRequest, "Invalid members", invalid)
 			return
 		}
 
-		// Inserisci gli altri membri specificati
-		for _, uuid := range body.Members {
-			if uuid == ctx.UserUUID {
-				// Il creatore è già stato inserito
-				continue
-			}
-
-			if err := rt.db.AddMember(uuid, conv.ID); err != nil {
-				http.Error(w, `{"error":"Errore aggiunta membro"}`, http.StatusInternalServerError)
-				return
-			}
+		// Crea la conversazione di gruppo insieme ai suoi membri (il creatore è aggiunto come amministratore)
+		conv, err := rt.db.CreateGroupConversation(r.Context(), ctx.UserUUID, body.GroupName, body.GroupPhoto, members)
+		if err != nil {
+			sendDBError(w, ctx, err, "Can't create the group")
+			return
 		}
 
 		w.WriteHeader(http.StatusCreated)
-		if err := json.NewEncoder(w).Encode(conv); err != nil {
-			http.Error(w, `{"error":" errore nella codifica di risposta"}`, http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(dto.FromConversation(conv)); err != nil {
+			ctx.Logger.WithError(err).Error("can't encode the response")
 			return
 		}
 		return
@@ -170,112 +173,68 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _
 func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o non valido"}`, http.StatusUnauthorized)
-		return
+	// La conversazione e la finestra di cronologia visibile all’utente sono caricate da requireConversationReader
+	conv := ctx.Conversation
+	convID := conv.ID
+	window := ctx.History
+	var removedAt *string
+	if window.Until != "" {
+		removedAt = &window.Until
 	}
 
-	// Prendi l’ID della conversazione da path param
-	convID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	// Recupera i messaggi visibili della conversazione comprensivi delle reazioni
+	baseMessages, err := rt.db.GetMessagesByConversationID(r.Context(), convID, window)
 	if err != nil {
-		http.Error(w, `{"error":"ID conversazione non valido"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Recupera la conversazione
-	conv, err := rt.db.GetConversationByID(convID)
-	if err != nil {
-		http.Error(w, `{"error":"Conversazione non trovata"}`, http.StatusNotFound)
-		return
-	}
-
-	// Controlla se l’utente ne fa parte
-	isMember, err := rt.db.IsMember(ctx.UserUUID, convID)
-	if err != nil {
-		http.Error(w, `{"error":"Errore accesso conversazione"}`, http.StatusInternalServerError)
-		return
-	}
-	if !isMember {
-		http.Error(w, `{"error":"Accesso negato alla conversazione"}`, http.StatusForbidden)
-		return
-	}
-
-	// Recupera i messaggi della conversazione comprensivi delle reazioni
-	baseMessages, err := rt.db.GetMessagesByConversationID(convID)
-	if err != nil {
-		http.Error(w, `{"error":"Errore recupero messaggi"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't load messages")
 		return
 	}
 
 	// Aggiungi gli status delivered/seen ad ogni messaggio
-
-	type ReplyMessage struct {
-		Type     string  `json:"type"`
-		Content  string  `json:"content"`
-		MediaUrl *string `json:"mediaUrl"`
-	}
-
-
-	type MessageWithStatus struct {
-		database.Message
-		Delivered      []string      `json:"delivered"`
-		Seen           []string      `json:"seen"`
-		UsernameSender string        `json:"usernameSender"`
-		ReplyToMessage *ReplyMessage `json:"replyToMessage,omitempty"`
-	}
-
-	var messagesWithStatus []MessageWithStatus
+	messagesWithStatus := []dto.MessageWithStatus{}
 	for _, m := range baseMessages {
-		statuses, err := rt.db.GetAllStatusesByMessage(m.ID)
+		statuses, err := rt.db.GetAllStatusesByMessage(r.Context(), m.ID)
 		if err != nil {
-			http.Error(w, `{"error":"Errore recupero stati messaggi"}`, http.StatusInternalServerError)
+			sendInternalError(w, ctx, err, "Can't load message statuses")
 			return
 		}
 
-		var delivered []string
-		var seen []string
-		for _, st := range statuses {
-			if st.Delivered {
-				delivered = append(delivered, st.UUIDUser)
-			}
-			if st.Seen {
-				seen = append(seen, st.UUIDUser)
-			}
-		}
-
 		username := ""
-		if user, err := rt.db.GetUserByUUID(m.UUIDSender); err == nil {
+		if user, err := rt.db.GetUserByUUID(r.Context(), m.UUIDSender); err == nil {
 			username = user.Username
 		}
 
+		item := dto.NewMessageWithStatus(m, statuses, username)
 
-		var replyMsg *ReplyMessage
 		if m.IDRepliesTo != nil {
-			if original, err := rt.db.GetMessageByID(*m.IDRepliesTo); err == nil {
-				replyMsg = &ReplyMessage{
-					Type:     original.Type,
-					Content:  original.Content,
-					MediaUrl: original.MediaUrl,
+			// L'anteprima è mostrata solo se anche il messaggio originale è visibile all'utente
+			if original, err := rt.db.GetMessageByID(r.Context(), *m.IDRepliesTo); err == nil && window.Contains(original.Timestamp) {
+				preview := dto.FromReplied(original)
+				item.ReplyToMessage = &preview
+			}
+		}
+
+		// Evento di sistema con gli username risolti per la visualizzazione
+		if item.System != nil {
+			if actor, err := rt.db.GetUserByUUID(r.Context(), item.System.Actor); err == nil {
+				item.System.ActorUsername = actor.Username
+			}
+			for _, t := range item.System.Targets {
+				if target, err := rt.db.GetUserByUUID(r.Context(), t); err == nil {
+					item.System.TargetUsernames = append(item.System.TargetUsernames, target.Username)
 				}
 			}
 		}
 
-		messagesWithStatus = append(messagesWithStatus, MessageWithStatus{
-			Message:        m,
-			Delivered:      delivered,
-			Seen:           seen,
-			UsernameSender: username,
-			ReplyToMessage: replyMsg,
-		})
+		messagesWithStatus = append(messagesWithStatus, item)
 	}
 
 	// Se diretta, recupera info del peer
 	var usernamePeer *string
 	var photoUrlPeer *string
 	if conv.IsDirect {
-		peer, err := rt.db.GetPeerData(convID, ctx.UserUUID)
+		peer, err := rt.db.GetPeerData(r.Context(), convID, ctx.UserUUID)
 		if err != nil {
-			http.Error(w, `{"error":"Errore recupero peer"}`, http.StatusInternalServerError)
+			sendInternalError(w, ctx, err, "Can't load the peer")
 			return
 		}
 		usernamePeer = &peer.Username
@@ -283,39 +242,24 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 	}
 
 	// Numero di membri della conversazione
-	members, err := rt.db.GetMembersByConversation(convID)
+	members, err := rt.db.GetMembersByConversation(r.Context(), convID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore recupero membri"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't load the members")
 		return
 	}
 
-	// Dettagli conversazione da restituire
-	type conversationDetail struct {
-		ID            int64   `json:"id"`
-		IsDirect      bool    `json:"isDirect"`
-		GroupName     *string `json:"groupName"`
-		GroupPhoto    *string `json:"groupPhoto"`
-		UsernamePeer  *string `json:"usernamePeer,omitempty"`
-		PhotoUrlPeer  *string `json:"photoUrlPeer,omitempty"`
-		NumberMembers int     `json:"numberMembers"`
-	}
-
-	convDetail := conversationDetail{
-		ID:            conv.ID,
-		IsDirect:      conv.IsDirect,
-		GroupName:     conv.GroupName,
-		GroupPhoto:    conv.GroupPhoto,
-		UsernamePeer:  usernamePeer,
-		PhotoUrlPeer:  photoUrlPeer,
-		NumberMembers: len(members),
-	}
-
 	// Tutto ok, restituisci dettagli e messaggi
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"conversationDetail": convDetail,
-		"messages":           messagesWithStatus,
+	if err := json.NewEncoder(w).Encode(dto.ConversationPage{
+		ConversationDetail: dto.ConversationDetail{
+			Conversation:  dto.FromConversation(*conv),
+			UsernamePeer:  usernamePeer,
+			PhotoUrlPeer:  photoUrlPeer,
+			NumberMembers: len(members),
+			RemovedAt:     removedAt,
+		},
+		Messages: messagesWithStatus,
 	}); err != nil {
-		http.Error(w, `{"error":" errore nella codifica di risposta"}`, http.StatusInternalServerError)
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 }
@@ -323,78 +267,44 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o non valido"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Prendi ID da path
-	convID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
-	if err != nil {
-		http.Error(w, `{"error":"ID conversazione non valido"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Controlla se la conversazione esiste
-	conv, err := rt.db.GetConversationByID(convID)
-	if err != nil {
-		http.Error(w, `{"error":"Conversazione non trovata"}`, http.StatusNotFound)
-		return
-	}
-
-	// Solo per gruppi
-	if conv.IsDirect {
-		http.Error(w, `{"error":"Non puoi modificare una conversazione diretta"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Controlla che l’utente sia membro
-	isMember, err := rt.db.IsMember(ctx.UserUUID, convID)
-	if err != nil {
-		http.Error(w, `{"error":"Errore accesso"}`, http.StatusInternalServerError)
-		return
-	}
-	if !isMember {
-		http.Error(w, `{"error":"Non hai accesso alla conversazione"}`, http.StatusForbidden)
-		return
-	}
+	convID := ctx.Conversation.ID
 
 	// Leggi i dati del body
 	var body struct {
 		GroupName string `json:"groupName"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, `{"error":"Body malformato"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Malformed request body")
 		return
 	}
 
 	// Controllo obbligatorietà e validità
 	if body.GroupName == "" {
-		http.Error(w, `{"error":"Nome gruppo obbligatorio"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Group name is required")
 		return
 	}
 
 	re := regexp.MustCompile(`^[a-zA-Z0-9_]{3,30}$`)
 	if !re.MatchString(body.GroupName) {
-		http.Error(w, `{"error":"Nome gruppo non valido"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Invalid group name")
 		return
 	}
 
 	// Esegui update nel DB
-	err = rt.db.SetGroupName(convID, body.GroupName)
+	err := rt.db.SetGroupName(r.Context(), convID, body.GroupName, ctx.UserUUID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore aggiornamento gruppo"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't update the group")
 		return
 	}
 
 	// Ritorna l’oggetto aggiornato
-	updated, err := rt.db.GetConversationByID(convID)
+	updated, err := rt.db.GetConversationByID(r.Context(), convID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore lettura dopo aggiornamento"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't read the updated conversation")
 		return
 	}
-	if err := json.NewEncoder(w).Encode(updated); err != nil {
-		http.Error(w, `{"error":" errore nella codifica di risposta"}`, http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromConversation(updated)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
@@ -403,98 +313,63 @@ func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httpr
 func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o non valido"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Prendi ID da path
-	convID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
-	if err != nil {
-		http.Error(w, `{"error":"ID conversazione non valido"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Controlla se la conversazione esiste
-	conv, err := rt.db.GetConversationByID(convID)
-	if err != nil {
-		http.Error(w, `{"error":"Conversazione non trovata"}`, http.StatusNotFound)
-		return
-	}
-
-	// Solo per gruppi
-	if conv.IsDirect {
-		http.Error(w, `{"error":"Non puoi modificare una conversazione diretta"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Controlla che l’utente sia membro
-	isMember, err := rt.db.IsMember(ctx.UserUUID, convID)
-	if err != nil {
-		http.Error(w, `{"error":"Errore accesso"}`, http.StatusInternalServerError)
-		return
-	}
-	if !isMember {
-		http.Error(w, `{"error":"Non hai accesso alla conversazione"}`, http.StatusForbidden)
-		return
-	}
+	convID := ctx.Conversation.ID
 
 	// Leggi i dati del body
 	// Crea ./webui/public/ se non esiste
-	if err := os.MkdirAll("./webui/public", os.ModePerm); err != nil {
-		log.Println("❌ Errore creazione cartella ./webui/public:", err)
-		http.Error(w, `{"error":"Cannot create upload directory"}`, http.StatusInternalServerError)
+	if err := os.MkdirAll(MediaDir, os.ModePerm); err != nil {
+		sendInternalError(w, ctx, err, "Cannot create upload directory")
 		return
 	}
 
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		log.Println("❌ Errore ParseMultipartForm:", err)
-		http.Error(w, `{"error":"Cannot parse form data"}`, http.StatusBadRequest)
+		ctx.Logger.WithError(err).Debug("can't parse the multipart form")
+		sendError(w, ctx, http.StatusBadRequest, "Cannot parse form data")
 		return
 	}
 
 	file, handler, err := r.FormFile("photo")
 	if err != nil {
-		log.Println("⚠️ ERRORE R.FormFile:", err)
-		http.Error(w, `{"error":"File not found in request"}`, http.StatusBadRequest)
+		ctx.Logger.WithError(err).Debug("photo missing in the multipart form")
+		sendError(w, ctx, http.StatusBadRequest, "File not found in request")
 		return
 	}
 	defer file.Close()
 
 	safeName := regexp.MustCompile(`[^a-zA-Z0-9\.\-_]`).ReplaceAllString(handler.Filename, "_")
 	filename := fmt.Sprintf("conv%d_%d_%s", convID, time.Now().Unix(), safeName)
-	filepath := "./webui/public/" + filename
+	filepath := MediaDir + "/" + filename
 
 	dst, err := os.Create(filepath)
 	if err != nil {
-		log.Println("❌ Errore salvataggio file:", err)
-		http.Error(w, `{"error":"Cannot save file"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Cannot save file")
 		return
 	}
 	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
-		log.Println("❌ Errore copia file:", err)
-		http.Error(w, `{"error":"Error saving file"}`, http.StatusInternalServerError)
+	written, err := io.Copy(dst, file)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Error saving file")
 		return
 	}
+	rt.metrics.uploadBytes.Add(float64(written), "group_photo")
 
 	publicPath := "/" + filename
 
-	err = rt.db.SetGroupPhoto(convID, publicPath)
+	err = rt.db.SetGroupPhoto(r.Context(), convID, publicPath, ctx.UserUUID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore aggiornamento gruppo"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't update the group")
 		return
 	}
 
 	// Ritorna l’oggetto aggiornato
-	updated, err := rt.db.GetConversationByID(convID)
+	updated, err := rt.db.GetConversationByID(r.Context(), convID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore lettura dopo aggiornamento"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't read the updated conversation")
 		return
 	}
-	if err := json.NewEncoder(w).Encode(updated); err != nil {
-		http.Error(w, `{"error":" errore nella codifica di risposta"}`, http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromConversation(updated)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
@@ -503,52 +378,36 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 func (rt *_router) getGroupMembers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o non valido"}`, http.StatusUnauthorized)
-		return
-	}
-
-	convID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
-	if err != nil {
-		http.Error(w, `{"error":"ID conversazione non valido"}`, http.StatusBadRequest)
-		return
-	}
-
-	if _, err := rt.db.GetConversationByID(convID); err != nil {
-		http.Error(w, `{"error":"Conversazione non trovata"}`, http.StatusNotFound)
-		return
-	}
+	convID := ctx.Conversation.ID
 
-	isMember, err := rt.db.IsMember(ctx.UserUUID, convID)
+	uuids, err := rt.db.GetMembersByConversation(r.Context(), convID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore accesso DB"}`, http.StatusInternalServerError)
-		return
-	}
-	if !isMember {
-		http.Error(w, `{"error":"Non fai parte della conversazione"}`, http.StatusForbidden)
-		return
-	}
-
-	uuids, err := rt.db.GetMembersByConversation(convID)
-	if err != nil {
-		http.Error(w, `{"error":"Errore recupero membri"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't load the members")
 		return
 	}
 
 	var usernames []string
+	var admins []string
 	for _, uuid := range uuids {
-		user, err := rt.db.GetUserByUUID(uuid)
+		user, err := rt.db.GetUserByUUID(r.Context(), uuid)
 		if err != nil {
-			http.Error(w, `{"error":"Errore recupero utente"}`, http.StatusInternalServerError)
+			sendInternalError(w, ctx, err, "Can't load the user")
 			return
 		}
 		usernames = append(usernames, user.Username)
+
+		isAdmin, err := rt.db.IsAdmin(r.Context(), uuid, convID)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Database error")
+			return
+		}
+		if isAdmin {
+			admins = append(admins, user.Username)
+		}
 	}
 
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"members": usernames,
-	}); err != nil {
-		http.Error(w, `{"error":"Errore codifica risposta"}`, http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.NewMemberList(usernames, admins)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 }
@@ -556,80 +415,55 @@ func (rt *_router) getGroupMembers(w http.ResponseWriter, r *http.Request, ps ht
 func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Controlla autenticazione
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o invalido"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Prendi ID conversazione dal path
-	convID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
-	if err != nil {
-		http.Error(w, `{"error":"ID conversazione non valido"}`, http.StatusBadRequest)
-		return
-	}
+	convID := ctx.Conversation.ID
 
-	// Verifica che la conversazione esista e sia di tipo gruppo
-	conversation, err := rt.db.GetConversationByID(convID)
-	if err != nil {
-		http.Error(w, `{"error":"Conversazione non trovata"}`, http.StatusNotFound)
-		return
+	// Leggi il corpo della richiesta
+	var body struct {
+		Members []string `json:"members"`
 	}
-	if conversation.IsDirect {
-		http.Error(w, `{"error":"Non puoi aggiungere membri a una conversazione diretta"}`, http.StatusBadRequest)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Members) == 0 {
+		sendError(w, ctx, http.StatusBadRequest, "Invalid payload or missing members")
 		return
 	}
 
-	// Verifica che l’utente sia membro del gruppo
-	isMember, err := rt.db.IsMember(ctx.UserUUID, convID)
+	// Tutti i membri indicati devono essere utenti esistenti
+	members, invalid, err := rt.resolveMembers(r.Context(), body.Members)
 	if err != nil {
-		http.Error(w, `{"error":"Errore verifica membro"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Database error")
 		return
 	}
-	if !isMember {
-		http.Error(w, `{"error":"Non fai parte della conversazione"}`, http.StatusForbidden)
-		return
-	}
-
-	// Leggi il corpo della richiesta
-	var body struct {
-		Members []string `json:"members"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Members) == 0 {
-		http.Error(w, `{"error":"Payload non valido o membri mancanti"}`, http.StatusBadRequest)
+	if len(invalid) > 0 {
+		sendErrorDetails(w, ctx, http.StatusBadRequest, "Invalid members", invalid)
 		return
 	}
 
 	var added []string
 	var alreadyPresent []string
 
-	for _, uuid := range body.Members {
-		isAlready, err := rt.db.IsMember(uuid, convID)
+	for _, uuid := range members {
+		isAlready, err := rt.db.IsMember(r.Context(), uuid, convID)
 		if err != nil {
-			http.Error(w, `{"error":"Errore accesso al DB"}`, http.StatusInternalServerError)
+			sendInternalError(w, ctx, err, "Database error")
 			return
 		}
 		if isAlready {
 			alreadyPresent = append(alreadyPresent, uuid)
 			continue
 		}
+		added = append(added, uuid)
+	}
 
-		// Aggiungi il membro
-		err = rt.db.AddMember(uuid, convID)
-		if err != nil {
-			http.Error(w, `{"error":"Errore aggiunta membro"}`, http.StatusInternalServerError)
+	// Aggiungi i nuovi membri (con il relativo messaggio di sistema)
+	if len(added) > 0 {
+		if err := rt.db.AddMembers(r.Context(), convID, added, ctx.UserUUID); err != nil {
+			sendInternalError(w, ctx, err, "Can't add the members")
 			return
 		}
-		added = append(added, uuid)
 	}
 
-	// Risposta finale	convs); err != nil {
-
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"added":          added,
-		"alreadyPresent": alreadyPresent,
-	}); err != nil {
-		http.Error(w, `{"error":" errore nella codifica di risposta"}`, http.StatusInternalServerError)
+	// Risposta finale
+	if err := json.NewEncoder(w).Encode(dto.NewAddedMembers(added, alreadyPresent)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 }
@@ -637,53 +471,146 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o invalido"}`, http.StatusUnauthorized)
+	conversationID := ctx.Conversation.ID
+
+	// Rimuovi il membro e, se la conversazione resta vuota, eliminala
+	err := rt.db.WithTx(r.Context(), func(tx database.AppDatabase) error {
+		if err := tx.RemoveMember(r.Context(), ctx.UserUUID, conversationID); err != nil {
+			return err
+		}
+		return tx.DeleteConversationIfEmpty(r.Context(), conversationID)
+	})
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't leave the group")
 		return
 	}
 
-	// Parse ID conversazione
-	conversationID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
-	if err != nil {
-		http.Error(w, `{"error":"ID conversazione non valido"}`, http.StatusBadRequest)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dismissFormerGroup toglie dalla lista dell'utente un gruppo da cui è uscito o è stato rimosso
+func (rt *_router) dismissFormerGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := rt.db.DismissFormerConversation(r.Context(), ctx.UserUUID, ctx.Conversation.ID); err != nil {
+		sendDBError(w, ctx, err, "Can't dismiss the group")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeMember gestisce DELETE /conversations/:id/members/:uuid. Con "me" (o il proprio UUID) l'utente lascia il
+// gruppo o, se ne è già un ex membro, lo toglie dalla sua lista; altrimenti un amministratore rimuove un altro membro.
+func (rt *_router) removeMember(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	target := ps.ByName("uuid")
+	if target == "me" || (ctx.UserUUID != "" && target == ctx.UserUUID) {
+		isMember, err := rt.db.IsMember(r.Context(), ctx.UserUUID, ctx.Conversation.ID)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Can't check membership")
+			return
+		}
+		if !isMember {
+			rt.dismissFormerGroup(w, r, ps, ctx)
+			return
+		}
+		rt.leaveGroup(w, r, ps, ctx)
 		return
 	}
 
-	// Verifica esistenza e tipo della conversazione
-	conversation, err := rt.db.GetConversationByID(conversationID)
+	w.Header().Set("Content-Type", "application/json")
+
+	conversationID := ctx.Conversation.ID
+
+	// Solo gli amministratori del gruppo possono rimuovere altri membri
+	isAdmin, err := rt.db.IsAdmin(r.Context(), ctx.UserUUID, conversationID)
 	if err != nil {
-		http.Error(w, `{"error":"Conversazione non trovata"}`, http.StatusNotFound)
+		sendInternalError(w, ctx, err, "Can't check membership")
 		return
 	}
-	if conversation.IsDirect {
-		http.Error(w, `{"error":"Una conversazione diretta non può essere abbandonata"}`, http.StatusBadRequest)
+	if !isAdmin {
+		sendError(w, ctx, http.StatusForbidden, "Only admins can remove members")
 		return
 	}
 
-	// Verifica che l’utente sia membro
-	isMember, err := rt.db.IsMember(ctx.UserUUID, conversationID)
+	// Il membro da rimuovere deve far parte del gruppo e non essere a sua volta amministratore
+	isTargetMember, err := rt.db.IsMember(r.Context(), target, conversationID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore controllo membro"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't check membership")
 		return
 	}
-	if !isMember {
-		http.Error(w, `{"error":"Non sei membro di questa conversazione"}`, http.StatusForbidden)
+	if !isTargetMember {
+		sendError(w, ctx, http.StatusNotFound, "User is not a member of the conversation")
 		return
 	}
-
-	// Rimuovi il membro
-	err = rt.db.RemoveMember(ctx.UserUUID, conversationID)
+	isTargetAdmin, err := rt.db.IsAdmin(r.Context(), target, conversationID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore rimozione membro"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't check membership")
+		return
+	}
+	if isTargetAdmin {
+		sendError(w, ctx, http.StatusForbidden, "Admins can't be removed")
 		return
 	}
 
-	// Se la conversazione è vuota, eliminala
-	err = rt.db.DeleteConversationIfEmpty(conversationID)
-	if err != nil {
-		http.Error(w, `{"error":"Errore eliminazione conversazione"}`, http.StatusInternalServerError)
+	if err := rt.db.KickMember(r.Context(), target, conversationID, ctx.UserUUID); err != nil {
+		sendInternalError(w, ctx, err, "Can't remove the member")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// setGroupHistoryVisibility imposta quanta cronologia vedono i nuovi membri del gruppo: tutta ("full") o solo i
+// messaggi successivi al loro ingresso ("since_join"). Solo gli amministratori possono modificarla.
+func (rt *_router) setGroupHistoryVisibility(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	convID := ctx.Conversation.ID
+
+	var body struct {
+		HistoryVisibility string `json:"historyVisibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		sendError(w, ctx, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+	if body.HistoryVisibility != database.HistoryFull && body.HistoryVisibility != database.HistorySinceJoin {
+		sendError(w, ctx, http.StatusBadRequest, "Invalid history visibility")
+		return
+	}
+
+	if err := rt.db.SetGroupHistoryVisibility(r.Context(), convID, body.HistoryVisibility); err != nil {
+		sendInternalError(w, ctx, err, "Can't update the group")
+		return
+	}
+
+	// Ritorna l’oggetto aggiornato
+	updated, err := rt.db.GetConversationByID(r.Context(), convID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't read the updated conversation")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(dto.FromConversation(updated)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// searchMessages cerca un testo tra i messaggi della conversazione visibili all’utente
+func (rt *_router) searchMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query().Get("search")
+	if query == "" {
+		sendError(w, ctx, http.StatusBadRequest, "Missing search parameter")
+		return
+	}
+
+	messages, err := rt.db.SearchMessages(r.Context(), ctx.Conversation.ID, query, ctx.History)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't search messages")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(dto.MessageList{Messages: dto.FromMessages(messages)}); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
@@ -0,0 +1,116 @@
+package dto
+
+import "github.com/albyma98/WASAText/service/database"
+
+// Conversation è una conversazione 1:1 o di gruppo
+type Conversation struct {
+	ID                   int64   `json:"id"`
+	IsDirect             bool    `json:"isDirect"`
+	GroupName            *string `json:"groupName"`
+	GroupPhoto           *string `json:"groupPhoto"`
+	TimestampCreated     string  `json:"timestampCreated"`
+	TimestampLastMessage string  `json:"timestampLastMessage"`
+	RequiresApproval     bool    `json:"requiresApproval"`
+	HistoryVisibility    string  `json:"historyVisibility"`
+}
+
+// FromConversation converte una conversazione del database
+func FromConversation(c database.Conversation) Conversation {
+	return Conversation{
+		ID:                   c.ID,
+		IsDirect:             c.IsDirect,
+		GroupName:            c.GroupName,
+		GroupPhoto:           c.GroupPhoto,
+		TimestampCreated:     c.TimestampCreated,
+		TimestampLastMessage: c.TimestampLastMessage,
+		RequiresApproval:     c.RequiresApproval,
+		HistoryVisibility:    c.HistoryVisibility,
+	}
+}
+
+// ConversationSummary è una conversazione nella lista dell'utente, con l'anteprima dell'ultimo messaggio
+type ConversationSummary struct {
+	Conversation
+
+	// PeerUsername e PeerPhoto descrivono l'altro partecipante delle conversazioni 1:1
+	PeerUsername *string `json:"peerUsername,omitempty"`
+	PeerPhoto    *string `json:"peerPhoto,omitempty"`
+
+	// LastMessageText e LastMessageType sono presenti se l'ultimo messaggio è visibile all'utente
+	LastMessageText *string `json:"lastMessageText,omitempty"`
+	LastMessageType *string `json:"lastMessageType,omitempty"`
+
+	// RemovedAt è presente se l'utente è uscito o è stato rimosso dal gruppo
+	RemovedAt *string `json:"removedAt,omitempty"`
+}
+
+// ConversationList è la risposta di GET /conversations
+type ConversationList struct {
+	Conversations []ConversationSummary `json:"conversations"`
+}
+
+// ConversationDetail è la conversazione restituita da GET /conversations/:id insieme ai messaggi
+type ConversationDetail struct {
+	Conversation
+
+	UsernamePeer  *string `json:"usernamePeer,omitempty"`
+	PhotoUrlPeer  *string `json:"photoUrlPeer,omitempty"`
+	NumberMembers int     `json:"numberMembers"`
+	RemovedAt     *string `json:"removedAt,omitempty"`
+}
+
+// ConversationPage è la risposta di GET /conversations/:id
+type ConversationPage struct {
+	ConversationDetail ConversationDetail  `json:"conversationDetail"`
+	Messages           []MessageWithStatus `json:"messages"`
+}
+
+// Group è un gruppo trovato dalla ricerca dei gruppi che accettano richieste di ingresso
+type Group struct {
+	ID         int64   `json:"id"`
+	GroupName  *string `json:"groupName"`
+	GroupPhoto *string `json:"groupPhoto"`
+}
+
+// FromGroup converte una conversazione di gruppo del database
+func FromGroup(c database.Conversation) Group {
+	return Group{
+		ID:         c.ID,
+		GroupName:  c.GroupName,
+		GroupPhoto: c.GroupPhoto,
+	}
+}
+
+// GroupList è la risposta di GET /groups
+type GroupList struct {
+	Groups []Group `json:"groups"`
+}
+
+// MemberList è la risposta di GET /conversations/:id/members: gli username dei membri e, tra questi, degli
+// amministratori
+type MemberList struct {
+	Members []string `json:"members"`
+	Admins  []string `json:"admins"`
+}
+
+// NewMemberList costruisce una MemberList con liste mai nulle
+func NewMemberList(members, admins []string) MemberList {
+	return MemberList{
+		Members: nonNil(members),
+		Admins:  nonNil(admins),
+	}
+}
+
+// AddedMembers è la risposta di POST /conversations/:id/members
+type AddedMembers struct {
+	Added          []string `json:"added"`
+	AlreadyPresent []string `json:"alreadyPresent"`
+}
+
+// NewAddedMembers costruisce un AddedMembers con liste mai nulle
+func NewAddedMembers(added, alreadyPresent []string) AddedMembers {
+	return AddedMembers{
+		Added:          nonNil(added),
+		AlreadyPresent: nonNil(alreadyPresent),
+	}
+}
@@ -0,0 +1,21 @@
+/*
+Package dto contiene i tipi delle risposte dell'API e le funzioni che li costruiscono a partire dai tipi del package
+database.
+
+Gli handler non codificano mai direttamente le strutture del database: la forma JSON di una risposta è decisa solo
+da questo package, così una modifica al database non cambia l'API senza che ce ne si accorga. Tutti i campi usano nomi
+in camelCase e le liste vuote sono codificate come [] e non come null.
+
+I tipi descrivono la versione 1 dell'API (doc/api.yaml). Si possono aggiungere campi, ma non rinominarli, rimuoverli
+o cambiarne il tipo: una modifica incompatibile richiede un nuovo tipo per la nuova versione (ad esempio MessageV2),
+lasciando invariato quello esistente.
+*/
+package dto
+
+// nonNil restituisce list, oppure una lista vuota se list è nil, così che venga codificata come []
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,14 @@
+package dto
+
+// Export è lo stato di un'esportazione dei dati dell'utente, restituito da POST e GET /user/me/export
+type Export struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"createdAt"`
+
+	// ExpiresAt è presente quando l'esportazione è terminata: da quel momento l'archivio non è più disponibile
+	ExpiresAt *string `json:"expiresAt,omitempty"`
+
+	// Size è la dimensione in byte dell'archivio, presente quando è pronto
+	Size *int64 `json:"size,omitempty"`
+}
@@ -0,0 +1,65 @@
+package dto
+
+import "github.com/albyma98/WASAText/service/database"
+
+// JoinRequest è una richiesta di ingresso in un gruppo
+type JoinRequest struct {
+	UUIDUser         string  `json:"uuidUser"`
+	IDConversation   int64   `json:"idConversation"`
+	Status           string  `json:"status"`
+	TimestampCreated string  `json:"timestampCreated"`
+	TimestampDecided *string `json:"timestampDecided"`
+	UUIDDecidedBy    *string `json:"uuidDecidedBy"`
+}
+
+// FromJoinRequest converte una richiesta di ingresso del database
+func FromJoinRequest(jr database.JoinRequest) JoinRequest {
+	return JoinRequest{
+		UUIDUser:         jr.UUIDUser,
+		IDConversation:   jr.IDConversation,
+		Status:           jr.Status,
+		TimestampCreated: jr.TimestampCreated,
+		TimestampDecided: jr.TimestampDecided,
+		UUIDDecidedBy:    jr.UUIDDecidedBy,
+	}
+}
+
+// PendingJoinRequest è una richiesta in attesa mostrata ai membri del gruppo, con lo username del richiedente
+type PendingJoinRequest struct {
+	JoinRequest
+	Username string `json:"username"`
+}
+
+// PendingJoinRequestList è la risposta di GET /conversations/:id/join-requests
+type PendingJoinRequestList struct {
+	Requests []PendingJoinRequest `json:"requests"`
+}
+
+// MyJoinRequest è una richiesta dell'utente autenticato, con il nome del gruppo
+type MyJoinRequest struct {
+	JoinRequest
+	GroupName *string `json:"groupName"`
+}
+
+// MyJoinRequestList è la risposta di GET /user/me/join-requests
+type MyJoinRequestList struct {
+	Requests []MyJoinRequest `json:"requests"`
+}
+
+// GroupInvite è il codice di invito di un gruppo, restituito ai suoi amministratori
+type GroupInvite struct {
+	IDConversation   int64   `json:"idConversation"`
+	Code             string  `json:"code"`
+	UUIDCreatedBy    *string `json:"uuidCreatedBy"`
+	TimestampCreated string  `json:"timestampCreated"`
+}
+
+// FromGroupInvite converte un codice di invito del database
+func FromGroupInvite(i database.GroupInvite) GroupInvite {
+	return GroupInvite{
+		IDConversation:   i.IDConversation,
+		Code:             i.Code,
+		UUIDCreatedBy:    i.UUIDCreatedBy,
+		TimestampCreated: i.TimestampCreated,
+	}
+}
@@ -0,0 +1,167 @@
+package dto
+
+import "github.com/albyma98/WASAText/service/database"
+
+// Message è un messaggio di una conversazione
+type Message struct {
+	ID              int64        `json:"id"`
+	Type            string       `json:"type"`
+	Content         string       `json:"content"`
+	MediaUrl        *string      `json:"mediaUrl"`
+	Timestamp       string       `json:"timestamp"`
+	IDConversation  int64        `json:"idConversation"`
+	UUIDSender      string       `json:"uuidSender"`
+	IDRepliesTo     *int64       `json:"idRepliesTo"`
+	IDForwardedFrom *int64       `json:"idForwardedFrom"`
+	Reactions       []Reaction   `json:"reactions"`
+	System          *SystemEvent `json:"system,omitempty"`
+}
+
+// FromMessage converte un messaggio del database. Gli username dell'evento di sistema, se presente, non sono
+// valorizzati: li aggiunge chi ha bisogno di mostrarli.
+func FromMessage(m database.Message) Message {
+	out := Message{
+		ID:              m.ID,
+		Type:            m.Type,
+		Content:         m.Content,
+		MediaUrl:        m.MediaUrl,
+		Timestamp:       m.Timestamp,
+		IDConversation:  m.IDConversation,
+		UUIDSender:      m.UUIDSender,
+		IDRepliesTo:     m.IDRepliesTo,
+		IDForwardedFrom: m.IDForwardedFrom,
+		Reactions:       FromReactions(m.Reactions),
+	}
+	if m.System != nil {
+		ev := FromSystemEvent(*m.System)
+		out.System = &ev
+	}
+	return out
+}
+
+// FromMessages converte una lista di messaggi del database
+func FromMessages(messages []database.Message) []Message {
+	out := make([]Message, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, FromMessage(m))
+	}
+	return out
+}
+
+// MessageList è la risposta della ricerca dei messaggi
+type MessageList struct {
+	Messages []Message `json:"messages"`
+}
+
+// MessageWithStatus è un messaggio nella cronologia di una conversazione, con gli stati di consegna e lettura e i dati
+// per la visualizzazione
+type MessageWithStatus struct {
+	Message
+
+	// Delivered e Seen sono gli UUID degli utenti a cui il messaggio è stato consegnato e che lo hanno visto
+	Delivered []string `json:"delivered"`
+	Seen      []string `json:"seen"`
+
+	UsernameSender string        `json:"usernameSender"`
+	ReplyToMessage *ReplyPreview `json:"replyToMessage,omitempty"`
+}
+
+// NewMessageWithStatus costruisce un MessageWithStatus dal messaggio e dai suoi stati
+func NewMessageWithStatus(m database.Message, statuses []database.MessageStatus, usernameSender string) MessageWithStatus {
+	out := MessageWithStatus{
+		Message:        FromMessage(m),
+		Delivered:      []string{},
+		Seen:           []string{},
+		UsernameSender: usernameSender,
+	}
+	for _, st := range statuses {
+		if st.Delivered {
+			out.Delivered = append(out.Delivered, st.UUIDUser)
+		}
+		if st.Seen {
+			out.Seen = append(out.Seen, st.UUIDUser)
+		}
+	}
+	return out
+}
+
+// ReplyPreview è l'anteprima del messaggio a cui si risponde
+type ReplyPreview struct {
+	Type     string  `json:"type"`
+	Content  string  `json:"content"`
+	MediaUrl *string `json:"mediaUrl"`
+}
+
+// FromReplied costruisce l'anteprima del messaggio originale a cui si risponde
+func FromReplied(original database.Message) ReplyPreview {
+	return ReplyPreview{
+		Type:     original.Type,
+		Content:  original.Content,
+		MediaUrl: original.MediaUrl,
+	}
+}
+
+// SystemEvent è il contenuto strutturato di un messaggio di sistema
+type SystemEvent struct {
+	Kind            string   `json:"kind"`
+	Actor           string   `json:"actor"`
+	ActorUsername   string   `json:"actorUsername,omitempty"`
+	Targets         []string `json:"targets,omitempty"`
+	TargetUsernames []string `json:"targetUsernames,omitempty"`
+	OldValue        *string  `json:"oldValue,omitempty"`
+	NewValue        *string  `json:"newValue,omitempty"`
+}
+
+// FromSystemEvent converte un evento di sistema del database
+func FromSystemEvent(ev database.SystemEvent) SystemEvent {
+	return SystemEvent{
+		Kind:     ev.Kind,
+		Actor:    ev.Actor,
+		Targets:  ev.Targets,
+		OldValue: ev.OldValue,
+		NewValue: ev.NewValue,
+	}
+}
+
+// Reaction è la reazione di un utente a un messaggio
+type Reaction struct {
+	UUIDUser string `json:"uuidUser"`
+	Username string `json:"username"`
+	Emoji    string `json:"emoji"`
+}
+
+// FromReaction converte una reazione del database
+func FromReaction(r database.ReactionWithUser) Reaction {
+	return Reaction{
+		UUIDUser: r.UUIDUser,
+		Username: r.Username,
+		Emoji:    r.Emoji,
+	}
+}
+
+// FromReactions converte una lista di reazioni del database
+func FromReactions(reactions []database.ReactionWithUser) []Reaction {
+	out := make([]Reaction, 0, len(reactions))
+	for _, r := range reactions {
+		out = append(out, FromReaction(r))
+	}
+	return out
+}
+
+// MessageStatus è lo stato di un messaggio per un utente
+type MessageStatus struct {
+	UUIDUser  string `json:"uuidUser"`
+	IDMessage int64  `json:"idMessage"`
+	Delivered bool   `json:"delivered"`
+	Seen      bool   `json:"seen"`
+}
+
+// FromMessageStatus converte uno stato del database
+func FromMessageStatus(s database.MessageStatus) MessageStatus {
+	return MessageStatus{
+		UUIDUser:  s.UUIDUser,
+		IDMessage: s.IDMessage,
+		Delivered: s.Delivered,
+		Seen:      s.Seen,
+	}
+}
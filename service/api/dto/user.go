@@ -0,0 +1,33 @@
+package dto
+
+import "github.com/albyma98/WASAText/service/database"
+
+// User è un utente
+type User struct {
+	UUID     string  `json:"uuid"`
+	Username string  `json:"username"`
+	PhotoUrl *string `json:"photoUrl"`
+}
+
+// FromUser converte un utente del database. Le foto mai impostate sono salvate come stringa vuota: nella risposta
+// diventano null.
+func FromUser(u database.User) User {
+	photo := u.PhotoUrl
+	if photo != nil && *photo == "" {
+		photo = nil
+	}
+	return User{
+		UUID:     u.UUID,
+		Username: u.Username,
+		PhotoUrl: photo,
+	}
+}
+
+// FromUsers converte una lista di utenti del database
+func FromUsers(users []database.User) []User {
+	out := make([]User, 0, len(users))
+	for _, u := range users {
+		out = append(out, FromUser(u))
+	}
+	return out
+}
@@ -0,0 +1,1020 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albyma98/WASAText/service/api/dto"
+	"github.com/albyma98/WASAText/service/database"
+	"github.com/albyma98/WASAText/service/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// TestMain, dopo i test, confronta con la specifica OpenAPI tutte le richieste servite dagli harness (vedi specDrift).
+// Quando vengono eseguiti tutti i test del package controlla anche che ogni route registrata in Handler sia stata
+// chiamata almeno una volta: una nuova route senza test fa fallire la suite.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if drift := specDrift(); len(drift) > 0 {
+		for _, d := range drift {
+			fmt.Fprintf(os.Stderr, "differenza da doc/api.yaml: %s\n", d)
+		}
+		code = 1
+	}
+	if code == 0 && flag.Lookup("test.run").Value.String() == "" {
+		if missing := uncoveredRoutes(); len(missing) > 0 {
+			for _, r := range missing {
+				fmt.Fprintf(os.Stderr, "route senza test end-to-end: %s %s\n", r.method, r.path)
+			}
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// uncoveredRoutes restituisce le route registrate che non compaiono in traffic
+func uncoveredRoutes() []route {
+	trafficMu.Lock()
+	defer trafficMu.Unlock()
+
+	hit := make(map[route]bool)
+	for _, ex := range traffic {
+		hit[ex.route] = true
+	}
+	var missing []route
+	for _, r := range registeredRoutes {
+		if !hit[r] {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+func TestServiceRoutes(t *testing.T) {
+	h := newHarness(t)
+	anon := h.anonymous().unversioned()
+
+	anon.get("/").expect(http.StatusOK)
+	anon.get("/liveness").expect(http.StatusOK)
+	anon.get("/context").expect(http.StatusOK)
+	anon.get("/non-esiste").expect(http.StatusNotFound)
+}
+
+func TestReadiness(t *testing.T) {
+	inTempDir(t)
+	h := newHarness(t)
+	anon := h.anonymous().unversioned()
+
+	var report healthReport
+	anon.get("/readiness").expect(http.StatusOK).decode(&report)
+	for _, name := range []string{"database", "migrations", "media", "shutdown"} {
+		if report.Checks[name].Status != healthOK {
+			t.Errorf("controllo %s: %+v", name, report.Checks[name])
+		}
+	}
+
+	// Dopo Close il server non è più pronto, ma è ancora vivo
+	if err := h.rt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	report = healthReport{}
+	resp := anon.get("/readiness").expect(http.StatusServiceUnavailable).decode(&report)
+	if bytes.Contains(resp.body, []byte("shutting down")) {
+		t.Fatalf("il motivo del fallimento va solo nel log: %s", resp.body)
+	}
+	if report.Status != healthUnavailable || report.Checks["shutdown"].Status != healthUnavailable ||
+		report.Checks["database"].Status != healthOK {
+		t.Fatalf("esito inatteso dopo Close: %+v", report)
+	}
+	anon.get("/liveness").expect(http.StatusOK)
+}
+
+// TestRequestID controlla l'header X-Request-ID e l'access log, che non deve contenere il token né l'UUID dell'utente
+func TestRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	h := newHarness(t, func(cfg *Config) {
+		logger := logrus.New()
+		logger.SetOutput(&logs)
+		logger.SetLevel(logrus.DebugLevel)
+		logger.SetFormatter(&logrus.JSONFormatter{})
+		cfg.Logger = logger
+		cfg.BehindProxy = true
+	})
+	alice := h.login("alice")
+
+	var errResp errorResponse
+	resp := alice.get("/conversations/999999").expect(http.StatusNotFound).decode(&errResp)
+	if id := resp.header.Get("X-Request-ID"); id == "" || id != errResp.RequestID {
+		t.Fatalf("X-Request-ID %q diverso dal requestId della risposta %q", id, errResp.RequestID)
+	}
+
+	// Un ID valido ricevuto da un proxy viene riusato, uno non valido sostituito
+	upstream := "6f1c1f1e-3a3b-4c4d-8e8f-9a9b9c9d9e9f"
+	for header, reused := range map[string]bool{upstream: true, "non-valido": false} {
+		req, err := http.NewRequest(http.MethodGet, h.server.URL+"/v1/user/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Request-ID", header)
+		id := alice.send(req).expect(http.StatusOK).header.Get("X-Request-ID")
+		if (id == header) != reused || id == "" {
+			t.Errorf("X-Request-ID %q ricevuto, %q restituito", header, id)
+		}
+	}
+
+	var access map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["msg"] == "request served" && entry["reqid"] == upstream {
+			access = entry
+		}
+	}
+	if access == nil {
+		t.Fatalf("access log della richiesta %s mancante:\n%s", upstream, logs.String())
+	}
+	if access["route"] != "/v1/user/me" || access["status"] != float64(http.StatusOK) || access["user"] != userLogID(alice.uuid) {
+		t.Errorf("access log inatteso: %v", access)
+	}
+	if strings.Contains(logs.String(), "Bearer") || strings.Contains(logs.String(), alice.uuid) {
+		t.Errorf("il token compare nel log:\n%s", logs.String())
+	}
+
+	// Senza proxy l'header è scritto dal client e non viene riusato
+	direct := newHarness(t)
+	bob := direct.login("bob")
+	req, err := http.NewRequest(http.MethodGet, direct.server.URL+"/v1/user/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", upstream)
+	if id := bob.send(req).expect(http.StatusOK).header.Get("X-Request-ID"); id == upstream || id == "" {
+		t.Errorf("X-Request-ID del client riusato senza proxy: %q", id)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		behindProxy bool
+		forwarded   []string
+		want        string
+	}{
+		{false, nil, "192.0.2.1"},
+		{false, []string{"203.0.113.7"}, "192.0.2.1"},
+		{true, nil, "192.0.2.1"},
+		{true, []string{"203.0.113.7"}, "203.0.113.7"},
+		{true, []string{"10.0.0.1, 203.0.113.7"}, "203.0.113.7"},
+		{true, []string{"10.0.0.1", "203.0.113.8"}, "203.0.113.8"},
+		{true, []string{"non-un-ip"}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		rt := &_router{behindProxy: tt.behindProxy}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:51234"
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := rt.clientIP(r); got != tt.want {
+			t.Errorf("clientIP(behindProxy=%v, X-Forwarded-For=%q) = %q, atteso %q", tt.behindProxy, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	h := newHarness(t, func(cfg *Config) {
+		cfg.RateLimits = RateLimits{
+			Session:  RateLimit{Requests: 3, Per: time.Minute},
+			Messages: RateLimit{Requests: 1, Per: time.Hour},
+			IPFactor: 3,
+		}
+	})
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+
+	// POST /session è limitata per IP: tutti i client del test hanno lo stesso
+	resp := h.anonymous().post("/session", map[string]string{"username": "dave"}).expectError(http.StatusTooManyRequests, codeRateLimited)
+	if retry, err := strconv.Atoi(resp.header.Get("Retry-After")); err != nil || retry < 1 || retry > 20 {
+		t.Fatalf("Retry-After inatteso: %q", resp.header.Get("Retry-After"))
+	}
+
+	// L'invio dei messaggi è limitato per utente
+	convID := alice.createDirect("bob")
+	alice.sendText(convID, "ciao")
+	alice.post(fmt.Sprintf("/conversations/%d/messages", convID), map[string]string{"type": "text", "content": "ancora"}).
+		expectError(http.StatusTooManyRequests, codeRateLimited)
+	bob.sendText(convID, "ciao a te")
+
+	// ...e anche per IP: le tre richieste precedenti, compresa quella rifiutata, hanno esaurito il budget dell'IP
+	// comune, anche per un utente che non ha ancora inviato nulla
+	carolConv := carol.createDirect("alice")
+	carol.post(fmt.Sprintf("/conversations/%d/messages", carolConv), map[string]string{"type": "text", "content": "ciao"}).
+		expectError(http.StatusTooManyRequests, codeRateLimited)
+
+	// Le route senza limite non sono toccate
+	alice.get("/user/me").expect(http.StatusOK)
+}
+
+func TestTokenBucket(t *testing.T) {
+	l := newRateLimiter(RateLimit{Requests: 2, Per: 10 * time.Second})
+	now := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("richiesta %d rifiutata", i+1)
+		}
+	}
+	if ok, wait := l.allow("a", now); ok || wait != 5*time.Second {
+		t.Fatalf("terza richiesta: ok=%v, attesa %v invece di 5s", ok, wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Fatal("il budget di un client non deve dipendere dagli altri")
+	}
+
+	// Dopo 5 secondi è tornato un token; dopo 10 il bucket è pieno e cleanup lo elimina
+	if ok, _ := l.allow("a", now.Add(5*time.Second)); !ok {
+		t.Fatal("token non ricaricato dopo 5s")
+	}
+	l.cleanup(now.Add(15 * time.Second))
+	if len(l.buckets) != 0 {
+		t.Fatalf("bucket non eliminati: %d", len(l.buckets))
+	}
+}
+
+// spanRecorder è un tracing.Exporter che tiene in memoria gli span esportati
+type spanRecorder struct {
+	spans []tracing.SpanData
+}
+
+func (e *spanRecorder) Export(_ context.Context, _ string, spans []tracing.SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// TestTracing controlla che lo span di una richiesta continui la traccia del client e che le chiamate al database
+// ne siano figlie
+func TestTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer := tracing.New("test", recorder)
+	h := newHarness(t, func(cfg *Config) { cfg.Tracer = tracer })
+	alice := h.login("alice")
+	h.login("bob")
+	convID := alice.createDirect("bob")
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/conversations/%d", h.server.URL, convID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", parent)
+	resp := alice.send(req).expect(http.StatusOK)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := tracing.ParseTraceParent(resp.header.Get("traceresponse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("traceresponse non continua la traccia del client: %s", resp.header.Get("traceresponse"))
+	}
+
+	var server *tracing.SpanData
+	dbSpans := 0
+	for i, span := range recorder.spans {
+		switch {
+		case span.SpanID == sc.SpanID:
+			server = &recorder.spans[i]
+		case span.TraceID == sc.TraceID && span.Parent == sc.SpanID && strings.HasPrefix(span.Name, "db."):
+			dbSpans++
+		}
+	}
+	if server == nil {
+		t.Fatal("span della richiesta non esportato")
+	}
+	if server.Name != "GET /v1/conversations/:id" || server.Parent.String() != "00f067aa0ba902b7" ||
+		server.Attributes["http.status_code"] != http.StatusOK || server.Attributes["enduser.id"] != userLogID(alice.uuid) {
+		t.Errorf("span della richiesta inatteso: %+v", server)
+	}
+	if dbSpans == 0 {
+		t.Error("nessuno span del database figlio di quello della richiesta")
+	}
+}
+
+// TestLegacyAliases controlla che ogni route della versione 1 risponda anche senza prefisso, con gli header di
+// deprecazione, e che le route con prefisso non li abbiano
+func TestLegacyAliases(t *testing.T) {
+	sunset := time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+	h := newHarness(t, func(cfg *Config) { cfg.LegacySunset = sunset })
+
+	versioned := make(map[route]bool)
+	for _, r := range h.rt.routes {
+		versioned[r] = true
+	}
+	for _, alias := range h.rt.aliases {
+		if !versioned[route{method: alias.method, path: apiV1.prefix + alias.path}] {
+			t.Errorf("alias senza la route corrispondente in %s: %s %s", apiV1.prefix, alias.method, alias.path)
+		}
+	}
+
+	alice := h.login("alice")
+	resp := alice.unversioned().get("/user/me").expect(http.StatusOK)
+	if resp.header.Get("Deprecation") == "" ||
+		resp.header.Get("Sunset") != "Fri, 30 Apr 2027 00:00:00 GMT" ||
+		resp.header.Get("Link") != `</v1/user/me>; rel="successor-version"` {
+		t.Fatalf("header di deprecazione inattesi: %v", resp.header)
+	}
+	var me dto.User
+	resp.decode(&me)
+	if me.UUID != alice.uuid {
+		t.Fatalf("l'alias non risponde come la route originale: %+v", me)
+	}
+
+	resp = alice.get("/user/me").expect(http.StatusOK)
+	if resp.header.Get("Deprecation") != "" || resp.header.Get("Sunset") != "" {
+		t.Fatalf("header di deprecazione su una route con prefisso: %v", resp.header)
+	}
+}
+
+func TestSession(t *testing.T) {
+	h := newHarness(t)
+	anon := h.anonymous()
+
+	var first, second dto.User
+	anon.post("/session", map[string]string{"username": "alice"}).expect(http.StatusCreated).decode(&first)
+	anon.post("/session", map[string]string{"username": "alice"}).expect(http.StatusOK).decode(&second)
+	if first.UUID == "" || first.UUID != second.UUID {
+		t.Fatalf("il secondo login deve restituire lo stesso utente: %+v, %+v", first, second)
+	}
+
+	anon.post("/session", "{").expectError(http.StatusBadRequest, codeBadRequest)
+	anon.post("/session", map[string]string{"username": "al"}).expectError(http.StatusBadRequest, codeBadRequest)
+	anon.post("/session", map[string]string{"username": "al ice"}).expectError(http.StatusBadRequest, codeBadRequest)
+}
+
+func TestAuthRequired(t *testing.T) {
+	h := newHarness(t)
+	anon := h.anonymous()
+	alice := h.login("alice")
+
+	anon.get("/user/me").expectError(http.StatusUnauthorized, codeUnauthorized)
+	anon.get("/conversations").expectError(http.StatusUnauthorized, codeUnauthorized)
+	alice.withToken("non-un-token").get("/user/me").expectError(http.StatusUnauthorized, codeUnauthorized)
+}
+
+func TestUsers(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	h.login("bob")
+
+	var me dto.User
+	alice.get("/user/me").expect(http.StatusOK).decode(&me)
+	if me.UUID != alice.uuid || me.Username != "alice" {
+		t.Fatalf("utente inatteso: %+v", me)
+	}
+
+	alice.put("/user/me/username", map[string]string{"username": "alice2"}).expect(http.StatusOK).decode(&me)
+	if me.Username != "alice2" {
+		t.Fatalf("username non aggiornato: %+v", me)
+	}
+	alice.put("/user/me/username", map[string]string{"username": "bob"}).expectError(http.StatusConflict, codeConflict)
+	alice.put("/user/me/username", map[string]string{"username": "x"}).expectError(http.StatusBadRequest, codeBadRequest)
+	alice.put("/user/me/username", "{").expectError(http.StatusBadRequest, codeBadRequest)
+
+	var all []dto.User
+	alice.get("/user/all").expect(http.StatusOK).decode(&all)
+	if len(all) != 2 {
+		t.Fatalf("attesi 2 utenti, trovati %d", len(all))
+	}
+
+	var found []dto.User
+	alice.get("/user?search=bo").expect(http.StatusOK).decode(&found)
+	if len(found) != 1 || found[0].Username != "bob" {
+		t.Fatalf("ricerca inattesa: %+v", found)
+	}
+	alice.get("/user?search=").expectError(http.StatusBadRequest, codeBadRequest)
+	alice.get("/user?search=%%25").expectError(http.StatusBadRequest, codeBadRequest)
+}
+
+func TestUserPhoto(t *testing.T) {
+	inTempDir(t)
+	h := newHarness(t)
+	alice := h.login("alice")
+
+	var me dto.User
+	alice.upload("/user/me/photo", "me.png", []byte("png")).expect(http.StatusOK).decode(&me)
+	if me.PhotoUrl == nil {
+		t.Fatal("foto non impostata")
+	}
+	if _, err := os.Stat("webui/public" + *me.PhotoUrl); err != nil {
+		t.Fatalf("file della foto non salvato: %v", err)
+	}
+
+	alice.put("/user/me/photo", map[string]string{}).expectError(http.StatusBadRequest, codeBadRequest)
+}
+
+func TestDirectConversation(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+
+	conv := alice.createDirect("bob")
+	alice.post("/conversations", map[string]interface{}{"isDirect": true, "members": []string{bob.uuid}}).
+		expectError(http.StatusConflict, codeConflict)
+	alice.post("/conversations", map[string]interface{}{"isDirect": true, "members": []string{"alice"}}).
+		expectError(http.StatusBadRequest, codeBadRequest)
+	alice.post("/conversations", map[string]interface{}{"isDirect": true, "members": []string{"nessuno"}}).
+		expectError(http.StatusBadRequest, codeBadRequest)
+	alice.post("/conversations", "{").expectError(http.StatusBadRequest, codeBadRequest)
+
+	alice.sendText(conv, "ciao bob")
+
+	var list struct {
+		Conversations []struct {
+			ID              int64   `json:"id"`
+			PeerUsername    *string `json:"peerUsername"`
+			LastMessageText *string `json:"lastMessageText"`
+		} `json:"conversations"`
+	}
+	bob.get("/conversations").expect(http.StatusOK).decode(&list)
+	if len(list.Conversations) != 1 || list.Conversations[0].ID != conv ||
+		list.Conversations[0].PeerUsername == nil || *list.Conversations[0].PeerUsername != "alice" ||
+		list.Conversations[0].LastMessageText == nil || *list.Conversations[0].LastMessageText != "ciao bob" {
+		t.Fatalf("elenco conversazioni inatteso: %+v", list)
+	}
+
+	var detail struct {
+		ConversationDetail struct {
+			UsernamePeer  *string `json:"usernamePeer"`
+			NumberMembers int     `json:"numberMembers"`
+		} `json:"conversationDetail"`
+		Messages []struct {
+			Content        string `json:"content"`
+			UsernameSender string `json:"usernameSender"`
+		} `json:"messages"`
+	}
+	bob.get("/conversations/%d", conv).expect(http.StatusOK).decode(&detail)
+	if detail.ConversationDetail.NumberMembers != 2 || len(detail.Messages) != 1 ||
+		detail.Messages[0].UsernameSender != "alice" {
+		t.Fatalf("dettaglio inatteso: %+v", detail)
+	}
+
+	carol.get("/conversations/%d", conv).expectError(http.StatusForbidden, codeForbidden)
+	alice.get("/conversations/%d", conv+100).expectError(http.StatusNotFound, codeNotFound)
+	alice.get("/conversations/abc").expectError(http.StatusBadRequest, codeBadRequest)
+
+	// Le operazioni sui gruppi non sono ammesse sulle chat dirette
+	alice.put(fmt.Sprintf("/conversations/%d/name", conv), map[string]string{"groupName": "nuovo"}).
+		expectError(http.StatusBadRequest, codeBadRequest)
+}
+
+func TestGroupConversation(t *testing.T) {
+	inTempDir(t)
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+	dave := h.login("dave")
+
+	conv := alice.createGroup("amici", "bob")
+	base := fmt.Sprintf("/conversations/%d", conv)
+
+	alice.post("/conversations", map[string]interface{}{"isDirect": false, "groupName": "x", "members": []string{"bob"}}).
+		expectError(http.StatusBadRequest, codeBadRequest)
+	alice.post("/conversations", map[string]interface{}{"isDirect": false, "groupName": "gruppo", "members": []string{"nessuno"}}).
+		expectError(http.StatusBadRequest, codeBadRequest)
+
+	var updated dto.Conversation
+	bob.put(base+"/name", map[string]string{"groupName": "amici_veri"}).expect(http.StatusOK).decode(&updated)
+	if updated.GroupName == nil || *updated.GroupName != "amici_veri" {
+		t.Fatalf("nome non aggiornato: %+v", updated)
+	}
+	bob.put(base+"/name", map[string]string{"groupName": "no spazi"}).expectError(http.StatusBadRequest, codeBadRequest)
+	carol.put(base+"/name", map[string]string{"groupName": "intrusa"}).expectError(http.StatusForbidden, codeForbidden)
+
+	bob.upload(base+"/photo", "gruppo.jpg", []byte("jpg")).expect(http.StatusOK).decode(&updated)
+	if updated.GroupPhoto == nil {
+		t.Fatal("foto del gruppo non impostata")
+	}
+	bob.put(base+"/photo", map[string]string{}).expectError(http.StatusBadRequest, codeBadRequest)
+
+	var added struct {
+		Added          []string `json:"added"`
+		AlreadyPresent []string `json:"alreadyPresent"`
+	}
+	bob.post(base+"/members", map[string][]string{"members": {"carol", "alice"}}).expect(http.StatusOK).decode(&added)
+	if len(added.Added) != 1 || added.Added[0] != carol.uuid || len(added.AlreadyPresent) != 1 {
+		t.Fatalf("aggiunta inattesa: %+v", added)
+	}
+	bob.post(base+"/members", map[string][]string{"members": {}}).expectError(http.StatusBadRequest, codeBadRequest)
+	bob.post(base+"/members", map[string][]string{"members": {"nessuno"}}).expectError(http.StatusBadRequest, codeBadRequest)
+
+	var members struct {
+		Members []string `json:"members"`
+		Admins  []string `json:"admins"`
+	}
+	carol.get(base + "/members").expect(http.StatusOK).decode(&members)
+	if len(members.Members) != 3 || len(members.Admins) != 1 || members.Admins[0] != "alice" {
+		t.Fatalf("membri inattesi: %+v", members)
+	}
+	dave.get(base+"/members").expectError(http.StatusForbidden, codeForbidden)
+
+	alice.put(base+"/history-visibility", map[string]string{"historyVisibility": database.HistorySinceJoin}).
+		expect(http.StatusOK).decode(&updated)
+	if updated.HistoryVisibility != database.HistorySinceJoin {
+		t.Fatalf("visibilità non aggiornata: %+v", updated)
+	}
+	alice.put(base+"/history-visibility", map[string]string{"historyVisibility": "qualcosa"}).
+		expectError(http.StatusBadRequest, codeBadRequest)
+	bob.put(base+"/history-visibility", map[string]string{"historyVisibility": database.HistoryFull}).
+		expectError(http.StatusForbidden, codeForbidden)
+}
+
+// TestHistoryVisibility controlla che con la politica since_join un nuovo membro non veda i messaggi precedenti al suo
+// ingresso, né come anteprima delle risposte, né nella ricerca, anche se inviati nello stesso secondo
+func TestHistoryVisibility(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+
+	conv := alice.createGroup("riservato", "bob")
+	base := fmt.Sprintf("/conversations/%d", conv)
+	alice.put(base+"/history-visibility", map[string]string{"historyVisibility": database.HistorySinceJoin}).
+		expect(http.StatusOK)
+
+	before := alice.sendText(conv, "segreto di prima")
+	alice.post(base+"/members", map[string][]string{"members": {"carol"}}).expect(http.StatusOK)
+	reply := bob.post(base+"/messages", map[string]interface{}{"type": "text", "content": "risposta", "idRepliesTo": before})
+	var replyMsg dto.Message
+	reply.expect(http.StatusCreated).decode(&replyMsg)
+
+	var page dto.ConversationPage
+	carol.get(base).expect(http.StatusOK).decode(&page)
+	var texts []string
+	for _, m := range page.Messages {
+		if m.ID == before {
+			t.Fatalf("carol vede un messaggio precedente al suo ingresso: %+v", m)
+		}
+		if m.ID == replyMsg.ID && m.ReplyToMessage != nil {
+			t.Fatalf("carol vede l'anteprima di un messaggio precedente al suo ingresso: %+v", m.ReplyToMessage)
+		}
+		if m.Type == "text" {
+			texts = append(texts, m.Content)
+		}
+	}
+	if len(texts) != 1 || texts[0] != "risposta" {
+		t.Fatalf("messaggi visibili a carol inattesi: %+v", page.Messages)
+	}
+
+	var found dto.MessageList
+	carol.get(base + "/messages?search=segreto").expect(http.StatusOK).decode(&found)
+	if len(found.Messages) != 0 {
+		t.Fatalf("la ricerca mostra a carol messaggi precedenti al suo ingresso: %+v", found)
+	}
+	carol.post(base+"/messages", map[string]interface{}{"type": "text", "content": "cioè?", "idRepliesTo": before}).
+		expectError(http.StatusNotFound, codeNotFound)
+
+	// Chi era già nel gruppo continua a vedere tutto
+	bob.get(base).expect(http.StatusOK).decode(&page)
+	if len(page.Messages) == 0 || page.Messages[len(page.Messages)-1].ReplyToMessage == nil {
+		t.Fatalf("bob deve vedere l'anteprima della risposta: %+v", page.Messages)
+	}
+}
+
+func TestRemoveMembers(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+
+	conv := alice.createGroup("amici", "bob", "carol")
+	base := fmt.Sprintf("/conversations/%d", conv)
+	alice.sendText(conv, "prima della rimozione")
+
+	bob.delete(base+"/members/%s", carol.uuid).expectError(http.StatusForbidden, codeForbidden)
+	alice.delete(base+"/members/%s", "sconosciuto").expectError(http.StatusNotFound, codeNotFound)
+	alice.delete(base+"/members/%s", carol.uuid).expect(http.StatusNoContent)
+
+	// L'ex membro legge ancora la cronologia fino alla rimozione, ma non può più scrivere
+	var detail struct {
+		ConversationDetail struct {
+			RemovedAt *string `json:"removedAt"`
+		} `json:"conversationDetail"`
+	}
+	carol.get(base).expect(http.StatusOK).decode(&detail)
+	if detail.ConversationDetail.RemovedAt == nil {
+		t.Fatalf("removedAt mancante per l'ex membro")
+	}
+	carol.post(base+"/messages", map[string]string{"type": "text", "content": "ci sono?"}).
+		expectError(http.StatusForbidden, codeForbidden)
+	carol.delete(base+"/members/%s", bob.uuid).expectError(http.StatusForbidden, codeForbidden)
+
+	// Chi esce diventa un ex membro come chi viene rimosso
+	bob.delete(base + "/members/me").expect(http.StatusNoContent)
+	var list struct {
+		Conversations []struct {
+			ID        int64   `json:"id"`
+			RemovedAt *string `json:"removedAt"`
+		} `json:"conversations"`
+	}
+	bob.get("/conversations").expect(http.StatusOK).decode(&list)
+	if len(list.Conversations) != 1 || list.Conversations[0].ID != conv || list.Conversations[0].RemovedAt == nil {
+		t.Fatalf("il gruppo lasciato deve restare nella lista: %+v", list)
+	}
+	bob.get(base).expect(http.StatusOK)
+
+	// L'ex membro toglie il gruppo dalla sua lista e non ne vede più la cronologia
+	carol.delete(base + "/members/me").expect(http.StatusNoContent)
+	carol.delete(base+"/members/me").expectError(http.StatusForbidden, codeForbidden)
+	carol.get(base).expectError(http.StatusForbidden, codeForbidden)
+	list.Conversations = nil
+	carol.get("/conversations").expect(http.StatusOK).decode(&list)
+	if len(list.Conversations) != 0 {
+		t.Fatalf("il gruppo tolto non deve comparire nella lista: %+v", list)
+	}
+
+	alice.delete(base + "/members/me").expect(http.StatusNoContent)
+
+	// Uscito l'ultimo membro il gruppo viene eliminato
+	alice.get(base).expectError(http.StatusNotFound, codeNotFound)
+}
+
+func TestJoinRequests(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+
+	conv := alice.createGroup("club", "bob")
+	base := fmt.Sprintf("/conversations/%d", conv)
+
+	carol.post(base+"/join-requests", nil).expectError(http.StatusForbidden, codeForbidden)
+
+	alice.put(base+"/approval", map[string]string{}).expectError(http.StatusBadRequest, codeBadRequest)
+	bob.put(base+"/approval", map[string]bool{"requiresApproval": true}).expectError(http.StatusForbidden, codeForbidden)
+	alice.put(base+"/approval", map[string]bool{"requiresApproval": true}).expect(http.StatusOK)
+
+	var groups struct {
+		Groups []struct {
+			ID int64 `json:"id"`
+		} `json:"groups"`
+	}
+	carol.get("/groups?search=cl").expect(http.StatusOK).decode(&groups)
+	if len(groups.Groups) != 1 || groups.Groups[0].ID != conv {
+		t.Fatalf("ricerca gruppi inattesa: %+v", groups)
+	}
+	carol.get("/groups?search=").expectError(http.StatusBadRequest, codeBadRequest)
+
+	carol.post(base+"/join-requests", nil).expect(http.StatusCreated)
+	carol.post(base+"/join-requests", nil).expectError(http.StatusConflict, codeConflict)
+	bob.post(base+"/join-requests", nil).expectError(http.StatusConflict, codeConflict)
+
+	var pending struct {
+		Requests []dto.JoinRequest `json:"requests"`
+	}
+	bob.get(base + "/join-requests").expect(http.StatusOK).decode(&pending)
+	if len(pending.Requests) != 1 || pending.Requests[0].UUIDUser != carol.uuid {
+		t.Fatalf("richieste inattese: %+v", pending)
+	}
+	carol.get(base+"/join-requests").expectError(http.StatusForbidden, codeForbidden)
+
+	// Solo gli amministratori decidono chi entra
+	bob.put(base+"/join-requests/"+carol.uuid, map[string]bool{"approve": true}).expectError(http.StatusForbidden, codeForbidden)
+	alice.put(base+"/join-requests/"+carol.uuid, "{").expectError(http.StatusBadRequest, codeBadRequest)
+	alice.put(base+"/join-requests/"+bob.uuid, map[string]bool{"approve": true}).expectError(http.StatusNotFound, codeNotFound)
+
+	var decided dto.JoinRequest
+	alice.put(base+"/join-requests/"+carol.uuid, map[string]bool{"approve": true}).expect(http.StatusOK).decode(&decided)
+	if decided.Status != database.JoinRequestApproved {
+		t.Fatalf("richiesta non approvata: %+v", decided)
+	}
+	alice.put(base+"/join-requests/"+carol.uuid, map[string]bool{"approve": false}).expectError(http.StatusConflict, codeConflict)
+
+	var mine struct {
+		Requests []dto.JoinRequest `json:"requests"`
+	}
+	carol.get("/user/me/join-requests").expect(http.StatusOK).decode(&mine)
+	if len(mine.Requests) != 1 || mine.Requests[0].Status != database.JoinRequestApproved {
+		t.Fatalf("richieste dell'utente inattese: %+v", mine)
+	}
+	carol.get(base).expect(http.StatusOK)
+}
+
+func TestGroupInvites(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+
+	conv := alice.createGroup("segreto", "bob")
+	base := fmt.Sprintf("/conversations/%d", conv)
+
+	alice.get(base+"/invite").expectError(http.StatusNotFound, codeNotFound)
+	bob.post(base+"/invite", nil).expectError(http.StatusForbidden, codeForbidden)
+	carol.post(base+"/invite", nil).expectError(http.StatusForbidden, codeForbidden)
+
+	var first, invite dto.GroupInvite
+	alice.post(base+"/invite", nil).expect(http.StatusCreated).decode(&first)
+	alice.post(base+"/invite", nil).expect(http.StatusCreated).decode(&invite)
+	if invite.Code == "" || invite.Code == first.Code {
+		t.Fatalf("il nuovo codice deve sostituire il precedente: %+v, %+v", first, invite)
+	}
+	var read dto.GroupInvite
+	alice.get(base + "/invite").expect(http.StatusOK).decode(&read)
+	if read.Code != invite.Code {
+		t.Fatalf("invito inatteso: %+v", read)
+	}
+	bob.get(base+"/invite").expectError(http.StatusForbidden, codeForbidden)
+
+	// Il gruppo non richiede approvazione: senza un codice valido non si può chiedere di entrare
+	carol.post(base+"/join-requests", nil).expectError(http.StatusForbidden, codeForbidden)
+	carol.post(base+"/join-requests", map[string]string{"inviteCode": first.Code}).
+		expectError(http.StatusForbidden, codeForbidden)
+	carol.post(base+"/join-requests", "{").expectError(http.StatusBadRequest, codeBadRequest)
+	carol.post(base+"/join-requests", map[string]string{"inviteCode": invite.Code}).expect(http.StatusCreated)
+
+	var pending struct {
+		Requests []dto.JoinRequest `json:"requests"`
+	}
+	alice.get(base + "/join-requests").expect(http.StatusOK).decode(&pending)
+	if len(pending.Requests) != 1 || pending.Requests[0].UUIDUser != carol.uuid {
+		t.Fatalf("richieste inattese: %+v", pending)
+	}
+
+	bob.delete(base+"/invite").expectError(http.StatusForbidden, codeForbidden)
+	alice.delete(base + "/invite").expect(http.StatusNoContent)
+	alice.delete(base+"/invite").expectError(http.StatusNotFound, codeNotFound)
+	h.login("dave").post(base+"/join-requests", map[string]string{"inviteCode": invite.Code}).
+		expectError(http.StatusForbidden, codeForbidden)
+}
+
+func TestMessages(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+
+	conv := alice.createDirect("bob")
+	other := alice.createGroup("altri", "carol")
+	base := fmt.Sprintf("/conversations/%d/messages", conv)
+
+	first := alice.sendText(conv, "Ciao a tutti")
+	alice.post(base, map[string]interface{}{"type": "text", "content": "risposta", "idRepliesTo": first}).
+		expect(http.StatusCreated)
+	alice.post(base, map[string]interface{}{"type": "text", "content": "risposta", "idRepliesTo": first + 100}).
+		expectError(http.StatusNotFound, codeNotFound)
+	alice.post(base, map[string]string{"type": "text"}).expectError(http.StatusBadRequest, codeBadRequest)
+	alice.post(base, map[string]string{"type": "photo"}).expectError(http.StatusBadRequest, codeBadRequest)
+	alice.post(base, map[string]string{"type": "video", "content": "x"}).expectError(http.StatusBadRequest, codeBadRequest)
+	alice.post(base, "{").expectError(http.StatusBadRequest, codeBadRequest)
+	carol.post(base, map[string]string{"type": "text", "content": "x"}).expectError(http.StatusForbidden, codeForbidden)
+
+	var found struct {
+		Messages []dto.Message `json:"messages"`
+	}
+	bob.get(base+"?search=%s", "CIAO").expect(http.StatusOK).decode(&found)
+	if len(found.Messages) != 1 || found.Messages[0].ID != first {
+		t.Fatalf("ricerca inattesa: %+v", found)
+	}
+	bob.get(base).expectError(http.StatusBadRequest, codeBadRequest)
+	carol.get(base+"?search=%s", "ciao").expectError(http.StatusForbidden, codeForbidden)
+
+	var forwarded dto.Message
+	alice.post(fmt.Sprintf("/messages/%d/forward", first), map[string]int64{"idConversation": other}).
+		expect(http.StatusCreated).decode(&forwarded)
+	if forwarded.IDForwardedFrom == nil || *forwarded.IDForwardedFrom != first || forwarded.IDConversation != other {
+		t.Fatalf("inoltro inatteso: %+v", forwarded)
+	}
+	bob.post(fmt.Sprintf("/messages/%d/forward", first), map[string]int64{"idConversation": other}).
+		expectError(http.StatusForbidden, codeForbidden)
+	alice.post(fmt.Sprintf("/messages/%d/forward", first), map[string]int64{}).expectError(http.StatusBadRequest, codeBadRequest)
+	carol.post(fmt.Sprintf("/messages/%d/forward", first), map[string]int64{"idConversation": other}).
+		expectError(http.StatusNotFound, codeNotFound)
+
+	bob.delete("/messages/%d", first).expectError(http.StatusForbidden, codeForbidden)
+	carol.delete("/messages/%d", first).expectError(http.StatusNotFound, codeNotFound)
+	alice.delete("/messages/abc").expectError(http.StatusBadRequest, codeBadRequest)
+	alice.delete("/messages/%d", first).expect(http.StatusNoContent)
+	alice.delete("/messages/%d", first).expectError(http.StatusNotFound, codeNotFound)
+}
+
+func TestReactions(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+	carol := h.login("carol")
+
+	conv := alice.createDirect("bob")
+	msg := alice.sendText(conv, "ciao")
+	path := fmt.Sprintf("/messages/%d/reactions", msg)
+
+	var reaction dto.Reaction
+	bob.post(path, map[string]string{"emoji": "👍"}).expect(http.StatusCreated).decode(&reaction)
+	if reaction.Username != "bob" || reaction.Emoji != "👍" {
+		t.Fatalf("reazione inattesa: %+v", reaction)
+	}
+	bob.post(path, map[string]string{"emoji": ""}).expectError(http.StatusBadRequest, codeBadRequest)
+	carol.post(path, map[string]string{"emoji": "👍"}).expectError(http.StatusNotFound, codeNotFound)
+
+	bob.delete(path + "/me").expect(http.StatusNoContent)
+	bob.delete(path+"/me").expectError(http.StatusNotFound, codeNotFound)
+}
+
+func TestMessageStatus(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+
+	conv := alice.createDirect("bob")
+	msg := alice.sendText(conv, "ciao")
+	path := fmt.Sprintf("/messages/%d/status", msg)
+
+	bob.put(path, map[string]bool{"delivered": true}).expect(http.StatusOK)
+	bob.put(path, map[string]bool{"seen": true}).expect(http.StatusOK)
+	bob.put(path, map[string]bool{}).expectError(http.StatusBadRequest, codeBadRequest)
+	bob.put(path, "{").expectError(http.StatusBadRequest, codeBadRequest)
+
+	// Il mittente non ha uno stato di consegna per i propri messaggi
+	alice.put(path, map[string]bool{"seen": true}).expectError(http.StatusNotFound, codeNotFound)
+
+	var detail struct {
+		Messages []struct {
+			Seen []string `json:"seen"`
+		} `json:"messages"`
+	}
+	alice.get("/conversations/%d", conv).expect(http.StatusOK).decode(&detail)
+	if len(detail.Messages) != 1 || len(detail.Messages[0].Seen) != 1 || detail.Messages[0].Seen[0] != bob.uuid {
+		t.Fatalf("stato di lettura inatteso: %+v", detail)
+	}
+}
+
+func TestExport(t *testing.T) {
+	inTempDir(t)
+	h := newHarness(t)
+	alice := h.login("alice")
+	bob := h.login("bob")
+
+	alice.get("/user/me/export").expectError(http.StatusNotFound, codeNotFound)
+	alice.get("/user/me/export/archive").expectError(http.StatusNotFound, codeNotFound)
+
+	alice.upload("/user/me/photo", "me.png", []byte("png")).expect(http.StatusOK)
+	conv := alice.createGroup("amici", "bob")
+	alice.sendText(conv, "Ciao <b>bob</b>")
+	var photo dto.Message
+	alice.post(fmt.Sprintf("/conversations/%d/messages", conv), map[string]string{
+		"type":     "photo",
+		"mediaUrl": "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("foto")),
+	}).expect(http.StatusCreated).decode(&photo)
+	reply := bob.sendText(conv, "ciao alice")
+	alice.post(fmt.Sprintf("/messages/%d/reactions", reply), map[string]string{"emoji": "👍"}).expect(http.StatusCreated)
+
+	var export dto.Export
+	resp := alice.post("/user/me/export", nil).expect(http.StatusAccepted).decode(&export)
+	if loc := resp.header.Get("Location"); loc != "/v1/user/me/export" {
+		t.Fatalf("Location inattesa: %q", loc)
+	}
+	for deadline := time.Now().Add(5 * time.Second); export.Status == exportPending && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		alice.get("/user/me/export").expect(http.StatusOK).decode(&export)
+	}
+	if export.Status != exportReady || export.Size == nil || export.ExpiresAt == nil {
+		t.Fatalf("esportazione non pronta: %+v", export)
+	}
+	bob.get("/user/me/export").expectError(http.StatusNotFound, codeNotFound)
+
+	resp = alice.get("/user/me/export/archive").expect(http.StatusOK)
+	if ct := resp.header.Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("Content-Type inatteso: %q", ct)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(resp.body), int64(len(resp.body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+
+	if files["media/profile.png"] != "png" || files[fmt.Sprintf("media/message-%d.png", photo.ID)] != "foto" {
+		t.Fatalf("foto mancanti nell'archivio: %v", archive.File)
+	}
+	var conversations []exportConversation
+	if err := json.Unmarshal([]byte(files[exportConversationsFile]), &conversations); err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 1 || conversations[0].Name != "amici" || len(conversations[0].Messages) != 3 {
+		t.Fatalf("conversazioni inattese: %s", files[exportConversationsFile])
+	}
+	var reactions []exportReaction
+	if err := json.Unmarshal([]byte(files[exportReactionsFile]), &reactions); err != nil {
+		t.Fatal(err)
+	}
+	if len(reactions) != 1 || reactions[0].IDMessage != reply || reactions[0].Emoji != "👍" {
+		t.Fatalf("reazioni inattese: %s", files[exportReactionsFile])
+	}
+	transcript := files[exportTranscriptFile]
+	if !strings.Contains(transcript, "Ciao &lt;b&gt;bob&lt;/b&gt;") || !strings.Contains(transcript, `src="media/profile.png"`) {
+		t.Fatalf("trascrizione inattesa:\n%s", transcript)
+	}
+
+	// Scaduta l'esportazione, l'archivio viene eliminato
+	h.rt.exports.cleanup(time.Now().Add(25 * time.Hour))
+	alice.get("/user/me/export").expectError(http.StatusNotFound, codeNotFound)
+	alice.get("/user/me/export/archive").expectError(http.StatusNotFound, codeNotFound)
+	if left, _ := filepath.Glob(filepath.Join(h.rt.exports.dir, "*")); len(left) != 0 {
+		t.Fatalf("file rimasti dopo la scadenza: %v", left)
+	}
+}
+
+func TestRequestCounters(t *testing.T) {
+	h := newHarness(t)
+	alice := h.login("alice")
+
+	count := func(key string) int64 {
+		if v, ok := requestsByRoute.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	versioned, legacy := count("GET /v1/user/me"), count("GET /user/me")
+
+	alice.get("/user/me").expect(http.StatusOK)
+	alice.get("/user/me").expect(http.StatusOK)
+	alice.unversioned().get("/user/me").expect(http.StatusOK)
+
+	if got := count("GET /v1/user/me") - versioned; got != 2 {
+		t.Errorf("richieste contate per GET /v1/user/me: %d invece di 2", got)
+	}
+	if got := count("GET /user/me") - legacy; got != 1 {
+		t.Errorf("richieste contate per l'alias GET /user/me: %d invece di 1", got)
+	}
+	if n := activeRequests.Value(); n != 0 {
+		t.Errorf("richieste in corso a fine test: %d", n)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	inTempDir(t)
+	h := newHarness(t)
+	alice := h.login("alice")
+	h.login("bob")
+
+	convID := alice.createDirect("bob")
+	alice.sendText(convID, "ciao")
+	alice.upload("/user/me/photo", "me.png", []byte("png")).expect(http.StatusOK)
+	alice.get("/conversations/999999").expect(http.StatusNotFound)
+
+	// Le metriche non sono servite dall'API, ma solo dall'handler dedicato
+	h.anonymous().unversioned().get("/metrics").expect(http.StatusNotFound)
+	rec := httptest.NewRecorder()
+	h.rt.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type inatteso: %q", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`wasatext_http_requests_total{method="POST",route="/v1/conversations/:id/messages",status="201"} 1`,
+		`wasatext_http_requests_total{method="GET",route="/v1/conversations/:id",status="404"} 1`,
+		`wasatext_http_request_duration_seconds_count{method="POST",route="/v1/conversations/:id/messages",status="201"} 1`,
+		`wasatext_db_operation_duration_seconds_count{method="CreateMessage"} 1`,
+		`wasatext_messages_sent_total{type="text"} 1`,
+		`wasatext_upload_bytes_total{kind="user_photo"} 3`,
+		`wasatext_active_users 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("manca la riga %q in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/albyma98/WASAText/service/api/reqcontext"
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// Codici di errore restituiti nel campo `code`. A differenza del messaggio, sono stabili e pensati per essere
+// interpretati dai client.
+const (
+	codeBadRequest   = "bad_request"
+	codeUnauthorized = "unauthorized"
+	codeForbidden    = "forbidden"
+	codeNotFound     = "not_found"
+	codeConflict     = "conflict"
+	codeInternal     = "internal_error"
+	codeTimeout      = "timeout"
+	codeRateLimited  = "rate_limited"
+)
+
+// errorResponse è il corpo di tutte le risposte di errore dell'API
+type errorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId"`
+}
+
+// errorCode restituisce il codice di errore associato allo status HTTP
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeBadRequest
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusServiceUnavailable:
+		return codeTimeout
+	case http.StatusTooManyRequests:
+		return codeRateLimited
+	default:
+		return codeInternal
+	}
+}
+
+// sendError risponde con lo status e il messaggio indicati
+func sendError(w http.ResponseWriter, ctx reqcontext.RequestContext, status int, message string) {
+	sendErrorDetails(w, ctx, status, message, nil)
+}
+
+// sendErrorDetails risponde con lo status e il messaggio indicati, allegando i dettagli (ad esempio l'elenco dei campi
+// non validi)
+func sendErrorDetails(w http.ResponseWriter, ctx reqcontext.RequestContext, status int, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(errorResponse{
+		Code:      errorCode(status),
+		Message:   message,
+		Details:   details,
+		RequestID: ctx.ReqUUID.String(),
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the error response")
+	}
+}
+
+// sendInternalError registra l'errore nel log e risponde 500. Il dettaglio dell'errore non viene mai mostrato al client.
+// Se l'errore è dovuto alla scadenza del tempo massimo della richiesta risponde invece 503.
+func sendInternalError(w http.ResponseWriter, ctx reqcontext.RequestContext, err error, message string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		ctx.Logger.WithError(err).Warn(message)
+		sendError(w, ctx, http.StatusServiceUnavailable, "The request took too long")
+		return
+	}
+	ctx.Logger.WithError(err).Error(message)
+	sendError(w, ctx, http.StatusInternalServerError, message)
+}
+
+// sendDBError traduce un errore del database nella risposta corrispondente: 404 per database.ErrNotFound, 403 per
+// database.ErrForbidden e 409 per database.ErrConflict, con il messaggio indicato. Tutti gli altri errori sono
+// errori interni.
+func sendDBError(w http.ResponseWriter, ctx reqcontext.RequestContext, err error, message string) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		sendError(w, ctx, http.StatusNotFound, message)
+	case errors.Is(err, database.ErrForbidden):
+		sendError(w, ctx, http.StatusForbidden, message)
+	case errors.Is(err, database.ErrConflict):
+		sendError(w, ctx, http.StatusConflict, message)
+	default:
+		sendInternalError(w, ctx, err, "Database error")
+	}
+}
@@ -0,0 +1,376 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/albyma98/WASAText/service/api/dto"
+	"github.com/albyma98/WASAText/service/database"
+	"github.com/albyma98/WASAText/service/globaltime"
+)
+
+// File contenuti nell'archivio di un'esportazione
+const (
+	exportProfileFile       = "profile.json"
+	exportConversationsFile = "conversations.json"
+	exportReactionsFile     = "reactions.json"
+	exportTranscriptFile    = "transcript.html"
+	exportMediaPrefix       = "media/"
+)
+
+// exportProfile è il contenuto di profile.json
+type exportProfile struct {
+	User         dto.User            `json:"user"`
+	ExportedAt   string              `json:"exportedAt"`
+	JoinRequests []dto.MyJoinRequest `json:"joinRequests"`
+}
+
+// exportConversation è una conversazione in conversations.json, con i messaggi che l'utente può vedere
+type exportConversation struct {
+	dto.Conversation
+
+	// Name è il nome del gruppo o, per le chat dirette, lo username dell'altro partecipante
+	Name string `json:"name"`
+
+	// Members sono gli username dei membri attuali
+	Members []string `json:"members"`
+
+	// RemovedAt è presente se l'utente non fa più parte del gruppo
+	RemovedAt *string `json:"removedAt,omitempty"`
+
+	Messages []exportMessage `json:"messages"`
+}
+
+// exportMessage è un messaggio in conversations.json
+type exportMessage struct {
+	dto.Message
+	UsernameSender string `json:"usernameSender"`
+
+	// Media è il percorso nell'archivio della foto del messaggio, presente per le foto inviate dall'utente
+	Media string `json:"media,omitempty"`
+}
+
+// exportReaction è una reazione dell'utente in reactions.json
+type exportReaction struct {
+	IDMessage      int64  `json:"idMessage"`
+	IDConversation int64  `json:"idConversation"`
+	Emoji          string `json:"emoji"`
+}
+
+// exportData raccoglie i dati dell'utente da scrivere nell'archivio
+type exportData struct {
+	Profile       exportProfile
+	Conversations []exportConversation
+	Reactions     []exportReaction
+
+	// ProfilePhoto è il percorso nell'archivio della foto profilo, se presente
+	ProfilePhoto string
+
+	// media sono i file da aggiungere sotto exportMediaPrefix, con il loro contenuto
+	media []exportMedia
+}
+
+// exportMedia è un file multimediale caricato dall'utente: una foto in MediaDir (path) o decodificata da un data URL
+// (data)
+type exportMedia struct {
+	name string
+	path string
+	data []byte
+}
+
+// writeExportArchive raccoglie i dati dell'utente e li scrive in zw: profilo, conversazioni con i messaggi visibili,
+// reazioni, una trascrizione HTML leggibile nel browser e le foto caricate dall'utente
+func (rt *_router) writeExportArchive(ctx context.Context, zw *zip.Writer, uuidUser string) error {
+	data, err := rt.collectExport(ctx, uuidUser)
+	if err != nil {
+		return err
+	}
+	modified := globaltime.Now()
+
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{exportProfileFile, data.Profile},
+		{exportConversationsFile, data.Conversations},
+		{exportReactionsFile, data.Reactions},
+	}
+	for _, f := range files {
+		w, err := createExportFile(zw, f.name, modified)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.value); err != nil {
+			return fmt.Errorf("writing %s: %w", f.name, err)
+		}
+	}
+
+	w, err := createExportFile(zw, exportTranscriptFile, modified)
+	if err != nil {
+		return err
+	}
+	if err := transcriptTemplate.Execute(w, data); err != nil {
+		return fmt.Errorf("writing %s: %w", exportTranscriptFile, err)
+	}
+
+	for _, m := range data.media {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := addExportMedia(zw, m, modified); err != nil {
+			return fmt.Errorf("adding %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// createExportFile aggiunge all'archivio il file name, compresso e con data di modifica modified
+func createExportFile(zw *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+}
+
+// addExportMedia aggiunge il file m all'archivio. Le foto che non esistono più in MediaDir vengono ignorate.
+func addExportMedia(zw *zip.Writer, m exportMedia, modified time.Time) error {
+	if m.data != nil {
+		w, err := createExportFile(zw, m.name, modified)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(m.data)
+		return err
+	}
+
+	f, err := os.Open(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := createExportFile(zw, m.name, modified)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// collectExport legge dal database i dati dell'utente
+func (rt *_router) collectExport(ctx context.Context, uuidUser string) (exportData, error) {
+	var data exportData
+
+	user, err := rt.db.GetUserByUUID(ctx, uuidUser)
+	if err != nil {
+		return data, err
+	}
+	data.Profile = exportProfile{
+		User:         dto.FromUser(user),
+		ExportedAt:   globaltime.Now().UTC().Format(time.RFC3339),
+		JoinRequests: []dto.MyJoinRequest{},
+	}
+	if user.PhotoUrl != nil {
+		if path, ok := localMediaPath(*user.PhotoUrl); ok {
+			data.ProfilePhoto = exportMediaPrefix + "profile" + filepath.Ext(path)
+			data.media = append(data.media, exportMedia{name: data.ProfilePhoto, path: path})
+		}
+	}
+
+	requests, err := rt.db.GetJoinRequestsByUser(ctx, uuidUser)
+	if err != nil {
+		return data, err
+	}
+	for _, jr := range requests {
+		item := dto.MyJoinRequest{JoinRequest: dto.FromJoinRequest(jr)}
+		if conv, err := rt.db.GetConversationByID(ctx, jr.IDConversation); err == nil {
+			item.GroupName = conv.GroupName
+		}
+		data.Profile.JoinRequests = append(data.Profile.JoinRequests, item)
+	}
+
+	current, err := rt.db.GetConversationsByUser(ctx, uuidUser)
+	if err != nil {
+		return data, err
+	}
+	former, err := rt.db.GetFormerConversationsByUser(ctx, uuidUser)
+	if err != nil {
+		return data, err
+	}
+
+	usernames := map[string]string{user.UUID: user.Username}
+	username := func(uuid string) string {
+		if name, ok := usernames[uuid]; ok {
+			return name
+		}
+		if u, err := rt.db.GetUserByUUID(ctx, uuid); err == nil {
+			usernames[uuid] = u.Username
+		}
+		return usernames[uuid]
+	}
+
+	data.Conversations = []exportConversation{}
+	data.Reactions = []exportReaction{}
+	for _, conv := range append(current, former...) {
+		c, err := rt.collectExportConversation(ctx, uuidUser, conv, username, &data)
+		if err != nil {
+			return data, fmt.Errorf("conversation %d: %w", conv.ID, err)
+		}
+		data.Conversations = append(data.Conversations, c)
+	}
+	return data, nil
+}
+
+// collectExportConversation legge la conversazione con i messaggi che l'utente può vedere, aggiungendo a data le sue
+// reazioni e le foto che ha inviato
+func (rt *_router) collectExportConversation(ctx context.Context, uuidUser string, conv database.Conversation, username func(string) string, data *exportData) (exportConversation, error) {
+	out := exportConversation{Conversation: dto.FromConversation(conv), Members: []string{}, Messages: []exportMessage{}}
+
+	window, err := rt.db.GetHistoryWindow(ctx, uuidUser, conv.ID)
+	if err != nil {
+		return out, err
+	}
+	if window.Until != "" {
+		out.RemovedAt = &window.Until
+	}
+
+	members, err := rt.db.GetMembersByConversation(ctx, conv.ID)
+	if err != nil {
+		return out, err
+	}
+	for _, m := range members {
+		out.Members = append(out.Members, username(m))
+	}
+	if conv.GroupName != nil {
+		out.Name = *conv.GroupName
+	} else if conv.IsDirect {
+		for _, m := range members {
+			if m != uuidUser {
+				out.Name = username(m)
+			}
+		}
+	}
+
+	messages, err := rt.db.GetMessagesByConversationID(ctx, conv.ID, window)
+	if err != nil {
+		return out, err
+	}
+	for _, m := range messages {
+		item := exportMessage{Message: dto.FromMessage(m), UsernameSender: username(m.UUIDSender)}
+		if m.UUIDSender == uuidUser && m.Type == "photo" && m.MediaUrl != nil {
+			if media, ok := messageMedia(m.ID, *m.MediaUrl); ok {
+				item.Media = media.name
+				data.media = append(data.media, media)
+			}
+		}
+		for _, r := range m.Reactions {
+			if r.UUIDUser == uuidUser {
+				data.Reactions = append(data.Reactions, exportReaction{IDMessage: m.ID, IDConversation: conv.ID, Emoji: r.Emoji})
+			}
+		}
+		out.Messages = append(out.Messages, item)
+	}
+	return out, nil
+}
+
+// localMediaPath restituisce il percorso in MediaDir della foto con URL pubblico url ("/<nome file>"). Gli URL che
+// non indicano un file di MediaDir, compresi quelli che proverebbero a uscirne, vengono scartati.
+func localMediaPath(url string) (string, bool) {
+	name := strings.TrimPrefix(url, "/")
+	if name == url || name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	return filepath.Join(MediaDir, name), true
+}
+
+// messageMedia restituisce il file della foto del messaggio id, che il client invia come data URL base64 o come URL
+// di un file in MediaDir
+func messageMedia(id int64, mediaUrl string) (exportMedia, bool) {
+	name := fmt.Sprintf("%smessage-%d", exportMediaPrefix, id)
+	if path, ok := localMediaPath(mediaUrl); ok {
+		return exportMedia{name: name + filepath.Ext(path), path: path}, true
+	}
+
+	comma := strings.Index(mediaUrl, ",")
+	if !strings.HasPrefix(mediaUrl, "data:") || comma < 0 || !strings.HasSuffix(mediaUrl[:comma], ";base64") {
+		return exportMedia{}, false
+	}
+	meta, payload := mediaUrl[len("data:"):comma], mediaUrl[comma+1:]
+	content, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return exportMedia{}, false
+	}
+	ext, ok := imageExtensions[strings.TrimSuffix(meta, ";base64")]
+	if !ok {
+		ext = ".bin"
+	}
+	return exportMedia{name: name + ext, data: content}, true
+}
+
+// imageExtensions sono le estensioni dei file estratti dai data URL, per tipo MIME
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// transcriptTemplate è la trascrizione HTML delle conversazioni, leggibile aprendo transcript.html dall'archivio
+// estratto. Le foto sono mostrate dai file in media/ o, per quelle degli altri utenti, dal loro data URL.
+var transcriptTemplate = template.Must(template.New(exportTranscriptFile).Funcs(template.FuncMap{
+	"imageSrc": func(m exportMessage) interface{} {
+		if m.Media != "" {
+			return m.Media
+		}
+		// html/template scarta gli URL data: come potenzialmente pericolosi: sono ammessi solo quelli di immagini
+		if m.MediaUrl != nil && strings.HasPrefix(*m.MediaUrl, "data:image/") {
+			return template.URL(*m.MediaUrl)
+		}
+		return ""
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="it">
+<head>
+<meta charset="utf-8">
+<title>WASAText – {{.Profile.User.Username}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; color: #222; }
+.message { margin: .5em 0; }
+.meta { color: #777; font-size: .85em; }
+.system { color: #777; font-style: italic; }
+img { max-width: 20em; display: block; }
+</style>
+</head>
+<body>
+<h1>{{.Profile.User.Username}}</h1>
+{{with .ProfilePhoto}}<img src="{{.}}" alt="Foto profilo">{{end}}
+<p class="meta">Esportato il {{.Profile.ExportedAt}}</p>
+{{range .Conversations}}
+<section>
+<h2>{{if .Name}}{{.Name}}{{else}}Conversazione {{.ID}}{{end}}</h2>
+<p class="meta">Membri: {{range $i, $m := .Members}}{{if $i}}, {{end}}{{$m}}{{end}}{{with .RemovedAt}} · uscito il {{.}}{{end}}</p>
+{{range .Messages}}
+{{if eq .Type "system"}}<p class="message system">{{.Content}} <span class="meta">{{.Timestamp}}</span></p>
+{{else}}<div class="message">
+<span class="meta">{{.Timestamp}}</span> <strong>{{.UsernameSender}}</strong>
+{{if eq .Type "photo"}}{{with imageSrc .}}<img src="{{.}}" alt="Foto">{{else}}[foto]{{end}}{{end}}
+{{with .Content}}<div>{{.}}</div>{{end}}
+{{with .Reactions}}<div class="meta">{{range .}}{{.Emoji}} {{.Username}} {{end}}</div>{{end}}
+</div>
+{{end}}{{else}}<p class="meta">Nessun messaggio</p>
+{{end}}
+</section>
+{{else}}<p>Nessuna conversazione</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/albyma98/WASAText/service/api/dto"
+	"github.com/albyma98/WASAText/service/api/reqcontext"
+	"github.com/albyma98/WASAText/service/globaltime"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// Stati di un'esportazione dei dati, vedi exportJob
+const (
+	exportPending = "pending"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+)
+
+// defaultExportTTL è il tempo per cui un'esportazione resta disponibile se Config.ExportTTL è zero
+const defaultExportTTL = 24 * time.Hour
+
+// exportCleanupInterval è ogni quanto vengono eliminate le esportazioni scadute
+const exportCleanupInterval = time.Minute
+
+// exportJob è un'esportazione dei dati di un utente. id e createdAt non cambiano; gli altri campi sono protetti da
+// exports.mu.
+type exportJob struct {
+	id        string
+	createdAt time.Time
+
+	status string
+
+	// expiresAt è il momento da cui l'esportazione, terminata, non è più disponibile
+	expiresAt time.Time
+
+	// path e size descrivono l'archivio, se l'esportazione è pronta
+	path string
+	size int64
+}
+
+// exports tiene l'ultima esportazione richiesta da ogni utente. Le esportazioni sono solo in memoria: al riavvio del
+// server vengono perse e gli archivi rimasti in dir sono eliminati da newExports.
+type exports struct {
+	dir string
+	ttl time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*exportJob
+
+	// running conta le esportazioni in corso, attese da Close
+	running sync.WaitGroup
+}
+
+// newExports prepara la cartella degli archivi, eliminando quelli lasciati da un'esecuzione precedente
+func newExports(dir string, ttl time.Duration) (*exports, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "wasatext-exports")
+	}
+	if ttl <= 0 {
+		ttl = defaultExportTTL
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	for _, pattern := range []string{"export-*.zip", ".export-*.tmp"} {
+		stale, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range stale {
+			if err := os.Remove(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &exports{dir: dir, ttl: ttl, jobs: make(map[string]*exportJob)}, nil
+}
+
+// start crea una nuova esportazione per l'utente e la restituisce con started a true. Se ne ha già una in corso
+// restituisce quella, con started a false; un'esportazione terminata viene invece sostituita e il suo archivio
+// eliminato.
+func (e *exports) start(uuidUser string) (job exportJob, started bool, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if old, ok := e.jobs[uuidUser]; ok {
+		if old.status == exportPending {
+			return *old, false, nil
+		}
+		if old.path != "" {
+			_ = os.Remove(old.path)
+		}
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return exportJob{}, false, err
+	}
+	j := &exportJob{id: id.String(), createdAt: globaltime.Now(), status: exportPending}
+	e.jobs[uuidUser] = j
+	e.running.Add(1)
+	return *j, true, nil
+}
+
+// finish registra l'esito dell'esportazione id dell'utente, che da questo momento scade dopo e.ttl
+func (e *exports) finish(uuidUser string, id string, path string, size int64, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	j, ok := e.jobs[uuidUser]
+	if !ok || j.id != id {
+		return
+	}
+	j.expiresAt = globaltime.Now().Add(e.ttl)
+	if err != nil {
+		j.status = exportFailed
+		return
+	}
+	j.status, j.path, j.size = exportReady, path, size
+}
+
+// get restituisce una copia dell'esportazione dell'utente, se ne ha una non scaduta
+func (e *exports) get(uuidUser string) (exportJob, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	j, ok := e.jobs[uuidUser]
+	if !ok || j.expired(globaltime.Now()) {
+		return exportJob{}, false
+	}
+	return *j, true
+}
+
+// cleanup elimina le esportazioni scadute e i loro archivi
+func (e *exports) cleanup(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for uuidUser, j := range e.jobs {
+		if !j.expired(now) {
+			continue
+		}
+		if j.path != "" {
+			_ = os.Remove(j.path)
+		}
+		delete(e.jobs, uuidUser)
+	}
+}
+
+// expired indica se l'esportazione è terminata e scaduta al momento now
+func (j *exportJob) expired(now time.Time) bool {
+	return j.status != exportPending && !now.Before(j.expiresAt)
+}
+
+// toDTO converte l'esportazione nella risposta dell'API
+func (j *exportJob) toDTO() dto.Export {
+	out := dto.Export{
+		ID:        j.id,
+		Status:    j.status,
+		CreatedAt: j.createdAt.UTC().Format(time.RFC3339),
+	}
+	if j.status != exportPending {
+		expires := j.expiresAt.UTC().Format(time.RFC3339)
+		out.ExpiresAt = &expires
+	}
+	if j.status == exportReady {
+		size := j.size
+		out.Size = &size
+	}
+	return out
+}
+
+// cleanupExports elimina periodicamente le esportazioni scadute, finché stop non viene chiuso
+func (rt *_router) cleanupExports(stop <-chan struct{}) {
+	ticker := time.NewTicker(exportCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rt.exports.cleanup(globaltime.Now())
+		}
+	}
+}
+
+// requestExport avvia l'esportazione dei dati dell'utente autenticato, o restituisce quella già in corso. L'archivio
+// viene preparato in background: il client controlla lo stato con GET /user/me/export e lo scarica da
+// GET /user/me/export/archive quando è pronto.
+func (rt *_router) requestExport(w http.ResponseWriter, _ *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	job, started, err := rt.exports.start(ctx.UserUUID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't start the export")
+		return
+	}
+	if started {
+		ctx.Logger.WithField("export", job.id).Info("export requested")
+		go rt.runExport(ctx.UserUUID, job.id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiV1.prefix+"/user/me/export")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(job.toDTO()); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// getExport restituisce lo stato dell'ultima esportazione dell'utente autenticato
+func (rt *_router) getExport(w http.ResponseWriter, _ *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	job, ok := rt.exports.get(ctx.UserUUID)
+	if !ok {
+		sendError(w, ctx, http.StatusNotFound, "No export requested or export expired")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job.toDTO()); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// downloadExport invia l'archivio dell'ultima esportazione dell'utente autenticato, se è pronta
+func (rt *_router) downloadExport(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	job, ok := rt.exports.get(ctx.UserUUID)
+	if !ok {
+		sendError(w, ctx, http.StatusNotFound, "No export requested or export expired")
+		return
+	}
+	switch job.status {
+	case exportPending:
+		sendError(w, ctx, http.StatusConflict, "Export not ready yet")
+		return
+	case exportFailed:
+		sendError(w, ctx, http.StatusConflict, "Export failed, request a new one")
+		return
+	}
+
+	f, err := os.Open(job.path)
+	if os.IsNotExist(err) {
+		// L'archivio è stato eliminato dopo get, perché scaduto o sostituito da una nuova esportazione
+		sendError(w, ctx, http.StatusNotFound, "No export requested or export expired")
+		return
+	} else if err != nil {
+		sendInternalError(w, ctx, err, "Can't open the export")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="wasatext-export-%s.zip"`,
+		job.createdAt.UTC().Format("20060102")))
+	http.ServeContent(w, r, "", job.createdAt, f)
+}
+
+// runExport prepara l'archivio dell'esportazione id dell'utente e ne registra l'esito. L'esportazione viene
+// interrotta da Close.
+func (rt *_router) runExport(uuidUser string, id string) {
+	defer rt.exports.running.Done()
+	// L'utente non compare nel log (il suo UUID è il token): la riga "export requested" collega l'id alla richiesta
+	logger := rt.baseLogger.WithField("export", id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-rt.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	path, size, err := rt.writeExport(ctx, uuidUser, id)
+	rt.exports.finish(uuidUser, id, path, size, err)
+	if err != nil {
+		logger.WithError(err).Error("export failed")
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		"size":     size,
+		"duration": time.Since(start).String(),
+	}).Info("export ready")
+}
+
+// writeExport scrive l'archivio in un file temporaneo, rinominato in export-<id>.zip solo quando è completo, e ne
+// restituisce percorso e dimensione
+func (rt *_router) writeExport(ctx context.Context, uuidUser string, id string) (string, int64, error) {
+	tmp, err := os.CreateTemp(rt.exports.dir, ".export-*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		// Dopo il rename il file temporaneo non esiste più e Remove fallisce senza conseguenze
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	zw := zip.NewWriter(tmp)
+	if err := rt.writeExportArchive(ctx, zw, uuidUser); err != nil {
+		return "", 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return "", 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	path := filepath.Join(rt.exports.dir, "export-"+id+".zip")
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	return path, info.Size(), nil
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/albyma98/WASAText/service/api/dto"
+	"github.com/albyma98/WASAText/service/api/reqcontext"
+	"github.com/julienschmidt/httprouter"
+)
+
+// inviteCodeBytes è il numero di byte casuali di un codice di invito, codificati in base64 URL (22 caratteri)
+const inviteCodeBytes = 16
+
+// newInviteCode genera un codice di invito casuale
+func newInviteCode() (string, error) {
+	b := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// createGroupInvite genera un nuovo codice di invito per il gruppo, che sostituisce il precedente. Chi riceve il codice
+// può chiedere di entrare con POST /conversations/:id/join-requests anche se il gruppo non è in modalità
+// "approvazione richiesta".
+func (rt *_router) createGroupInvite(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	code, err := newInviteCode()
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't generate the invite code")
+		return
+	}
+	invite, err := rt.db.SetGroupInvite(r.Context(), ctx.Conversation.ID, code, ctx.UserUUID)
+	if err != nil {
+		sendDBError(w, ctx, err, "Can't create the invite")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(dto.FromGroupInvite(invite)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// getGroupInvite restituisce il codice di invito del gruppo
+func (rt *_router) getGroupInvite(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	invite, err := rt.db.GetGroupInvite(r.Context(), ctx.Conversation.ID)
+	if err != nil {
+		sendDBError(w, ctx, err, "The group has no invite")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(dto.FromGroupInvite(invite)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// deleteGroupInvite revoca il codice di invito del gruppo. Le richieste già create con il codice restano valide.
+func (rt *_router) deleteGroupInvite(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := rt.db.DeleteGroupInvite(r.Context(), ctx.Conversation.ID); err != nil {
+		sendDBError(w, ctx, err, "The group has no invite")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/albyma98/WASAText/service/api/dto"
+	"github.com/albyma98/WASAText/service/database"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+// harness avvia l'API su un database SQLite temporaneo e registra tutte le richieste servite, così che i test possano
+// verificare quali route sono state coperte (vedi TestMain).
+type harness struct {
+	t      *testing.T
+	rt     *_router
+	server *httptest.Server
+}
+
+// exchange è una richiesta servita dall'harness, con la route che l'ha gestita e la risposta
+type exchange struct {
+	route    route
+	request  *http.Request
+	reqBody  []byte
+	status   int
+	header   http.Header
+	respBody []byte
+}
+
+var (
+	trafficMu sync.Mutex
+
+	// traffic raccoglie le richieste servite da tutti gli harness del package
+	traffic []exchange
+
+	// registeredRoutes sono le route registrate da Handler, uguali per tutti gli harness
+	registeredRoutes []route
+)
+
+// newHarness avvia un'istanza dell'API con un database vuoto, chiusa alla fine del test. options può modificare la
+// configurazione passata a New.
+func newHarness(t *testing.T, options ...func(cfg *Config)) *harness {
+	t.Helper()
+
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := Config{Logger: logger, Database: db, RequestTimeout: 5 * time.Second, ExportDir: t.TempDir()}
+	for _, option := range options {
+		option(&cfg)
+	}
+	router, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = router.Close() })
+
+	h := &harness{t: t, rt: router.(*_router)}
+	handler := router.Handler()
+	trafficMu.Lock()
+	registeredRoutes = h.rt.routes
+	trafficMu.Unlock()
+	h.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec := &teeResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+		h.record(exchange{
+			request:  r,
+			reqBody:  body,
+			status:   rec.status,
+			header:   w.Header().Clone(),
+			respBody: rec.body.Bytes(),
+		})
+	}))
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+// record aggiunge la richiesta a traffic, ricostruendo il percorso della route dai parametri trovati dal router
+func (h *harness) record(ex exchange) {
+	r := ex.request
+	handle, params, _ := h.rt.router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return
+	}
+	segments := strings.Split(r.URL.Path, "/")
+	next := 0
+	for i, seg := range segments {
+		if next < len(params) && seg == params[next].Value {
+			segments[i] = ":" + params[next].Key
+			next++
+		}
+	}
+
+	trafficMu.Lock()
+	defer trafficMu.Unlock()
+	ex.route = route{method: r.Method, path: strings.Join(segments, "/")}
+	traffic = append(traffic, ex)
+}
+
+// anonymous restituisce un client senza token
+func (h *harness) anonymous() *client {
+	return &client{h: h, prefix: apiV1.prefix}
+}
+
+// login esegue il login (creando l'utente se non esiste) e restituisce il client autenticato
+func (h *harness) login(username string) *client {
+	h.t.Helper()
+	var user dto.User
+	h.anonymous().post("/session", map[string]string{"username": username}).expectSuccess().decode(&user)
+	return &client{h: h, prefix: apiV1.prefix, uuid: user.UUID, username: username}
+}
+
+// client esegue richieste all'API come un certo utente
+type client struct {
+	h *harness
+
+	// prefix è aggiunto all'inizio di tutti i percorsi, di norma quello della versione dell'API
+	prefix string
+
+	uuid     string
+	username string
+}
+
+// withToken restituisce una copia del client che usa `token` come Bearer token
+func (c *client) withToken(token string) *client {
+	return &client{h: c.h, prefix: c.prefix, uuid: token, username: c.username}
+}
+
+// unversioned restituisce una copia del client che usa i percorsi così come sono, senza prefisso di versione: per le
+// route di servizio e per gli alias deprecati
+func (c *client) unversioned() *client {
+	return &client{h: c.h, uuid: c.uuid, username: c.username}
+}
+
+func (c *client) get(path string, args ...interface{}) *response {
+	return c.do(http.MethodGet, fmt.Sprintf(path, args...), nil)
+}
+
+func (c *client) post(path string, body interface{}) *response {
+	return c.do(http.MethodPost, path, body)
+}
+
+func (c *client) put(path string, body interface{}) *response {
+	return c.do(http.MethodPut, path, body)
+}
+
+func (c *client) delete(path string, args ...interface{}) *response {
+	return c.do(http.MethodDelete, fmt.Sprintf(path, args...), nil)
+}
+
+// do esegue la richiesta. body viene codificato in JSON, tranne le stringhe che sono inviate così come sono.
+func (c *client) do(method string, path string, body interface{}) *response {
+	c.h.t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			c.h.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.h.server.URL+c.prefix+path, reader)
+	if err != nil {
+		c.h.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req)
+}
+
+// upload invia `content` come file "photo" in un form multipart, come fa la web UI per le foto
+func (c *client) upload(path string, filename string, content []byte) *response {
+	c.h.t.Helper()
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	part, err := form.CreateFormFile("photo", filename)
+	if err != nil {
+		c.h.t.Fatal(err)
+	}
+	_, _ = part.Write(content)
+	if err := form.Close(); err != nil {
+		c.h.t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, c.h.server.URL+c.prefix+path, &buf)
+	if err != nil {
+		c.h.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return c.send(req)
+}
+
+func (c *client) send(req *http.Request) *response {
+	c.h.t.Helper()
+	if c.uuid != "" {
+		req.Header.Set("Authorization", "Bearer "+c.uuid)
+	}
+	resp, err := c.h.server.Client().Do(req)
+	if err != nil {
+		c.h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.h.t.Fatal(err)
+	}
+	return &response{t: c.h.t, req: req, status: resp.StatusCode, header: resp.Header, body: body}
+}
+
+// createGroup crea un gruppo con i membri indicati (username o UUID) e ne restituisce l'ID
+func (c *client) createGroup(name string, members ...string) int64 {
+	c.h.t.Helper()
+	var conv struct{ ID int64 }
+	c.post("/conversations", map[string]interface{}{
+		"isDirect":  false,
+		"groupName": name,
+		"members":   members,
+	}).expect(http.StatusCreated).decode(&conv)
+	return conv.ID
+}
+
+// createDirect crea una chat diretta con `peer` e ne restituisce l'ID
+func (c *client) createDirect(peer string) int64 {
+	c.h.t.Helper()
+	var conv struct{ ID int64 }
+	c.post("/conversations", map[string]interface{}{
+		"isDirect": true,
+		"members":  []string{peer},
+	}).expect(http.StatusCreated).decode(&conv)
+	return conv.ID
+}
+
+// sendText invia un messaggio di testo e ne restituisce l'ID
+func (c *client) sendText(convID int64, text string) int64 {
+	c.h.t.Helper()
+	var msg struct{ ID int64 }
+	c.post(fmt.Sprintf("/conversations/%d/messages", convID), map[string]interface{}{
+		"type":    "text",
+		"content": text,
+	}).expect(http.StatusCreated).decode(&msg)
+	return msg.ID
+}
+
+// response è la risposta a una richiesta del client
+type response struct {
+	t      *testing.T
+	req    *http.Request
+	status int
+	header http.Header
+	body   []byte
+}
+
+// expect fa fallire il test se lo status della risposta non è `status`
+func (r *response) expect(status int) *response {
+	r.t.Helper()
+	if r.status != status {
+		r.t.Fatalf("%s %s: status %d, atteso %d: %s", r.req.Method, r.req.URL.Path, r.status, status, r.body)
+	}
+	return r
+}
+
+// expectSuccess fa fallire il test se lo status della risposta non è 2xx
+func (r *response) expectSuccess() *response {
+	r.t.Helper()
+	if r.status < 200 || r.status > 299 {
+		r.t.Fatalf("%s %s: status %d: %s", r.req.Method, r.req.URL.Path, r.status, r.body)
+	}
+	return r
+}
+
+// expectError controlla status e codice di una risposta di errore
+func (r *response) expectError(status int, code string) *response {
+	r.t.Helper()
+	r.expect(status)
+	var e errorResponse
+	r.decode(&e)
+	if e.Code != code || e.RequestID == "" {
+		r.t.Fatalf("%s %s: errore inatteso: %s", r.req.Method, r.req.URL.Path, r.body)
+	}
+	return r
+}
+
+// decode decodifica il corpo JSON della risposta in v
+func (r *response) decode(v interface{}) *response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		r.t.Fatalf("%s %s: risposta non valida (%v): %s", r.req.Method, r.req.URL.Path, err, r.body)
+	}
+	return r
+}
+
+// inTempDir esegue il test nella cartella temporanea del test, per gli handler che scrivono file su percorsi relativi
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/albyma98/WASAText/service/api/dto"
+	"github.com/albyma98/WASAText/service/api/reqcontext"
+	"github.com/albyma98/WASAText/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// setGroupApproval attiva o disattiva la modalità "approvazione richiesta" di un gruppo
+func (rt *_router) setGroupApproval(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	convID := ctx.Conversation.ID
+
+	var body struct {
+		RequiresApproval *bool `json:"requiresApproval"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RequiresApproval == nil {
+		sendError(w, ctx, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	if err := rt.db.SetGroupRequiresApproval(r.Context(), convID, *body.RequiresApproval); err != nil {
+		sendDBError(w, ctx, err, "Can't update the group")
+		return
+	}
+
+	// Ritorna l’oggetto aggiornato
+	updated, err := rt.db.GetConversationByID(r.Context(), convID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't read the updated conversation")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(dto.FromConversation(updated)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// searchGroups permette di scoprire i gruppi che accettano richieste di ingresso
+func (rt *_router) searchGroups(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query().Get("search")
+	if len(query) < 1 || len(query) > 30 || !regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString(query) {
+		sendError(w, ctx, http.StatusBadRequest, "Invalid search parameter")
+		return
+	}
+
+	groups, err := rt.db.SearchGroupsByName(r.Context(), query)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't search groups")
+		return
+	}
+
+	output := dto.GroupList{Groups: []dto.Group{}}
+	for _, g := range groups {
+		output.Groups = append(output.Groups, dto.FromGroup(g))
+	}
+
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// requestToJoin crea una richiesta di ingresso in attesa per un gruppo con approvazione, o per qualsiasi gruppo se il
+// corpo contiene il suo codice di invito
+func (rt *_router) requestToJoin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	conv := ctx.Conversation
+	convID := conv.ID
+
+	// Il corpo è facoltativo: serve solo per presentare un codice di invito
+	var body struct {
+		InviteCode string `json:"inviteCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		sendError(w, ctx, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	if body.InviteCode != "" {
+		invite, err := rt.db.GetGroupInvite(r.Context(), convID)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			sendDBError(w, ctx, err, "Can't check the invite")
+			return
+		}
+		if err != nil || subtle.ConstantTimeCompare([]byte(invite.Code), []byte(body.InviteCode)) != 1 {
+			sendError(w, ctx, http.StatusForbidden, "Invalid invite code")
+			return
+		}
+	} else if !conv.RequiresApproval {
+		// Senza approvazione né invito si entra solo se aggiunti da un membro
+		sendError(w, ctx, http.StatusForbidden, "The group doesn't accept join requests")
+		return
+	}
+
+	isMember, err := rt.db.IsMember(r.Context(), ctx.UserUUID, convID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't check membership")
+		return
+	}
+	if isMember {
+		sendError(w, ctx, http.StatusConflict, "You are already a member of the group")
+		return
+	}
+
+	// Una sola richiesta in attesa per utente
+	existing, err := rt.db.GetJoinRequest(r.Context(), ctx.UserUUID, convID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		sendDBError(w, ctx, err, "Can't check the join request")
+		return
+	}
+	if err == nil && existing.Status == database.JoinRequestPending {
+		sendError(w, ctx, http.StatusConflict, "Join request already pending")
+		return
+	}
+
+	jr, err := rt.db.CreateJoinRequest(r.Context(), ctx.UserUUID, convID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't create the join request")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(dto.FromJoinRequest(jr)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// getJoinRequests restituisce ai membri del gruppo le richieste di ingresso in attesa
+func (rt *_router) getJoinRequests(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	convID := ctx.Conversation.ID
+
+	requests, err := rt.db.GetPendingJoinRequests(r.Context(), convID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't load join requests")
+		return
+	}
+
+	output := dto.PendingJoinRequestList{Requests: []dto.PendingJoinRequest{}}
+	for _, jr := range requests {
+		user, err := rt.db.GetUserByUUID(r.Context(), jr.UUIDUser)
+		if err != nil {
+			sendInternalError(w, ctx, err, "Can't load the user")
+			return
+		}
+		output.Requests = append(output.Requests, dto.PendingJoinRequest{
+			JoinRequest: dto.FromJoinRequest(jr),
+			Username:    user.Username,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// decideJoinRequest approva o rifiuta una richiesta di ingresso in attesa
+func (rt *_router) decideJoinRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	convID := ctx.Conversation.ID
+	requester := ps.ByName("uuid")
+
+	var body struct {
+		Approve *bool `json:"approve"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Approve == nil {
+		sendError(w, ctx, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	jr, err := rt.db.GetJoinRequest(r.Context(), requester, convID)
+	if err != nil {
+		sendDBError(w, ctx, err, "Join request not found")
+		return
+	}
+	if jr.Status != database.JoinRequestPending {
+		sendError(w, ctx, http.StatusConflict, "Join request already decided")
+		return
+	}
+
+	if *body.Approve {
+		err = rt.db.ApproveJoinRequest(r.Context(), requester, convID, ctx.UserUUID)
+	} else {
+		err = rt.db.DenyJoinRequest(r.Context(), requester, convID, ctx.UserUUID)
+	}
+	if err != nil {
+		sendDBError(w, ctx, err, "Join request not found")
+		return
+	}
+
+	// Ritorna la richiesta con l'esito
+	updated, err := rt.db.GetJoinRequest(r.Context(), requester, convID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't read the updated join request")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(dto.FromJoinRequest(updated)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
+
+// getMyJoinRequests restituisce all'utente autenticato le sue richieste di ingresso con il relativo esito
+func (rt *_router) getMyJoinRequests(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	w.Header().Set("Content-Type", "application/json")
+
+	requests, err := rt.db.GetJoinRequestsByUser(r.Context(), ctx.UserUUID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Database error")
+		return
+	}
+
+	output := dto.MyJoinRequestList{Requests: []dto.MyJoinRequest{}}
+	for _, jr := range requests {
+		item := dto.MyJoinRequest{JoinRequest: dto.FromJoinRequest(jr)}
+		if conv, err := rt.db.GetConversationByID(r.Context(), jr.IDConversation); err == nil {
+			item.GroupName = conv.GroupName
+		}
+		output.Requests = append(output.Requests, item)
+	}
+
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
+		return
+	}
+}
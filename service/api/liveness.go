@@ -1,16 +1,111 @@
 package api
 
 import (
-	"github.com/julienschmidt/httprouter"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
 )
 
-// liveness is an HTTP handler that checks the API server status. If the server cannot serve requests (e.g., some
-// resources are not ready), this should reply with HTTP Status 500. Otherwise, with HTTP Status 200
+// readinessTimeout è il tempo massimo dei controlli di readiness
+const readinessTimeout = 2 * time.Second
+
+// liveness is an HTTP handler that checks the API server status. Risponde sempre 200 finché il processo è in grado di
+// servire richieste: le dipendenze esterne (database, storage) sono controllate da readiness, così un database
+// irraggiungibile non fa riavviare il server.
 func (rt *_router) liveness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	/* Example of liveness check:
-	if err := rt.DB.Ping(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}*/
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(healthReport{Status: healthOK})
+}
+
+// Valori di healthReport.Status e healthCheck.Status
+const (
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+)
+
+// healthReport è la risposta di /liveness e /readiness
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// healthCheck è l'esito di un singolo controllo di readiness. Il motivo di un fallimento va solo nel log: /readiness
+// non richiede autenticazione e i messaggi di errore possono contenere percorsi e dettagli del database.
+type healthCheck struct {
+	Status string `json:"status"`
+}
+
+// readiness controlla che il server possa servire le richieste dell'API: il database risponde e ha lo schema
+// aggiornato, la cartella dei media è scrivibile e il server non si sta spegnendo. Risponde 200 se tutti i controlli
+// passano e 503 altrimenti, con l'esito di ogni controllo; il motivo dei fallimenti è registrato nel log.
+func (rt *_router) readiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]func() error{
+		"database":   func() error { return rt.db.Ping(ctx) },
+		"migrations": func() error { return rt.checkSchema(ctx) },
+		"media":      checkMediaDir,
+		"shutdown":   rt.checkNotClosing,
+	}
+
+	report := healthReport{Status: healthOK, Checks: make(map[string]healthCheck, len(checks))}
+	for name, check := range checks {
+		if err := check(); err != nil {
+			rt.baseLogger.WithError(err).WithField("check", name).Warn("readiness check failed")
+			report.Status = healthUnavailable
+			report.Checks[name] = healthCheck{Status: healthUnavailable}
+			continue
+		}
+		report.Checks[name] = healthCheck{Status: healthOK}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != healthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// checkSchema controlla che lo schema del database sia quello atteso da questo eseguibile
+func (rt *_router) checkSchema(ctx context.Context) error {
+	current, latest, err := rt.db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current < latest {
+		return fmt.Errorf("%d pending migrations (schema version %d, expected %d)", latest-current, current, latest)
+	}
+	if current > latest {
+		return fmt.Errorf("schema version %d is newer than the supported version %d", current, latest)
+	}
+	return nil
+}
+
+// checkMediaDir controlla che nella cartella dei media si possano salvare i file caricati
+func checkMediaDir() error {
+	if err := os.MkdirAll(MediaDir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(MediaDir, ".readiness-*")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkNotClosing fallisce dopo Close, così che il server smetta di ricevere traffico mentre termina le richieste in
+// corso
+func (rt *_router) checkNotClosing() error {
+	if atomic.LoadInt32(&rt.closing) != 0 {
+		return fmt.Errorf("server is shutting down")
+	}
+	return nil
 }
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// Motivi per cui un membro indicato in una richiesta viene rifiutato
+const (
+	memberNotFound = "not_found"
+	memberEmpty    = "empty"
+	memberSelf     = "self"
+)
+
+// memberError descrive un membro della richiesta che non è stato accettato
+type memberError struct {
+	Member string `json:"member"`
+	Reason string `json:"reason"`
+}
+
+// resolveMembers risolve i membri indicati dal client, per UUID o per username, negli UUID degli utenti esistenti.
+// Ritorna gli UUID senza duplicati e nell'ordine della richiesta, più un memberError per ogni voce che non corrisponde
+// a nessun utente. L'errore è valorizzato solo per i problemi di accesso al DB.
+func (rt *_router) resolveMembers(ctx context.Context, refs []string) ([]string, []memberError, error) {
+	var resolved []string
+	var invalid []memberError
+	seen := make(map[string]bool)
+
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			invalid = append(invalid, memberError{Member: ref, Reason: memberEmpty})
+			continue
+		}
+
+		user, err := rt.db.GetUserByUUID(ctx, ref)
+		if errors.Is(err, database.ErrNotFound) {
+			user, err = rt.db.GetUserByUsername(ctx, ref)
+		}
+		if errors.Is(err, database.ErrNotFound) {
+			invalid = append(invalid, memberError{Member: ref, Reason: memberNotFound})
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if !seen[user.UUID] {
+			seen[user.UUID] = true
+			resolved = append(resolved, user.UUID)
+		}
+	}
+
+	return resolved, invalid, nil
+}
@@ -2,10 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strconv"
-	"time"
 
+	"github.com/albyma98/WASAText/service/api/dto"
 	"github.com/albyma98/WASAText/service/api/reqcontext"
 	"github.com/albyma98/WASAText/service/database"
 	"github.com/julienschmidt/httprouter"
@@ -14,30 +14,7 @@ import (
 func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// ID conversazione dal path
-	convID, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
-	if err != nil {
-		http.Error(w, `{"error":"ID conversazione non valido"}`, http.StatusBadRequest)
-		return
-	}
-
-	// Verifica che la conversazione esista
-	_, err = rt.db.GetConversationByID(convID)
-	if err != nil {
-		http.Error(w, `{"error":"Conversazione non trovata"}`, http.StatusNotFound)
-		return
-	}
-
-	// Verifica che l’utente sia membro della conversazione
-	isMember, err := rt.db.IsMember(ctx.UserUUID, convID)
-	if err != nil {
-		http.Error(w, `{"error":"Errore interno durante il controllo membri"}`, http.StatusInternalServerError)
-		return
-	}
-	if !isMember {
-		http.Error(w, `{"error":"L’utente non fa parte della conversazione"}`, http.StatusForbidden)
-		return
-	}
+	convID := ctx.Conversation.ID
 
 	// Decode JSON request
 	var body struct {
@@ -47,28 +24,38 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		IDRepliesTo *int64  `json:"idRepliesTo"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, `{"error":"JSON non valido"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
 
 	// Validazioni logiche
 	if body.Type == "text" && (body.Content == nil || *body.Content == "") {
-		http.Error(w, `{"error":"Content richiesto per messaggio testuale"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "content is required for text messages")
 		return
 	}
 	if body.Type == "photo" && (body.MediaUrl == nil || *body.MediaUrl == "") {
-		http.Error(w, `{"error":"MediaUrl richiesto per messaggio foto"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "mediaUrl is required for photo messages")
 		return
 	}
 	if body.Type != "text" && body.Type != "photo" {
-		http.Error(w, `{"error":"Tipo di messaggio non valido"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Invalid message type")
 		return
 	}
 
-	// Se il messaggio è una reply, verifica che il messaggio esista
+	// Se il messaggio è una reply, verifica che il messaggio esista, appartenga alla conversazione e sia visibile
+	// all'utente secondo la politica di cronologia del gruppo
 	if body.IDRepliesTo != nil {
-		if _, err := rt.db.GetMessageByID(*body.IDRepliesTo); err != nil {
-			http.Error(w, `{"error":"Messaggio a cui rispondere non trovato"}`, http.StatusNotFound)
+		original, err := rt.db.GetMessageByID(r.Context(), *body.IDRepliesTo)
+		if err != nil {
+			sendDBError(w, ctx, err, "Replied message not found")
+			return
+		}
+		if original.IDConversation != convID {
+			sendError(w, ctx, http.StatusNotFound, "Replied message not found")
+			return
+		}
+		if !ctx.History.Contains(original.Timestamp) {
+			sendError(w, ctx, http.StatusNotFound, "Replied message not found")
 			return
 		}
 	}
@@ -87,20 +74,24 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 	}
 
 	// Inserisci messaggio
-	newID, err := rt.db.CreateMessage(msg)
+	newID, err := rt.db.CreateMessage(r.Context(), msg)
 	if err != nil {
-		http.Error(w, `{"error":"Errore durante la creazione del messaggio"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't create the message")
 		return
 	}
+	rt.metrics.messagesSent.Inc(msg.Type)
 
 	// Recupera messaggio completo (incluso timestamp)
-	msg.ID = newID
-	msg.Timestamp = time.Now().Format(time.RFC3339)
+	msg, err = rt.db.GetMessageByID(r.Context(), newID)
+	if err != nil {
+		sendInternalError(w, ctx, err, "Can't read the new message")
+		return
+	}
 
 	// Risposta
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(msg); err != nil {
-		http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromMessage(msg)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
@@ -109,85 +100,66 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Autenticazione
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o non valido"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// 1. Estrai ID dal path
-	idStr := ps.ByName("id")
-	msgID, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		http.Error(w, "ID non valido", http.StatusBadRequest)
-		return
-	}
-
-	// 2. Estrai UUID utente autenticato dal context
+	// 1. Estrai UUID utente autenticato dal context
 	uuid := ctx.UserUUID
 
-	// 3. Elimina messaggio (solo se inviato da lui)
-	err = rt.db.DeleteMessageByID(msgID, uuid)
+	// 2. Elimina messaggio (solo se inviato da lui)
+	err := rt.db.DeleteMessageByID(r.Context(), ctx.Message.ID, uuid)
 	if err != nil {
-		if err.Error() == "nessun messaggio eliminato (ID non esistente o UUID non corrispondente)" {
-			http.Error(w, `{"error":"Non autorizzato o messaggio non trovato"}`, http.StatusForbidden)
-		} else {
-			http.Error(w, `{"error":"Errore durante l'eliminazione"}`, http.StatusInternalServerError)
-		}
+		sendDBError(w, ctx, err, "Message not found or not sent by you")
 		return
 	}
 
-	// 4. Risposta 204
+	// 3. Risposta 204
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// 1. Estrai l'ID del messaggio dalla path
-	idMsg, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
-	if err != nil || idMsg <= 0 {
-		http.Error(w, `{"error":"ID messaggio non valido"}`, http.StatusBadRequest)
-		return
-	}
-
-	// 2. Decodifica il body JSON
+	// 1. Decodifica il body JSON
 	var body struct {
 		IdConversation int64 `json:"idConversation"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, `{"error":"Body malformato"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Malformed request body")
 		return
 	}
 	if body.IdConversation <= 0 {
-		http.Error(w, `{"error":"ID conversazione mancante o non valido"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Missing or invalid conversation ID")
 		return
 	}
 
-	// 3. Esegui l'inoltro del messaggio
-	newID, err := rt.db.ForwardMessage(idMsg, body.IdConversation, ctx.UserUUID)
-	if err != nil {
-		switch err.Error() {
-		case "messaggio originale non trovato":
-			http.Error(w, `{"error":"Messaggio non trovato"}`, http.StatusNotFound)
-		case "utente non autorizzato":
-			http.Error(w, `{"error":"Non puoi inoltrare in questa conversazione"}`, http.StatusForbidden)
-		default:
-			http.Error(w, `{"error":"Errore interno"}`, http.StatusInternalServerError)
-		}
+	// requireMessageAccess garantisce che l'utente possa vedere il messaggio nella conversazione di origine
+	original := ctx.Message
+
+	// I messaggi di sistema non possono essere inoltrati
+	if original.Type == "system" {
+		sendError(w, ctx, http.StatusBadRequest, "System messages can't be forwarded")
+		return
+	}
+
+	// 2. Esegui l'inoltro del messaggio
+	newID, err := rt.db.ForwardMessage(r.Context(), original.ID, body.IdConversation, ctx.UserUUID)
+	if errors.Is(err, database.ErrNotFound) {
+		sendError(w, ctx, http.StatusNotFound, "Message not found")
+		return
+	} else if err != nil {
+		sendDBError(w, ctx, err, "You can't forward to this conversation")
 		return
 	}
+	rt.metrics.messagesSent.Inc(original.Type)
 
-	// 4. Recupera il messaggio appena creato per inviarlo come risposta
-	forwardedMsg, err := rt.db.GetMessageByID(newID)
+	// 3. Recupera il messaggio appena creato per inviarlo come risposta
+	forwardedMsg, err := rt.db.GetMessageByID(r.Context(), newID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore nel recupero del messaggio inoltrato"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't load the forwarded message")
 		return
 	}
 
-	// 5. Risposta 201 con JSON del messaggio
+	// 4. Risposta 201 con JSON del messaggio
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(forwardedMsg); err != nil {
-		http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromMessage(forwardedMsg)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/albyma98/WASAText/service/globaltime"
+	"github.com/albyma98/WASAText/service/metrics"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Contatori delle richieste, pubblicati con expvar (su /debug/vars del server di debug). Sono variabili del package,
+// condivise da tutti i router: expvar non permette di pubblicare due volte lo stesso nome.
+var (
+	// requestsByRoute è il numero di richieste ricevute da ogni route, con chiave "METODO percorso" (ad esempio
+	// "GET /v1/conversations/:id"). Gli alias senza prefisso di versione hanno una chiave propria, così si vede quanto
+	// sono ancora usati.
+	requestsByRoute = expvar.NewMap("api_requests")
+
+	// activeRequests è il numero di richieste in corso. Sostituisce il contatore degli stream attivi previsto per il
+	// server di debug, che non viene pubblicato: l'API non ha stream (SSE, websocket o risposte in più parti) da
+	// contare, e le richieste in corso sono la misura di carico più vicina.
+	activeRequests = expvar.NewInt("api_active_requests")
+)
+
+// routeKey è la chiave del context della richiesta sotto cui counted salva il percorso della route
+type routeKey struct{}
+
+// counted aggiorna i contatori delle richieste prima e dopo next, per la route method e path, e salva path nel
+// context della richiesta per le metriche di wrap
+func counted(method string, path string, next httprouter.Handle) httprouter.Handle {
+	key := method + " " + path
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		requestsByRoute.Add(key, 1)
+		activeRequests.Add(1)
+		defer activeRequests.Add(-1)
+		next(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, path)), ps)
+	}
+}
+
+// routePath restituisce il percorso della route che serve r, come registrato (ad esempio "/v1/conversations/:id")
+func routePath(r *http.Request) string {
+	path, _ := r.Context().Value(routeKey{}).(string)
+	return path
+}
+
+// activeUserWindow è il tempo per cui un utente resta attivo dopo la sua ultima richiesta autenticata
+const activeUserWindow = 5 * time.Minute
+
+// apiMetrics sono le metriche del router, servite da MetricsHandler nel formato di Prometheus. Ogni router ha le
+// proprie, a differenza dei contatori di expvar.
+type apiMetrics struct {
+	registry *metrics.Registry
+
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	dbDuration      *metrics.Histogram
+	messagesSent    *metrics.Counter
+	uploadBytes     *metrics.Counter
+
+	// lastSeen è il momento dell'ultima richiesta autenticata di ogni utente, per il gauge degli utenti attivi
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+func newAPIMetrics() *apiMetrics {
+	reg := metrics.NewRegistry()
+	m := &apiMetrics{
+		registry: reg,
+		requests: reg.NewCounter("wasatext_http_requests_total",
+			"HTTP requests served, by route and status code.", "method", "route", "status"),
+		requestDuration: reg.NewHistogram("wasatext_http_request_duration_seconds",
+			"Time spent serving HTTP requests, by route and status code.", metrics.DefaultBuckets, "method", "route", "status"),
+		dbDuration: reg.NewHistogram("wasatext_db_operation_duration_seconds",
+			"Time spent in database operations, by AppDatabase method.", metrics.DefaultBuckets, "method"),
+		messagesSent: reg.NewCounter("wasatext_messages_sent_total",
+			"Messages sent or forwarded, by message type.", "type"),
+		uploadBytes: reg.NewCounter("wasatext_upload_bytes_total",
+			"Bytes of uploaded photos, by kind of upload.", "kind"),
+		lastSeen: make(map[string]time.Time),
+	}
+	reg.NewGaugeFunc("wasatext_active_users",
+		"Users with an authenticated request in the last "+activeUserWindow.String()+".", m.activeUsers)
+	return m
+}
+
+// observeRequest registra una richiesta servita da wrap, iniziata in start
+func (m *apiMetrics) observeRequest(r *http.Request, status int, start time.Time) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	code := strconv.Itoa(status)
+	route := routePath(r)
+	m.requests.Inc(r.Method, route, code)
+	m.requestDuration.Observe(time.Since(start).Seconds(), r.Method, route, code)
+}
+
+// userSeen segna l'utente come attivo
+func (m *apiMetrics) userSeen(uuid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSeen[uuid] = globaltime.Now()
+}
+
+// activeUsers conta gli utenti attivi, dimenticando quelli che non lo sono più
+func (m *apiMetrics) activeUsers() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit := globaltime.Now().Add(-activeUserWindow)
+	for uuid, seen := range m.lastSeen {
+		if seen.Before(limit) {
+			delete(m.lastSeen, uuid)
+		}
+	}
+	return float64(len(m.lastSeen))
+}
+
+// statusWriter inoltra la risposta e ne ricorda lo status e la dimensione del corpo. Come per http.ResponseWriter,
+// conta solo la prima chiamata a WriteHeader, e lo status è 200 se l'handler scrive il corpo senza chiamarla.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+// MetricsHandler restituisce l'handler delle metriche del router, vedi Router.MetricsHandler
+func (rt *_router) MetricsHandler() http.Handler {
+	return rt.metrics.registry
+}
@@ -0,0 +1,369 @@
+/*
+Package openapi valida richieste e risposte HTTP secondo una specifica OpenAPI 3.0.
+
+Supporta la parte della specifica usata da doc/api.yaml: percorsi con parametri, parametri di path e di query, corpi
+JSON descritti da uno schema (type, nullable, enum, properties, required, items, allOf/oneOf/anyOf, limiti di lunghezza,
+valori e numero di elementi, pattern e i formati uuid, date-time e int32) e i riferimenti `$ref` interni al documento.
+Le altre parole chiave vengono ignorate.
+
+Uso:
+
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		return err
+	}
+	op, params := spec.Find(r.Method, r.URL.Path)
+	if op != nil {
+		problems := op.ValidateRequest(r, params, body)
+		...
+	}
+*/
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Spec è una specifica OpenAPI caricata con Load
+type Spec struct {
+	operations []*Operation
+
+	// basePath è il percorso del primo server della specifica, vedi BasePath
+	basePath string
+}
+
+// Operation è un'operazione della specifica, cioè un metodo HTTP su un percorso
+type Operation struct {
+	// Method è il metodo HTTP, in maiuscolo
+	Method string
+
+	// Path è il percorso come scritto nella specifica, ad esempio /conversations/{id}
+	Path string
+
+	// ID è l'operationId
+	ID string
+
+	segments   []string
+	parameters []*Parameter
+	body       *RequestBody
+	responses  map[string]*Response
+}
+
+// Parameter è un parametro di path o di query
+type Parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+// RequestBody è il corpo accettato da un'operazione, per tipo di contenuto
+type RequestBody struct {
+	Ref      string                `yaml:"$ref"`
+	Required bool                  `yaml:"required"`
+	Content  map[string]*MediaType `yaml:"content"`
+}
+
+// Response è una delle risposte di un'operazione
+type Response struct {
+	Ref     string                `yaml:"$ref"`
+	Content map[string]*MediaType `yaml:"content"`
+}
+
+// MediaType descrive il corpo per un tipo di contenuto
+type MediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// document è la struttura del file YAML. Solo le parti usate dalla validazione vengono lette.
+type document struct {
+	Servers []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths      map[string]map[string]interface{} `yaml:"paths"`
+	Components struct {
+		Schemas       map[string]*Schema      `yaml:"schemas"`
+		Responses     map[string]*Response    `yaml:"responses"`
+		Parameters    map[string]*Parameter   `yaml:"parameters"`
+		RequestBodies map[string]*RequestBody `yaml:"requestBodies"`
+	} `yaml:"components"`
+}
+
+type operationDoc struct {
+	OperationID string               `yaml:"operationId"`
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+}
+
+var methods = map[string]string{
+	"get":     http.MethodGet,
+	"put":     http.MethodPut,
+	"post":    http.MethodPost,
+	"delete":  http.MethodDelete,
+	"patch":   http.MethodPatch,
+	"head":    http.MethodHead,
+	"options": http.MethodOptions,
+}
+
+// Load legge una specifica OpenAPI 3.0 in formato YAML e risolve i suoi riferimenti
+func Load(data []byte) (*Spec, error) {
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("lettura della specifica: %w", err)
+	}
+
+	r := resolver{doc: &doc, resolved: make(map[*Schema]bool)}
+	spec := &Spec{}
+	if len(doc.Servers) > 0 {
+		u, err := url.Parse(doc.Servers[0].URL)
+		if err != nil {
+			return nil, fmt.Errorf("indirizzo del server: %w", err)
+		}
+		spec.basePath = strings.TrimSuffix(u.Path, "/")
+	}
+	for path, item := range doc.Paths {
+		for key, value := range item {
+			method, ok := methods[key]
+			if !ok {
+				continue
+			}
+
+			// L'operazione viene ricodificata per leggerla nella sua struttura
+			raw, err := yaml.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			var od operationDoc
+			if err := yaml.Unmarshal(raw, &od); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+
+			op := &Operation{
+				Method:    method,
+				Path:      path,
+				ID:        od.OperationID,
+				segments:  strings.Split(strings.Trim(path, "/"), "/"),
+				responses: make(map[string]*Response),
+			}
+			for _, p := range od.Parameters {
+				p, err := r.parameter(p)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %w", method, path, err)
+				}
+				op.parameters = append(op.parameters, p)
+			}
+			if od.RequestBody != nil {
+				if op.body, err = r.requestBody(od.RequestBody); err != nil {
+					return nil, fmt.Errorf("%s %s: %w", method, path, err)
+				}
+			}
+			for status, resp := range od.Responses {
+				if op.responses[status], err = r.response(resp); err != nil {
+					return nil, fmt.Errorf("%s %s %s: %w", method, path, status, err)
+				}
+			}
+			spec.operations = append(spec.operations, op)
+		}
+	}
+
+	// Ordine stabile per Operations e per i messaggi di errore
+	sort.Slice(spec.operations, func(i, j int) bool {
+		a, b := spec.operations[i], spec.operations[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+	return spec, nil
+}
+
+// Operations restituisce tutte le operazioni della specifica, ordinate per percorso e metodo
+func (s *Spec) Operations() []*Operation {
+	return s.operations
+}
+
+// BasePath restituisce il percorso del primo server della specifica (ad esempio /v1 per http://example.com/v1), a cui
+// sono relativi i percorsi delle operazioni. È vuoto se la specifica non ne indica uno.
+func (s *Spec) BasePath() string {
+	return s.basePath
+}
+
+// Find cerca l'operazione che corrisponde a metodo e percorso della richiesta e restituisce anche i valori dei
+// parametri di path. Il percorso base (vedi BasePath), se presente all'inizio di path, viene ignorato. Se più percorsi
+// corrispondono vince quello con più parti fisse (/messages/{id}/reactions/me prima di /messages/{id}/reactions/{uuid}).
+// Restituisce nil se nessuna operazione corrisponde.
+func (s *Spec) Find(method string, path string) (*Operation, map[string]string) {
+	if s.basePath != "" && (path == s.basePath || strings.HasPrefix(path, s.basePath+"/")) {
+		path = path[len(s.basePath):]
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var best *Operation
+	var bestParams map[string]string
+	bestLiterals := -1
+	for _, op := range s.operations {
+		if op.Method != method || len(op.segments) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		literals := 0
+		for i, seg := range op.segments {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				if segments[i] == "" {
+					params = nil
+					break
+				}
+				params[seg[1:len(seg)-1]] = segments[i]
+				continue
+			}
+			if seg != segments[i] {
+				params = nil
+				break
+			}
+			literals++
+		}
+		if params != nil && literals > bestLiterals {
+			best, bestParams, bestLiterals = op, params, literals
+		}
+	}
+	return best, bestParams
+}
+
+// resolver sostituisce i `$ref` con gli oggetti di components a cui puntano
+type resolver struct {
+	doc *document
+
+	// resolved contiene gli schemi già visitati, per non ripetere il lavoro e non entrare in ciclo con gli schemi
+	// ricorsivi
+	resolved map[*Schema]bool
+}
+
+// refName restituisce il nome dell'oggetto a cui punta ref, che deve essere nella sezione indicata di components
+func refName(ref string, section string) (string, error) {
+	prefix := "#/components/" + section + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("riferimento non supportato: %s", ref)
+	}
+	return strings.TrimPrefix(ref, prefix), nil
+}
+
+func (r *resolver) parameter(p *Parameter) (*Parameter, error) {
+	if p.Ref != "" {
+		name, err := refName(p.Ref, "parameters")
+		if err != nil {
+			return nil, err
+		}
+		target, ok := r.doc.Components.Parameters[name]
+		if !ok {
+			return nil, fmt.Errorf("parametro %s non definito", p.Ref)
+		}
+		return r.parameter(target)
+	}
+	if p.Name == "" || (p.In != "path" && p.In != "query" && p.In != "header" && p.In != "cookie") {
+		return nil, errors.New("parametro senza nome o posizione")
+	}
+	var err error
+	p.Schema, err = r.schema(p.Schema)
+	return p, err
+}
+
+func (r *resolver) requestBody(b *RequestBody) (*RequestBody, error) {
+	if b.Ref != "" {
+		name, err := refName(b.Ref, "requestBodies")
+		if err != nil {
+			return nil, err
+		}
+		target, ok := r.doc.Components.RequestBodies[name]
+		if !ok {
+			return nil, fmt.Errorf("corpo %s non definito", b.Ref)
+		}
+		return r.requestBody(target)
+	}
+	return b, r.content(b.Content)
+}
+
+func (r *resolver) response(resp *Response) (*Response, error) {
+	if resp == nil {
+		return nil, errors.New("risposta vuota")
+	}
+	if resp.Ref != "" {
+		name, err := refName(resp.Ref, "responses")
+		if err != nil {
+			return nil, err
+		}
+		target, ok := r.doc.Components.Responses[name]
+		if !ok {
+			return nil, fmt.Errorf("risposta %s non definita", resp.Ref)
+		}
+		return r.response(target)
+	}
+	return resp, r.content(resp.Content)
+}
+
+func (r *resolver) content(content map[string]*MediaType) error {
+	for _, mt := range content {
+		if mt == nil {
+			continue
+		}
+		var err error
+		if mt.Schema, err = r.schema(mt.Schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schema risolve s e, ricorsivamente, tutti gli schemi che contiene. Restituisce lo schema da usare al posto di s.
+func (r *resolver) schema(s *Schema) (*Schema, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if s.Ref != "" {
+		name, err := refName(s.Ref, "schemas")
+		if err != nil {
+			return nil, err
+		}
+		target, ok := r.doc.Components.Schemas[name]
+		if !ok || target == nil {
+			return nil, fmt.Errorf("schema %s non definito", s.Ref)
+		}
+		return r.schema(target)
+	}
+	if r.resolved[s] {
+		return s, nil
+	}
+	r.resolved[s] = true
+
+	var err error
+	for name, prop := range s.Properties {
+		if s.Properties[name], err = r.schema(prop); err != nil {
+			return nil, err
+		}
+	}
+	if s.Items, err = r.schema(s.Items); err != nil {
+		return nil, err
+	}
+	for _, list := range [][]*Schema{s.AllOf, s.OneOf, s.AnyOf} {
+		for i := range list {
+			if list[i], err = r.schema(list[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s.Pattern != "" {
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", s.Pattern, err)
+		}
+	}
+	return s, nil
+}
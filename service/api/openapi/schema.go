@@ -0,0 +1,302 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema è uno schema OpenAPI (un sottoinsieme di JSON Schema)
+type Schema struct {
+	Ref string `yaml:"$ref"`
+
+	Type     string        `yaml:"type"`
+	Format   string        `yaml:"format"`
+	Nullable bool          `yaml:"nullable"`
+	Enum     []interface{} `yaml:"enum"`
+
+	Properties map[string]*Schema `yaml:"properties"`
+	Required   []string           `yaml:"required"`
+
+	// AdditionalProperties vale false per vietare le proprietà non dichiarate anche nella validazione non stretta
+	AdditionalProperties interface{} `yaml:"additionalProperties"`
+
+	Items *Schema   `yaml:"items"`
+	AllOf []*Schema `yaml:"allOf"`
+	OneOf []*Schema `yaml:"oneOf"`
+	AnyOf []*Schema `yaml:"anyOf"`
+
+	MinLength *int     `yaml:"minLength"`
+	MaxLength *int     `yaml:"maxLength"`
+	Pattern   string   `yaml:"pattern"`
+	Minimum   *float64 `yaml:"minimum"`
+	Maximum   *float64 `yaml:"maximum"`
+	MinItems  *int     `yaml:"minItems"`
+	MaxItems  *int     `yaml:"maxItems"`
+
+	pattern *regexp.Regexp
+}
+
+var uuidRx = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validator raccoglie i problemi trovati confrontando un valore con uno schema
+type validator struct {
+	// strict fa considerare un problema le proprietà non dichiarate negli schemi degli oggetti
+	strict bool
+
+	problems []string
+}
+
+func (v *validator) addf(path string, format string, args ...interface{}) {
+	if path == "" {
+		path = "$"
+	}
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+// decodeJSON decodifica data mantenendo i numeri come json.Number, per distinguere gli interi dai decimali
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("dati dopo il valore JSON")
+	}
+	return value, nil
+}
+
+// validate confronta value (decodificato da decodeJSON) con s. path è la posizione di value nel documento, usata nei
+// messaggi (ad esempio $.messages[0].id).
+func (v *validator) validate(s *Schema, value interface{}, path string) {
+	v.check(s, value, path, false)
+}
+
+// check è validate. Con open = true le proprietà non dichiarate da s sono ammesse anche nella validazione stretta: serve
+// per gli schemi di allOf, che descrivono solo una parte dell'oggetto (il controllo è fatto dallo schema che li
+// contiene).
+func (v *validator) check(s *Schema, value interface{}, path string, open bool) {
+	if s == nil {
+		return
+	}
+
+	for _, sub := range s.AllOf {
+		v.check(sub, value, path, true)
+	}
+	if len(s.OneOf) > 0 && v.matching(s.OneOf, value) != 1 {
+		v.addf(path, "deve corrispondere a esattamente uno degli schemi di oneOf")
+	}
+	if len(s.AnyOf) > 0 && v.matching(s.AnyOf, value) == 0 {
+		v.addf(path, "deve corrispondere ad almeno uno degli schemi di anyOf")
+	}
+
+	if value == nil {
+		if s.Type != "" && !s.Nullable {
+			v.addf(path, "null non ammesso")
+		}
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		v.addf(path, "valore %v non tra quelli ammessi %v", value, s.Enum)
+	}
+
+	switch s.Type {
+	case "":
+		// Senza type lo schema accetta qualsiasi valore (ad esempio `details` di Error)
+		if s.Properties != nil || len(s.AllOf) > 0 {
+			if obj, ok := value.(map[string]interface{}); ok {
+				v.object(s, obj, path, open)
+			}
+		}
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			v.addf(path, "atteso un oggetto, trovato %s", kind(value))
+			return
+		}
+		v.object(s, obj, path, open)
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			v.addf(path, "atteso un array, trovato %s", kind(value))
+			return
+		}
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			v.addf(path, "almeno %d elementi, trovati %d", *s.MinItems, len(arr))
+		}
+		if s.MaxItems != nil && len(arr) > *s.MaxItems {
+			v.addf(path, "al massimo %d elementi, trovati %d", *s.MaxItems, len(arr))
+		}
+		for i, item := range arr {
+			v.validate(s.Items, item, fmt.Sprintf("%s[%d]", pathOrRoot(path), i))
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			v.addf(path, "attesa una stringa, trovato %s", kind(value))
+			return
+		}
+		v.string(s, str, path)
+	case "integer", "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			v.addf(path, "atteso un numero, trovato %s", kind(value))
+			return
+		}
+		v.number(s, num, path)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.addf(path, "atteso un booleano, trovato %s", kind(value))
+		}
+	default:
+		v.addf(path, "tipo %q non supportato dallo schema", s.Type)
+	}
+}
+
+// matching restituisce quanti schemi di list accettano value
+func (v *validator) matching(list []*Schema, value interface{}) int {
+	n := 0
+	for _, sub := range list {
+		probe := validator{strict: v.strict}
+		probe.validate(sub, value, "")
+		if len(probe.problems) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (v *validator) object(s *Schema, obj map[string]interface{}, path string, open bool) {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			v.addf(path, "proprietà obbligatoria %q mancante", name)
+		}
+	}
+
+	// Le chiavi sono ordinate per avere i problemi sempre nello stesso ordine
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	closed := !open && (s.AdditionalProperties == false || (v.strict && s.AdditionalProperties == nil))
+	for _, k := range keys {
+		if prop, ok := s.Properties[k]; ok {
+			v.validate(prop, obj[k], pathOrRoot(path)+"."+k)
+		} else if _, ok := s.properties(k); !ok && closed {
+			v.addf(path, "proprietà %q non prevista dallo schema", k)
+		}
+	}
+}
+
+// properties cerca la proprietà name tra quelle di s e dei suoi sotto-schemi (allOf, oneOf, anyOf). Restituisce ok = false se non è dichiarata.
+func (s *Schema) properties(name string) (*Schema, bool) {
+	if prop, ok := s.Properties[name]; ok {
+		return prop, true
+	}
+	for _, list := range [][]*Schema{s.AllOf, s.OneOf, s.AnyOf} {
+		for _, sub := range list {
+			if sub == nil {
+				continue
+			}
+			if prop, ok := sub.properties(name); ok {
+				return prop, ok
+			}
+		}
+	}
+	return nil, false
+}
+
+func (v *validator) string(s *Schema, str string, path string) {
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		v.addf(path, "lunghezza minima %d, trovata %d", *s.MinLength, length)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		v.addf(path, "lunghezza massima %d, trovata %d", *s.MaxLength, length)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		v.addf(path, "%q non corrisponde al pattern %s", str, s.Pattern)
+	}
+	switch s.Format {
+	case "uuid":
+		if !uuidRx.MatchString(str) {
+			v.addf(path, "%q non è un UUID", str)
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			v.addf(path, "%q non è una data RFC 3339", str)
+		}
+	}
+}
+
+func (v *validator) number(s *Schema, num json.Number, path string) {
+	f, err := num.Float64()
+	if err != nil {
+		v.addf(path, "numero non valido %s", num)
+		return
+	}
+	if s.Type == "integer" {
+		i, err := strconv.ParseInt(num.String(), 10, 64)
+		if err != nil {
+			v.addf(path, "atteso un intero, trovato %s", num)
+			return
+		}
+		if s.Format == "int32" && (i < math.MinInt32 || i > math.MaxInt32) {
+			v.addf(path, "%d fuori dall'intervallo di int32", i)
+		}
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		v.addf(path, "%s minore del minimo %v", num, *s.Minimum)
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		v.addf(path, "%s maggiore del massimo %v", num, *s.Maximum)
+	}
+}
+
+// inEnum indica se value è uno dei valori di enum. I valori di enum vengono dal YAML, value dal JSON: il confronto
+// avviene sulla rappresentazione testuale.
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, value) || fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// kind descrive il tipo JSON di value, per i messaggi
+func kind(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "un oggetto"
+	case []interface{}:
+		return "un array"
+	case string:
+		return "una stringa"
+	case json.Number:
+		return "un numero"
+	case bool:
+		return "un booleano"
+	default:
+		return "null"
+	}
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "$"
+	}
+	return path
+}
@@ -0,0 +1,219 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateRequest confronta la richiesta con l'operazione: parametri di path (params, come restituiti da Find) e di
+// query, tipo di contenuto e corpo (body, già letto da r.Body). Le proprietà del corpo non dichiarate nella specifica
+// sono ammesse, come nella decodifica JSON degli handler. Restituisce i problemi trovati, nessuno se la richiesta è
+// valida.
+func (op *Operation) ValidateRequest(r *http.Request, params map[string]string, body []byte) []string {
+	v := validator{}
+
+	query := r.URL.Query()
+	for _, p := range op.parameters {
+		switch p.In {
+		case "path":
+			value, ok := params[p.Name]
+			if !ok {
+				v.addf("path."+p.Name, "parametro mancante")
+				continue
+			}
+			v.param(p, value)
+		case "query":
+			if _, ok := query[p.Name]; !ok {
+				if p.Required {
+					v.addf("query."+p.Name, "parametro obbligatorio mancante")
+				}
+				continue
+			}
+			v.param(p, query.Get(p.Name))
+		}
+	}
+
+	if op.body == nil {
+		return v.problems
+	}
+	if len(body) == 0 {
+		if op.body.Required {
+			v.addf("body", "corpo obbligatorio mancante")
+		}
+		return v.problems
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType, mtParams, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		v.addf("body", "tipo di contenuto %q non valido", contentType)
+		return v.problems
+	}
+	media, ok := lookupMedia(op.body.Content, mediaType)
+	if !ok {
+		v.addf("body", "tipo di contenuto %q non previsto", mediaType)
+		return v.problems
+	}
+
+	switch {
+	case isJSON(mediaType):
+		v.json(media.Schema, body)
+	case mediaType == "multipart/form-data":
+		v.multipart(media.Schema, body, mtParams["boundary"])
+	}
+	return v.problems
+}
+
+// ValidateResponse confronta una risposta dell'operazione con quelle previste: lo status deve essere dichiarato e il
+// corpo deve rispettare lo schema. A differenza di ValidateRequest le proprietà non dichiarate sono un problema: la
+// risposta è prodotta dal server, quindi ogni campo in più è una differenza dalla specifica.
+func (op *Operation) ValidateResponse(status int, header http.Header, body []byte) []string {
+	v := validator{strict: true}
+
+	resp, ok := op.responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.responses[strconv.Itoa(status/100)+"XX"]
+	}
+	if !ok {
+		resp, ok = op.responses["default"]
+	}
+	if !ok {
+		v.addf("status", "status %d non previsto dall'operazione %s", status, op.ID)
+		return v.problems
+	}
+
+	if len(resp.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			v.addf("body", "la risposta %d non prevede un corpo", status)
+		}
+		return v.problems
+	}
+
+	contentType := header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		v.addf("body", "tipo di contenuto %q non valido", contentType)
+		return v.problems
+	}
+	media, ok := lookupMedia(resp.Content, mediaType)
+	if !ok {
+		v.addf("body", "tipo di contenuto %q non previsto per la risposta %d", mediaType, status)
+		return v.problems
+	}
+	if isJSON(mediaType) {
+		v.json(media.Schema, body)
+	}
+	return v.problems
+}
+
+// param valida il valore testuale di un parametro, convertito nel tipo del suo schema
+func (v *validator) param(p *Parameter, raw string) {
+	path := p.In + "." + p.Name
+	if p.Schema == nil {
+		return
+	}
+
+	var value interface{} = raw
+	switch p.Schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			v.addf(path, "atteso un numero, trovato %q", raw)
+			return
+		}
+		value = json.Number(raw)
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			v.addf(path, "atteso un booleano, trovato %q", raw)
+			return
+		}
+		value = b
+	}
+	v.validate(p.Schema, value, path)
+}
+
+// json decodifica body e lo confronta con schema
+func (v *validator) json(schema *Schema, body []byte) {
+	value, err := decodeJSON(body)
+	if err != nil {
+		v.addf("body", "JSON non valido: %v", err)
+		return
+	}
+	v.validate(schema, value, "body")
+}
+
+// multipart controlla che il form contenga i campi obbligatori dello schema e, se lo schema lo vieta con
+// additionalProperties: false, nessun campo non dichiarato
+func (v *validator) multipart(schema *Schema, body []byte, boundary string) {
+	if boundary == "" {
+		v.addf("body", "boundary del form multipart mancante")
+		return
+	}
+
+	fields := make(map[string]bool)
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			v.addf("body", "form multipart non valido: %v", err)
+			return
+		}
+		fields[part.FormName()] = true
+		_ = part.Close()
+	}
+	if schema == nil {
+		return
+	}
+
+	for _, name := range schema.Required {
+		if !fields[name] {
+			v.addf("body", "campo obbligatorio %q mancante", name)
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := schema.properties(name); !ok && schema.AdditionalProperties == false {
+			v.addf("body", "campo %q non previsto dallo schema", name)
+		}
+	}
+}
+
+// lookupMedia cerca il tipo di contenuto tra quelli dichiarati, anche tramite i caratteri jolly (image/*, */*)
+func lookupMedia(content map[string]*MediaType, mediaType string) (*MediaType, bool) {
+	if media, ok := content[mediaType]; ok {
+		return nonNilMedia(media), true
+	}
+	if i := strings.Index(mediaType, "/"); i >= 0 {
+		if media, ok := content[mediaType[:i]+"/*"]; ok {
+			return nonNilMedia(media), true
+		}
+	}
+	media, ok := content["*/*"]
+	return nonNilMedia(media), ok
+}
+
+func nonNilMedia(media *MediaType) *MediaType {
+	if media == nil {
+		return &MediaType{}
+	}
+	return media
+}
+
+// isJSON indica se il tipo di contenuto è JSON (application/json o un tipo con suffisso +json)
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/albyma98/WASAText/doc"
+	"github.com/albyma98/WASAText/service/api/openapi"
+)
+
+// undocumentedRoutes sono le route di servizio che non fanno parte dell'API descritta in doc/api.yaml
+var undocumentedRoutes = map[route]bool{
+	{method: http.MethodGet, path: "/"}:             true,
+	{method: http.MethodGet, path: "/context"}:      true,
+	{method: http.MethodGet, path: "/liveness"}:     true,
+	{method: http.MethodGet, path: "/readiness"}:    true,
+	{method: http.MethodGet, path: "/openapi.yaml"}: true,
+}
+
+// specDrift confronta con la specifica tutte le richieste servite dagli harness e restituisce le differenze. Le risposte
+// devono essere sempre previste dalla specifica; le richieste solo se il server le ha accettate, perché i test dei
+// casi di errore inviano apposta richieste non valide.
+func specDrift() []string {
+	trafficMu.Lock()
+	defer trafficMu.Unlock()
+	if len(traffic) == 0 {
+		return nil
+	}
+
+	spec, err := openapi.Load(doc.APISpec)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	seen := make(map[string]bool)
+	var drift []string
+	for _, ex := range traffic {
+		op, params := spec.Find(ex.request.Method, ex.request.URL.Path)
+		if op == nil {
+			continue
+		}
+
+		problems := op.ValidateResponse(ex.status, ex.header, ex.respBody)
+		if ex.status >= 200 && ex.status <= 299 {
+			problems = append(problems, op.ValidateRequest(ex.request, params, ex.reqBody)...)
+		}
+		for _, p := range problems {
+			msg := fmt.Sprintf("%s %s (%d): %s", ex.route.method, ex.route.path, ex.status, p)
+			if !seen[msg] {
+				seen[msg] = true
+				drift = append(drift, msg)
+			}
+		}
+	}
+	sort.Strings(drift)
+	return drift
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	h := newHarness(t)
+
+	resp := h.anonymous().unversioned().get("/openapi.yaml").expect(http.StatusOK)
+	if !bytes.Equal(resp.body, doc.APISpec) || !strings.HasPrefix(resp.header.Get("Content-Type"), "application/yaml") {
+		t.Fatalf("specifica non servita correttamente (Content-Type %q)", resp.header.Get("Content-Type"))
+	}
+}
+
+// TestSpecDocumentsRoutes controlla che ogni route registrata in Handler sia descritta nella specifica e viceversa
+func TestSpecDocumentsRoutes(t *testing.T) {
+	h := newHarness(t)
+
+	documented := make(map[route]bool)
+	for _, op := range h.rt.spec.Operations() {
+		path := strings.NewReplacer("{", ":", "}", "").Replace(op.Path)
+		documented[route{method: op.Method, path: path}] = true
+	}
+
+	for _, r := range h.rt.routes {
+		if undocumentedRoutes[r] {
+			continue
+		}
+		if !strings.HasPrefix(r.path, apiV1.prefix+"/") {
+			t.Errorf("route fuori dalla versione 1 dell'API: %s %s", r.method, r.path)
+			continue
+		}
+		r.path = strings.TrimPrefix(r.path, apiV1.prefix)
+		if !documented[r] {
+			t.Errorf("route non documentata in doc/api.yaml: %s %s", r.method, r.path)
+		}
+		delete(documented, r)
+	}
+	for r := range documented {
+		t.Errorf("operazione di doc/api.yaml senza route: %s %s", r.method, r.path)
+	}
+}
+
+func TestValidationMiddleware(t *testing.T) {
+	h := newHarness(t, func(cfg *Config) { cfg.ValidateAPI = true })
+	alice := h.login("alice")
+	h.login("bob")
+
+	// Le richieste valide arrivano agli handler
+	conv := alice.createDirect("bob")
+	alice.sendText(conv, "ciao")
+
+	// Quelle non valide sono rifiutate con l'elenco dei problemi, anche quando l'handler le accetterebbe
+	var e struct {
+		Code    string   `json:"code"`
+		Details []string `json:"details"`
+	}
+	alice.post(fmt.Sprintf("/conversations/%d/messages", conv), map[string]string{"type": "text", "content": strings.Repeat("x", 501)}).
+		expect(http.StatusBadRequest).decode(&e)
+	if e.Code != codeBadRequest || len(e.Details) != 1 || !strings.HasPrefix(e.Details[0], "body.content:") {
+		t.Fatalf("errore di validazione inatteso: %+v", e)
+	}
+
+	alice.get("/conversations/0").expect(http.StatusBadRequest).decode(&e)
+	if len(e.Details) != 1 || !strings.HasPrefix(e.Details[0], "path.id:") {
+		t.Fatalf("errore di validazione inatteso: %+v", e)
+	}
+}
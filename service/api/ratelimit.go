@@ -0,0 +1,211 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/albyma98/WASAText/service/api/reqcontext"
+	"github.com/albyma98/WASAText/service/globaltime"
+	"github.com/julienschmidt/httprouter"
+)
+
+// rateLimitCleanupInterval è ogni quanto vengono eliminati i bucket inutilizzati dei limitatori
+const rateLimitCleanupInterval = time.Minute
+
+// RateLimit è il budget di richieste di un gruppo di route: Requests richieste subito, poi altre Requests distribuite
+// uniformemente in ogni intervallo Per (un token bucket). Il valore zero non limita le richieste.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRateLimit legge un budget nel formato "N/durata", ad esempio "10/1m" o "100/1h". La stringa vuota e "0"
+// indicano nessun limite.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" || s == "0" {
+		return RateLimit{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: expected requests/duration, e.g. 10/1m", s)
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: bad number of requests", s)
+	}
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: bad duration", s)
+	}
+	return RateLimit{Requests: requests, Per: per}, nil
+}
+
+// RateLimits sono i budget dei gruppi di route limitati. Ogni client ha un budget separato per IP (vedi clientIP); sulle
+// route autenticate anche ogni utente ha il proprio, e la richiesta deve rientrare in entrambi: il budget per utente
+// ferma chi cambia IP, quello per IP chi cambia account.
+type RateLimits struct {
+	// Session limita POST /session, che crea anche gli account
+	Session RateLimit
+
+	// Messages limita l'invio e l'inoltro dei messaggi
+	Messages RateLimit
+
+	// Uploads limita il caricamento delle foto del profilo e dei gruppi
+	Uploads RateLimit
+
+	// Search limita la ricerca di utenti e gruppi
+	Search RateLimit
+
+	// IPFactor moltiplica il budget per IP delle route autenticate rispetto a quello per utente, perché più utenti
+	// possono condividere lo stesso IP (ad esempio dietro un NAT). Se è zero il budget è lo stesso.
+	IPFactor int
+}
+
+// rateLimiter applica un RateLimit, con un token bucket per ogni client
+type rateLimiter struct {
+	limit RateLimit
+
+	// ip è il limitatore per IP delle richieste autenticate, che questo limita per utente (vedi RateLimits.IPFactor)
+	ip *rateLimiter
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket sono i token disponibili a un client al momento last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// refill è il numero di token aggiunti al bucket ogni secondo
+func (l *rateLimiter) refill() float64 {
+	return float64(l.limit.Requests) / l.limit.Per.Seconds()
+}
+
+// allow consuma un token del client key, se disponibile. Altrimenti restituisce false e il tempo da attendere prima
+// che un token sia di nuovo disponibile.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Requests), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Requests), b.tokens+now.Sub(b.last).Seconds()*l.refill())
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.refill() * float64(time.Second))
+	return false, wait
+}
+
+// cleanup elimina i bucket che a now sono di nuovo pieni: sono uguali a quelli creati alla prossima richiesta
+func (l *rateLimiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.refill() >= float64(l.limit.Requests) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit accetta le richieste finché il client ha budget in l, e risponde 429 con l'header Retry-After quando lo
+// ha esaurito. Con un limitatore nil le richieste non sono limitate.
+//
+// Le richieste autenticate consumano prima il budget dell'IP e poi quello dell'utente: una richiesta rifiutata per
+// l'IP non intacca il budget dell'utente, che non paga per gli altri account dietro lo stesso IP.
+func (rt *_router) rateLimit(l *rateLimiter, next httpRouterHandler) httpRouterHandler {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		now := globaltime.Now()
+		ipKey := "ip:" + rt.clientIP(r)
+
+		var ok bool
+		var wait time.Duration
+		if ctx.UserUUID == "" {
+			ok, wait = l.allow(ipKey, now)
+		} else if ok, wait = l.ip.allow(ipKey, now); ok {
+			ok, wait = l.allow("user:"+ctx.UserUUID, now)
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			sendError(w, ctx, http.StatusTooManyRequests, "Too many requests, retry later")
+			return
+		}
+		next(w, r, ps, ctx)
+	}
+}
+
+// rateLimiters sono i limitatori dei gruppi di RateLimits; quelli dei gruppi senza limite sono nil
+type rateLimiters struct {
+	session  *rateLimiter
+	messages *rateLimiter
+	uploads  *rateLimiter
+	search   *rateLimiter
+}
+
+func newRateLimiters(limits RateLimits) rateLimiters {
+	factor := limits.IPFactor
+	if factor <= 0 {
+		factor = 1
+	}
+	create := func(limit RateLimit) *rateLimiter {
+		if limit.Requests <= 0 || limit.Per <= 0 {
+			return nil
+		}
+		l := newRateLimiter(limit)
+		l.ip = newRateLimiter(RateLimit{Requests: limit.Requests * factor, Per: limit.Per})
+		return l
+	}
+	return rateLimiters{
+		session:  create(limits.Session),
+		messages: create(limits.Messages),
+		uploads:  create(limits.Uploads),
+		search:   create(limits.Search),
+	}
+}
+
+// all restituisce i limitatori attivi, compresi quelli per IP delle richieste autenticate
+func (ls rateLimiters) all() []*rateLimiter {
+	var out []*rateLimiter
+	for _, l := range []*rateLimiter{ls.session, ls.messages, ls.uploads, ls.search} {
+		if l != nil {
+			out = append(out, l, l.ip)
+		}
+	}
+	return out
+}
+
+// cleanupRateLimiters elimina periodicamente i bucket inutilizzati, finché stop non viene chiuso
+func (rt *_router) cleanupRateLimiters(stop <-chan struct{}) {
+	ticker := time.NewTicker(rateLimitCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for _, l := range rt.limiters.all() {
+				l.cleanup(globaltime.Now())
+			}
+		}
+	}
+}
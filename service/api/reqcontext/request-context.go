@@ -7,6 +7,7 @@ Each value here should be assumed valid only per request only, with some excepti
 package reqcontext
 
 import (
+	"github.com/albyma98/WASAText/service/database"
 	"github.com/gofrs/uuid"
 	"github.com/sirupsen/logrus"
 )
@@ -20,4 +21,14 @@ type RequestContext struct {
 	Logger logrus.FieldLogger
 
 	UserUUID string // ⬅️ aggiunto: UUID dell’utente autenticato
+
+	// Conversation is the conversation in the `:id` path parameter, loaded by the requireConversation* middlewares
+	// (or by the conversation of Message, for requireMessageAccess)
+	Conversation *database.Conversation
+
+	// Message is the message in the `:id` path parameter, loaded by requireMessageAccess
+	Message *database.Message
+
+	// History is the part of the Conversation history visible to the user
+	History database.HistoryWindow
 }
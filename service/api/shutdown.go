@@ -1,6 +1,15 @@
 package api
 
+import "sync/atomic"
+
 // Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines.
+// Da questo momento readiness risponde 503, così che il server non riceva nuovo traffico durante lo spegnimento. Le
+// esportazioni dei dati in corso vengono interrotte e attese.
 func (rt *_router) Close() error {
+	atomic.StoreInt32(&rt.closing, 1)
+	rt.closeOnce.Do(func() {
+		close(rt.stop)
+	})
+	rt.exports.running.Wait()
 	return nil
 }
@@ -3,8 +3,8 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
 
+	"github.com/albyma98/WASAText/service/api/dto"
 	"github.com/albyma98/WASAText/service/api/reqcontext"
 	"github.com/julienschmidt/httprouter"
 )
@@ -12,18 +12,7 @@ import (
 func (rt *_router) updateMessageStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Token mancante o invalido"}`, http.StatusUnauthorized)
-		return
-	}
-
-	// Estrai ID messaggio
-	idStr := ps.ByName("id")
-	messageID, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		http.Error(w, `{"error":"ID non valido"}`, http.StatusBadRequest)
-		return
-	}
+	messageID := ctx.Message.ID
 
 	// Decodifica il body
 	var req struct {
@@ -31,48 +20,44 @@ func (rt *_router) updateMessageStatus(w http.ResponseWriter, r *http.Request, p
 		Seen      bool `json:"seen"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"Formato JSON non valido"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
 
 	// Non si può mettere false
 	if !req.Delivered && !req.Seen {
-		http.Error(w, `{"error":"Non è possibile impostare delivered o seen a false"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Delivered and seen can't be set to false")
 		return
 	}
 
 	// Controlla se esiste lo status
-	_, err = rt.db.GetMessageStatus(ctx.UserUUID, messageID)
+	_, err := rt.db.GetMessageStatus(r.Context(), ctx.UserUUID, messageID)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			http.Error(w, `{"error":"Messaggio non trovato o stato non tracciabile"}`, http.StatusNotFound)
-		} else {
-			http.Error(w, `{"error":"Errore interno"}`, http.StatusInternalServerError)
-		}
+		sendDBError(w, ctx, err, "Message not found or status not tracked")
 		return
 	}
 
 	// Aggiorna i campi richiesti
 	if req.Seen {
-		err = rt.db.SetSeen(ctx.UserUUID, messageID)
+		err = rt.db.SetSeen(r.Context(), ctx.UserUUID, messageID)
 	} else if req.Delivered {
-		err = rt.db.SetDelivered(ctx.UserUUID, messageID)
+		err = rt.db.SetDelivered(r.Context(), ctx.UserUUID, messageID)
 	}
 	if err != nil {
-		http.Error(w, `{"error":"Errore durante aggiornamento"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't update the status")
 		return
 	}
 
 	// Recupera lo status aggiornato
-	status, err := rt.db.GetMessageStatus(ctx.UserUUID, messageID)
+	status, err := rt.db.GetMessageStatus(r.Context(), ctx.UserUUID, messageID)
 	if err != nil {
-		http.Error(w, `{"error":"Errore nel recupero dello stato aggiornato"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Can't load the updated status")
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(status); err != nil {
-		http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromMessageStatus(status)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
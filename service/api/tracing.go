@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/albyma98/WASAText/service/tracing"
+)
+
+// startRequestSpan apre lo span della richiesta, figlio di quello indicato dal client nell'header traceparent, e lo
+// comunica al client nell'header traceresponse. Restituisce la richiesta con lo span nel context, a cui si
+// agganciano gli span delle chiamate al database.
+func (rt *_router) startRequestSpan(w http.ResponseWriter, r *http.Request) (*http.Request, *tracing.Span) {
+	ctx, span := rt.tracer.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+routePath(r), tracing.KindServer)
+	tracing.Inject(ctx, w.Header(), tracing.TraceResponseHeader)
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.route", routePath(r))
+	span.SetAttribute("http.target", r.URL.Path)
+	return r.WithContext(ctx), span
+}
+
+// endRequestSpan chiude lo span della richiesta con lo status della risposta; le risposte 5xx segnano lo span come
+// fallito
+func endRequestSpan(span *tracing.Span, status int) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttribute("http.status_code", status)
+	if status >= 500 {
+		span.SetError(fmt.Errorf("HTTP status %d", status))
+	}
+	span.End()
+}
+
+// observeDB misura una chiamata all'AppDatabase per le metriche di /metrics e la registra come span figlio di quello
+// della richiesta, vedi database.Instrument
+func (rt *_router) observeDB(ctx context.Context, method string) func(err error) {
+	start := time.Now()
+	_, span := rt.tracer.Start(ctx, "db."+method, tracing.KindClient)
+	span.SetAttribute("db.operation", method)
+	return func(err error) {
+		rt.metrics.dbDuration.Observe(time.Since(start).Seconds(), method)
+		span.SetError(err)
+		span.End()
+	}
+}
@@ -4,35 +4,30 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"regexp"
 	"time"
 
+	"github.com/albyma98/WASAText/service/api/dto"
 	"github.com/albyma98/WASAText/service/api/reqcontext"
 	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
 )
 
 // Handler per GET /user/me
 func (rt *_router) getMyUserInfo(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// Verifica presenza del token (già validato da wrap)
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
 	// Recupera utente autenticato
-	user, err := rt.db.GetUserByUUID(ctx.UserUUID)
+	user, err := rt.db.GetUserByUUID(r.Context(), ctx.UserUUID)
 	if err != nil {
-		http.Error(w, `{"error":"User not found or database error"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "User not found")
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromUser(user)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
@@ -41,59 +36,54 @@ func (rt *_router) getMyUserInfo(w http.ResponseWriter, r *http.Request, _ httpr
 func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
 	var req struct {
 		Username *string `json:"username"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"Invalid request body"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Aggiorna username se presente
 	if req.Username != nil {
 		if len(*req.Username) < 3 || len(*req.Username) > 16 {
-			http.Error(w, `{"error":"Username must be between 3 and 16 characters"}`, http.StatusBadRequest)
+			sendError(w, ctx, http.StatusBadRequest, "Username must be between 3 and 16 characters")
 			return
 		}
 		if !regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString(*req.Username) {
-			http.Error(w, `{"error":"Username format invalid"}`, http.StatusBadRequest)
+			sendError(w, ctx, http.StatusBadRequest, "Username format invalid")
 			return
 		}
 
 		// Verifica che non sia già in uso da un altro utente
-		users, err := rt.db.SearchUsersByPrefix(*req.Username)
+		users, err := rt.db.SearchUsersByPrefix(r.Context(), *req.Username)
 		if err != nil {
-			http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+			sendInternalError(w, ctx, err, "Database error")
 			return
 		}
 		for _, u := range users {
 			if u.Username == *req.Username && u.UUID != ctx.UserUUID {
-				http.Error(w, `{"error":"Username already in use"}`, http.StatusConflict)
+				sendError(w, ctx, http.StatusConflict, "Username already in use")
 				return
 			}
 		}
 
-		if err := rt.db.SetUserName(ctx.UserUUID, *req.Username); err != nil {
-			http.Error(w, `{"error":"Unable to update username"}`, http.StatusInternalServerError)
+		if err := rt.db.SetUserName(r.Context(), ctx.UserUUID, *req.Username); err != nil {
+			sendDBError(w, ctx, err, "Username already in use")
 			return
 		}
 	}
 
 	// Recupera e restituisci utente aggiornato
-	user, err := rt.db.GetUserByUUID(ctx.UserUUID)
+	user, err := rt.db.GetUserByUUID(r.Context(), ctx.UserUUID)
 	if err != nil {
-		http.Error(w, `{"error":"Unable to retrieve updated user"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Unable to retrieve updated user")
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromUser(user)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
@@ -103,81 +93,70 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, _ httprout
 	w.Header().Set("Content-Type", "application/json")
 
 	// Crea ./webui/public/ se non esiste (evita errore "no such file or directory")
-	if err := os.MkdirAll("./webui/public", os.ModePerm); err != nil {
-		log.Println("❌ Errore creazione cartella ./webui/public:", err)
-		http.Error(w, `{"error":"Cannot create upload directory"}`, http.StatusInternalServerError)
-		return
-	}
-
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+	if err := os.MkdirAll(MediaDir, os.ModePerm); err != nil {
+		sendInternalError(w, ctx, err, "Cannot create upload directory")
 		return
 	}
 
-	// 🔍 DEBUG HEADERS E CONTENT-TYPE
-	log.Println("🛂 Headers:", r.Header)
-	log.Println("📦 Content-Type:", r.Header.Get("Content-Type"))
-
 	err := r.ParseMultipartForm(10 << 20) // max 10MB
 	if err != nil {
-		log.Println("❌ Errore ParseMultipartForm:", err)
-		http.Error(w, `{"error":"Cannot parse form data"}`, http.StatusBadRequest)
+		ctx.Logger.WithError(err).Debug("can't parse the multipart form")
+		sendError(w, ctx, http.StatusBadRequest, "Cannot parse form data")
 		return
 	}
 
 	file, handler, err := r.FormFile("photo")
 	if err != nil {
-		log.Println("⚠️ ERRORE R.FormFile:", err)
-		http.Error(w, `{"error":"File not found in request"}`, http.StatusBadRequest)
+		ctx.Logger.WithError(err).Debug("photo missing in the multipart form")
+		sendError(w, ctx, http.StatusBadRequest, "File not found in request")
 		return
 	}
 	defer file.Close()
 
-	// 🔍 LOG SUCCESSSO FILE
-	log.Println("✅ FILE TROVATO:", handler.Filename, handler.Header.Get("Content-Type"))
+	ctx.Logger.WithFields(logrus.Fields{
+		"filename":     handler.Filename,
+		"content-type": handler.Header.Get("Content-Type"),
+		"size":         handler.Size,
+	}).Debug("photo received")
 
 	// Genera nome sicuro per il file
 	safeName := regexp.MustCompile(`[^a-zA-Z0-9\.\-_]`).ReplaceAllString(handler.Filename, "_")
 	filename := fmt.Sprintf("%s_%d_%s", ctx.UserUUID, time.Now().Unix(), safeName)
-	filepath := "./webui/public/" + filename
+	filepath := MediaDir + "/" + filename
 
 	// Salva il file
 	dst, err := os.Create(filepath)
 	if err != nil {
-		log.Println("❌ Errore salvataggio file:", err)
-		http.Error(w, `{"error":"Cannot save file"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Cannot save file")
 		return
 	}
 	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
+	written, err := io.Copy(dst, file)
 	if err != nil {
-		log.Println("❌ Errore copia file:", err)
-		http.Error(w, `{"error":"Error saving file"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Error saving file")
 		return
 	}
+	rt.metrics.uploadBytes.Add(float64(written), "user_photo")
 
 	// Path da salvare nel DB
 	publicPath := "/" + filename
 
 	// Salva nel DB
-	if err := rt.db.SetPhotoUrl(ctx.UserUUID, publicPath); err != nil {
-		log.Println("❌ Errore salvataggio DB:", err)
-		http.Error(w, `{"error":"Unable to update photo URL"}`, http.StatusInternalServerError)
+	if err := rt.db.SetPhotoUrl(r.Context(), ctx.UserUUID, publicPath); err != nil {
+		sendInternalError(w, ctx, err, "Unable to update photo URL")
 		return
 	}
 
 	// Restituisci utente aggiornato
-	user, err := rt.db.GetUserByUUID(ctx.UserUUID)
+	user, err := rt.db.GetUserByUUID(r.Context(), ctx.UserUUID)
 	if err != nil {
-		log.Println("❌ Errore recupero utente:", err)
-		http.Error(w, `{"error":"Unable to retrieve updated user"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Unable to retrieve updated user")
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		log.Printf("Errore nell'encoding JSON della risposta user: %v", err)
-		http.Error(w, "Errore nel generare la risposta", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromUser(user)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 }
@@ -185,19 +164,14 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, _ httprout
 func (rt *_router) getAllUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
-	users, err := rt.db.GetAllUsers()
+	users, err := rt.db.GetAllUsers(r.Context())
 	if err != nil {
-		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Database error")
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(users); err != nil {
-		http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromUsers(users)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
@@ -206,26 +180,21 @@ func (rt *_router) getAllUsers(w http.ResponseWriter, r *http.Request, _ httprou
 func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if ctx.UserUUID == "" {
-		http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
-		return
-	}
-
 	// Legge il parametro "search" dalla query string
 	query := r.URL.Query().Get("search")
 	if query == "" || len(query) < 1 || len(query) > 16 || !regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString(query) {
-		http.Error(w, `{"error":"Invalid search parameter"}`, http.StatusBadRequest)
+		sendError(w, ctx, http.StatusBadRequest, "Invalid search parameter")
 		return
 	}
 
-	users, err := rt.db.SearchUsersByPrefix(query)
+	users, err := rt.db.SearchUsersByPrefix(r.Context(), query)
 	if err != nil {
-		http.Error(w, `{"error":"Database error"}`, http.StatusInternalServerError)
+		sendInternalError(w, ctx, err, "Database error")
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(users); err != nil {
-		http.Error(w, "errore nella codifica della risposta", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(dto.FromUsers(users)); err != nil {
+		ctx.Logger.WithError(err).Error("can't encode the response")
 		return
 	}
 
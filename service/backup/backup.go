@@ -0,0 +1,416 @@
+/*
+Package backup crea e ripristina gli archivi di backup di un'istanza: il database SQLite, copiato mentre è in uso con
+database.Admin.Snapshot, e le foto caricate (api.MediaDir).
+
+Un archivio è un file tar compresso con gzip che contiene, in quest'ordine:
+
+	wasatext.db      la copia del database
+	media/...        le foto, con i percorsi relativi alla cartella dei media
+	MANIFEST.json    il Manifest: data di creazione, versione dello schema, dimensione e SHA-256 di ogni file
+
+Il manifest è l'ultimo file perché le checksum vengono calcolate durante la scrittura. Verify e Restore rifiutano un
+archivio se manca un file elencato nel manifest, se ce n'è uno non elencato o se una checksum non corrisponde.
+
+Schedule crea periodicamente gli archivi in una cartella, conservando solo i più recenti.
+*/
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// Nomi dei file nell'archivio
+const (
+	DatabaseFile = "wasatext.db"
+	MediaPrefix  = "media/"
+	ManifestFile = "MANIFEST.json"
+)
+
+// FormatVersion è la versione del formato degli archivi scritti da questo package
+const FormatVersion = 1
+
+// Manifest descrive il contenuto di un archivio
+type Manifest struct {
+	FormatVersion int       `json:"formatVersion"`
+	CreatedAt     time.Time `json:"createdAt"`
+
+	// SchemaVersion è la versione dello schema del database copiato (vedi database.AppDatabase.SchemaVersion)
+	SchemaVersion int `json:"schemaVersion"`
+
+	Files []File `json:"files"`
+}
+
+// File è un file dell'archivio, con il percorso usato nell'archivio
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Snapshotter copia il database in un file SQLite; è implementato da database.Admin
+type Snapshotter interface {
+	Snapshot(ctx context.Context, path string) error
+}
+
+// Write scrive in w un archivio con una copia del database db e i file in mediaDir. Una cartella dei media inesistente
+// equivale a una cartella vuota; i file nascosti (che iniziano con un punto) vengono ignorati.
+func Write(ctx context.Context, db Snapshotter, mediaDir string, w io.Writer) (Manifest, error) {
+	tmp, err := os.MkdirTemp("", "wasatext-backup-*")
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	snapshot := filepath.Join(tmp, DatabaseFile)
+	if err := db.Snapshot(ctx, snapshot); err != nil {
+		return Manifest{}, fmt.Errorf("copying the database: %w", err)
+	}
+	version, err := database.VerifySnapshot(ctx, snapshot)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("checking the database copy: %w", err)
+	}
+
+	m := Manifest{FormatVersion: FormatVersion, CreatedAt: time.Now().UTC(), SchemaVersion: version}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if err := addFile(tw, &m, DatabaseFile, snapshot); err != nil {
+		return m, err
+	}
+	media, err := mediaFiles(mediaDir)
+	if err != nil {
+		return m, fmt.Errorf("listing the media files: %w", err)
+	}
+	for _, rel := range media {
+		if err := ctx.Err(); err != nil {
+			return m, err
+		}
+		if err := addFile(tw, &m, MediaPrefix+rel, filepath.Join(mediaDir, filepath.FromSlash(rel))); err != nil {
+			return m, err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: ManifestFile, Mode: 0o644, Size: int64(len(manifest)), ModTime: m.CreatedAt})
+	if err != nil {
+		return m, err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return m, err
+	}
+	if err := tw.Close(); err != nil {
+		return m, err
+	}
+	return m, gz.Close()
+}
+
+// mediaFiles restituisce i percorsi, relativi a dir e separati da "/", dei file non nascosti in dir
+func mediaFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == dir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if p != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+// addFile aggiunge all'archivio il file in src con il nome name, registrandone la checksum nel manifest
+func addFile(tw *tar.Writer, m *Manifest, name string, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(tw, io.TeeReader(f, h)); err != nil {
+		return fmt.Errorf("archiving %s: %w", name, err)
+	}
+	m.Files = append(m.Files, File{Path: name, Size: info.Size(), SHA256: hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+// Verify legge l'archivio da r e controlla che corrisponda al suo manifest. Se dir non è vuota, i file vengono estratti
+// in dir (che deve esistere) con i percorsi dell'archivio.
+func Verify(r io.Reader, dir string) (Manifest, error) {
+	var m Manifest
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return m, fmt.Errorf("not a backup archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	found := make(map[string]File)
+	manifest := false
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return m, fmt.Errorf("reading the archive: %w", err)
+		}
+		if manifest {
+			return m, fmt.Errorf("unexpected file %q after the manifest", hdr.Name)
+		}
+		if hdr.Name == ManifestFile {
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return m, fmt.Errorf("reading the manifest: %w", err)
+			}
+			manifest = true
+			continue
+		}
+		if !validName(hdr.Name) || hdr.Typeflag != tar.TypeReg {
+			return m, fmt.Errorf("unexpected file %q in the archive", hdr.Name)
+		}
+		if _, ok := found[hdr.Name]; ok {
+			return m, fmt.Errorf("duplicate file %q in the archive", hdr.Name)
+		}
+
+		f, err := extract(tr, hdr.Name, dir)
+		if err != nil {
+			return m, err
+		}
+		found[hdr.Name] = f
+	}
+
+	if !manifest {
+		return m, errors.New("the archive has no manifest")
+	}
+	if m.FormatVersion != FormatVersion {
+		return m, fmt.Errorf("unsupported archive format version %d", m.FormatVersion)
+	}
+	if _, ok := found[DatabaseFile]; !ok {
+		return m, errors.New("the archive has no database")
+	}
+	for _, want := range m.Files {
+		got, ok := found[want.Path]
+		if !ok {
+			return m, fmt.Errorf("%s is listed in the manifest but missing", want.Path)
+		}
+		if got != want {
+			return m, fmt.Errorf("%s does not match the manifest (size %d, sha256 %s)", want.Path, got.Size, got.SHA256)
+		}
+		delete(found, want.Path)
+	}
+	if len(found) > 0 {
+		var extra []string
+		for name := range found {
+			extra = append(extra, name)
+		}
+		sort.Strings(extra)
+		return m, fmt.Errorf("%s is not listed in the manifest", strings.Join(extra, ", "))
+	}
+	return m, nil
+}
+
+// validName controlla che name sia il database o un file dentro media/, senza componenti che escano dalla cartella
+func validName(name string) bool {
+	if name == DatabaseFile {
+		return true
+	}
+	rel := strings.TrimPrefix(name, MediaPrefix)
+	return rel != name && rel != "" && path.Clean(rel) == rel && !path.IsAbs(rel) && rel != ".." &&
+		!strings.HasPrefix(rel, "../")
+}
+
+// extract legge il file corrente dell'archivio calcolandone la checksum e, se dir non è vuota, lo salva in dir
+func extract(r io.Reader, name string, dir string) (File, error) {
+	out := io.Discard
+	if dir != "" {
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return File{}, err
+		}
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return File{}, err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(out, io.TeeReader(r, h))
+	if err != nil {
+		return File{}, fmt.Errorf("extracting %s: %w", name, err)
+	}
+	return File{Path: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Restore ripristina l'archivio in archive: il database diventa il file in dbPath e le foto vengono copiate in
+// mediaDir, sovrascrivendo quelle con lo stesso nome. L'archivio viene verificato per intero, compresa l'integrità del
+// database, prima di modificare qualsiasi file. Il server non deve essere in esecuzione.
+func Restore(ctx context.Context, archive string, dbPath string, mediaDir string) (Manifest, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer f.Close()
+
+	// I file vengono estratti accanto al database, così da poterlo sostituire con un rename nello stesso filesystem
+	tmp, err := os.MkdirTemp(filepath.Dir(dbPath), ".wasatext-restore-*")
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	m, err := Verify(f, tmp)
+	if err != nil {
+		return m, err
+	}
+	snapshot := filepath.Join(tmp, DatabaseFile)
+	if _, err := database.VerifySnapshot(ctx, snapshot); err != nil {
+		return m, fmt.Errorf("checking the archived database: %w", err)
+	}
+
+	// I file del journal del database precedente non valgono per quello ripristinato
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			return m, err
+		}
+	}
+	if err := os.Rename(snapshot, dbPath); err != nil {
+		return m, fmt.Errorf("replacing the database: %w", err)
+	}
+
+	for _, file := range m.Files {
+		rel := strings.TrimPrefix(file.Path, MediaPrefix)
+		if rel == file.Path {
+			continue
+		}
+		if err := copyFile(filepath.Join(tmp, filepath.FromSlash(file.Path)), filepath.Join(mediaDir, filepath.FromSlash(rel))); err != nil {
+			return m, fmt.Errorf("restoring %s: %w", file.Path, err)
+		}
+	}
+	return m, nil
+}
+
+// copyFile copia src in dst, creando le cartelle mancanti
+func copyFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// archivePrefix e archiveSuffix delimitano i nomi degli archivi creati da CreateFile; in mezzo c'è la data di
+// creazione in UTC, così l'ordine alfabetico è anche quello cronologico
+const (
+	archivePrefix = "wasatext-"
+	archiveSuffix = ".tar.gz"
+	archiveTime   = "20060102T150405Z"
+)
+
+// CreateFile scrive un nuovo archivio nella cartella dir e ne restituisce il percorso. L'archivio viene scritto in un
+// file temporaneo e rinominato solo se completo, così nella cartella non restano archivi a metà.
+func CreateFile(ctx context.Context, db Snapshotter, mediaDir string, dir string) (string, Manifest, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", Manifest{}, err
+	}
+	f, err := os.CreateTemp(dir, ".wasatext-*.tmp")
+	if err != nil {
+		return "", Manifest{}, err
+	}
+	defer os.Remove(f.Name())
+
+	m, err := Write(ctx, db, mediaDir, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return "", m, err
+	}
+
+	dst := filepath.Join(dir, archivePrefix+m.CreatedAt.Format(archiveTime)+archiveSuffix)
+	return dst, m, os.Rename(f.Name(), dst)
+}
+
+// Prune elimina dalla cartella dir gli archivi creati da CreateFile, tranne i keep più recenti, e restituisce i
+// percorsi di quelli eliminati
+func Prune(dir string, keep int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var archives []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, archivePrefix) && strings.HasSuffix(name, archiveSuffix) {
+			archives = append(archives, name)
+		}
+	}
+	sort.Strings(archives)
+
+	var removed []string
+	for len(archives) > keep {
+		p := filepath.Join(dir, archives[0])
+		if err := os.Remove(p); err != nil {
+			return removed, err
+		}
+		removed = append(removed, p)
+		archives = archives[1:]
+	}
+	return removed, nil
+}
@@ -0,0 +1,207 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/albyma98/WASAText/service/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openDB apre un database SQLite nel file path, chiuso alla fine del test
+func openDB(t *testing.T, path string) database.Admin {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", path+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := database.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.(database.Admin)
+}
+
+// writeFiles crea in dir i file indicati da files (percorso relativo → contenuto)
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := openDB(t, filepath.Join(dir, "source.db"))
+	if err := db.CreateUser(ctx, "alice", "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	media := filepath.Join(dir, "media")
+	writeFiles(t, media, map[string]string{"foto.png": "png", "sub/altra.jpg": "jpg", ".readiness-123": "temp"})
+
+	archive, m, err := CreateFile(ctx, db, media, filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 3 || m.Files[0].Path != DatabaseFile || m.SchemaVersion == 0 {
+		t.Fatalf("manifest inatteso: %+v", m)
+	}
+
+	// Il database cambia dopo il backup: il ripristino lo riporta allo stato precedente
+	if err := db.CreateUser(ctx, "bob", "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	restoredDB := filepath.Join(dir, "restored.db")
+	restoredMedia := filepath.Join(dir, "restored-media")
+	if _, err := Restore(ctx, archive, restoredDB, restoredMedia); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openDB(t, restoredDB)
+	users, err := restored.GetAllUsers(ctx)
+	if err != nil || len(users) != 1 || users[0].UUID != "alice" {
+		t.Fatalf("utenti ripristinati: %+v, %v", users, err)
+	}
+	content, err := os.ReadFile(filepath.Join(restoredMedia, "sub", "altra.jpg"))
+	if err != nil || string(content) != "jpg" {
+		t.Fatalf("foto ripristinata: %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(restoredMedia, ".readiness-123")); !os.IsNotExist(err) {
+		t.Fatalf("i file nascosti non vanno inclusi: %v", err)
+	}
+}
+
+// rewrite riscrive l'archivio in data applicando edit a ogni file; edit restituisce false per ometterlo
+func rewrite(t *testing.T, data []byte, edit func(hdr *tar.Header, content []byte) ([]byte, bool)) []byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, keep := edit(hdr, content)
+		if !keep {
+			continue
+		}
+		hdr.Size = int64(len(content))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestVerifyRejectsTamperedArchives(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := openDB(t, filepath.Join(dir, "source.db"))
+	media := filepath.Join(dir, "media")
+	writeFiles(t, media, map[string]string{"foto.png": "png"})
+
+	var buf bytes.Buffer
+	if _, err := Write(ctx, db, media, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(bytes.NewReader(buf.Bytes()), ""); err != nil {
+		t.Fatalf("archivio integro rifiutato: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(hdr *tar.Header, content []byte) ([]byte, bool)
+		want string
+	}{
+		{"checksum", func(hdr *tar.Header, content []byte) ([]byte, bool) {
+			if hdr.Name == MediaPrefix+"foto.png" {
+				return []byte("PNG"), true
+			}
+			return content, true
+		}, "does not match"},
+		{"file mancante", func(hdr *tar.Header, content []byte) ([]byte, bool) {
+			return content, hdr.Name != MediaPrefix+"foto.png"
+		}, "missing"},
+		{"manifest mancante", func(hdr *tar.Header, content []byte) ([]byte, bool) {
+			return content, hdr.Name != ManifestFile
+		}, "no manifest"},
+		{"percorso fuori dai media", func(hdr *tar.Header, content []byte) ([]byte, bool) {
+			if hdr.Name == MediaPrefix+"foto.png" {
+				hdr.Name = MediaPrefix + "../../foto.png"
+			}
+			return content, true
+		}, "unexpected file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(bytes.NewReader(rewrite(t, buf.Bytes(), tt.edit)), "")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("atteso un errore con %q, ottenuto %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"wasatext-20260101T000000Z.tar.gz",
+		"wasatext-20260102T000000Z.tar.gz",
+		"wasatext-20260103T000000Z.tar.gz",
+		"altro.txt",
+	}
+	for _, name := range names {
+		writeFiles(t, dir, map[string]string{name: ""})
+	}
+
+	removed, err := Prune(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != names[0] {
+		t.Fatalf("archivi eliminati: %v", removed)
+	}
+	for _, name := range names[1:] {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("%s non va eliminato: %v", name, err)
+		}
+	}
+}
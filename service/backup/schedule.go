@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options configura i backup periodici di Schedule
+type Options struct {
+	// Dir è la cartella in cui vengono salvati gli archivi
+	Dir string
+
+	// Interval è il tempo tra un backup e il successivo
+	Interval time.Duration
+
+	// Keep è il numero di archivi conservati in Dir; i più vecchi vengono eliminati dopo ogni backup. Zero li conserva
+	// tutti.
+	Keep int
+
+	// MediaDir è la cartella delle foto da includere negli archivi
+	MediaDir string
+}
+
+// Schedule crea un archivio in opts.Dir ogni opts.Interval, finché ctx non viene annullato. Gli errori vengono
+// registrati nel log e non interrompono i backup successivi. Il canale restituito viene chiuso quando Schedule ha
+// terminato, compreso l'eventuale backup in corso all'annullamento di ctx.
+func Schedule(ctx context.Context, db Snapshotter, opts Options, logger logrus.FieldLogger) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run(ctx, db, opts, logger)
+			}
+		}
+	}()
+	return done
+}
+
+// run crea un archivio ed elimina quelli in eccesso
+func run(ctx context.Context, db Snapshotter, opts Options, logger logrus.FieldLogger) {
+	start := time.Now()
+	path, m, err := CreateFile(ctx, db, opts.MediaDir, opts.Dir)
+	if err != nil {
+		logger.WithError(err).Error("backup failed")
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		"path":     path,
+		"files":    len(m.Files),
+		"duration": time.Since(start).String(),
+	}).Info("backup created")
+
+	if opts.Keep <= 0 {
+		return
+	}
+	removed, err := Prune(opts.Dir, opts.Keep)
+	for _, p := range removed {
+		logger.WithField("path", p).Info("old backup removed")
+	}
+	if err != nil {
+		logger.WithError(err).Error("removing old backups")
+	}
+}
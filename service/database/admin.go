@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Admin raccoglie le operazioni di manutenzione usate da cmd/wasatext-admin. Non fanno parte di AppDatabase perché
+// l'API non le espone: le implementano solo i database restituiti da New e NewPostgres, da cui si ottengono con una
+// type assertion (`db.(database.Admin)`).
+type Admin interface {
+	AppDatabase
+
+	// GetAllConversations restituisce tutte le conversazioni, dirette e di gruppo, in ordine di creazione
+	GetAllConversations(ctx context.Context) ([]Conversation, error)
+
+	// DeleteUser elimina l'utente con tutto ciò che gli appartiene: messaggi inviati, reazioni, stati, richieste di
+	// ingresso e chat dirette (che senza di lui resterebbero con un solo membro). Dai gruppi esce come con
+	// RemoveMember, ma senza messaggio di sistema; i gruppi rimasti vuoti vengono eliminati. I messaggi di sistema dei
+	// gruppi restano nella cronologia, con l'utente sostituito da DeletedUser.
+	DeleteUser(ctx context.Context, uuid string) error
+
+	// ForceRemoveMember toglie l'utente dalla conversazione senza che lo faccia un altro membro: l'utente diventa un
+	// ex membro come con KickMember, ma senza autore della rimozione né messaggio di sistema. Se era l'unico
+	// amministratore del gruppo ne viene nominato un altro.
+	ForceRemoveMember(ctx context.Context, uuidUser string, idConversation int64) error
+
+	// PurgeMessagesBefore elimina i messaggi inviati prima di `before`, con le loro reazioni e i loro stati, e
+	// restituisce quanti sono stati eliminati. Le risposte ai messaggi eliminati perdono il riferimento.
+	PurgeMessagesBefore(ctx context.Context, before time.Time) (int64, error)
+
+	// Stats conta i dati salvati nel database
+	Stats(ctx context.Context) (Stats, error)
+
+	// Snapshot copia il database, mentre è in uso, in un nuovo file SQLite in path (vedi snapshot.go)
+	Snapshot(ctx context.Context, path string) error
+}
+
+// Stats sono i conteggi restituiti da Admin.Stats
+type Stats struct {
+	Users               int64
+	DirectConversations int64
+	Groups              int64
+	Messages            int64
+	Reactions           int64
+	PendingJoinRequests int64
+
+	// FirstMessage e LastMessage sono i timestamp del primo e dell'ultimo messaggio, vuoti se non ce ne sono
+	FirstMessage string
+	LastMessage  string
+}
+
+func (db *appdbimpl) GetAllConversations(ctx context.Context) ([]Conversation, error) {
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT id, isDirect, groupName, groupPhoto, timestampCreated, timestampLastMessage, requiresApproval, historyVisibility
+		FROM conversation
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		var c Conversation
+		err := rows.Scan(&c.ID, &c.IsDirect, &c.GroupName, &c.GroupPhoto, &c.TimestampCreated, &c.TimestampLastMessage, &c.RequiresApproval, &c.HistoryVisibility)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+func (db *appdbimpl) DeleteUser(ctx context.Context, uuid string) error {
+	return db.withTx(ctx, func(tx *appdbimpl) error {
+		rows, err := tx.c.QueryContext(ctx, `
+			SELECT m.idConversation
+			FROM member m
+			JOIN conversation c ON c.id = m.idConversation
+			WHERE m.uuidUser = ? AND c.isDirect = FALSE
+		`, uuid)
+		if err != nil {
+			return err
+		}
+		var groups []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			groups = append(groups, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// I messaggi vanno eliminati esplicitamente: con ON DELETE SET NULL resterebbero senza mittente. Quelli di
+		// sistema restano nella cronologia dei gruppi: il mittente diventa NULL e l'utente è sostituito da DeletedUser
+		_, err = tx.c.ExecContext(ctx, `DELETE FROM message WHERE uuidSender = ? AND type != 'system'`, uuid)
+		if err != nil {
+			return err
+		}
+		if err := anonymizeSystemEvents(ctx, tx.c, uuid); err != nil {
+			return err
+		}
+		_, err = tx.c.ExecContext(ctx, `
+			DELETE FROM conversation
+			WHERE isDirect = TRUE AND id IN (SELECT idConversation FROM member WHERE uuidUser = ?)
+		`, uuid)
+		if err != nil {
+			return err
+		}
+
+		// Iscrizioni, reazioni, stati e richieste di ingresso vengono eliminati dalle FOREIGN KEY
+		result, err := tx.c.ExecContext(ctx, `DELETE FROM "user" WHERE uuid = ?`, uuid)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return newError(ErrNotFound, "utente inesistente")
+		}
+
+		for _, id := range groups {
+			if err := ensureAdmin(ctx, tx.c, id); err != nil {
+				return err
+			}
+			if err := tx.DeleteConversationIfEmpty(ctx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *appdbimpl) ForceRemoveMember(ctx context.Context, uuidUser string, idConversation int64) error {
+	return db.withTx(ctx, func(tx *appdbimpl) error {
+		if err := moveToFormerMembers(ctx, tx.c, uuidUser, idConversation, nil, now()); err != nil {
+			return err
+		}
+		return ensureAdmin(ctx, tx.c, idConversation)
+	})
+}
+
+func (db *appdbimpl) PurgeMessagesBefore(ctx context.Context, before time.Time) (int64, error) {
+	// I timestamp sono salvati in TimestampFormat, quindi vanno confrontati nello stesso formato
+	result, err := db.c.ExecContext(ctx, `DELETE FROM message WHERE timestamp < ?`, Timestamp(before))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *appdbimpl) Stats(ctx context.Context) (Stats, error) {
+	var s Stats
+	var first, last *string
+	err := db.c.QueryRowContext(ctx, `
+		SELECT
+		  (SELECT COUNT(*) FROM "user"),
+		  (SELECT COUNT(*) FROM conversation WHERE isDirect = TRUE),
+		  (SELECT COUNT(*) FROM conversation WHERE isDirect = FALSE),
+		  (SELECT COUNT(*) FROM message),
+		  (SELECT COUNT(*) FROM reaction),
+		  (SELECT COUNT(*) FROM joinRequest WHERE status = 'pending'),
+		  (SELECT MIN(timestamp) FROM message),
+		  (SELECT MAX(timestamp) FROM message)
+	`).Scan(&s.Users, &s.DirectConversations, &s.Groups, &s.Messages, &s.Reactions, &s.PendingJoinRequests, &first, &last)
+	if err != nil {
+		return s, err
+	}
+	if first != nil {
+		s.FirstMessage, s.LastMessage = *first, *last
+	}
+	return s, nil
+}
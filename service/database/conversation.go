@@ -1,11 +1,10 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"log"
-	"time"
 )
 
 type Conversation struct {
@@ -15,14 +14,16 @@ type Conversation struct {
 	GroupPhoto           *string
 	TimestampCreated     string
 	TimestampLastMessage string
+	RequiresApproval     bool
+	HistoryVisibility    string
 }
 
-func (db *appdbimpl) CreateDirectConversation(uuid1, uuid2 string) (Conversation, error) {
-	// Prendi il timestamp corrente in RFC3339
-	now := time.Now().Format(time.RFC3339)
+func (db *appdbimpl) CreateDirectConversation(ctx context.Context, uuid1, uuid2 string) (Conversation, error) {
+	// Prendi il timestamp corrente, vedi TimestampFormat
+	timestamp := now()
 
 	// Inizia la transazione
-	tx, err := db.c.Begin()
+	tx, err := db.begin(ctx)
 	if err != nil {
 		return Conversation{}, err
 	}
@@ -35,26 +36,23 @@ func (db *appdbimpl) CreateDirectConversation(uuid1, uuid2 string) (Conversation
 	}()
 
 	// Inserisci la conversazione
-	res, err := tx.Exec(`
+	var conversationID int64
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO conversation (isDirect, timestampCreated, timestampLastMessage)
 		VALUES (true, ?, ?)
-	`, now, now)
-	if err != nil {
-		return Conversation{}, err
-	}
-
-	conversationID, err := res.LastInsertId()
+		RETURNING id
+	`, timestamp, timestamp).Scan(&conversationID)
 	if err != nil {
 		return Conversation{}, err
 	}
 
 	// Aggiungi i due utenti come membri
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO member (uuidUser, idConversation, timestampJoined)
 		VALUES (?, ?, ?), (?, ?, ?)
-	`, uuid1, conversationID, now, uuid2, conversationID, now)
+	`, uuid1, conversationID, timestamp, uuid2, conversationID, timestamp)
 	if err != nil {
-		return Conversation{}, err
+		return Conversation{}, classify(err)
 	}
 
 	// Conferma la transazione
@@ -66,45 +64,59 @@ func (db *appdbimpl) CreateDirectConversation(uuid1, uuid2 string) (Conversation
 	return Conversation{
 		ID:                   conversationID,
 		IsDirect:             true,
-		TimestampCreated:     now,
-		TimestampLastMessage: now,
+		TimestampCreated:     timestamp,
+		TimestampLastMessage: timestamp,
+		HistoryVisibility:    HistoryFull,
 	}, nil
 }
 
-func (db *appdbimpl) CreateGroupConversation(creatorUUID string, groupName, groupPhoto *string) (Conversation, error) {
-	tx, err := db.c.Begin()
+// CreateGroupConversation crea il gruppo con il creatore come amministratore e gli altri `members` come membri, tutto
+// nella stessa transazione: se un membro non può essere inserito il gruppo non viene creato.
+func (db *appdbimpl) CreateGroupConversation(ctx context.Context, creatorUUID string, groupName, groupPhoto *string, members []string) (Conversation, error) {
+	tx, err := db.begin(ctx)
 	if err != nil {
 		return Conversation{}, err
 	}
 	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("rollback fallito: %v", rErr)
 		}
 	}()
 
 	// Ottieni timestamp corrente
-	timestamp := time.Now().Format(time.RFC3339)
+	timestamp := now()
 	// Inserisci conversazione
-	res, err := tx.Exec(`
+	var conversationID int64
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO conversation (isDirect, groupName, groupPhoto, timestampCreated, timestampLastMessage)
-		VALUES (FALSE, ?, ?, ?, ?)`,
-		groupName, groupPhoto, timestamp, timestamp)
+		VALUES (FALSE, ?, ?, ?, ?)
+		RETURNING id`,
+		groupName, groupPhoto, timestamp, timestamp).Scan(&conversationID)
 	if err != nil {
 		return Conversation{}, err
 	}
 
-	conversationID, err := res.LastInsertId()
+	// Inserisci il creatore come primo membro (e amministratore)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO member (uuidUser, idConversation, timestampJoined, isAdmin)
+		VALUES (?, ?, ?, TRUE)`,
+		creatorUUID, conversationID, timestamp)
 	if err != nil {
-		return Conversation{}, err
+		return Conversation{}, classify(err)
 	}
 
-	// Inserisci il creatore come primo membro
-	_, err = tx.Exec(`
-		INSERT INTO member (uuidUser, idConversation, timestampJoined)
-		VALUES (?, ?, ?)`,
-		creatorUUID, conversationID, timestamp)
-	if err != nil {
-		return Conversation{}, err
+	for _, uuid := range members {
+		if uuid == creatorUUID {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO member (uuidUser, idConversation, timestampJoined)
+			VALUES (?, ?, ?)
+			ON CONFLICT(uuidUser, idConversation) DO NOTHING`,
+			uuid, conversationID, timestamp)
+		if err != nil {
+			return Conversation{}, classify(err)
+		}
 	}
 
 	err = tx.Commit()
@@ -119,16 +131,49 @@ func (db *appdbimpl) CreateGroupConversation(creatorUUID string, groupName, grou
 		GroupPhoto:           groupPhoto,
 		TimestampCreated:     timestamp,
 		TimestampLastMessage: timestamp,
+		HistoryVisibility:    HistoryFull,
 	}, nil
 }
 
-func (db *appdbimpl) GetConversationsByUser(uuid string) ([]Conversation, error) {
-	rows, err := db.c.Query(`
-		SELECT c.id, c.isDirect, c.groupName, c.groupPhoto, c.timestampCreated, c.timestampLastMessage
+func (db *appdbimpl) GetConversationsByUser(ctx context.Context, uuid string) ([]Conversation, error) {
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT c.id, c.isDirect, c.groupName, c.groupPhoto, c.timestampCreated, c.timestampLastMessage, c.requiresApproval, c.historyVisibility
 		FROM conversation c
 		JOIN member m ON c.id = m.idConversation
 		WHERE m.uuidUser = ?
-		ORDER BY c.timestampLastMessage DESC
+		ORDER BY c.timestampLastMessage DESC, c.id DESC
+	`, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+
+	for rows.Next() {
+		var c Conversation
+		err := rows.Scan(&c.ID, &c.IsDirect, &c.GroupName, &c.GroupPhoto, &c.TimestampCreated, &c.TimestampLastMessage, &c.RequiresApproval, &c.HistoryVisibility)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return conversations, nil
+}
+
+// GetFormerConversationsByUser restituisce i gruppi da cui l'utente è uscito o è stato rimosso e di cui può ancora
+// leggere la cronologia fino a quel momento.
+func (db *appdbimpl) GetFormerConversationsByUser(ctx context.Context, uuid string) ([]Conversation, error) {
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT c.id, c.isDirect, c.groupName, c.groupPhoto, c.timestampCreated, c.timestampLastMessage, c.requiresApproval, c.historyVisibility
+		FROM conversation c
+		JOIN formerMember f ON c.id = f.idConversation
+		WHERE f.uuidUser = ?
+		ORDER BY f.timestampRemoved DESC, c.id DESC
 	`, uuid)
 	if err != nil {
 		return nil, err
@@ -139,7 +184,7 @@ func (db *appdbimpl) GetConversationsByUser(uuid string) ([]Conversation, error)
 
 	for rows.Next() {
 		var c Conversation
-		err := rows.Scan(&c.ID, &c.IsDirect, &c.GroupName, &c.GroupPhoto, &c.TimestampCreated, &c.TimestampLastMessage)
+		err := rows.Scan(&c.ID, &c.IsDirect, &c.GroupName, &c.GroupPhoto, &c.TimestampCreated, &c.TimestampLastMessage, &c.RequiresApproval, &c.HistoryVisibility)
 		if err != nil {
 			return nil, err
 		}
@@ -152,38 +197,38 @@ func (db *appdbimpl) GetConversationsByUser(uuid string) ([]Conversation, error)
 	return conversations, nil
 }
 
-func (db *appdbimpl) GetLastMessageByConversation(id int64) (Message, error) {
+func (db *appdbimpl) GetLastMessageByConversation(ctx context.Context, id int64) (Message, error) {
 	var msg Message
-	err := db.c.QueryRow(`
+	err := db.c.QueryRowContext(ctx, `
 		SELECT id, type, content, mediaUrl, timestamp, idConversation, uuidSender, idRepliesTo
 		FROM message
 		WHERE idConversation = ?
-		ORDER BY timestamp DESC
+		ORDER BY timestamp DESC, id DESC
 		LIMIT 1
 	`, id).Scan(
 		&msg.ID, &msg.Type, &msg.Content, &msg.MediaUrl, &msg.Timestamp,
-		&msg.IDConversation, &msg.UUIDSender, &msg.IDRepliesTo,
+		&msg.IDConversation, senderColumn{&msg.UUIDSender}, &msg.IDRepliesTo,
 	)
-	return msg, err
+	return msg, classify(err)
 }
 
-func (db *appdbimpl) GetDirectConversationBetween(uuid1, uuid2 string) (Conversation, error) {
+func (db *appdbimpl) GetDirectConversationBetween(ctx context.Context, uuid1, uuid2 string) (Conversation, error) {
 	var conv Conversation
-	err := db.c.QueryRow(`
-		SELECT c.id, c.isDirect, c.groupName, c.groupPhoto, c.timestampCreated, c.timestampLastMessage
+	err := db.c.QueryRowContext(ctx, `
+		SELECT c.id, c.isDirect, c.groupName, c.groupPhoto, c.timestampCreated, c.timestampLastMessage, c.requiresApproval, c.historyVisibility
 		FROM conversation c
 		JOIN member m1 ON c.id = m1.idConversation
 		JOIN member m2 ON c.id = m2.idConversation
 		WHERE c.isDirect = TRUE
 		AND m1.uuidUser = ? AND m2.uuidUser = ?
-	`, uuid1, uuid2).Scan(&conv.ID, &conv.IsDirect, &conv.GroupName, &conv.GroupPhoto, &conv.TimestampCreated, &conv.TimestampLastMessage)
+	`, uuid1, uuid2).Scan(&conv.ID, &conv.IsDirect, &conv.GroupName, &conv.GroupPhoto, &conv.TimestampCreated, &conv.TimestampLastMessage, &conv.RequiresApproval, &conv.HistoryVisibility)
 
-	return conv, err
+	return conv, classify(err)
 }
 
-func (db *appdbimpl) DeleteConversationIfEmpty(id int64) error {
+func (db *appdbimpl) DeleteConversationIfEmpty(ctx context.Context, id int64) error {
 	var count int
-	err := db.c.QueryRow(`
+	err := db.c.QueryRowContext(ctx, `
 		SELECT COUNT(*)
 		FROM member
 		WHERE idConversation = ?
@@ -193,25 +238,25 @@ func (db *appdbimpl) DeleteConversationIfEmpty(id int64) error {
 	}
 
 	if count == 0 {
-		_, err := db.c.Exec(`DELETE FROM conversation WHERE id = ?`, id)
+		_, err := db.c.ExecContext(ctx, `DELETE FROM conversation WHERE id = ?`, id)
 		return err
 	}
 
 	return nil
 }
 
-func (db *appdbimpl) UpdateGroupConversation(id int64, newName, newPhoto *string) error {
+func (db *appdbimpl) UpdateGroupConversation(ctx context.Context, id int64, newName, newPhoto *string) error {
 	// Verifica che la conversazione sia un gruppo
 	var isDirect bool
-	err := db.c.QueryRow(`SELECT isDirect FROM conversation WHERE id = ?`, id).Scan(&isDirect)
+	err := db.c.QueryRowContext(ctx, `SELECT isDirect FROM conversation WHERE id = ?`, id).Scan(&isDirect)
 	if err != nil {
-		return err
+		return classify(err)
 	}
 	if isDirect {
-		return fmt.Errorf("impossibile modificare nome/foto: conversazione diretta")
+		return newError(ErrForbidden, "impossibile modificare nome/foto: conversazione diretta")
 	}
 
-	_, err = db.c.Exec(`
+	_, err = db.c.ExecContext(ctx, `
 		UPDATE conversation
 		SET groupName = ?, groupPhoto = ?
 		WHERE id = ?
@@ -220,50 +265,139 @@ func (db *appdbimpl) UpdateGroupConversation(id int64, newName, newPhoto *string
 	return err
 }
 
-func (db *appdbimpl) SetGroupName(id int64, newName string) error {
+// SetGroupName rinomina il gruppo e registra la modifica (con il nome precedente) come messaggio di sistema.
+func (db *appdbimpl) SetGroupName(ctx context.Context, id int64, newName string, uuidActor string) error {
+	return db.updateGroupField(ctx, id, "groupName", EventGroupRenamed, newName, uuidActor)
+}
+
+// SetGroupPhoto cambia la foto del gruppo e registra la modifica come messaggio di sistema.
+func (db *appdbimpl) SetGroupPhoto(ctx context.Context, id int64, newPhoto string, uuidActor string) error {
+	return db.updateGroupField(ctx, id, "groupPhoto", EventGroupPhotoChanged, newPhoto, uuidActor)
+}
+
+// updateGroupField aggiorna nome o foto di un gruppo (`column` è sempre una costante interna) inserendo nella stessa
+// transazione il messaggio di sistema `kind` con il valore precedente e quello nuovo.
+func (db *appdbimpl) updateGroupField(ctx context.Context, id int64, column string, kind string, newValue string, uuidActor string) error {
+	timestamp := now()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("rollback fallito: %v", rErr)
+		}
+	}()
+
 	// Verifica che la conversazione sia un gruppo
 	var isDirect bool
-	err := db.c.QueryRow(`SELECT isDirect FROM conversation WHERE id = ?`, id).Scan(&isDirect)
+	var oldValue *string
+	err = tx.QueryRowContext(ctx, `SELECT isDirect, `+column+` FROM conversation WHERE id = ?`, id).Scan(&isDirect, &oldValue)
+	if err != nil {
+		return classify(err)
+	}
+	if isDirect {
+		return newError(ErrForbidden, "impossibile modificare nome/foto: conversazione diretta")
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversation
+		SET `+column+` = ?
+		WHERE id = ?
+	`, newValue, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertSystemMessage(ctx, tx, id, SystemEvent{
+		Kind:     kind,
+		Actor:    uuidActor,
+		OldValue: oldValue,
+		NewValue: &newValue,
+	}, timestamp)
 	if err != nil {
 		return err
 	}
+
+	return tx.Commit()
+}
+
+func (db *appdbimpl) SetGroupRequiresApproval(ctx context.Context, id int64, requiresApproval bool) error {
+	// Verifica che la conversazione sia un gruppo
+	var isDirect bool
+	err := db.c.QueryRowContext(ctx, `SELECT isDirect FROM conversation WHERE id = ?`, id).Scan(&isDirect)
+	if err != nil {
+		return classify(err)
+	}
 	if isDirect {
-		return fmt.Errorf("impossibile modificare nome/foto: conversazione diretta")
+		return newError(ErrForbidden, "impossibile richiedere approvazione: conversazione diretta")
 	}
-	_, err = db.c.Exec(`
+
+	_, err = db.c.ExecContext(ctx, `
 		UPDATE conversation
-		SET groupName = ?
+		SET requiresApproval = ?
 		WHERE id = ?
-	`, newName, id)
+	`, requiresApproval, id)
+
 	return err
 }
 
-func (db *appdbimpl) SetGroupPhoto(id int64, newPhoto string) error {
+func (db *appdbimpl) SetGroupHistoryVisibility(ctx context.Context, id int64, visibility string) error {
 	// Verifica che la conversazione sia un gruppo
 	var isDirect bool
-	err := db.c.QueryRow(`SELECT isDirect FROM conversation WHERE id = ?`, id).Scan(&isDirect)
+	err := db.c.QueryRowContext(ctx, `SELECT isDirect FROM conversation WHERE id = ?`, id).Scan(&isDirect)
 	if err != nil {
-		return err
+		return classify(err)
 	}
 	if isDirect {
-		return fmt.Errorf("impossibile modificare nome/foto: conversazione diretta")
+		return newError(ErrForbidden, "impossibile modificare la visibilità: conversazione diretta")
 	}
 
-	_, err = db.c.Exec(`
+	_, err = db.c.ExecContext(ctx, `
 		UPDATE conversation
-		SET groupPhoto = ?
+		SET historyVisibility = ?
 		WHERE id = ?
-	`, newPhoto, id)
+	`, visibility, id)
 
 	return err
 }
 
-func (db *appdbimpl) GetConversationByID(id int64) (Conversation, error) {
+// SearchGroupsByName restituisce i gruppi che accettano richieste di ingresso il cui nome inizia con `prefix`.
+func (db *appdbimpl) SearchGroupsByName(ctx context.Context, prefix string) ([]Conversation, error) {
+	rows, err := db.c.QueryContext(ctx, `
+		SELECT id, isDirect, groupName, groupPhoto, timestampCreated, timestampLastMessage, requiresApproval, historyVisibility
+		FROM conversation
+		WHERE isDirect = FALSE AND requiresApproval = TRUE AND LOWER(groupName) LIKE LOWER(?)
+		ORDER BY groupName ASC, id ASC
+	`, prefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []Conversation
+	for rows.Next() {
+		var c Conversation
+		err := rows.Scan(&c.ID, &c.IsDirect, &c.GroupName, &c.GroupPhoto, &c.TimestampCreated, &c.TimestampLastMessage, &c.RequiresApproval, &c.HistoryVisibility)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func (db *appdbimpl) GetConversationByID(ctx context.Context, id int64) (Conversation, error) {
 	var c Conversation
-	err := db.c.QueryRow(`
-		SELECT id, isDirect, groupName, groupPhoto, timestampCreated, timestampLastMessage
+	err := db.c.QueryRowContext(ctx, `
+		SELECT id, isDirect, groupName, groupPhoto, timestampCreated, timestampLastMessage, requiresApproval, historyVisibility
 		FROM conversation
 		WHERE id = ?
-	`, id).Scan(&c.ID, &c.IsDirect, &c.GroupName, &c.GroupPhoto, &c.TimestampCreated, &c.TimestampLastMessage)
-	return c, err
+	`, id).Scan(&c.ID, &c.IsDirect, &c.GroupName, &c.GroupPhoto, &c.TimestampCreated, &c.TimestampLastMessage, &c.RequiresApproval, &c.HistoryVisibility)
+	return c, classify(err)
 }
@@ -16,7 +16,7 @@ This is an example on how to migrate the DB and connect to it:
 
 	// Start Database
 	logger.Println("initializing database support")
-	db, err := sql.Open("sqlite3", "./foo.db")
+	db, err := sql.Open("sqlite3", "file:./foo.db?_foreign_keys=on")
 	if err != nil {
 		logger.WithError(err).Error("error opening SQLite DB")
 		return fmt.Errorf("opening SQLite: %w", err)
@@ -31,71 +31,123 @@ Then you can initialize the AppDatabase and pass it to the api package.
 package database
 
 import (
+	"context"
 	"database/sql"
+	_ "embed"
 	"errors"
 	"fmt"
 	"log"
-	"os"
 )
 
-// AppDatabase is the high level interface for the DB
+// schemaSQL è lo schema iniziale del database SQLite, incluso nell'eseguibile
+//
+//go:embed schema.sql
+var schemaSQL string
+
+// AppDatabase is the high level interface for the DB.
+// Tutti i metodi ricevono il context della richiesta: se viene annullato o scade, le query in corso vengono interrotte
+// e il metodo restituisce l'errore del context.
 type AppDatabase interface {
-	GetName() (string, error)
-	SetName(name string) error
+	GetName(ctx context.Context) (string, error)
+	SetName(ctx context.Context, name string) error
 
 	// user.go
-	CreateUser(uuid string, username string, photoUrl string) error
-	GetUserByUUID(uuid string) (User, error)
-	SetUserName(uuid string, newUsername string) error
-	SetPhotoUrl(uuid string, newPhotoUrl string) error
-	SearchUsersByPrefix(prefix string) ([]User, error)
-	GetAllUsers() ([]User, error)
-	UserExists(uuid string) (bool, error)
-	GetPeerData(convID int64, uuidMe string) (User, error)
+	CreateUser(ctx context.Context, uuid string, username string, photoUrl string) error
+	GetUserByUUID(ctx context.Context, uuid string) (User, error)
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+	SetUserName(ctx context.Context, uuid string, newUsername string) error
+	SetPhotoUrl(ctx context.Context, uuid string, newPhotoUrl string) error
+	SearchUsersByPrefix(ctx context.Context, prefix string) ([]User, error)
+	GetAllUsers(ctx context.Context) ([]User, error)
+	UserExists(ctx context.Context, uuid string) (bool, error)
+	GetPeerData(ctx context.Context, convID int64, uuidMe string) (User, error)
 
 	// message.go
-	CreateMessage(msg Message) (int64, error)
-	GetMessageByID(id int64) (Message, error)
-	GetMessagesByConversationID(convoID int64) ([]Message, error)
-	DeleteMessageByID(id int64, uuidSender string) error
-	ForwardMessage(originalMsgID int64, destConversationID int64, senderUUID string) (int64, error)
-	GetLastMessage(convID int64) (Message, error)
+	CreateMessage(ctx context.Context, msg Message) (int64, error)
+	GetMessageByID(ctx context.Context, id int64) (Message, error)
+	GetMessagesByConversationID(ctx context.Context, convoID int64, window HistoryWindow) ([]Message, error)
+	SearchMessages(ctx context.Context, convoID int64, query string, window HistoryWindow) ([]Message, error)
+	DeleteMessageByID(ctx context.Context, id int64, uuidSender string) error
+	ForwardMessage(ctx context.Context, originalMsgID int64, destConversationID int64, senderUUID string) (int64, error)
+	GetLastMessage(ctx context.Context, convID int64) (Message, error)
 
 	// reaction.go
-	AddReaction(messageID int64, uuid string, emoji string) error
-	RemoveReaction(messageID int64, uuid string) error
-	GetReactionsByMessageID(messageID int64) ([]Reaction, error)
-	GetReactionsWithUserByMessageID(messageID int64) ([]ReactionWithUser, error)
+	AddReaction(ctx context.Context, messageID int64, uuid string, emoji string) error
+	RemoveReaction(ctx context.Context, messageID int64, uuid string) error
+	GetReactionsByMessageID(ctx context.Context, messageID int64) ([]Reaction, error)
+	GetReactionsWithUserByMessageID(ctx context.Context, messageID int64) ([]ReactionWithUser, error)
 
 	// message_status.go
-	SetDelivered(uuidUser string, idMessage int64) error
-	SetSeen(uuidUser string, idMessage int64) error
-	GetMessageStatus(uuidUser string, idMessage int64) (MessageStatus, error)
-	GetAllStatusesByMessage(idMessage int64) ([]MessageStatus, error)
+	SetDelivered(ctx context.Context, uuidUser string, idMessage int64) error
+	SetSeen(ctx context.Context, uuidUser string, idMessage int64) error
+	GetMessageStatus(ctx context.Context, uuidUser string, idMessage int64) (MessageStatus, error)
+	GetAllStatusesByMessage(ctx context.Context, idMessage int64) ([]MessageStatus, error)
 
 	// conversation.go
-	CreateDirectConversation(uuid1, uuid2 string) (Conversation, error)
-	CreateGroupConversation(creatorUUID string, groupName, groupPhoto *string) (Conversation, error)
-	GetConversationsByUser(uuid string) ([]Conversation, error)
-	GetLastMessageByConversation(id int64) (Message, error)
-	GetDirectConversationBetween(uuid1, uuid2 string) (Conversation, error)
-	DeleteConversationIfEmpty(id int64) error
-	GetConversationByID(id int64) (Conversation, error)
-	SetGroupName(id int64, newName string) error
-	SetGroupPhoto(id int64, newPhoto string) error
+	CreateDirectConversation(ctx context.Context, uuid1, uuid2 string) (Conversation, error)
+	CreateGroupConversation(ctx context.Context, creatorUUID string, groupName, groupPhoto *string, members []string) (Conversation, error)
+	GetConversationsByUser(ctx context.Context, uuid string) ([]Conversation, error)
+	GetFormerConversationsByUser(ctx context.Context, uuid string) ([]Conversation, error)
+	GetLastMessageByConversation(ctx context.Context, id int64) (Message, error)
+	GetDirectConversationBetween(ctx context.Context, uuid1, uuid2 string) (Conversation, error)
+	DeleteConversationIfEmpty(ctx context.Context, id int64) error
+	GetConversationByID(ctx context.Context, id int64) (Conversation, error)
+	SetGroupName(ctx context.Context, id int64, newName string, uuidActor string) error
+	SetGroupPhoto(ctx context.Context, id int64, newPhoto string, uuidActor string) error
+	SetGroupRequiresApproval(ctx context.Context, id int64, requiresApproval bool) error
+	SetGroupHistoryVisibility(ctx context.Context, id int64, visibility string) error
+	SearchGroupsByName(ctx context.Context, prefix string) ([]Conversation, error)
 
 	// member.go
-	AddMember(uuidUser string, idConversation int64) error
-	RemoveMember(uuidUser string, idConversation int64) error
-	IsMember(uuidUser string, idConversation int64) (bool, error)
-	GetMembersByConversation(idConversation int64) ([]string, error)
-	GetJoinedAt(uuidUser string, idConversation int64) (string, error)
-
-	Ping() error
+	AddMember(ctx context.Context, uuidUser string, idConversation int64) error
+	AddMembers(ctx context.Context, idConversation int64, uuids []string, uuidActor string) error
+	RemoveMember(ctx context.Context, uuidUser string, idConversation int64) error
+	KickMember(ctx context.Context, uuidUser string, idConversation int64, uuidRemovedBy string) error
+	IsMember(ctx context.Context, uuidUser string, idConversation int64) (bool, error)
+	IsAdmin(ctx context.Context, uuidUser string, idConversation int64) (bool, error)
+	GetMembersByConversation(ctx context.Context, idConversation int64) ([]string, error)
+	GetJoinedAt(ctx context.Context, uuidUser string, idConversation int64) (string, error)
+	GetRemovedAt(ctx context.Context, uuidUser string, idConversation int64) (string, error)
+	DismissFormerConversation(ctx context.Context, uuidUser string, idConversation int64) error
+
+	// history.go
+	GetHistoryWindow(ctx context.Context, uuidUser string, idConversation int64) (HistoryWindow, error)
+
+	// join_request.go
+	CreateJoinRequest(ctx context.Context, uuidUser string, idConversation int64) (JoinRequest, error)
+	GetJoinRequest(ctx context.Context, uuidUser string, idConversation int64) (JoinRequest, error)
+	GetPendingJoinRequests(ctx context.Context, idConversation int64) ([]JoinRequest, error)
+	GetJoinRequestsByUser(ctx context.Context, uuidUser string) ([]JoinRequest, error)
+	ApproveJoinRequest(ctx context.Context, uuidUser string, idConversation int64, uuidDecidedBy string) error
+	DenyJoinRequest(ctx context.Context, uuidUser string, idConversation int64, uuidDecidedBy string) error
+
+	// group_invite.go
+	SetGroupInvite(ctx context.Context, idConversation int64, code string, uuidCreatedBy string) (GroupInvite, error)
+	GetGroupInvite(ctx context.Context, idConversation int64) (GroupInvite, error)
+	DeleteGroupInvite(ctx context.Context, idConversation int64) error
+
+	// tx.go
+	WithTx(ctx context.Context, fn func(tx AppDatabase) error) error
+
+	Ping(ctx context.Context) error
+
+	// SchemaVersion restituisce la versione dello schema del database e l'ultima versione nota a questo eseguibile:
+	// sono diverse se mancano delle migrazioni o se il database è stato aggiornato da una versione più recente.
+	SchemaVersion(ctx context.Context) (current int, latest int, err error)
 }
 
 type appdbimpl struct {
-	c *sql.DB
+	// c esegue le query: è la connessione, oppure la transazione in corso dentro WithTx
+	c querier
+
+	// conn è la connessione al database
+	conn *sql.DB
+
+	// tx è la transazione in corso, se questa istanza è il `tx` passato alla funzione di WithTx
+	tx *sql.Tx
+
+	// dialect è il database su cui lavora questa istanza
+	dialect dialect
 }
 
 // New returns a new instance of AppDatabase based on the SQLite connection `db`.
@@ -116,12 +168,7 @@ func New(db *sql.DB) (AppDatabase, error) {
 	if count == 0 {
 		log.Printf("Database vuoto, eseguo schema.sql...")
 
-		schemaBytes, err := os.ReadFile("service/database/schema.sql")
-		if err != nil {
-			return nil, fmt.Errorf("errore lettura schema.sql: %w", err)
-		}
-
-		_, err = db.Exec(string(schemaBytes))
+		_, err = db.Exec(schemaSQL)
 		if err != nil {
 			return nil, fmt.Errorf("errore esecuzione schema.sql: %w", err)
 		}
@@ -129,15 +176,24 @@ func New(db *sql.DB) (AppDatabase, error) {
 		log.Printf("schema.sql eseguito con successo")
 	}
 
+	// Applica le modifiche allo schema successive a schema.sql
+	if err := migrate(db); err != nil {
+		return nil, fmt.Errorf("errore durante la migrazione del DB: %w", err)
+	}
+
+	// Il PRAGMA vale solo per la connessione che lo esegue: per attivare le FOREIGN KEY su tutto il pool la connessione
+	// va aperta con _foreign_keys=on
 	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
 		log.Printf("errore nell'abilitare le foreign keys: %v", err)
 	}
 
 	return &appdbimpl{
-		c: db,
+		c:       dialectSQLite.wrap(db),
+		conn:    db,
+		dialect: dialectSQLite,
 	}, nil
 }
 
-func (db *appdbimpl) Ping() error {
-	return db.c.Ping()
+func (db *appdbimpl) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
 }
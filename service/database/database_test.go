@@ -0,0 +1,135 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albyma98/WASAText/service/database"
+	"github.com/albyma98/WASAText/service/database/dbtest"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLite(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+		// _foreign_keys attiva le FOREIGN KEY su tutte le connessioni del pool, non solo su quella usata da New
+		conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+
+		db, err := database.New(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+// TestSQLiteTimestampMigration controlla che la migrazione 6 converta i timestamp salvati con il fuso orario locale nel
+// formato TimestampFormat, in UTC
+func TestSQLiteTimestampMigration(t *testing.T) {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := database.New(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dati salvati prima della migrazione, con due fusi orari diversi (ora legale e solare)
+	_, err = conn.Exec(`
+		PRAGMA user_version = 5;
+		INSERT INTO user (uuid, username) VALUES ('alice', 'alice');
+		INSERT INTO conversation (id, isDirect, groupName, timestampCreated, timestampLastMessage)
+		VALUES (1, FALSE, 'gruppo', '2025-03-30T01:59:00+01:00', '2025-03-30T03:01:00+02:00');
+		INSERT INTO member (uuidUser, idConversation, timestampJoined, isAdmin) VALUES ('alice', 1, '2025-03-30T01:59:00+01:00', TRUE);
+		INSERT INTO message (id, type, content, timestamp, idConversation, uuidSender)
+		VALUES (1, 'text', 'ciao', '2025-03-30T03:01:00+02:00', 1, 'alice');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv, err := db.GetConversationByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := db.GetMessageByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.TimestampCreated != "2025-03-30T00:59:00.000000000Z" || msg.Timestamp != "2025-03-30T01:01:00.000000000Z" {
+		t.Fatalf("timestamp non convertiti: %q, %q", conv.TimestampCreated, msg.Timestamp)
+	}
+	if current, latest, err := db.SchemaVersion(context.Background()); err != nil || current != latest {
+		t.Fatalf("versione dello schema %d, attesa %d: %v", current, latest, err)
+	}
+}
+
+// TestPostgres esegue la suite su PostgreSQL. Il database è indicato dalla variabile WASATEXT_TEST_POSTGRES_DSN (ad
+// esempio "postgres://postgres@localhost/wasatext_test?sslmode=disable"); senza la variabile il test viene saltato.
+// Ogni sotto-test lavora in uno schema creato per l'occasione ed eliminato alla fine.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("WASATEXT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("WASATEXT_TEST_POSTGRES_DSN non impostata")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	n := 0
+	dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+		n++
+		schema := fmt.Sprintf("wasatext_test_%d_%d", time.Now().UnixNano(), n)
+		if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+				t.Errorf("eliminazione schema %s: %v", schema, err)
+			}
+		})
+
+		conn, err := sql.Open("postgres", withSearchPath(dsn, schema))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+
+		db, err := database.NewPostgres(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+// withSearchPath aggiunge al DSN il parametro search_path, sia in forma di URL sia in forma "chiave=valore"
+func withSearchPath(dsn string, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + url.QueryEscape(schema)
+	}
+	return dsn + " search_path=" + schema
+}
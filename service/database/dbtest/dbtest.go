@@ -0,0 +1,1021 @@
+/*
+Package dbtest contiene la suite di test di comportamento comune a tutte le implementazioni di database.AppDatabase.
+
+Ogni implementazione la esegue dai propri test passando una funzione che apre un database vuoto:
+
+	func TestSQLite(t *testing.T) {
+		dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+			...
+		})
+	}
+*/
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// Open apre un database vuoto per un singolo test. Le risorse vanno rilasciate con t.Cleanup.
+type Open func(t *testing.T) database.AppDatabase
+
+// Run esegue la suite su database aperti con `open`, uno per ogni sotto-test.
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, db database.AppDatabase)
+	}{
+		{"Users", testUsers},
+		{"DirectConversation", testDirectConversation},
+		{"GroupConversation", testGroupConversation},
+		{"KickMember", testKickMember},
+		{"LeaveGroup", testLeaveGroup},
+		{"HistoryVisibility", testHistoryVisibility},
+		{"Messages", testMessages},
+		{"SearchMessages", testSearchMessages},
+		{"Reactions", testReactions},
+		{"JoinRequests", testJoinRequests},
+		{"GroupInvites", testGroupInvites},
+		{"WithTx", testWithTx},
+		{"DeleteCascade", testDeleteCascade},
+		{"Concurrency", testConcurrency},
+		{"Canceled", testCanceled},
+		{"SchemaVersion", testSchemaVersion},
+		{"AdminDeleteUser", testAdminDeleteUser},
+		{"AdminDeleteUserKeepsSystemEvents", testAdminDeleteUserKeepsSystemEvents},
+		{"AdminForceRemoveMember", testAdminForceRemoveMember},
+		{"AdminPurgeAndStats", testAdminPurgeAndStats},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+// mustUsers crea un utente per ogni username, con uuid uguale allo username
+func mustUsers(t *testing.T, db database.AppDatabase, usernames ...string) {
+	t.Helper()
+	for _, name := range usernames {
+		if err := db.CreateUser(context.Background(), name, name, ""); err != nil {
+			t.Fatalf("CreateUser(%q): %v", name, err)
+		}
+	}
+}
+
+func mustGroup(t *testing.T, db database.AppDatabase, creator string, name string, members ...string) database.Conversation {
+	t.Helper()
+	conv, err := db.CreateGroupConversation(context.Background(), creator, &name, nil, members)
+	if err != nil {
+		t.Fatalf("CreateGroupConversation: %v", err)
+	}
+	return conv
+}
+
+func mustMessage(t *testing.T, db database.AppDatabase, convID int64, sender string, content string) int64 {
+	t.Helper()
+	id, err := db.CreateMessage(context.Background(), database.Message{
+		Type:           "text",
+		Content:        content,
+		IDConversation: convID,
+		UUIDSender:     sender,
+	})
+	if err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	return id
+}
+
+func expectKind(t *testing.T, what string, err error, kind error) {
+	t.Helper()
+	if !errors.Is(err, kind) {
+		t.Fatalf("%s: atteso %v, ottenuto %v", what, kind, err)
+	}
+}
+
+func testUsers(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "Alberto", "bob")
+
+	expectKind(t, "username duplicato", db.CreateUser(ctx, "alice2", "alice", ""), database.ErrConflict)
+
+	_, err := db.GetUserByUUID(ctx, "nessuno")
+	expectKind(t, "utente inesistente", err, database.ErrNotFound)
+
+	u, err := db.GetUserByUsername(ctx, "bob")
+	if err != nil || u.UUID != "bob" {
+		t.Fatalf("GetUserByUsername: %+v, %v", u, err)
+	}
+
+	found, err := db.SearchUsersByPrefix(ctx, "AL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("la ricerca per prefisso deve ignorare le maiuscole: %+v", found)
+	}
+
+	if err := db.SetUserName(ctx, "bob", "roberto"); err != nil {
+		t.Fatal(err)
+	}
+	expectKind(t, "rinomina su username esistente", db.SetUserName(ctx, "bob", "alice"), database.ErrConflict)
+
+	exists, err := db.UserExists(ctx, "bob")
+	if err != nil || !exists {
+		t.Fatalf("UserExists: %v, %v", exists, err)
+	}
+}
+
+func testDirectConversation(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob")
+
+	conv, err := db.CreateDirectConversation(ctx, "alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conv.IsDirect || conv.HistoryVisibility != database.HistoryFull {
+		t.Fatalf("conversazione diretta inattesa: %+v", conv)
+	}
+
+	got, err := db.GetDirectConversationBetween(ctx, "bob", "alice")
+	if err != nil || got.ID != conv.ID {
+		t.Fatalf("GetDirectConversationBetween: %+v, %v", got, err)
+	}
+
+	peer, err := db.GetPeerData(ctx, conv.ID, "alice")
+	if err != nil || peer.UUID != "bob" {
+		t.Fatalf("GetPeerData: %+v, %v", peer, err)
+	}
+
+	expectKind(t, "rinomina di una chat diretta", db.SetGroupName(ctx, conv.ID, "x", "alice"), database.ErrForbidden)
+
+	_, err = db.GetConversationByID(ctx, conv.ID+1000)
+	expectKind(t, "conversazione inesistente", err, database.ErrNotFound)
+}
+
+func testGroupConversation(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob", "carol")
+
+	name := "gruppo"
+	conv := mustGroup(t, db, "alice", name, "bob")
+	if conv.IsDirect || conv.GroupName == nil || *conv.GroupName != "gruppo" {
+		t.Fatalf("gruppo inatteso: %+v", conv)
+	}
+
+	admin, err := db.IsAdmin(ctx, "alice", conv.ID)
+	if err != nil || !admin {
+		t.Fatalf("il creatore deve essere amministratore: %v, %v", admin, err)
+	}
+	admin, err = db.IsAdmin(ctx, "bob", conv.ID)
+	if err != nil || admin {
+		t.Fatalf("bob non deve essere amministratore: %v, %v", admin, err)
+	}
+
+	// Con un membro inesistente il gruppo non viene creato
+	_, err = db.CreateGroupConversation(ctx, "carol", &name, nil, []string{"bob", "nessuno"})
+	expectKind(t, "gruppo con un membro inesistente", err, database.ErrNotFound)
+	if convs, err := db.GetConversationsByUser(ctx, "carol"); err != nil || len(convs) != 0 {
+		t.Fatalf("il gruppo non deve essere creato: %+v, %v", convs, err)
+	}
+
+	if err := db.AddMembers(ctx, conv.ID, []string{"carol"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	expectKind(t, "membro già presente", db.AddMembers(ctx, conv.ID, []string{"carol"}, "alice"), database.ErrConflict)
+
+	members, err := db.GetMembersByConversation(ctx, conv.ID)
+	if err != nil || len(members) != 3 {
+		t.Fatalf("GetMembersByConversation: %v, %v", members, err)
+	}
+
+	if err := db.SetGroupName(ctx, conv.ID, "rinominato", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetConversationByID(ctx, conv.ID)
+	if err != nil || got.GroupName == nil || *got.GroupName != "rinominato" {
+		t.Fatalf("GetConversationByID: %+v, %v", got, err)
+	}
+
+	// Aggiunta e rinomina sono registrate come messaggi di sistema
+	msgs, err := db.GetMessagesByConversationID(ctx, conv.ID, database.HistoryWindow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].System == nil || msgs[0].System.Kind != database.EventMemberAdded ||
+		msgs[1].System == nil || msgs[1].System.Kind != database.EventGroupRenamed {
+		t.Fatalf("messaggi di sistema inattesi: %+v", msgs)
+	}
+
+	convs, err := db.GetConversationsByUser(ctx, "carol")
+	if err != nil || len(convs) != 1 || convs[0].ID != conv.ID {
+		t.Fatalf("GetConversationsByUser: %+v, %v", convs, err)
+	}
+}
+
+func testKickMember(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob", "carol")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob")
+
+	if err := db.KickMember(ctx, "bob", conv.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	member, err := db.IsMember(ctx, "bob", conv.ID)
+	if err != nil || member {
+		t.Fatalf("bob non deve più essere membro: %v, %v", member, err)
+	}
+	expectKind(t, "rimozione di un non membro", db.KickMember(ctx, "bob", conv.ID, "alice"), database.ErrNotFound)
+
+	// L'ex membro legge la cronologia fino alla rimozione
+	w, err := db.GetHistoryWindow(ctx, "bob", conv.ID)
+	if err != nil || w.Until == "" {
+		t.Fatalf("GetHistoryWindow dell'ex membro: %+v, %v", w, err)
+	}
+	former, err := db.GetFormerConversationsByUser(ctx, "bob")
+	if err != nil || len(former) != 1 {
+		t.Fatalf("GetFormerConversationsByUser: %+v, %v", former, err)
+	}
+
+	_, err = db.GetHistoryWindow(ctx, "carol", conv.ID)
+	expectKind(t, "finestra di un estraneo", err, database.ErrNotFound)
+
+	// Chi rientra non è più un ex membro
+	if err := db.AddMembers(ctx, conv.ID, []string{"bob"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	w, err = db.GetHistoryWindow(ctx, "bob", conv.ID)
+	if err != nil || w.Until != "" {
+		t.Fatalf("GetHistoryWindow dopo il rientro: %+v, %v", w, err)
+	}
+}
+
+func testLeaveGroup(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob", "carol")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob", "carol")
+
+	// Quando l'unico amministratore esce, il membro più anziano ne prende il posto
+	if err := db.RemoveMember(ctx, "alice", conv.ID); err != nil {
+		t.Fatal(err)
+	}
+	admins := 0
+	for _, uuid := range []string{"bob", "carol"} {
+		admin, err := db.IsAdmin(ctx, uuid, conv.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if admin {
+			admins++
+		}
+	}
+	if admins != 1 {
+		t.Fatalf("attesi 1 amministratore, trovati %d", admins)
+	}
+	expectKind(t, "uscita di un non membro", db.RemoveMember(ctx, "alice", conv.ID), database.ErrNotFound)
+
+	// Chi esce diventa un ex membro, come chi viene rimosso, finché non toglie il gruppo dalla sua lista
+	w, err := db.GetHistoryWindow(ctx, "alice", conv.ID)
+	if err != nil || w.Until == "" {
+		t.Fatalf("GetHistoryWindow di chi è uscito: %+v, %v", w, err)
+	}
+	former, err := db.GetFormerConversationsByUser(ctx, "alice")
+	if err != nil || len(former) != 1 || former[0].ID != conv.ID {
+		t.Fatalf("GetFormerConversationsByUser: %+v, %v", former, err)
+	}
+	if err := db.DismissFormerConversation(ctx, "alice", conv.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectKind(t, "gruppo già tolto", db.DismissFormerConversation(ctx, "alice", conv.ID), database.ErrNotFound)
+	expectKind(t, "gruppo di un membro", db.DismissFormerConversation(ctx, "bob", conv.ID), database.ErrNotFound)
+	former, err = db.GetFormerConversationsByUser(ctx, "alice")
+	if err != nil || len(former) != 0 {
+		t.Fatalf("GetFormerConversationsByUser dopo la rimozione dalla lista: %+v, %v", former, err)
+	}
+	_, err = db.GetHistoryWindow(ctx, "alice", conv.ID)
+	expectKind(t, "finestra dopo la rimozione dalla lista", err, database.ErrNotFound)
+
+	// Il gruppo vuoto viene eliminato
+	for _, uuid := range []string{"bob", "carol"} {
+		if err := db.RemoveMember(ctx, uuid, conv.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteConversationIfEmpty(ctx, conv.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetConversationByID(ctx, conv.ID)
+	expectKind(t, "gruppo vuoto", err, database.ErrNotFound)
+}
+
+// testHistoryVisibility controlla la finestra di cronologia di chi entra in un gruppo con la politica since_join e ne
+// viene poi rimosso. I messaggi sono inviati di seguito, quindi di solito nello stesso secondo dell'ingresso e della
+// rimozione: l'ordine va rispettato comunque.
+func testHistoryVisibility(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob", "carol")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob")
+	if err := db.SetGroupHistoryVisibility(ctx, conv.ID, database.HistorySinceJoin); err != nil {
+		t.Fatal(err)
+	}
+
+	before := mustMessage(t, db, conv.ID, "alice", "prima dell'ingresso")
+	if err := db.AddMembers(ctx, conv.ID, []string{"carol"}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	during := mustMessage(t, db, conv.ID, "bob", "benvenuta")
+	if err := db.KickMember(ctx, "carol", conv.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	after := mustMessage(t, db, conv.ID, "alice", "dopo la rimozione")
+
+	w, err := db.GetHistoryWindow(ctx, "carol", conv.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []string{w.Since, w.Until} {
+		if _, err := time.Parse(database.TimestampFormat, ts); err != nil {
+			t.Fatalf("timestamp %q non nel formato TimestampFormat: %v", ts, err)
+		}
+	}
+	msgs, err := db.GetMessagesByConversationID(ctx, conv.ID, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var visible []int64
+	for _, m := range msgs {
+		if m.Type != "system" {
+			visible = append(visible, m.ID)
+		}
+	}
+	if len(visible) != 1 || visible[0] != during {
+		t.Fatalf("carol deve vedere solo il messaggio inviato mentre era membro: %+v", msgs)
+	}
+	if len(msgs) != 3 || msgs[0].System == nil || msgs[0].System.Kind != database.EventMemberAdded ||
+		msgs[2].System == nil || msgs[2].System.Kind != database.EventMemberRemoved {
+		t.Fatalf("carol deve vedere gli eventi del suo ingresso e della sua rimozione: %+v", msgs)
+	}
+	for id, want := range map[int64]bool{before: false, during: true, after: false} {
+		m, err := db.GetMessageByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Contains(m.Timestamp) != want {
+			t.Errorf("Contains(%q) del messaggio %q = %v, atteso %v", m.Timestamp, m.Content, !want, want)
+		}
+	}
+
+	// Con la politica full resta solo il limite della rimozione
+	if err := db.SetGroupHistoryVisibility(ctx, conv.ID, database.HistoryFull); err != nil {
+		t.Fatal(err)
+	}
+	w, err = db.GetHistoryWindow(ctx, "carol", conv.ID)
+	if err != nil || w.Since != "" || w.Until == "" {
+		t.Fatalf("finestra con la politica full: %+v, %v", w, err)
+	}
+	found, err := db.SearchMessages(ctx, conv.ID, "ingresso", w)
+	if err != nil || len(found) != 1 || found[0].ID != before {
+		t.Fatalf("SearchMessages con la politica full: %+v, %v", found, err)
+	}
+}
+
+func testMessages(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob", "carol")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob", "carol")
+	direct, err := db.CreateDirectConversation(ctx, "alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := mustMessage(t, db, conv.ID, "alice", "primo")
+	second := mustMessage(t, db, conv.ID, "bob", "secondo")
+
+	statuses, err := db.GetAllStatusesByMessage(ctx, first)
+	if err != nil || len(statuses) != 2 {
+		t.Fatalf("il messaggio deve avere uno stato per destinatario: %+v, %v", statuses, err)
+	}
+	if err := db.SetSeen(ctx, "bob", first); err != nil {
+		t.Fatal(err)
+	}
+	st, err := db.GetMessageStatus(ctx, "bob", first)
+	if err != nil || !st.Seen {
+		t.Fatalf("GetMessageStatus: %+v, %v", st, err)
+	}
+
+	last, err := db.GetLastMessage(ctx, conv.ID)
+	if err != nil || last.ID != second {
+		t.Fatalf("GetLastMessage: %+v, %v", last, err)
+	}
+
+	msgs, err := db.GetMessagesByConversationID(ctx, conv.ID, database.HistoryWindow{})
+	if err != nil || len(msgs) != 2 || msgs[0].ID != first || msgs[1].ID != second {
+		t.Fatalf("GetMessagesByConversationID: %+v, %v", msgs, err)
+	}
+
+	msgs, err = db.GetMessagesByConversationID(ctx, conv.ID, database.HistoryWindow{Until: "2000-01-01T00:00:00Z"})
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("i messaggi fuori dalla finestra non vanno restituiti: %+v, %v", msgs, err)
+	}
+
+	fwd, err := db.ForwardMessage(ctx, first, direct.ID, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := db.GetMessageByID(ctx, fwd)
+	if err != nil || m.Content != "primo" || m.IDForwardedFrom == nil || *m.IDForwardedFrom != first {
+		t.Fatalf("messaggio inoltrato inatteso: %+v, %v", m, err)
+	}
+	_, err = db.ForwardMessage(ctx, first, direct.ID, "carol")
+	expectKind(t, "inoltro in una chat altrui", err, database.ErrForbidden)
+
+	expectKind(t, "eliminazione da parte di un altro utente", db.DeleteMessageByID(ctx, first, "bob"), database.ErrForbidden)
+	if err := db.DeleteMessageByID(ctx, first, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetMessageByID(ctx, first)
+	expectKind(t, "messaggio eliminato", err, database.ErrNotFound)
+}
+
+func testSearchMessages(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob")
+
+	mustMessage(t, db, conv.ID, "alice", "Ciao a tutti")
+	mustMessage(t, db, conv.ID, "bob", "sconto del 50% oggi")
+	mustMessage(t, db, conv.ID, "bob", "sconto del 50 oggi")
+
+	found, err := db.SearchMessages(ctx, conv.ID, "CIAO", database.HistoryWindow{})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("la ricerca deve ignorare le maiuscole: %+v, %v", found, err)
+	}
+
+	// % e _ sono cercati letteralmente
+	found, err = db.SearchMessages(ctx, conv.ID, "50%", database.HistoryWindow{})
+	if err != nil || len(found) != 1 {
+		t.Fatalf("i caratteri jolly vanno cercati letteralmente: %+v, %v", found, err)
+	}
+
+	// I messaggi di sistema (qui, il cambio di nome) non compaiono nella ricerca
+	if err := db.SetGroupName(ctx, conv.ID, "sconto", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	found, err = db.SearchMessages(ctx, conv.ID, "sconto", database.HistoryWindow{})
+	if err != nil || len(found) != 2 {
+		t.Fatalf("SearchMessages: %+v, %v", found, err)
+	}
+}
+
+func testReactions(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob")
+	id := mustMessage(t, db, conv.ID, "alice", "ciao")
+
+	if err := db.AddReaction(ctx, id, "bob", "👍"); err != nil {
+		t.Fatal(err)
+	}
+	expectKind(t, "seconda reazione", db.AddReaction(ctx, id, "bob", "❤️"), database.ErrConflict)
+
+	msgs, err := db.GetMessagesByConversationID(ctx, conv.ID, database.HistoryWindow{})
+	if err != nil || len(msgs) != 1 || len(msgs[0].Reactions) != 1 || msgs[0].Reactions[0].Username != "bob" {
+		t.Fatalf("reazioni inattese: %+v, %v", msgs, err)
+	}
+
+	if err := db.RemoveReaction(ctx, id, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	reactions, err := db.GetReactionsByMessageID(ctx, id)
+	if err != nil || len(reactions) != 0 {
+		t.Fatalf("GetReactionsByMessageID: %+v, %v", reactions, err)
+	}
+}
+
+func testJoinRequests(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob", "carol", "dave")
+	conv := mustGroup(t, db, "alice", "gruppo", "dave")
+	mustGroup(t, db, "bob", "gruppo chiuso")
+	if err := db.SetGroupRequiresApproval(ctx, conv.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetGroupHistoryVisibility(ctx, conv.ID, database.HistorySinceJoin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Solo i gruppi in modalità "approvazione richiesta" compaiono nella ricerca
+	groups, err := db.SearchGroupsByName(ctx, "GRU")
+	if err != nil || len(groups) != 1 || groups[0].ID != conv.ID || !groups[0].RequiresApproval {
+		t.Fatalf("SearchGroupsByName: %+v, %v", groups, err)
+	}
+
+	_, err = db.CreateJoinRequest(ctx, "bob", conv.ID+1000)
+	expectKind(t, "richiesta per un gruppo inesistente", err, database.ErrNotFound)
+	_, err = db.CreateJoinRequest(ctx, "nessuno", conv.ID)
+	expectKind(t, "richiesta di un utente inesistente", err, database.ErrNotFound)
+	for _, uuid := range []string{"bob", "carol"} {
+		jr, err := db.CreateJoinRequest(ctx, uuid, conv.ID)
+		if err != nil || jr.Status != database.JoinRequestPending {
+			t.Fatalf("CreateJoinRequest(%q): %+v, %v", uuid, jr, err)
+		}
+	}
+	pending, err := db.GetPendingJoinRequests(ctx, conv.ID)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("GetPendingJoinRequests: %+v, %v", pending, err)
+	}
+	_, err = db.GetJoinRequest(ctx, "dave", conv.ID)
+	expectKind(t, "richiesta mai creata", err, database.ErrNotFound)
+
+	// L'approvazione aggiunge il membro, registra l'evento e apre la sua cronologia da quel momento
+	if err := db.ApproveJoinRequest(ctx, "bob", conv.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DenyJoinRequest(ctx, "carol", conv.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	expectKind(t, "richiesta già decisa", db.ApproveJoinRequest(ctx, "carol", conv.ID, "alice"), database.ErrNotFound)
+	expectKind(t, "richiesta già decisa", db.DenyJoinRequest(ctx, "bob", conv.ID, "alice"), database.ErrNotFound)
+
+	member, err := db.IsMember(ctx, "bob", conv.ID)
+	if err != nil || !member {
+		t.Fatalf("la richiesta approvata deve aggiungere il membro: %v, %v", member, err)
+	}
+	approved, err := db.GetJoinRequest(ctx, "bob", conv.ID)
+	if err != nil || approved.Status != database.JoinRequestApproved || approved.TimestampDecided == nil ||
+		approved.UUIDDecidedBy == nil || *approved.UUIDDecidedBy != "alice" {
+		t.Fatalf("richiesta approvata: %+v, %v", approved, err)
+	}
+	w, err := db.GetHistoryWindow(ctx, "bob", conv.ID)
+	if err != nil || w.Since != *approved.TimestampDecided || w.Until != "" {
+		t.Fatalf("finestra del membro approvato: %+v, %v", w, err)
+	}
+	msgs, err := db.GetMessagesByConversationID(ctx, conv.ID, w)
+	if err != nil || len(msgs) != 1 || msgs[0].System == nil || msgs[0].System.Kind != database.EventMemberJoined ||
+		msgs[0].System.Actor != "alice" || len(msgs[0].System.Targets) != 1 || msgs[0].System.Targets[0] != "bob" {
+		t.Fatalf("evento di ingresso inatteso: %+v, %v", msgs, err)
+	}
+
+	mine, err := db.GetJoinRequestsByUser(ctx, "carol")
+	if err != nil || len(mine) != 1 || mine[0].Status != database.JoinRequestDenied {
+		t.Fatalf("GetJoinRequestsByUser: %+v, %v", mine, err)
+	}
+
+	// Una richiesta rifiutata può essere ripresentata
+	reopened, err := db.CreateJoinRequest(ctx, "carol", conv.ID)
+	if err != nil || reopened.Status != database.JoinRequestPending {
+		t.Fatalf("richiesta ripresentata: %+v, %v", reopened, err)
+	}
+	jr, err := db.GetJoinRequest(ctx, "carol", conv.ID)
+	if err != nil || jr.Status != database.JoinRequestPending || jr.TimestampDecided != nil || jr.UUIDDecidedBy != nil {
+		t.Fatalf("la richiesta ripresentata deve tornare in attesa: %+v, %v", jr, err)
+	}
+	pending, err = db.GetPendingJoinRequests(ctx, conv.ID)
+	if err != nil || len(pending) != 1 || pending[0].UUIDUser != "carol" {
+		t.Fatalf("GetPendingJoinRequests dopo le decisioni: %+v, %v", pending, err)
+	}
+
+	// Un ex membro riammesso non è più un ex membro
+	if err := db.KickMember(ctx, "dave", conv.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateJoinRequest(ctx, "dave", conv.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ApproveJoinRequest(ctx, "dave", conv.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	former, err := db.GetFormerConversationsByUser(ctx, "dave")
+	if err != nil || len(former) != 0 {
+		t.Fatalf("l'ex membro riammesso non deve più essere tale: %+v, %v", former, err)
+	}
+}
+
+func testGroupInvites(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob")
+	conv := mustGroup(t, db, "alice", "gruppo")
+	other := mustGroup(t, db, "bob", "altro")
+	direct, err := db.CreateDirectConversation(ctx, "alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.GetGroupInvite(ctx, conv.ID)
+	expectKind(t, "invito mai creato", err, database.ErrNotFound)
+	_, err = db.SetGroupInvite(ctx, direct.ID, "diretta", "alice")
+	expectKind(t, "invito in una chat diretta", err, database.ErrForbidden)
+
+	if _, err := db.SetGroupInvite(ctx, conv.ID, "primo", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetGroupInvite(ctx, conv.ID, "secondo", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	invite, err := db.GetGroupInvite(ctx, conv.ID)
+	if err != nil || invite.Code != "secondo" || invite.UUIDCreatedBy == nil || *invite.UUIDCreatedBy != "alice" {
+		t.Fatalf("il nuovo codice deve sostituire il precedente: %+v, %v", invite, err)
+	}
+	_, err = db.SetGroupInvite(ctx, other.ID, "secondo", "bob")
+	expectKind(t, "codice già usato da un altro gruppo", err, database.ErrConflict)
+
+	_, err = db.SetGroupInvite(ctx, conv.ID+1000, "terzo", "alice")
+	expectKind(t, "invito per un gruppo inesistente", err, database.ErrNotFound)
+
+	if err := db.DeleteGroupInvite(ctx, conv.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectKind(t, "invito già revocato", db.DeleteGroupInvite(ctx, conv.ID), database.ErrNotFound)
+	_, err = db.GetGroupInvite(ctx, conv.ID)
+	expectKind(t, "invito revocato", err, database.ErrNotFound)
+
+	// L'invito sparisce con il gruppo e il suo codice torna disponibile
+	if _, err := db.SetGroupInvite(ctx, other.ID, "terzo", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RemoveMember(ctx, "bob", other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteConversationIfEmpty(ctx, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetGroupInvite(ctx, other.ID)
+	expectKind(t, "invito di un gruppo eliminato", err, database.ErrNotFound)
+	if _, err := db.SetGroupInvite(ctx, conv.ID, "terzo", "alice"); err != nil {
+		t.Fatalf("il codice di un gruppo eliminato deve tornare disponibile: %v", err)
+	}
+}
+
+func testWithTx(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice")
+
+	// Un errore annulla tutte le operazioni della transazione
+	errAbort := errors.New("annulla")
+	err := db.WithTx(ctx, func(tx database.AppDatabase) error {
+		if err := tx.CreateUser(ctx, "bob", "bob", ""); err != nil {
+			return err
+		}
+		if _, err := tx.CreateGroupConversation(ctx, "alice", nil, nil, []string{"bob"}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx deve restituire l'errore di fn: %v", err)
+	}
+	exists, err := db.UserExists(ctx, "bob")
+	if err != nil || exists {
+		t.Fatalf("l'utente creato nella transazione annullata non deve esistere: %v, %v", exists, err)
+	}
+	convs, err := db.GetConversationsByUser(ctx, "alice")
+	if err != nil || len(convs) != 0 {
+		t.Fatalf("il gruppo creato nella transazione annullata non deve esistere: %+v, %v", convs, err)
+	}
+
+	// Le transazioni annidate fanno parte di quella esterna
+	err = db.WithTx(ctx, func(tx database.AppDatabase) error {
+		return tx.WithTx(ctx, func(inner database.AppDatabase) error {
+			return inner.CreateUser(ctx, "carol", "carol", "")
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = db.UserExists(ctx, "carol")
+	if err != nil || !exists {
+		t.Fatalf("la transazione confermata deve salvare l'utente: %v, %v", exists, err)
+	}
+}
+
+func testDeleteCascade(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob")
+	first := mustMessage(t, db, conv.ID, "alice", "originale")
+	reply, err := db.CreateMessage(ctx, database.Message{
+		Type:           "text",
+		Content:        "risposta",
+		IDConversation: conv.ID,
+		UUIDSender:     "bob",
+		IDRepliesTo:    &first,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddReaction(ctx, first, "bob", "👍"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Eliminando un messaggio spariscono reazioni e stati, mentre le risposte perdono il riferimento
+	if err := db.DeleteMessageByID(ctx, first, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	reactions, err := db.GetReactionsByMessageID(ctx, first)
+	if err != nil || len(reactions) != 0 {
+		t.Fatalf("le reazioni del messaggio eliminato vanno eliminate: %+v, %v", reactions, err)
+	}
+	statuses, err := db.GetAllStatusesByMessage(ctx, first)
+	if err != nil || len(statuses) != 0 {
+		t.Fatalf("gli stati del messaggio eliminato vanno eliminati: %+v, %v", statuses, err)
+	}
+	m, err := db.GetMessageByID(ctx, reply)
+	if err != nil || m.IDRepliesTo != nil {
+		t.Fatalf("la risposta deve perdere il riferimento: %+v, %v", m, err)
+	}
+
+	// Un messaggio che fa riferimento a dati inesistenti viene rifiutato
+	_, err = db.CreateMessage(ctx, database.Message{Type: "text", Content: "x", IDConversation: conv.ID + 1000, UUIDSender: "alice"})
+	expectKind(t, "messaggio in una conversazione inesistente", err, database.ErrNotFound)
+	expectKind(t, "reazione a un messaggio inesistente", db.AddReaction(ctx, first, "alice", "👍"), database.ErrNotFound)
+
+	// Eliminando una conversazione spariscono anche i suoi messaggi
+	for _, uuid := range []string{"alice", "bob"} {
+		if err := db.RemoveMember(ctx, uuid, conv.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteConversationIfEmpty(ctx, conv.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetMessageByID(ctx, reply)
+	expectKind(t, "messaggio di una conversazione eliminata", err, database.ErrNotFound)
+}
+
+func testConcurrency(t *testing.T, db database.AppDatabase) {
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob")
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.CreateMessage(ctx, database.Message{Type: "text", Content: "ciao", IDConversation: conv.ID, UUIDSender: "alice"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := db.GetMessagesByConversationID(ctx, conv.ID, database.HistoryWindow{})
+	if err != nil || len(msgs) != n {
+		t.Fatalf("attesi %d messaggi, ottenuti %d (%v)", n, len(msgs), err)
+	}
+	for _, m := range msgs {
+		statuses, err := db.GetAllStatusesByMessage(ctx, m.ID)
+		if err != nil || len(statuses) != 1 {
+			t.Fatalf("stati del messaggio %d: %+v, %v", m.ID, statuses, err)
+		}
+	}
+}
+
+func testCanceled(t *testing.T, db database.AppDatabase) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.GetAllUsers(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("atteso context.Canceled, ottenuto %v", err)
+	}
+	err := db.WithTx(ctx, func(tx database.AppDatabase) error {
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("WithTx: atteso context.Canceled, ottenuto %v", err)
+	}
+}
+
+// testSchemaVersion controlla che un database appena aperto abbia lo schema aggiornato
+func testSchemaVersion(t *testing.T, db database.AppDatabase) {
+	current, latest, err := db.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatalf("SchemaVersion: %v", err)
+	}
+	if latest < 1 {
+		t.Fatalf("ultima versione dello schema %d, attesa almeno 1", latest)
+	}
+	if current != latest {
+		t.Fatalf("versione dello schema %d, attesa %d", current, latest)
+	}
+}
+
+// mustAdmin restituisce le operazioni di amministrazione di db, saltando il test se l'implementazione non le supporta
+func mustAdmin(t *testing.T, db database.AppDatabase) database.Admin {
+	t.Helper()
+	admin, ok := db.(database.Admin)
+	if !ok {
+		t.Skip("l'implementazione non supporta database.Admin")
+	}
+	return admin
+}
+
+func testAdminDeleteUser(t *testing.T, db database.AppDatabase) {
+	admin := mustAdmin(t, db)
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob", "carol")
+	group := mustGroup(t, db, "alice", "gruppo", "bob")
+	solo := mustGroup(t, db, "alice", "da sola")
+	direct, err := db.CreateDirectConversation(ctx, "alice", "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := mustMessage(t, db, group.ID, "alice", "ciao")
+	reply, err := db.CreateMessage(ctx, database.Message{
+		Type:           "text",
+		Content:        "risposta",
+		IDConversation: group.ID,
+		UUIDSender:     "bob",
+		IDRepliesTo:    &first,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustMessage(t, db, direct.ID, "carol", "ciao alice")
+
+	if err := admin.DeleteUser(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	expectKind(t, "utente già eliminato", admin.DeleteUser(ctx, "alice"), database.ErrNotFound)
+	_, err = db.GetUserByUUID(ctx, "alice")
+	expectKind(t, "utente eliminato", err, database.ErrNotFound)
+
+	// I suoi messaggi spariscono, quelli degli altri restano leggibili
+	_, err = db.GetMessageByID(ctx, first)
+	expectKind(t, "messaggio dell'utente eliminato", err, database.ErrNotFound)
+	messages, err := db.GetMessagesByConversationID(ctx, group.ID, database.HistoryWindow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, m := range messages {
+		if m.UUIDSender == "alice" {
+			t.Fatalf("messaggio dell'utente eliminato ancora presente: %+v", m)
+		}
+		found = found || m.ID == reply
+	}
+	if !found {
+		t.Fatal("la risposta di bob deve restare nel gruppo")
+	}
+
+	// Il gruppo passa a bob, quello rimasto vuoto e la chat diretta vengono eliminati
+	if isAdmin, err := db.IsAdmin(ctx, "bob", group.ID); err != nil || !isAdmin {
+		t.Fatalf("bob deve diventare amministratore: %v, %v", isAdmin, err)
+	}
+	for _, id := range []int64{solo.ID, direct.ID} {
+		_, err = db.GetConversationByID(ctx, id)
+		expectKind(t, "conversazione dell'utente eliminato", err, database.ErrNotFound)
+	}
+	conversations, err := db.GetConversationsByUser(ctx, "carol")
+	if err != nil || len(conversations) != 0 {
+		t.Fatalf("carol non deve avere conversazioni: %+v, %v", conversations, err)
+	}
+}
+
+func testAdminDeleteUserKeepsSystemEvents(t *testing.T, db database.AppDatabase) {
+	admin := mustAdmin(t, db)
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob", "carol")
+	group := mustGroup(t, db, "bob", "gruppo", "carol")
+	if err := db.AddMembers(ctx, group.ID, []string{"alice"}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetGroupName(ctx, group.ID, "nuovo nome", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	mustMessage(t, db, group.ID, "alice", "ciao")
+	before, err := db.GetMessagesByConversationID(ctx, group.ID, database.HistoryWindow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := admin.DeleteUser(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Gli eventi del gruppo restano, con alice sostituita da DeletedUser; il suo messaggio di testo no
+	after, err := db.GetMessagesByConversationID(ctx, group.ID, database.HistoryWindow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before)-1 || len(after) != 2 {
+		t.Fatalf("attesi i 2 messaggi di sistema, trovati %+v", after)
+	}
+	added, renamed := after[0], after[1]
+	if added.System == nil || added.System.Kind != database.EventMemberAdded || added.UUIDSender != "bob" ||
+		added.System.Actor != "bob" || len(added.System.Targets) != 1 || added.System.Targets[0] != database.DeletedUser {
+		t.Errorf("evento di aggiunta inatteso: %+v %+v", added, added.System)
+	}
+	if renamed.System == nil || renamed.System.Kind != database.EventGroupRenamed ||
+		renamed.UUIDSender != database.DeletedUser || renamed.System.Actor != database.DeletedUser ||
+		renamed.Content != before[1].Content {
+		t.Errorf("evento di modifica del nome inatteso: %+v %+v", renamed, renamed.System)
+	}
+	msg, err := db.GetMessageByID(ctx, renamed.ID)
+	if err != nil || msg.UUIDSender != database.DeletedUser {
+		t.Errorf("GetMessageByID dell'evento: %+v, %v", msg, err)
+	}
+	last, err := db.GetLastMessage(ctx, group.ID)
+	if err != nil || last.ID != renamed.ID {
+		t.Errorf("ultimo messaggio: %+v, %v", last, err)
+	}
+}
+
+func testAdminForceRemoveMember(t *testing.T, db database.AppDatabase) {
+	admin := mustAdmin(t, db)
+	ctx := context.Background()
+	mustUsers(t, db, "alice", "bob")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob")
+	before, err := db.GetMessagesByConversationID(ctx, conv.ID, database.HistoryWindow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := admin.ForceRemoveMember(ctx, "alice", conv.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectKind(t, "rimozione di un non membro", admin.ForceRemoveMember(ctx, "alice", conv.ID), database.ErrNotFound)
+
+	// Come con KickMember l'ex membro legge la cronologia fino alla rimozione, ma non c'è un messaggio di sistema
+	w, err := db.GetHistoryWindow(ctx, "alice", conv.ID)
+	if err != nil || w.Until == "" {
+		t.Fatalf("GetHistoryWindow dell'ex membro: %+v, %v", w, err)
+	}
+	after, err := db.GetMessagesByConversationID(ctx, conv.ID, database.HistoryWindow{})
+	if err != nil || len(after) != len(before) {
+		t.Fatalf("messaggi dopo la rimozione: %d, prima %d, %v", len(after), len(before), err)
+	}
+	if isAdmin, err := db.IsAdmin(ctx, "bob", conv.ID); err != nil || !isAdmin {
+		t.Fatalf("bob deve diventare amministratore: %v, %v", isAdmin, err)
+	}
+}
+
+func testAdminPurgeAndStats(t *testing.T, db database.AppDatabase) {
+	admin := mustAdmin(t, db)
+	ctx := context.Background()
+
+	stats, err := admin.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (database.Stats{}) {
+		t.Fatalf("statistiche di un database vuoto: %+v", stats)
+	}
+
+	mustUsers(t, db, "alice", "bob", "carol")
+	conv := mustGroup(t, db, "alice", "gruppo", "bob")
+	if _, err := db.CreateDirectConversation(ctx, "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	id := mustMessage(t, db, conv.ID, "alice", "ciao")
+	if err := db.AddReaction(ctx, id, "bob", "👍"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateJoinRequest(ctx, "carol", conv.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = admin.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Users != 3 || stats.DirectConversations != 1 || stats.Groups != 1 || stats.Messages != 1 ||
+		stats.Reactions != 1 || stats.PendingJoinRequests != 1 || stats.FirstMessage == "" || stats.LastMessage == "" {
+		t.Fatalf("statistiche inattese: %+v", stats)
+	}
+
+	all, err := admin.GetAllConversations(ctx)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("GetAllConversations: %+v, %v", all, err)
+	}
+
+	// Nessun messaggio è più vecchio di un'ora fa; tutti sono più vecchi di domani
+	n, err := admin.PurgeMessagesBefore(ctx, time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Fatalf("PurgeMessagesBefore(un'ora fa): %d, %v", n, err)
+	}
+	n, err = admin.PurgeMessagesBefore(ctx, time.Now().Add(24*time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("PurgeMessagesBefore(domani): %d, %v", n, err)
+	}
+	_, err = db.GetMessageByID(ctx, id)
+	expectKind(t, "messaggio eliminato", err, database.ErrNotFound)
+	reactions, err := db.GetReactionsByMessageID(ctx, id)
+	if err != nil || len(reactions) != 0 {
+		t.Fatalf("le reazioni del messaggio eliminato vanno eliminate: %+v, %v", reactions, err)
+	}
+}
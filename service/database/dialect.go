@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// dialect identifica il database su cui lavora appdbimpl. Le query sono scritte una sola volta in un SQL comune a
+// SQLite e PostgreSQL (segnaposto `?`, `RETURNING id`, tabella "user" tra virgolette); il dialetto si occupa delle
+// poche differenze rimaste.
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// wrap adatta q al dialetto (con PostgreSQL i segnaposto `?` diventano `$1`, `$2`, ...) e ne conta le query, vedi
+// instrumentedQuerier
+func (d dialect) wrap(q querier) querier {
+	if d == dialectPostgres {
+		q = postgresQuerier{q: q}
+	}
+	return instrumentedQuerier{q: q}
+}
+
+// txOptions sono le opzioni delle transazioni di WithTx. Con PostgreSQL le transazioni sono SERIALIZABLE, così che i
+// controlli fatti dentro WithTx (ad esempio "la chat diretta non esiste ancora") valgano fino al commit come accade con
+// SQLite; i conflitti vengono segnalati come errori di serializzazione e la transazione viene ripetuta.
+func (d dialect) txOptions() *sql.TxOptions {
+	if d == dialectPostgres {
+		return &sql.TxOptions{Isolation: sql.LevelSerializable}
+	}
+	return nil
+}
+
+// postgresQuerier converte i segnaposto delle query prima di passarle a PostgreSQL
+type postgresQuerier struct {
+	q querier
+}
+
+func (p postgresQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.q.ExecContext(ctx, rebind(query), args...)
+}
+
+func (p postgresQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.q.QueryContext(ctx, rebind(query), args...)
+}
+
+func (p postgresQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.q.QueryRowContext(ctx, rebind(query), args...)
+}
+
+func (p postgresQuerier) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.q.PrepareContext(ctx, rebind(query))
+}
+
+// rebind sostituisce i segnaposto `?` con quelli numerati di PostgreSQL, ignorando quelli dentro le stringhe SQL
+func rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,22 @@
+package database
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`SELECT 1`, `SELECT 1`},
+		{`SELECT * FROM "user" WHERE uuid = ?`, `SELECT * FROM "user" WHERE uuid = $1`},
+		{`INSERT INTO t (a, b) VALUES (?, ?), (?, ?)`, `INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4)`},
+		{`SELECT '?' FROM t WHERE a = ?`, `SELECT '?' FROM t WHERE a = $1`},
+		{`WHERE a LIKE ? ESCAPE '\' AND b = ?`, `WHERE a LIKE $1 ESCAPE '\' AND b = $2`},
+		{`WHERE a = 'it''s ?' AND b = ?`, `WHERE a = 'it''s ?' AND b = $1`},
+	}
+	for _, tt := range tests {
+		if got := rebind(tt.query); got != tt.want {
+			t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Categorie di errore restituite dai metodi di AppDatabase. Vanno confrontate con errors.Is: l'errore effettivo è un
+// *Error che può contenere una descrizione e l'errore originale del driver.
+var (
+	// ErrNotFound indica che la risorsa richiesta, o una a cui si fa riferimento, non esiste
+	ErrNotFound = errors.New("not found")
+
+	// ErrForbidden indica che l'operazione non è permessa all'utente o su quella risorsa
+	ErrForbidden = errors.New("forbidden")
+
+	// ErrConflict indica che l'operazione è in conflitto con lo stato attuale, ad esempio un elemento già esistente
+	ErrConflict = errors.New("conflict")
+)
+
+// Error è un errore del database classificato in una delle categorie ErrNotFound, ErrForbidden o ErrConflict.
+type Error struct {
+	// Kind è la categoria dell'errore
+	Kind error
+
+	// Msg descrive l'errore (facoltativo)
+	Msg string
+
+	// Err è l'errore originale, se presente
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Msg != "" {
+		msg += ": " + e.Msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is fa sì che errors.Is(err, ErrNotFound) (e simili) riconosca la categoria dell'errore.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap permette di risalire all'errore originale del driver (ad esempio sql.ErrNoRows).
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind error, msg string) error {
+	return &Error{Kind: kind, Msg: msg}
+}
+
+// Codici di errore (SQLSTATE) di PostgreSQL usati in questo package
+const (
+	pqUniqueViolation      = "23505"
+	pqForeignKeyViolation  = "23503"
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+// classify traduce gli errori dei driver (SQLite e PostgreSQL) nelle categorie di questo package: sql.ErrNoRows e le
+// violazioni di FOREIGN KEY diventano ErrNotFound, le violazioni di UNIQUE o PRIMARY KEY diventano ErrConflict. Gli
+// altri errori (e nil) sono restituiti così come sono.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var dbErr *Error
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Err: err}
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		switch sqliteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return &Error{Kind: ErrConflict, Err: err}
+		case sqlite3.ErrConstraintForeignKey:
+			return &Error{Kind: ErrNotFound, Err: err}
+		}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case pqUniqueViolation:
+			return &Error{Kind: ErrConflict, Err: err}
+		case pqForeignKeyViolation:
+			return &Error{Kind: ErrNotFound, Err: err}
+		}
+	}
+	return err
+}
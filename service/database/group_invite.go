@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+)
+
+// GroupInvite è il codice di invito di un gruppo: chi lo conosce può chiedere di entrare anche se il gruppo non è in
+// modalità "approvazione richiesta". Ogni gruppo ha al più un codice valido.
+type GroupInvite struct {
+	IDConversation   int64   `json:"idConversation"`
+	Code             string  `json:"code"`
+	UUIDCreatedBy    *string `json:"uuidCreatedBy"`
+	TimestampCreated string  `json:"timestampCreated"`
+}
+
+// SetGroupInvite imposta il codice di invito del gruppo, sostituendo (e quindi invalidando) quello precedente.
+func (db *appdbimpl) SetGroupInvite(ctx context.Context, idConversation int64, code string, uuidCreatedBy string) (GroupInvite, error) {
+	var isDirect bool
+	err := db.c.QueryRowContext(ctx, `SELECT isDirect FROM conversation WHERE id = ?`, idConversation).Scan(&isDirect)
+	if err != nil {
+		return GroupInvite{}, classify(err)
+	}
+	if isDirect {
+		return GroupInvite{}, newError(ErrForbidden, "impossibile creare un invito: conversazione diretta")
+	}
+
+	timestamp := now()
+	_, err = db.c.ExecContext(ctx, `
+		INSERT INTO groupInvite (idConversation, code, uuidCreatedBy, timestampCreated)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(idConversation)
+		DO UPDATE SET code = excluded.code, uuidCreatedBy = excluded.uuidCreatedBy,
+			timestampCreated = excluded.timestampCreated;
+	`, idConversation, code, uuidCreatedBy, timestamp)
+	if err != nil {
+		return GroupInvite{}, classify(err)
+	}
+
+	return GroupInvite{
+		IDConversation:   idConversation,
+		Code:             code,
+		UUIDCreatedBy:    &uuidCreatedBy,
+		TimestampCreated: timestamp,
+	}, nil
+}
+
+// GetGroupInvite restituisce il codice di invito del gruppo, o ErrNotFound se non ne ha uno.
+func (db *appdbimpl) GetGroupInvite(ctx context.Context, idConversation int64) (GroupInvite, error) {
+	var invite GroupInvite
+	err := db.c.QueryRowContext(ctx, `
+		SELECT idConversation, code, uuidCreatedBy, timestampCreated
+		FROM groupInvite
+		WHERE idConversation = ?;
+	`, idConversation).Scan(&invite.IDConversation, &invite.Code, &invite.UUIDCreatedBy, &invite.TimestampCreated)
+	return invite, classify(err)
+}
+
+// DeleteGroupInvite revoca il codice di invito del gruppo. Ritorna ErrNotFound se il gruppo non ne ha uno.
+func (db *appdbimpl) DeleteGroupInvite(ctx context.Context, idConversation int64) error {
+	res, err := db.c.ExecContext(ctx, `DELETE FROM groupInvite WHERE idConversation = ?`, idConversation)
+	if err != nil {
+		return err
+	}
+	af, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if af == 0 {
+		return newError(ErrNotFound, "nessun invito")
+	}
+	return nil
+}
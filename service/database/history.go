@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Politiche di visibilità della cronologia di un gruppo
+const (
+	// HistoryFull: i nuovi membri vedono tutta la cronologia del gruppo
+	HistoryFull = "full"
+
+	// HistorySinceJoin: i nuovi membri vedono solo i messaggi successivi al loro ingresso
+	HistorySinceJoin = "since_join"
+)
+
+// HistoryWindow è l'intervallo di messaggi visibili a un utente in una conversazione. Since e Until sono timestamp nel
+// formato TimestampFormat (inclusi), confrontabili come stringhe; una stringa vuota indica che l'intervallo non è
+// limitato da quel lato.
+type HistoryWindow struct {
+	Since string
+	Until string
+}
+
+// Contains indica se un messaggio con il timestamp dato rientra nella finestra.
+func (w HistoryWindow) Contains(timestamp string) bool {
+	if w.Since != "" && timestamp < w.Since {
+		return false
+	}
+	if w.Until != "" && timestamp > w.Until {
+		return false
+	}
+	return true
+}
+
+// GetHistoryWindow calcola quali messaggi della conversazione può vedere l'utente, in base alla politica del gruppo, al
+// momento del suo ingresso (member.timestampJoined) e, per gli ex membri, al momento della rimozione. Se l'utente non
+// è né membro né ex membro ritorna ErrNotFound.
+func (db *appdbimpl) GetHistoryWindow(ctx context.Context, uuidUser string, idConversation int64) (HistoryWindow, error) {
+	var visibility, joinedAt string
+	err := db.c.QueryRowContext(ctx, `
+		SELECT c.historyVisibility, m.timestampJoined
+		FROM conversation c
+		JOIN member m ON c.id = m.idConversation
+		WHERE m.uuidUser = ? AND c.id = ?;
+	`, uuidUser, idConversation).Scan(&visibility, &joinedAt)
+	if err == nil {
+		var w HistoryWindow
+		if visibility == HistorySinceJoin {
+			w.Since = joinedAt
+		}
+		return w, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return HistoryWindow{}, err
+	}
+
+	// Ex membro: la cronologia si ferma al momento della rimozione
+	var removedAt string
+	err = db.c.QueryRowContext(ctx, `
+		SELECT c.historyVisibility, f.timestampJoined, f.timestampRemoved
+		FROM conversation c
+		JOIN formerMember f ON c.id = f.idConversation
+		WHERE f.uuidUser = ? AND c.id = ?;
+	`, uuidUser, idConversation).Scan(&visibility, &joinedAt, &removedAt)
+	if err != nil {
+		return HistoryWindow{}, classify(err)
+	}
+	w := HistoryWindow{Until: removedAt}
+	if visibility == HistorySinceJoin {
+		w.Since = joinedAt
+	}
+	return w, nil
+}
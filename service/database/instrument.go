@@ -0,0 +1,341 @@
+package database
+
+import "context"
+
+// Observer viene chiamato all'inizio di ogni chiamata a un metodo di AppDatabase, con il context e il nome del metodo,
+// e restituisce la funzione chiamata al termine con l'errore restituito dal metodo
+type Observer func(ctx context.Context, method string) (done func(err error))
+
+// Instrument restituisce un AppDatabase che inoltra ogni chiamata a db e la segnala a observe. Anche il `tx` ricevuto
+// dalla funzione di WithTx è instrumentato, così i metodi chiamati dentro una transazione vengono osservati come gli
+// altri; WithTx stesso viene osservato per l'intera durata della transazione.
+func Instrument(db AppDatabase, observe Observer) AppDatabase {
+	return instrumentedDB{db: db, observer: observe}
+}
+
+// instrumentedDB è l'AppDatabase restituito da Instrument
+type instrumentedDB struct {
+	db       AppDatabase
+	observer Observer
+}
+
+// observe segnala all'observer l'inizio della chiamata a method e restituisce la funzione da chiamare al termine, con
+// un puntatore all'errore restituito dal metodo
+func (i instrumentedDB) observe(ctx context.Context, method string) func(err *error) {
+	done := i.observer(ctx, method)
+	return func(err *error) {
+		done(*err)
+	}
+}
+
+func (i instrumentedDB) WithTx(ctx context.Context, fn func(tx AppDatabase) error) (err error) {
+	defer i.observe(ctx, "WithTx")(&err)
+	return i.db.WithTx(ctx, func(tx AppDatabase) error {
+		return fn(instrumentedDB{db: tx, observer: i.observer})
+	})
+}
+
+func (i instrumentedDB) GetName(ctx context.Context) (v string, err error) {
+	defer i.observe(ctx, "GetName")(&err)
+	return i.db.GetName(ctx)
+}
+
+func (i instrumentedDB) SetName(ctx context.Context, name string) (err error) {
+	defer i.observe(ctx, "SetName")(&err)
+	return i.db.SetName(ctx, name)
+}
+
+func (i instrumentedDB) CreateUser(ctx context.Context, uuid string, username string, photoUrl string) (err error) {
+	defer i.observe(ctx, "CreateUser")(&err)
+	return i.db.CreateUser(ctx, uuid, username, photoUrl)
+}
+
+func (i instrumentedDB) GetUserByUUID(ctx context.Context, uuid string) (v User, err error) {
+	defer i.observe(ctx, "GetUserByUUID")(&err)
+	return i.db.GetUserByUUID(ctx, uuid)
+}
+
+func (i instrumentedDB) GetUserByUsername(ctx context.Context, username string) (v User, err error) {
+	defer i.observe(ctx, "GetUserByUsername")(&err)
+	return i.db.GetUserByUsername(ctx, username)
+}
+
+func (i instrumentedDB) SetUserName(ctx context.Context, uuid string, newUsername string) (err error) {
+	defer i.observe(ctx, "SetUserName")(&err)
+	return i.db.SetUserName(ctx, uuid, newUsername)
+}
+
+func (i instrumentedDB) SetPhotoUrl(ctx context.Context, uuid string, newPhotoUrl string) (err error) {
+	defer i.observe(ctx, "SetPhotoUrl")(&err)
+	return i.db.SetPhotoUrl(ctx, uuid, newPhotoUrl)
+}
+
+func (i instrumentedDB) SearchUsersByPrefix(ctx context.Context, prefix string) (v []User, err error) {
+	defer i.observe(ctx, "SearchUsersByPrefix")(&err)
+	return i.db.SearchUsersByPrefix(ctx, prefix)
+}
+
+func (i instrumentedDB) GetAllUsers(ctx context.Context) (v []User, err error) {
+	defer i.observe(ctx, "GetAllUsers")(&err)
+	return i.db.GetAllUsers(ctx)
+}
+
+func (i instrumentedDB) UserExists(ctx context.Context, uuid string) (v bool, err error) {
+	defer i.observe(ctx, "UserExists")(&err)
+	return i.db.UserExists(ctx, uuid)
+}
+
+func (i instrumentedDB) GetPeerData(ctx context.Context, convID int64, uuidMe string) (v User, err error) {
+	defer i.observe(ctx, "GetPeerData")(&err)
+	return i.db.GetPeerData(ctx, convID, uuidMe)
+}
+
+func (i instrumentedDB) CreateMessage(ctx context.Context, msg Message) (v int64, err error) {
+	defer i.observe(ctx, "CreateMessage")(&err)
+	return i.db.CreateMessage(ctx, msg)
+}
+
+func (i instrumentedDB) GetMessageByID(ctx context.Context, id int64) (v Message, err error) {
+	defer i.observe(ctx, "GetMessageByID")(&err)
+	return i.db.GetMessageByID(ctx, id)
+}
+
+func (i instrumentedDB) GetMessagesByConversationID(ctx context.Context, convoID int64, window HistoryWindow) (v []Message, err error) {
+	defer i.observe(ctx, "GetMessagesByConversationID")(&err)
+	return i.db.GetMessagesByConversationID(ctx, convoID, window)
+}
+
+func (i instrumentedDB) SearchMessages(ctx context.Context, convoID int64, query string, window HistoryWindow) (v []Message, err error) {
+	defer i.observe(ctx, "SearchMessages")(&err)
+	return i.db.SearchMessages(ctx, convoID, query, window)
+}
+
+func (i instrumentedDB) DeleteMessageByID(ctx context.Context, id int64, uuidSender string) (err error) {
+	defer i.observe(ctx, "DeleteMessageByID")(&err)
+	return i.db.DeleteMessageByID(ctx, id, uuidSender)
+}
+
+func (i instrumentedDB) ForwardMessage(ctx context.Context, originalMsgID int64, destConversationID int64, senderUUID string) (v int64, err error) {
+	defer i.observe(ctx, "ForwardMessage")(&err)
+	return i.db.ForwardMessage(ctx, originalMsgID, destConversationID, senderUUID)
+}
+
+func (i instrumentedDB) GetLastMessage(ctx context.Context, convID int64) (v Message, err error) {
+	defer i.observe(ctx, "GetLastMessage")(&err)
+	return i.db.GetLastMessage(ctx, convID)
+}
+
+func (i instrumentedDB) AddReaction(ctx context.Context, messageID int64, uuid string, emoji string) (err error) {
+	defer i.observe(ctx, "AddReaction")(&err)
+	return i.db.AddReaction(ctx, messageID, uuid, emoji)
+}
+
+func (i instrumentedDB) RemoveReaction(ctx context.Context, messageID int64, uuid string) (err error) {
+	defer i.observe(ctx, "RemoveReaction")(&err)
+	return i.db.RemoveReaction(ctx, messageID, uuid)
+}
+
+func (i instrumentedDB) GetReactionsByMessageID(ctx context.Context, messageID int64) (v []Reaction, err error) {
+	defer i.observe(ctx, "GetReactionsByMessageID")(&err)
+	return i.db.GetReactionsByMessageID(ctx, messageID)
+}
+
+func (i instrumentedDB) GetReactionsWithUserByMessageID(ctx context.Context, messageID int64) (v []ReactionWithUser, err error) {
+	defer i.observe(ctx, "GetReactionsWithUserByMessageID")(&err)
+	return i.db.GetReactionsWithUserByMessageID(ctx, messageID)
+}
+
+func (i instrumentedDB) SetDelivered(ctx context.Context, uuidUser string, idMessage int64) (err error) {
+	defer i.observe(ctx, "SetDelivered")(&err)
+	return i.db.SetDelivered(ctx, uuidUser, idMessage)
+}
+
+func (i instrumentedDB) SetSeen(ctx context.Context, uuidUser string, idMessage int64) (err error) {
+	defer i.observe(ctx, "SetSeen")(&err)
+	return i.db.SetSeen(ctx, uuidUser, idMessage)
+}
+
+func (i instrumentedDB) GetMessageStatus(ctx context.Context, uuidUser string, idMessage int64) (v MessageStatus, err error) {
+	defer i.observe(ctx, "GetMessageStatus")(&err)
+	return i.db.GetMessageStatus(ctx, uuidUser, idMessage)
+}
+
+func (i instrumentedDB) GetAllStatusesByMessage(ctx context.Context, idMessage int64) (v []MessageStatus, err error) {
+	defer i.observe(ctx, "GetAllStatusesByMessage")(&err)
+	return i.db.GetAllStatusesByMessage(ctx, idMessage)
+}
+
+func (i instrumentedDB) CreateDirectConversation(ctx context.Context, uuid1, uuid2 string) (v Conversation, err error) {
+	defer i.observe(ctx, "CreateDirectConversation")(&err)
+	return i.db.CreateDirectConversation(ctx, uuid1, uuid2)
+}
+
+func (i instrumentedDB) CreateGroupConversation(ctx context.Context, creatorUUID string, groupName, groupPhoto *string, members []string) (v Conversation, err error) {
+	defer i.observe(ctx, "CreateGroupConversation")(&err)
+	return i.db.CreateGroupConversation(ctx, creatorUUID, groupName, groupPhoto, members)
+}
+
+func (i instrumentedDB) GetConversationsByUser(ctx context.Context, uuid string) (v []Conversation, err error) {
+	defer i.observe(ctx, "GetConversationsByUser")(&err)
+	return i.db.GetConversationsByUser(ctx, uuid)
+}
+
+func (i instrumentedDB) GetFormerConversationsByUser(ctx context.Context, uuid string) (v []Conversation, err error) {
+	defer i.observe(ctx, "GetFormerConversationsByUser")(&err)
+	return i.db.GetFormerConversationsByUser(ctx, uuid)
+}
+
+func (i instrumentedDB) GetLastMessageByConversation(ctx context.Context, id int64) (v Message, err error) {
+	defer i.observe(ctx, "GetLastMessageByConversation")(&err)
+	return i.db.GetLastMessageByConversation(ctx, id)
+}
+
+func (i instrumentedDB) GetDirectConversationBetween(ctx context.Context, uuid1, uuid2 string) (v Conversation, err error) {
+	defer i.observe(ctx, "GetDirectConversationBetween")(&err)
+	return i.db.GetDirectConversationBetween(ctx, uuid1, uuid2)
+}
+
+func (i instrumentedDB) DeleteConversationIfEmpty(ctx context.Context, id int64) (err error) {
+	defer i.observe(ctx, "DeleteConversationIfEmpty")(&err)
+	return i.db.DeleteConversationIfEmpty(ctx, id)
+}
+
+func (i instrumentedDB) GetConversationByID(ctx context.Context, id int64) (v Conversation, err error) {
+	defer i.observe(ctx, "GetConversationByID")(&err)
+	return i.db.GetConversationByID(ctx, id)
+}
+
+func (i instrumentedDB) SetGroupName(ctx context.Context, id int64, newName string, uuidActor string) (err error) {
+	defer i.observe(ctx, "SetGroupName")(&err)
+	return i.db.SetGroupName(ctx, id, newName, uuidActor)
+}
+
+func (i instrumentedDB) SetGroupPhoto(ctx context.Context, id int64, newPhoto string, uuidActor string) (err error) {
+	defer i.observe(ctx, "SetGroupPhoto")(&err)
+	return i.db.SetGroupPhoto(ctx, id, newPhoto, uuidActor)
+}
+
+func (i instrumentedDB) SetGroupRequiresApproval(ctx context.Context, id int64, requiresApproval bool) (err error) {
+	defer i.observe(ctx, "SetGroupRequiresApproval")(&err)
+	return i.db.SetGroupRequiresApproval(ctx, id, requiresApproval)
+}
+
+func (i instrumentedDB) SetGroupHistoryVisibility(ctx context.Context, id int64, visibility string) (err error) {
+	defer i.observe(ctx, "SetGroupHistoryVisibility")(&err)
+	return i.db.SetGroupHistoryVisibility(ctx, id, visibility)
+}
+
+func (i instrumentedDB) SearchGroupsByName(ctx context.Context, prefix string) (v []Conversation, err error) {
+	defer i.observe(ctx, "SearchGroupsByName")(&err)
+	return i.db.SearchGroupsByName(ctx, prefix)
+}
+
+func (i instrumentedDB) AddMember(ctx context.Context, uuidUser string, idConversation int64) (err error) {
+	defer i.observe(ctx, "AddMember")(&err)
+	return i.db.AddMember(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) AddMembers(ctx context.Context, idConversation int64, uuids []string, uuidActor string) (err error) {
+	defer i.observe(ctx, "AddMembers")(&err)
+	return i.db.AddMembers(ctx, idConversation, uuids, uuidActor)
+}
+
+func (i instrumentedDB) RemoveMember(ctx context.Context, uuidUser string, idConversation int64) (err error) {
+	defer i.observe(ctx, "RemoveMember")(&err)
+	return i.db.RemoveMember(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) KickMember(ctx context.Context, uuidUser string, idConversation int64, uuidRemovedBy string) (err error) {
+	defer i.observe(ctx, "KickMember")(&err)
+	return i.db.KickMember(ctx, uuidUser, idConversation, uuidRemovedBy)
+}
+
+func (i instrumentedDB) IsMember(ctx context.Context, uuidUser string, idConversation int64) (v bool, err error) {
+	defer i.observe(ctx, "IsMember")(&err)
+	return i.db.IsMember(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) IsAdmin(ctx context.Context, uuidUser string, idConversation int64) (v bool, err error) {
+	defer i.observe(ctx, "IsAdmin")(&err)
+	return i.db.IsAdmin(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) GetMembersByConversation(ctx context.Context, idConversation int64) (v []string, err error) {
+	defer i.observe(ctx, "GetMembersByConversation")(&err)
+	return i.db.GetMembersByConversation(ctx, idConversation)
+}
+
+func (i instrumentedDB) GetJoinedAt(ctx context.Context, uuidUser string, idConversation int64) (v string, err error) {
+	defer i.observe(ctx, "GetJoinedAt")(&err)
+	return i.db.GetJoinedAt(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) GetRemovedAt(ctx context.Context, uuidUser string, idConversation int64) (v string, err error) {
+	defer i.observe(ctx, "GetRemovedAt")(&err)
+	return i.db.GetRemovedAt(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) DismissFormerConversation(ctx context.Context, uuidUser string, idConversation int64) (err error) {
+	defer i.observe(ctx, "DismissFormerConversation")(&err)
+	return i.db.DismissFormerConversation(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) GetHistoryWindow(ctx context.Context, uuidUser string, idConversation int64) (v HistoryWindow, err error) {
+	defer i.observe(ctx, "GetHistoryWindow")(&err)
+	return i.db.GetHistoryWindow(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) CreateJoinRequest(ctx context.Context, uuidUser string, idConversation int64) (v JoinRequest, err error) {
+	defer i.observe(ctx, "CreateJoinRequest")(&err)
+	return i.db.CreateJoinRequest(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) GetJoinRequest(ctx context.Context, uuidUser string, idConversation int64) (v JoinRequest, err error) {
+	defer i.observe(ctx, "GetJoinRequest")(&err)
+	return i.db.GetJoinRequest(ctx, uuidUser, idConversation)
+}
+
+func (i instrumentedDB) GetPendingJoinRequests(ctx context.Context, idConversation int64) (v []JoinRequest, err error) {
+	defer i.observe(ctx, "GetPendingJoinRequests")(&err)
+	return i.db.GetPendingJoinRequests(ctx, idConversation)
+}
+
+func (i instrumentedDB) GetJoinRequestsByUser(ctx context.Context, uuidUser string) (v []JoinRequest, err error) {
+	defer i.observe(ctx, "GetJoinRequestsByUser")(&err)
+	return i.db.GetJoinRequestsByUser(ctx, uuidUser)
+}
+
+func (i instrumentedDB) ApproveJoinRequest(ctx context.Context, uuidUser string, idConversation int64, uuidDecidedBy string) (err error) {
+	defer i.observe(ctx, "ApproveJoinRequest")(&err)
+	return i.db.ApproveJoinRequest(ctx, uuidUser, idConversation, uuidDecidedBy)
+}
+
+func (i instrumentedDB) DenyJoinRequest(ctx context.Context, uuidUser string, idConversation int64, uuidDecidedBy string) (err error) {
+	defer i.observe(ctx, "DenyJoinRequest")(&err)
+	return i.db.DenyJoinRequest(ctx, uuidUser, idConversation, uuidDecidedBy)
+}
+
+func (i instrumentedDB) SetGroupInvite(ctx context.Context, idConversation int64, code string, uuidCreatedBy string) (v GroupInvite, err error) {
+	defer i.observe(ctx, "SetGroupInvite")(&err)
+	return i.db.SetGroupInvite(ctx, idConversation, code, uuidCreatedBy)
+}
+
+func (i instrumentedDB) GetGroupInvite(ctx context.Context, idConversation int64) (v GroupInvite, err error) {
+	defer i.observe(ctx, "GetGroupInvite")(&err)
+	return i.db.GetGroupInvite(ctx, idConversation)
+}
+
+func (i instrumentedDB) DeleteGroupInvite(ctx context.Context, idConversation int64) (err error) {
+	defer i.observe(ctx, "DeleteGroupInvite")(&err)
+	return i.db.DeleteGroupInvite(ctx, idConversation)
+}
+
+func (i instrumentedDB) Ping(ctx context.Context) (err error) {
+	defer i.observe(ctx, "Ping")(&err)
+	return i.db.Ping(ctx)
+}
+
+func (i instrumentedDB) SchemaVersion(ctx context.Context) (current int, latest int, err error) {
+	defer i.observe(ctx, "SchemaVersion")(&err)
+	return i.db.SchemaVersion(ctx)
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// Stati possibili di una richiesta di ingresso in un gruppo
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestDenied   = "denied"
+)
+
+type JoinRequest struct {
+	UUIDUser         string  `json:"uuidUser"`
+	IDConversation   int64   `json:"idConversation"`
+	Status           string  `json:"status"`
+	TimestampCreated string  `json:"timestampCreated"`
+	TimestampDecided *string `json:"timestampDecided"`
+	UUIDDecidedBy    *string `json:"uuidDecidedBy"`
+}
+
+// CreateJoinRequest registra una richiesta di ingresso in attesa. Una richiesta già rifiutata (o approvata, nel caso
+// l'utente abbia poi lasciato il gruppo) viene riaperta.
+func (db *appdbimpl) CreateJoinRequest(ctx context.Context, uuidUser string, idConversation int64) (JoinRequest, error) {
+	timestamp := now()
+	_, err := db.c.ExecContext(ctx, `
+		INSERT INTO joinRequest (uuidUser, idConversation, status, timestampCreated)
+		VALUES (?, ?, 'pending', ?)
+		ON CONFLICT(uuidUser, idConversation)
+		DO UPDATE SET status = 'pending', timestampCreated = excluded.timestampCreated,
+			timestampDecided = NULL, uuidDecidedBy = NULL;
+	`, uuidUser, idConversation, timestamp)
+	if err != nil {
+		return JoinRequest{}, classify(err)
+	}
+
+	return JoinRequest{
+		UUIDUser:         uuidUser,
+		IDConversation:   idConversation,
+		Status:           JoinRequestPending,
+		TimestampCreated: timestamp,
+	}, nil
+}
+
+func (db *appdbimpl) GetJoinRequest(ctx context.Context, uuidUser string, idConversation int64) (JoinRequest, error) {
+	var jr JoinRequest
+	err := db.c.QueryRowContext(ctx, `
+		SELECT uuidUser, idConversation, status, timestampCreated, timestampDecided, uuidDecidedBy
+		FROM joinRequest
+		WHERE uuidUser = ? AND idConversation = ?;
+	`, uuidUser, idConversation).Scan(
+		&jr.UUIDUser, &jr.IDConversation, &jr.Status, &jr.TimestampCreated, &jr.TimestampDecided, &jr.UUIDDecidedBy,
+	)
+	return jr, classify(err)
+}
+
+func (db *appdbimpl) GetPendingJoinRequests(ctx context.Context, idConversation int64) ([]JoinRequest, error) {
+	return db.queryJoinRequests(ctx, `
+		SELECT uuidUser, idConversation, status, timestampCreated, timestampDecided, uuidDecidedBy
+		FROM joinRequest
+		WHERE idConversation = ? AND status = 'pending'
+		ORDER BY timestampCreated ASC, uuidUser ASC;
+	`, idConversation)
+}
+
+func (db *appdbimpl) GetJoinRequestsByUser(ctx context.Context, uuidUser string) ([]JoinRequest, error) {
+	return db.queryJoinRequests(ctx, `
+		SELECT uuidUser, idConversation, status, timestampCreated, timestampDecided, uuidDecidedBy
+		FROM joinRequest
+		WHERE uuidUser = ?
+		ORDER BY timestampCreated DESC, idConversation DESC;
+	`, uuidUser)
+}
+
+func (db *appdbimpl) queryJoinRequests(ctx context.Context, query string, args ...interface{}) ([]JoinRequest, error) {
+	rows, err := db.c.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []JoinRequest
+	for rows.Next() {
+		var jr JoinRequest
+		err := rows.Scan(&jr.UUIDUser, &jr.IDConversation, &jr.Status, &jr.TimestampCreated, &jr.TimestampDecided, &jr.UUIDDecidedBy)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, jr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// ApproveJoinRequest chiude la richiesta come approvata e aggiunge il richiedente ai membri del gruppo nella stessa
+// transazione.
+func (db *appdbimpl) ApproveJoinRequest(ctx context.Context, uuidUser string, idConversation int64, uuidDecidedBy string) error {
+	timestamp := now()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("rollback fallito: %v", rErr)
+		}
+	}()
+
+	if err := decideJoinRequest(ctx, tx, uuidUser, idConversation, uuidDecidedBy, JoinRequestApproved, timestamp); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO member (uuidUser, idConversation, timestampJoined)
+		VALUES (?, ?, ?)
+		ON CONFLICT(uuidUser, idConversation) DO NOTHING;
+	`, uuidUser, idConversation, timestamp)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM formerMember
+		WHERE uuidUser = ? AND idConversation = ?;
+	`, uuidUser, idConversation)
+	if err != nil {
+		return err
+	}
+
+	err = insertSystemMessage(ctx, tx, idConversation, SystemEvent{
+		Kind:    EventMemberJoined,
+		Actor:   uuidDecidedBy,
+		Targets: []string{uuidUser},
+	}, timestamp)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *appdbimpl) DenyJoinRequest(ctx context.Context, uuidUser string, idConversation int64, uuidDecidedBy string) error {
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("rollback fallito: %v", rErr)
+		}
+	}()
+
+	timestamp := now()
+	if err := decideJoinRequest(ctx, tx, uuidUser, idConversation, uuidDecidedBy, JoinRequestDenied, timestamp); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// decideJoinRequest chiude una richiesta in attesa con l'esito `status`.
+func decideJoinRequest(ctx context.Context, tx querier, uuidUser string, idConversation int64, uuidDecidedBy string, status string, timestamp string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE joinRequest
+		SET status = ?, timestampDecided = ?, uuidDecidedBy = ?
+		WHERE uuidUser = ? AND idConversation = ? AND status = 'pending';
+	`, status, timestamp, uuidDecidedBy, uuidUser, idConversation)
+	if err != nil {
+		return err
+	}
+	af, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if af == 0 {
+		return newError(ErrNotFound, "nessuna richiesta in attesa")
+	}
+	return nil
+}
@@ -1,35 +1,204 @@
 package database
 
 import (
-	"time"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
 )
 
 type Member struct {
 	UUIDUser        string
 	IDConversation  int64
 	TimestampJoined string
+	IsAdmin         bool
 }
 
-func (db *appdbimpl) AddMember(uuidUser string, idConversation int64) error {
-	timestamp := time.Now().Format(time.RFC3339)
-	_, err := db.c.Exec(`
+func (db *appdbimpl) AddMember(ctx context.Context, uuidUser string, idConversation int64) error {
+	timestamp := now()
+	_, err := db.c.ExecContext(ctx, `
 		INSERT INTO member(uuidUser, idConversation, timestampJoined)
 		VALUES (?, ?, ?);
 	`, uuidUser, idConversation, timestamp)
+	if err != nil {
+		return classify(err)
+	}
+
+	// Chi rientra nel gruppo non è più un ex membro
+	_, err = db.c.ExecContext(ctx, `
+		DELETE FROM formerMember
+		WHERE uuidUser = ? AND idConversation = ?;
+	`, uuidUser, idConversation)
 	return err
 }
 
-func (db *appdbimpl) RemoveMember(uuidUser string, idConversation int64) error {
-	_, err := db.c.Exec(`
+// AddMembers aggiunge più utenti a un gruppo su iniziativa di `uuidActor` e registra l'evento come messaggio di
+// sistema, tutto nella stessa transazione.
+func (db *appdbimpl) AddMembers(ctx context.Context, idConversation int64, uuids []string, uuidActor string) error {
+	timestamp := now()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("rollback fallito: %v", rErr)
+		}
+	}()
+
+	for _, uuid := range uuids {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO member(uuidUser, idConversation, timestampJoined)
+			VALUES (?, ?, ?);
+		`, uuid, idConversation, timestamp)
+		if err != nil {
+			return classify(err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM formerMember
+			WHERE uuidUser = ? AND idConversation = ?;
+		`, uuid, idConversation)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertSystemMessage(ctx, tx, idConversation, SystemEvent{
+		Kind:    EventMemberAdded,
+		Actor:   uuidActor,
+		Targets: uuids,
+	}, timestamp)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember fa uscire `uuidUser` dal gruppo e registra l'uscita come messaggio di sistema. Come chi viene rimosso,
+// l'utente resta un ex membro e può leggere la cronologia fino al momento dell'uscita.
+func (db *appdbimpl) RemoveMember(ctx context.Context, uuidUser string, idConversation int64) error {
+	timestamp := now()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("rollback fallito: %v", rErr)
+		}
+	}()
+
+	if err := moveToFormerMembers(ctx, tx, uuidUser, idConversation, &uuidUser, timestamp); err != nil {
+		return err
+	}
+
+	err = insertSystemMessage(ctx, tx, idConversation, SystemEvent{
+		Kind:  EventMemberLeft,
+		Actor: uuidUser,
+	}, timestamp)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureAdmin(ctx, tx, idConversation); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// KickMember rimuove `uuidUser` dal gruppo su iniziativa di `uuidRemovedBy`. Nella stessa transazione l'utente viene
+// registrato come ex membro (così da poter ancora leggere la cronologia fino al momento della rimozione) e viene
+// inserito un messaggio di sistema nella conversazione.
+func (db *appdbimpl) KickMember(ctx context.Context, uuidUser string, idConversation int64, uuidRemovedBy string) error {
+	timestamp := now()
+
+	tx, err := db.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("rollback fallito: %v", rErr)
+		}
+	}()
+
+	if err := moveToFormerMembers(ctx, tx, uuidUser, idConversation, &uuidRemovedBy, timestamp); err != nil {
+		return err
+	}
+
+	err = insertSystemMessage(ctx, tx, idConversation, SystemEvent{
+		Kind:    EventMemberRemoved,
+		Actor:   uuidRemovedBy,
+		Targets: []string{uuidUser},
+	}, timestamp)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureAdmin(ctx, tx, idConversation); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// moveToFormerMembers toglie `uuidUser` dai membri del gruppo e lo registra come ex membro, uscito al momento
+// `timestamp` per mano di `uuidRemovedBy` (l'utente stesso se è uscito, nil se non è stato un membro del gruppo).
+// Ritorna ErrNotFound se l'utente non è membro.
+func moveToFormerMembers(ctx context.Context, tx querier, uuidUser string, idConversation int64, uuidRemovedBy *string, timestamp string) error {
+	var joinedAt string
+	err := tx.QueryRowContext(ctx, `
+		SELECT timestampJoined
+		FROM member
+		WHERE uuidUser = ? AND idConversation = ?;
+	`, uuidUser, idConversation).Scan(&joinedAt)
+	if err != nil {
+		return classify(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		DELETE FROM member
 		WHERE uuidUser = ? AND idConversation = ?;
 	`, uuidUser, idConversation)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO formerMember (uuidUser, idConversation, timestampJoined, timestampRemoved, uuidRemovedBy)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(uuidUser, idConversation)
+		DO UPDATE SET timestampJoined = excluded.timestampJoined, timestampRemoved = excluded.timestampRemoved,
+			uuidRemovedBy = excluded.uuidRemovedBy;
+	`, uuidUser, idConversation, joinedAt, timestamp, uuidRemovedBy)
+	return err
+}
+
+// ensureAdmin promuove ad amministratore il membro più anziano di un gruppo rimasto senza amministratori (a parità di
+// data di ingresso, quello con l'UUID minore).
+func ensureAdmin(ctx context.Context, tx querier, idConversation int64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE member SET isAdmin = TRUE
+		WHERE idConversation = ?
+		  AND idConversation IN (SELECT id FROM conversation WHERE isDirect = FALSE)
+		  AND NOT EXISTS (SELECT 1 FROM member m2 WHERE m2.idConversation = member.idConversation AND m2.isAdmin = TRUE)
+		  AND uuidUser = (
+		    SELECT m3.uuidUser FROM member m3 WHERE m3.idConversation = member.idConversation
+		    ORDER BY m3.timestampJoined ASC, m3.uuidUser ASC
+		    LIMIT 1
+		  );
+	`, idConversation)
 	return err
 }
 
-func (db *appdbimpl) IsMember(uuidUser string, idConversation int64) (bool, error) {
+func (db *appdbimpl) IsMember(ctx context.Context, uuidUser string, idConversation int64) (bool, error) {
 	var count int
-	err := db.c.QueryRow(`
+	err := db.c.QueryRowContext(ctx, `
 		SELECT COUNT(*)
 		FROM member
 		WHERE uuidUser = ? AND idConversation = ?;
@@ -37,8 +206,21 @@ func (db *appdbimpl) IsMember(uuidUser string, idConversation int64) (bool, erro
 	return count > 0, err
 }
 
-func (db *appdbimpl) GetMembersByConversation(idConversation int64) ([]string, error) {
-	rows, err := db.c.Query(`
+func (db *appdbimpl) IsAdmin(ctx context.Context, uuidUser string, idConversation int64) (bool, error) {
+	var isAdmin bool
+	err := db.c.QueryRowContext(ctx, `
+		SELECT isAdmin
+		FROM member
+		WHERE uuidUser = ? AND idConversation = ?;
+	`, uuidUser, idConversation).Scan(&isAdmin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return isAdmin, err
+}
+
+func (db *appdbimpl) GetMembersByConversation(ctx context.Context, idConversation int64) ([]string, error) {
+	rows, err := db.c.QueryContext(ctx, `
 		SELECT uuidUser
 		FROM member
 		WHERE idConversation = ?;
@@ -62,12 +244,43 @@ func (db *appdbimpl) GetMembersByConversation(idConversation int64) ([]string, e
 	return members, nil
 }
 
-func (db *appdbimpl) GetJoinedAt(uuidUser string, idConversation int64) (string, error) {
+func (db *appdbimpl) GetJoinedAt(ctx context.Context, uuidUser string, idConversation int64) (string, error) {
 	var timestamp string
-	err := db.c.QueryRow(`
+	err := db.c.QueryRowContext(ctx, `
 		SELECT timestampJoined
 		FROM member
 		WHERE uuidUser = ? AND idConversation = ?;
 	`, uuidUser, idConversation).Scan(&timestamp)
-	return timestamp, err
+	return timestamp, classify(err)
+}
+
+// GetRemovedAt restituisce il momento in cui un ex membro è uscito o è stato rimosso dal gruppo.
+func (db *appdbimpl) GetRemovedAt(ctx context.Context, uuidUser string, idConversation int64) (string, error) {
+	var timestamp string
+	err := db.c.QueryRowContext(ctx, `
+		SELECT timestampRemoved
+		FROM formerMember
+		WHERE uuidUser = ? AND idConversation = ?;
+	`, uuidUser, idConversation).Scan(&timestamp)
+	return timestamp, classify(err)
+}
+
+// DismissFormerConversation toglie il gruppo dalla lista dell'ex membro, che perde anche l'accesso alla cronologia.
+// Ritorna ErrNotFound se l'utente non è un ex membro del gruppo.
+func (db *appdbimpl) DismissFormerConversation(ctx context.Context, uuidUser string, idConversation int64) error {
+	res, err := db.c.ExecContext(ctx, `
+		DELETE FROM formerMember
+		WHERE uuidUser = ? AND idConversation = ?;
+	`, uuidUser, idConversation)
+	if err != nil {
+		return err
+	}
+	af, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if af == 0 {
+		return newError(ErrNotFound, "l'utente non è un ex membro della conversazione")
+	}
+	return nil
 }
@@ -0,0 +1,302 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+func (db *memdb) CreateDirectConversation(ctx context.Context, uuid1, uuid2 string) (database.Conversation, error) {
+	var conv database.Conversation
+	err := db.write(ctx, func(s *state) error {
+		for _, uuid := range []string{uuid1, uuid2} {
+			if err := s.requireUser(uuid); err != nil {
+				return err
+			}
+		}
+		if uuid1 == uuid2 {
+			return newError(database.ErrConflict, "membro duplicato")
+		}
+
+		now := database.Timestamp(time.Now())
+		conv = s.insertConversation(database.Conversation{IsDirect: true, TimestampCreated: now, TimestampLastMessage: now})
+		s.insertMember(uuid1, conv.ID, now, false)
+		s.insertMember(uuid2, conv.ID, now, false)
+		return nil
+	})
+	return conv, err
+}
+
+// CreateGroupConversation crea il gruppo con il creatore come amministratore e gli altri `members` come membri: se un
+// membro non esiste il gruppo non viene creato.
+func (db *memdb) CreateGroupConversation(ctx context.Context, creatorUUID string, groupName, groupPhoto *string, members []string) (database.Conversation, error) {
+	var conv database.Conversation
+	err := db.write(ctx, func(s *state) error {
+		if err := s.requireUser(creatorUUID); err != nil {
+			return err
+		}
+		for _, uuid := range members {
+			if err := s.requireUser(uuid); err != nil {
+				return err
+			}
+		}
+
+		now := database.Timestamp(time.Now())
+		conv = s.insertConversation(database.Conversation{
+			GroupName:            groupName,
+			GroupPhoto:           groupPhoto,
+			TimestampCreated:     now,
+			TimestampLastMessage: now,
+		})
+		s.insertMember(creatorUUID, conv.ID, now, true)
+		for _, uuid := range members {
+			if _, ok := s.members[memberKey{uuid, conv.ID}]; !ok {
+				s.insertMember(uuid, conv.ID, now, false)
+			}
+		}
+		return nil
+	})
+	return conv, err
+}
+
+func (db *memdb) GetConversationsByUser(ctx context.Context, uuid string) ([]database.Conversation, error) {
+	var conversations []database.Conversation
+	err := db.read(ctx, func(s *state) error {
+		for key := range s.members {
+			if key.uuidUser == uuid {
+				conversations = append(conversations, s.conversations[key.idConversation])
+			}
+		}
+		sort.Slice(conversations, func(i, j int) bool {
+			a, b := conversations[i], conversations[j]
+			if a.TimestampLastMessage != b.TimestampLastMessage {
+				return a.TimestampLastMessage > b.TimestampLastMessage
+			}
+			return a.ID > b.ID
+		})
+		return nil
+	})
+	return conversations, err
+}
+
+// GetFormerConversationsByUser restituisce i gruppi da cui l'utente è uscito o è stato rimosso, dal più recente.
+func (db *memdb) GetFormerConversationsByUser(ctx context.Context, uuid string) ([]database.Conversation, error) {
+	var conversations []database.Conversation
+	removedAt := make(map[int64]string)
+	err := db.read(ctx, func(s *state) error {
+		for key, f := range s.formerMembers {
+			if key.uuidUser == uuid {
+				conversations = append(conversations, s.conversations[key.idConversation])
+				removedAt[key.idConversation] = f.timestampRemoved
+			}
+		}
+		sort.Slice(conversations, func(i, j int) bool {
+			a, b := conversations[i], conversations[j]
+			if removedAt[a.ID] != removedAt[b.ID] {
+				return removedAt[a.ID] > removedAt[b.ID]
+			}
+			return a.ID > b.ID
+		})
+		return nil
+	})
+	return conversations, err
+}
+
+func (db *memdb) GetLastMessageByConversation(ctx context.Context, id int64) (database.Message, error) {
+	var msg database.Message
+	err := db.read(ctx, func(s *state) error {
+		last, ok := s.lastMessage(id)
+		if !ok {
+			return newError(database.ErrNotFound, "nessun messaggio")
+		}
+		// Come l'implementazione SQL, non restituisce l'inoltro né l'evento di sistema
+		last.IDForwardedFrom = nil
+		last.System = nil
+		msg = last
+		return nil
+	})
+	return msg, err
+}
+
+func (db *memdb) GetDirectConversationBetween(ctx context.Context, uuid1, uuid2 string) (database.Conversation, error) {
+	var conv database.Conversation
+	err := db.read(ctx, func(s *state) error {
+		var found []database.Conversation
+		for key := range s.members {
+			if key.uuidUser != uuid1 {
+				continue
+			}
+			c := s.conversations[key.idConversation]
+			if _, ok := s.members[memberKey{uuid2, c.ID}]; ok && c.IsDirect {
+				found = append(found, c)
+			}
+		}
+		if len(found) == 0 {
+			return newError(database.ErrNotFound, "conversazione diretta inesistente")
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+		conv = found[0]
+		return nil
+	})
+	return conv, err
+}
+
+func (db *memdb) DeleteConversationIfEmpty(ctx context.Context, id int64) error {
+	return db.write(ctx, func(s *state) error {
+		for key := range s.members {
+			if key.idConversation == id {
+				return nil
+			}
+		}
+		s.deleteConversation(id)
+		return nil
+	})
+}
+
+func (db *memdb) GetConversationByID(ctx context.Context, id int64) (database.Conversation, error) {
+	var conv database.Conversation
+	err := db.read(ctx, func(s *state) error {
+		c, ok := s.conversations[id]
+		if !ok {
+			return newError(database.ErrNotFound, "conversazione inesistente")
+		}
+		conv = c
+		return nil
+	})
+	return conv, err
+}
+
+// SetGroupName rinomina il gruppo e registra la modifica (con il nome precedente) come messaggio di sistema.
+func (db *memdb) SetGroupName(ctx context.Context, id int64, newName string, uuidActor string) error {
+	return db.updateGroupField(ctx, id, database.EventGroupRenamed, newName, uuidActor)
+}
+
+// SetGroupPhoto cambia la foto del gruppo e registra la modifica come messaggio di sistema.
+func (db *memdb) SetGroupPhoto(ctx context.Context, id int64, newPhoto string, uuidActor string) error {
+	return db.updateGroupField(ctx, id, database.EventGroupPhotoChanged, newPhoto, uuidActor)
+}
+
+// updateGroupField aggiorna il nome (EventGroupRenamed) o la foto (EventGroupPhotoChanged) di un gruppo e registra il
+// messaggio di sistema `kind` con il valore precedente e quello nuovo.
+func (db *memdb) updateGroupField(ctx context.Context, id int64, kind string, newValue string, uuidActor string) error {
+	return db.write(ctx, func(s *state) error {
+		conv, err := s.requireGroup(id, "impossibile modificare nome/foto: conversazione diretta")
+		if err != nil {
+			return err
+		}
+
+		field := &conv.GroupName
+		if kind == database.EventGroupPhotoChanged {
+			field = &conv.GroupPhoto
+		}
+		ev := database.SystemEvent{Kind: kind, Actor: uuidActor, OldValue: *field, NewValue: &newValue}
+		content, err := s.describe(ev)
+		if err != nil {
+			return err
+		}
+
+		*field = &newValue
+		s.conversations[id] = conv
+		s.insertSystemMessage(id, ev, content, database.Timestamp(time.Now()))
+		return nil
+	})
+}
+
+func (db *memdb) SetGroupRequiresApproval(ctx context.Context, id int64, requiresApproval bool) error {
+	return db.write(ctx, func(s *state) error {
+		conv, err := s.requireGroup(id, "impossibile richiedere approvazione: conversazione diretta")
+		if err != nil {
+			return err
+		}
+		conv.RequiresApproval = requiresApproval
+		s.conversations[id] = conv
+		return nil
+	})
+}
+
+func (db *memdb) SetGroupHistoryVisibility(ctx context.Context, id int64, visibility string) error {
+	return db.write(ctx, func(s *state) error {
+		conv, err := s.requireGroup(id, "impossibile modificare la visibilità: conversazione diretta")
+		if err != nil {
+			return err
+		}
+		if visibility != database.HistoryFull && visibility != database.HistorySinceJoin {
+			return fmt.Errorf("visibilità della cronologia non valida: %s", visibility)
+		}
+		conv.HistoryVisibility = visibility
+		s.conversations[id] = conv
+		return nil
+	})
+}
+
+// SearchGroupsByName restituisce i gruppi che accettano richieste di ingresso il cui nome inizia con `prefix`.
+func (db *memdb) SearchGroupsByName(ctx context.Context, prefix string) ([]database.Conversation, error) {
+	var groups []database.Conversation
+	err := db.read(ctx, func(s *state) error {
+		for _, c := range s.conversations {
+			if !c.IsDirect && c.RequiresApproval && c.GroupName != nil && like(*c.GroupName, prefix+"%", false) {
+				groups = append(groups, c)
+			}
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			a, b := groups[i], groups[j]
+			if *a.GroupName != *b.GroupName {
+				return *a.GroupName < *b.GroupName
+			}
+			return a.ID < b.ID
+		})
+		return nil
+	})
+	return groups, err
+}
+
+// insertConversation salva conv assegnandole un nuovo ID
+func (s *state) insertConversation(conv database.Conversation) database.Conversation {
+	conv.ID = s.nextConvID
+	s.nextConvID++
+	conv.HistoryVisibility = database.HistoryFull
+	s.conversations[conv.ID] = conv
+	return conv
+}
+
+// requireGroup restituisce la conversazione `id`, oppure ErrNotFound se non esiste ed ErrForbidden (con il messaggio
+// `directMsg`) se è una chat diretta.
+func (s *state) requireGroup(id int64, directMsg string) (database.Conversation, error) {
+	conv, ok := s.conversations[id]
+	if !ok {
+		return conv, newError(database.ErrNotFound, "conversazione inesistente")
+	}
+	if conv.IsDirect {
+		return conv, newError(database.ErrForbidden, directMsg)
+	}
+	return conv, nil
+}
+
+// deleteConversation elimina la conversazione con i dati collegati, come ON DELETE CASCADE
+func (s *state) deleteConversation(id int64) {
+	delete(s.conversations, id)
+	for key := range s.members {
+		if key.idConversation == id {
+			delete(s.members, key)
+		}
+	}
+	for key := range s.formerMembers {
+		if key.idConversation == id {
+			delete(s.formerMembers, key)
+		}
+	}
+	for key := range s.joinRequests {
+		if key.idConversation == id {
+			delete(s.joinRequests, key)
+		}
+	}
+	delete(s.invites, id)
+	for msgID, msg := range s.messages {
+		if msg.IDConversation == id {
+			s.deleteMessage(msgID)
+		}
+	}
+}
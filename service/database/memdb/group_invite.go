@@ -0,0 +1,58 @@
+package memdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// SetGroupInvite imposta il codice di invito del gruppo, sostituendo (e quindi invalidando) quello precedente.
+func (db *memdb) SetGroupInvite(ctx context.Context, idConversation int64, code string, uuidCreatedBy string) (database.GroupInvite, error) {
+	var invite database.GroupInvite
+	err := db.write(ctx, func(s *state) error {
+		if _, err := s.requireGroup(idConversation, "impossibile creare un invito: conversazione diretta"); err != nil {
+			return err
+		}
+		if err := s.requireUser(uuidCreatedBy); err != nil {
+			return err
+		}
+		for id, other := range s.invites {
+			if id != idConversation && other.Code == code {
+				return newError(database.ErrConflict, "codice di invito già in uso")
+			}
+		}
+		invite = database.GroupInvite{
+			IDConversation:   idConversation,
+			Code:             code,
+			UUIDCreatedBy:    &uuidCreatedBy,
+			TimestampCreated: database.Timestamp(time.Now()),
+		}
+		s.invites[idConversation] = invite
+		return nil
+	})
+	return invite, err
+}
+
+func (db *memdb) GetGroupInvite(ctx context.Context, idConversation int64) (database.GroupInvite, error) {
+	var invite database.GroupInvite
+	err := db.read(ctx, func(s *state) error {
+		i, ok := s.invites[idConversation]
+		if !ok {
+			return newError(database.ErrNotFound, "nessun invito")
+		}
+		invite = i
+		return nil
+	})
+	return invite, err
+}
+
+func (db *memdb) DeleteGroupInvite(ctx context.Context, idConversation int64) error {
+	return db.write(ctx, func(s *state) error {
+		if _, ok := s.invites[idConversation]; !ok {
+			return newError(database.ErrNotFound, "nessun invito")
+		}
+		delete(s.invites, idConversation)
+		return nil
+	})
+}
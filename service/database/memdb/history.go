@@ -0,0 +1,38 @@
+package memdb
+
+import (
+	"context"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// GetHistoryWindow calcola quali messaggi della conversazione può vedere l'utente, in base alla politica del gruppo, al
+// momento del suo ingresso e, per gli ex membri, al momento della rimozione. Se l'utente non è né membro né ex membro
+// ritorna ErrNotFound.
+func (db *memdb) GetHistoryWindow(ctx context.Context, uuidUser string, idConversation int64) (database.HistoryWindow, error) {
+	var w database.HistoryWindow
+	err := db.read(ctx, func(s *state) error {
+		conv, ok := s.conversations[idConversation]
+		if !ok {
+			return newError(database.ErrNotFound, "conversazione inesistente")
+		}
+
+		key := memberKey{uuidUser, idConversation}
+		joinedAt := ""
+		if m, ok := s.members[key]; ok {
+			joinedAt = m.TimestampJoined
+		} else if f, ok := s.formerMembers[key]; ok {
+			// Ex membro: la cronologia si ferma al momento della rimozione
+			joinedAt = f.timestampJoined
+			w.Until = f.timestampRemoved
+		} else {
+			return newError(database.ErrNotFound, "l'utente non è né membro né ex membro della conversazione")
+		}
+
+		if conv.HistoryVisibility == database.HistorySinceJoin {
+			w.Since = joinedAt
+		}
+		return nil
+	})
+	return w, err
+}
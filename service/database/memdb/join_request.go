@@ -0,0 +1,144 @@
+package memdb
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// CreateJoinRequest registra una richiesta di ingresso in attesa. Una richiesta già rifiutata (o approvata, nel caso
+// l'utente abbia poi lasciato il gruppo) viene riaperta.
+func (db *memdb) CreateJoinRequest(ctx context.Context, uuidUser string, idConversation int64) (database.JoinRequest, error) {
+	var jr database.JoinRequest
+	err := db.write(ctx, func(s *state) error {
+		if err := s.requireUser(uuidUser); err != nil {
+			return err
+		}
+		if err := s.requireConversation(idConversation); err != nil {
+			return err
+		}
+		jr = database.JoinRequest{
+			UUIDUser:         uuidUser,
+			IDConversation:   idConversation,
+			Status:           database.JoinRequestPending,
+			TimestampCreated: database.Timestamp(time.Now()),
+		}
+		s.joinRequests[memberKey{uuidUser, idConversation}] = jr
+		return nil
+	})
+	return jr, err
+}
+
+func (db *memdb) GetJoinRequest(ctx context.Context, uuidUser string, idConversation int64) (database.JoinRequest, error) {
+	var jr database.JoinRequest
+	err := db.read(ctx, func(s *state) error {
+		r, ok := s.joinRequests[memberKey{uuidUser, idConversation}]
+		if !ok {
+			return newError(database.ErrNotFound, "richiesta inesistente")
+		}
+		jr = r
+		return nil
+	})
+	return jr, err
+}
+
+// GetPendingJoinRequests restituisce le richieste in attesa per il gruppo, dalla più vecchia.
+func (db *memdb) GetPendingJoinRequests(ctx context.Context, idConversation int64) ([]database.JoinRequest, error) {
+	var requests []database.JoinRequest
+	err := db.read(ctx, func(s *state) error {
+		for _, jr := range s.joinRequests {
+			if jr.IDConversation == idConversation && jr.Status == database.JoinRequestPending {
+				requests = append(requests, jr)
+			}
+		}
+		sort.Slice(requests, func(i, j int) bool {
+			a, b := requests[i], requests[j]
+			if a.TimestampCreated != b.TimestampCreated {
+				return a.TimestampCreated < b.TimestampCreated
+			}
+			return a.UUIDUser < b.UUIDUser
+		})
+		return nil
+	})
+	return requests, err
+}
+
+// GetJoinRequestsByUser restituisce tutte le richieste dell'utente, dalla più recente.
+func (db *memdb) GetJoinRequestsByUser(ctx context.Context, uuidUser string) ([]database.JoinRequest, error) {
+	var requests []database.JoinRequest
+	err := db.read(ctx, func(s *state) error {
+		for _, jr := range s.joinRequests {
+			if jr.UUIDUser == uuidUser {
+				requests = append(requests, jr)
+			}
+		}
+		sort.Slice(requests, func(i, j int) bool {
+			a, b := requests[i], requests[j]
+			if a.TimestampCreated != b.TimestampCreated {
+				return a.TimestampCreated > b.TimestampCreated
+			}
+			return a.IDConversation > b.IDConversation
+		})
+		return nil
+	})
+	return requests, err
+}
+
+// ApproveJoinRequest chiude la richiesta come approvata e aggiunge il richiedente ai membri del gruppo.
+func (db *memdb) ApproveJoinRequest(ctx context.Context, uuidUser string, idConversation int64, uuidDecidedBy string) error {
+	return db.write(ctx, func(s *state) error {
+		key := memberKey{uuidUser, idConversation}
+		jr, err := s.pendingJoinRequest(key, uuidDecidedBy)
+		if err != nil {
+			return err
+		}
+		ev := database.SystemEvent{
+			Kind:    database.EventMemberJoined,
+			Actor:   uuidDecidedBy,
+			Targets: []string{uuidUser},
+		}
+		content, err := s.describe(ev)
+		if err != nil {
+			return err
+		}
+
+		timestamp := database.Timestamp(time.Now())
+		s.decide(jr, database.JoinRequestApproved, uuidDecidedBy, timestamp)
+		if _, ok := s.members[key]; !ok {
+			s.insertMember(uuidUser, idConversation, timestamp, false)
+		}
+		delete(s.formerMembers, key)
+		s.insertSystemMessage(idConversation, ev, content, timestamp)
+		return nil
+	})
+}
+
+func (db *memdb) DenyJoinRequest(ctx context.Context, uuidUser string, idConversation int64, uuidDecidedBy string) error {
+	return db.write(ctx, func(s *state) error {
+		jr, err := s.pendingJoinRequest(memberKey{uuidUser, idConversation}, uuidDecidedBy)
+		if err != nil {
+			return err
+		}
+		s.decide(jr, database.JoinRequestDenied, uuidDecidedBy, database.Timestamp(time.Now()))
+		return nil
+	})
+}
+
+// pendingJoinRequest restituisce la richiesta in attesa che `uuidDecidedBy` sta per decidere
+func (s *state) pendingJoinRequest(key memberKey, uuidDecidedBy string) (database.JoinRequest, error) {
+	jr, ok := s.joinRequests[key]
+	if !ok || jr.Status != database.JoinRequestPending {
+		return jr, newError(database.ErrNotFound, "nessuna richiesta in attesa")
+	}
+	return jr, s.requireUser(uuidDecidedBy)
+}
+
+// decide chiude la richiesta con l'esito `status`
+func (s *state) decide(jr database.JoinRequest, status string, uuidDecidedBy string, timestamp string) {
+	jr.Status = status
+	jr.TimestampDecided = &timestamp
+	jr.UUIDDecidedBy = &uuidDecidedBy
+	s.joinRequests[memberKey{jr.UUIDUser, jr.IDConversation}] = jr
+}
@@ -0,0 +1,248 @@
+package memdb
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+func (db *memdb) AddMember(ctx context.Context, uuidUser string, idConversation int64) error {
+	return db.write(ctx, func(s *state) error {
+		if err := s.checkNewMember(uuidUser, idConversation); err != nil {
+			return err
+		}
+		s.insertMember(uuidUser, idConversation, database.Timestamp(time.Now()), false)
+
+		// Chi rientra nel gruppo non è più un ex membro
+		delete(s.formerMembers, memberKey{uuidUser, idConversation})
+		return nil
+	})
+}
+
+// AddMembers aggiunge più utenti a un gruppo su iniziativa di `uuidActor` e registra l'evento come messaggio di
+// sistema. Se un utente non può essere aggiunto non viene aggiunto nessuno.
+func (db *memdb) AddMembers(ctx context.Context, idConversation int64, uuids []string, uuidActor string) error {
+	return db.write(ctx, func(s *state) error {
+		seen := make(map[string]bool, len(uuids))
+		for _, uuid := range uuids {
+			if err := s.checkNewMember(uuid, idConversation); err != nil {
+				return err
+			}
+			if seen[uuid] {
+				return newError(database.ErrConflict, "utente già membro")
+			}
+			seen[uuid] = true
+		}
+		ev := database.SystemEvent{
+			Kind:    database.EventMemberAdded,
+			Actor:   uuidActor,
+			Targets: append([]string(nil), uuids...),
+		}
+		content, err := s.describe(ev)
+		if err != nil {
+			return err
+		}
+
+		timestamp := database.Timestamp(time.Now())
+		for _, uuid := range uuids {
+			s.insertMember(uuid, idConversation, timestamp, false)
+			delete(s.formerMembers, memberKey{uuid, idConversation})
+		}
+		s.insertSystemMessage(idConversation, ev, content, timestamp)
+		return nil
+	})
+}
+
+// RemoveMember fa uscire `uuidUser` dal gruppo, registrandolo come ex membro, e registra l'uscita come messaggio di
+// sistema.
+func (db *memdb) RemoveMember(ctx context.Context, uuidUser string, idConversation int64) error {
+	return db.write(ctx, func(s *state) error {
+		key := memberKey{uuidUser, idConversation}
+		m, ok := s.members[key]
+		if !ok {
+			return newError(database.ErrNotFound, "l'utente non è membro della conversazione")
+		}
+		ev := database.SystemEvent{Kind: database.EventMemberLeft, Actor: uuidUser}
+		content, err := s.describe(ev)
+		if err != nil {
+			return err
+		}
+
+		timestamp := database.Timestamp(time.Now())
+		delete(s.members, key)
+		s.formerMembers[key] = formerMemberRow{
+			timestampJoined:  m.TimestampJoined,
+			timestampRemoved: timestamp,
+			uuidRemovedBy:    &uuidUser,
+		}
+		s.insertSystemMessage(idConversation, ev, content, timestamp)
+		s.ensureAdmin(idConversation)
+		return nil
+	})
+}
+
+// KickMember rimuove `uuidUser` dal gruppo su iniziativa di `uuidRemovedBy`, registrandolo come ex membro e inserendo
+// un messaggio di sistema nella conversazione.
+func (db *memdb) KickMember(ctx context.Context, uuidUser string, idConversation int64, uuidRemovedBy string) error {
+	return db.write(ctx, func(s *state) error {
+		key := memberKey{uuidUser, idConversation}
+		m, ok := s.members[key]
+		if !ok {
+			return newError(database.ErrNotFound, "l'utente non è membro della conversazione")
+		}
+		ev := database.SystemEvent{
+			Kind:    database.EventMemberRemoved,
+			Actor:   uuidRemovedBy,
+			Targets: []string{uuidUser},
+		}
+		content, err := s.describe(ev)
+		if err != nil {
+			return err
+		}
+
+		timestamp := database.Timestamp(time.Now())
+		delete(s.members, key)
+		s.formerMembers[key] = formerMemberRow{
+			timestampJoined:  m.TimestampJoined,
+			timestampRemoved: timestamp,
+			uuidRemovedBy:    &uuidRemovedBy,
+		}
+		s.insertSystemMessage(idConversation, ev, content, timestamp)
+		s.ensureAdmin(idConversation)
+		return nil
+	})
+}
+
+func (db *memdb) IsMember(ctx context.Context, uuidUser string, idConversation int64) (bool, error) {
+	var member bool
+	err := db.read(ctx, func(s *state) error {
+		_, member = s.members[memberKey{uuidUser, idConversation}]
+		return nil
+	})
+	return member, err
+}
+
+func (db *memdb) IsAdmin(ctx context.Context, uuidUser string, idConversation int64) (bool, error) {
+	var admin bool
+	err := db.read(ctx, func(s *state) error {
+		admin = s.members[memberKey{uuidUser, idConversation}].IsAdmin
+		return nil
+	})
+	return admin, err
+}
+
+func (db *memdb) GetMembersByConversation(ctx context.Context, idConversation int64) ([]string, error) {
+	var members []string
+	err := db.read(ctx, func(s *state) error {
+		for _, m := range s.sortedMembers(idConversation) {
+			members = append(members, m.UUIDUser)
+		}
+		return nil
+	})
+	return members, err
+}
+
+func (db *memdb) GetJoinedAt(ctx context.Context, uuidUser string, idConversation int64) (string, error) {
+	var timestamp string
+	err := db.read(ctx, func(s *state) error {
+		m, ok := s.members[memberKey{uuidUser, idConversation}]
+		if !ok {
+			return newError(database.ErrNotFound, "l'utente non è membro della conversazione")
+		}
+		timestamp = m.TimestampJoined
+		return nil
+	})
+	return timestamp, err
+}
+
+// GetRemovedAt restituisce il momento in cui un ex membro è uscito o è stato rimosso dal gruppo.
+func (db *memdb) GetRemovedAt(ctx context.Context, uuidUser string, idConversation int64) (string, error) {
+	var timestamp string
+	err := db.read(ctx, func(s *state) error {
+		f, ok := s.formerMembers[memberKey{uuidUser, idConversation}]
+		if !ok {
+			return newError(database.ErrNotFound, "l'utente non è un ex membro della conversazione")
+		}
+		timestamp = f.timestampRemoved
+		return nil
+	})
+	return timestamp, err
+}
+
+// DismissFormerConversation toglie il gruppo dalla lista dell'ex membro, che perde anche l'accesso alla cronologia.
+func (db *memdb) DismissFormerConversation(ctx context.Context, uuidUser string, idConversation int64) error {
+	return db.write(ctx, func(s *state) error {
+		key := memberKey{uuidUser, idConversation}
+		if _, ok := s.formerMembers[key]; !ok {
+			return newError(database.ErrNotFound, "l'utente non è un ex membro della conversazione")
+		}
+		delete(s.formerMembers, key)
+		return nil
+	})
+}
+
+// checkNewMember controlla i vincoli per l'inserimento di un membro: utente e conversazione devono esistere e l'utente
+// non deve essere già membro.
+func (s *state) checkNewMember(uuidUser string, idConversation int64) error {
+	if err := s.requireUser(uuidUser); err != nil {
+		return err
+	}
+	if err := s.requireConversation(idConversation); err != nil {
+		return err
+	}
+	if _, ok := s.members[memberKey{uuidUser, idConversation}]; ok {
+		return newError(database.ErrConflict, "utente già membro")
+	}
+	return nil
+}
+
+func (s *state) insertMember(uuidUser string, idConversation int64, timestamp string, isAdmin bool) {
+	s.members[memberKey{uuidUser, idConversation}] = memberRow{
+		Member: database.Member{
+			UUIDUser:        uuidUser,
+			IDConversation:  idConversation,
+			TimestampJoined: timestamp,
+			IsAdmin:         isAdmin,
+		},
+		seq: s.nextSeq(),
+	}
+}
+
+// sortedMembers restituisce i membri della conversazione in ordine di inserimento
+func (s *state) sortedMembers(idConversation int64) []memberRow {
+	var members []memberRow
+	for key, m := range s.members {
+		if key.idConversation == idConversation {
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].seq < members[j].seq })
+	return members
+}
+
+// ensureAdmin promuove ad amministratore il membro più anziano di un gruppo rimasto senza amministratori (a parità di
+// data di ingresso, quello con l'UUID minore).
+func (s *state) ensureAdmin(idConversation int64) {
+	if conv, ok := s.conversations[idConversation]; !ok || conv.IsDirect {
+		return
+	}
+
+	members := s.sortedMembers(idConversation)
+	if len(members) == 0 {
+		return
+	}
+	oldest := members[0]
+	for _, m := range members {
+		if m.IsAdmin {
+			return
+		}
+		if m.TimestampJoined < oldest.TimestampJoined ||
+			(m.TimestampJoined == oldest.TimestampJoined && m.UUIDUser < oldest.UUIDUser) {
+			oldest = m
+		}
+	}
+	oldest.IsAdmin = true
+	s.members[memberKey{oldest.UUIDUser, idConversation}] = oldest
+}
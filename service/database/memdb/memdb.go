@@ -0,0 +1,287 @@
+/*
+Package memdb è un'implementazione di database.AppDatabase che tiene tutti i dati in memoria, pensata per i test e le
+demo: non richiede file, driver o schema e parte sempre vuota.
+
+Il comportamento è lo stesso delle implementazioni SQL del package database, comprese le categorie di errore
+(database.ErrNotFound, database.ErrForbidden, database.ErrConflict), i vincoli di FOREIGN KEY con le relative
+cancellazioni a cascata e l'ordinamento dei risultati. La suite del package dbtest verifica che le implementazioni
+restino allineate.
+
+Tutti i metodi possono essere chiamati da più goroutine: le letture condividono un RWMutex, le scritture e WithTx lo
+tengono in modo esclusivo.
+*/
+package memdb
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+type memberKey struct {
+	uuidUser       string
+	idConversation int64
+}
+
+type userMessageKey struct {
+	uuidUser  string
+	idMessage int64
+}
+
+// Le righe delle tabelle. seq è l'ordine di inserimento, usato dove le query SQL non specificano un ordinamento.
+type (
+	userRow struct {
+		database.User
+		seq int64
+	}
+
+	memberRow struct {
+		database.Member
+		seq int64
+	}
+
+	formerMemberRow struct {
+		timestampJoined  string
+		timestampRemoved string
+		uuidRemovedBy    *string
+	}
+
+	reactionRow struct {
+		emoji string
+		seq   int64
+	}
+
+	statusRow struct {
+		delivered bool
+		seen      bool
+		seq       int64
+	}
+
+	joinRequestRow = database.JoinRequest
+)
+
+// state contiene le tabelle. Le righe sono salvate per valore e i puntatori al loro interno non vengono mai modificati
+// sul posto, quindi una copia delle mappe basta per una fotografia consistente (vedi clone).
+type state struct {
+	seq           int64
+	nextConvID    int64
+	nextMessageID int64
+	name          *string
+	users         map[string]userRow
+	conversations map[int64]database.Conversation
+	members       map[memberKey]memberRow
+	formerMembers map[memberKey]formerMemberRow
+	messages      map[int64]database.Message
+	reactions     map[userMessageKey]reactionRow
+	statuses      map[userMessageKey]statusRow
+	joinRequests  map[memberKey]joinRequestRow
+	invites       map[int64]database.GroupInvite
+}
+
+func newState() *state {
+	return &state{
+		nextConvID:    1,
+		nextMessageID: 1,
+		users:         make(map[string]userRow),
+		conversations: make(map[int64]database.Conversation),
+		members:       make(map[memberKey]memberRow),
+		formerMembers: make(map[memberKey]formerMemberRow),
+		messages:      make(map[int64]database.Message),
+		reactions:     make(map[userMessageKey]reactionRow),
+		statuses:      make(map[userMessageKey]statusRow),
+		joinRequests:  make(map[memberKey]joinRequestRow),
+		invites:       make(map[int64]database.GroupInvite),
+	}
+}
+
+// clone restituisce una copia di s che non condivide mappe con l'originale
+func (s *state) clone() *state {
+	c := *s
+	c.users = make(map[string]userRow, len(s.users))
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	c.conversations = make(map[int64]database.Conversation, len(s.conversations))
+	for k, v := range s.conversations {
+		c.conversations[k] = v
+	}
+	c.members = make(map[memberKey]memberRow, len(s.members))
+	for k, v := range s.members {
+		c.members[k] = v
+	}
+	c.formerMembers = make(map[memberKey]formerMemberRow, len(s.formerMembers))
+	for k, v := range s.formerMembers {
+		c.formerMembers[k] = v
+	}
+	c.messages = make(map[int64]database.Message, len(s.messages))
+	for k, v := range s.messages {
+		c.messages[k] = v
+	}
+	c.reactions = make(map[userMessageKey]reactionRow, len(s.reactions))
+	for k, v := range s.reactions {
+		c.reactions[k] = v
+	}
+	c.statuses = make(map[userMessageKey]statusRow, len(s.statuses))
+	for k, v := range s.statuses {
+		c.statuses[k] = v
+	}
+	c.joinRequests = make(map[memberKey]joinRequestRow, len(s.joinRequests))
+	for k, v := range s.joinRequests {
+		c.joinRequests[k] = v
+	}
+	c.invites = make(map[int64]database.GroupInvite, len(s.invites))
+	for k, v := range s.invites {
+		c.invites[k] = v
+	}
+	return &c
+}
+
+// nextSeq restituisce il prossimo numero d'ordine di inserimento
+func (s *state) nextSeq() int64 {
+	s.seq++
+	return s.seq
+}
+
+type memdb struct {
+	// mu protegge st. È condiviso dal database e dai `tx` passati a WithTx.
+	mu *sync.RWMutex
+
+	// st punta allo stato corrente: WithTx lo sostituisce con la fotografia iniziale se la transazione fallisce
+	st **state
+
+	// inTx indica che questa istanza è il `tx` di WithTx: il lock è già tenuto da WithTx e i metodi non lo prendono
+	inTx bool
+}
+
+// New restituisce un AppDatabase in memoria vuoto.
+func New() database.AppDatabase {
+	st := newState()
+	return &memdb{mu: &sync.RWMutex{}, st: &st}
+}
+
+// read esegue fn in lettura sullo stato
+func (db *memdb) read(ctx context.Context, fn func(s *state) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !db.inTx {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+	}
+	return fn(*db.st)
+}
+
+// write esegue fn con accesso esclusivo allo stato. fn deve controllare i vincoli prima di modificare lo stato: se
+// ritorna un errore, le modifiche già fatte non vengono annullate (per le operazioni in più passi c'è WithTx).
+func (db *memdb) write(ctx context.Context, fn func(s *state) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !db.inTx {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+	return fn(*db.st)
+}
+
+// WithTx esegue fn in una transazione: fn lavora su `tx`, che tiene il database bloccato in modo esclusivo fino alla
+// fine. Se fn ritorna un errore lo stato torna a com'era prima di WithTx. Come per le implementazioni SQL, dentro fn va
+// usato solo `tx`: il database originale resterebbe in attesa del lock.
+func (db *memdb) WithTx(ctx context.Context, fn func(tx database.AppDatabase) error) error {
+	return db.withTx(ctx, func(tx *memdb) error {
+		return fn(tx)
+	})
+}
+
+// withTx è come WithTx, ma passa a fn l'implementazione concreta, per i metodi di questo package
+func (db *memdb) withTx(ctx context.Context, fn func(tx *memdb) error) error {
+	if db.inTx {
+		return fn(db)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snapshot := (*db.st).clone()
+	if err := fn(&memdb{mu: db.mu, st: db.st, inTx: true}); err != nil {
+		*db.st = snapshot
+		return err
+	}
+	return nil
+}
+
+func (db *memdb) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// SchemaVersion restituisce l'ultima versione dello schema SQLite sia come versione corrente sia come più recente: il
+// database in memoria nasce già con il modello dati aggiornato e non ha migrazioni da applicare
+func (db *memdb) SchemaVersion(ctx context.Context) (int, int, error) {
+	latest := database.LatestSchemaVersion()
+	return latest, latest, ctx.Err()
+}
+
+func (db *memdb) GetName(ctx context.Context) (string, error) {
+	var name string
+	err := db.read(ctx, func(s *state) error {
+		if s.name == nil {
+			return newError(database.ErrNotFound, "nome non impostato")
+		}
+		name = *s.name
+		return nil
+	})
+	return name, err
+}
+
+func (db *memdb) SetName(ctx context.Context, name string) error {
+	return db.write(ctx, func(s *state) error {
+		if s.name != nil {
+			return newError(database.ErrConflict, "nome già impostato")
+		}
+		s.name = &name
+		return nil
+	})
+}
+
+func newError(kind error, msg string) error {
+	return &database.Error{Kind: kind, Msg: msg}
+}
+
+// like confronta s con un pattern LIKE (`%` e `_` come caratteri jolly) senza distinguere maiuscole e minuscole, come
+// `LOWER(s) LIKE LOWER(pattern)`. Se escape è true, `\` fa trattare letteralmente il carattere successivo.
+func like(s string, pattern string, escape bool) bool {
+	str := []rune(strings.ToLower(s))
+	pat := []rune(strings.ToLower(pattern))
+
+	// match[i] indica se i primi i caratteri di str corrispondono alla parte di pattern già esaminata
+	match := make([]bool, len(str)+1)
+	match[0] = true
+	for p := 0; p < len(pat); p++ {
+		next := make([]bool, len(str)+1)
+		switch c := pat[p]; {
+		case c == '%':
+			seen := false
+			for i := range match {
+				seen = seen || match[i]
+				next[i] = seen
+			}
+		default:
+			literal := c != '_'
+			if escape && c == '\\' && p+1 < len(pat) {
+				p++
+				c = pat[p]
+				literal = true
+			}
+			for i := 1; i <= len(str); i++ {
+				next[i] = match[i-1] && (!literal || str[i-1] == c)
+			}
+		}
+		match = next
+	}
+	return match[len(str)]
+}
@@ -0,0 +1,39 @@
+package memdb
+
+import (
+	"testing"
+
+	"github.com/albyma98/WASAText/service/database"
+	"github.com/albyma98/WASAText/service/database/dbtest"
+)
+
+func TestMemDB(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+		return New()
+	})
+}
+
+func TestLike(t *testing.T) {
+	tests := []struct {
+		s       string
+		pattern string
+		escape  bool
+		want    bool
+	}{
+		{"Alice", "al%", false, true},
+		{"Alice", "%LIC%", false, true},
+		{"Alice", "a_ice", false, true},
+		{"Alice", "bo%", false, false},
+		{"", "%", false, true},
+		{"50% di sconto", `%50\%%`, true, true},
+		{"500 di sconto", `%50\%%`, true, false},
+		{"a_b", `a\_b`, true, true},
+		{"axb", `a\_b`, true, false},
+		{`a\b`, `a\\b`, true, true},
+	}
+	for _, tt := range tests {
+		if got := like(tt.s, tt.pattern, tt.escape); got != tt.want {
+			t.Errorf("like(%q, %q, %v) = %v, want %v", tt.s, tt.pattern, tt.escape, got, tt.want)
+		}
+	}
+}
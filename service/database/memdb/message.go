@@ -0,0 +1,256 @@
+package memdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+// CreateMessage salva il messaggio, aggiorna timestampLastMessage della conversazione e crea gli stati di consegna dei
+// destinatari.
+func (db *memdb) CreateMessage(ctx context.Context, msg database.Message) (int64, error) {
+	var id int64
+	err := db.write(ctx, func(s *state) error {
+		var err error
+		id, err = s.insertMessage(msg)
+		return err
+	})
+	return id, err
+}
+
+func (db *memdb) GetMessageByID(ctx context.Context, id int64) (database.Message, error) {
+	var msg database.Message
+	err := db.read(ctx, func(s *state) error {
+		m, ok := s.messages[id]
+		if !ok {
+			return newError(database.ErrNotFound, "messaggio inesistente")
+		}
+		msg = m
+		return nil
+	})
+	return msg, err
+}
+
+// GetMessagesByConversationID restituisce i messaggi nella finestra di cronologia visibile, con le reazioni.
+func (db *memdb) GetMessagesByConversationID(ctx context.Context, convoID int64, window database.HistoryWindow) ([]database.Message, error) {
+	var messages []database.Message
+	err := db.read(ctx, func(s *state) error {
+		messages = s.messagesWithReactions(func(m database.Message) bool {
+			return m.IDConversation == convoID && window.Contains(m.Timestamp)
+		})
+		return nil
+	})
+	return messages, err
+}
+
+// SearchMessages cerca i messaggi di una conversazione il cui testo contiene `query`, limitandosi alla finestra di
+// cronologia visibile. I messaggi di sistema sono esclusi.
+func (db *memdb) SearchMessages(ctx context.Context, convoID int64, query string, window database.HistoryWindow) ([]database.Message, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
+	var messages []database.Message
+	err := db.read(ctx, func(s *state) error {
+		messages = s.messagesWithReactions(func(m database.Message) bool {
+			return m.IDConversation == convoID && m.Type != "system" && window.Contains(m.Timestamp) &&
+				like(m.Content, "%"+escaped+"%", true)
+		})
+		return nil
+	})
+	return messages, err
+}
+
+// DeleteMessageByID elimina il messaggio solo se lo ha mandato l'utente.
+func (db *memdb) DeleteMessageByID(ctx context.Context, id int64, uuidSender string) error {
+	return db.write(ctx, func(s *state) error {
+		msg, ok := s.messages[id]
+		if !ok || msg.UUIDSender != uuidSender || msg.Type == "system" {
+			return newError(database.ErrForbidden, "nessun messaggio eliminato (ID non esistente o UUID non corrispondente)")
+		}
+		s.deleteMessage(id)
+		return nil
+	})
+}
+
+func (db *memdb) ForwardMessage(ctx context.Context, originalMsgID int64, destConversationID int64, senderUUID string) (int64, error) {
+	var id int64
+	err := db.write(ctx, func(s *state) error {
+		original, ok := s.messages[originalMsgID]
+		if !ok {
+			return newError(database.ErrNotFound, "messaggio originale non trovato")
+		}
+		if _, ok := s.members[memberKey{senderUUID, destConversationID}]; !ok {
+			return newError(database.ErrForbidden, "utente non autorizzato")
+		}
+
+		var err error
+		id, err = s.insertMessage(database.Message{
+			Type:            original.Type,
+			Content:         original.Content,
+			MediaUrl:        original.MediaUrl,
+			IDConversation:  destConversationID,
+			UUIDSender:      senderUUID,
+			IDForwardedFrom: &originalMsgID,
+		})
+		return err
+	})
+	return id, err
+}
+
+func (db *memdb) GetLastMessage(ctx context.Context, convID int64) (database.Message, error) {
+	var msg database.Message
+	err := db.read(ctx, func(s *state) error {
+		last, ok := s.lastMessage(convID)
+		if !ok {
+			return newError(database.ErrNotFound, "nessun messaggio")
+		}
+		msg = last
+		return nil
+	})
+	return msg, err
+}
+
+// insertMessage controlla i vincoli e salva msg con un nuovo ID, come CreateMessage
+func (s *state) insertMessage(msg database.Message) (int64, error) {
+	if err := s.requireConversation(msg.IDConversation); err != nil {
+		return 0, err
+	}
+	if err := s.requireUser(msg.UUIDSender); err != nil {
+		return 0, err
+	}
+	if msg.Type != "text" && msg.Type != "photo" && msg.Type != "system" {
+		return 0, fmt.Errorf("tipo di messaggio non valido: %s", msg.Type)
+	}
+	for _, ref := range []*int64{msg.IDRepliesTo, msg.IDForwardedFrom} {
+		if ref == nil {
+			continue
+		}
+		if _, ok := s.messages[*ref]; !ok {
+			return 0, newError(database.ErrNotFound, "messaggio inesistente")
+		}
+	}
+
+	msg.ID = s.nextMessageID
+	s.nextMessageID++
+	msg.Timestamp = database.Timestamp(time.Now())
+	msg.Reactions = nil
+	msg.System = nil
+	s.messages[msg.ID] = msg
+	s.touchConversation(msg.IDConversation, msg.Timestamp)
+
+	for _, m := range s.sortedMembers(msg.IDConversation) {
+		if m.UUIDUser != msg.UUIDSender {
+			s.statuses[userMessageKey{m.UUIDUser, msg.ID}] = statusRow{delivered: true, seq: s.nextSeq()}
+		}
+	}
+	return msg.ID, nil
+}
+
+// insertSystemMessage registra nella conversazione il messaggio di sistema per l'evento `ev`, con la descrizione
+// `content` ottenuta da describe. I messaggi di sistema non hanno stati di consegna/lettura.
+func (s *state) insertSystemMessage(idConversation int64, ev database.SystemEvent, content string, timestamp string) {
+	msg := database.Message{
+		ID:             s.nextMessageID,
+		Type:           "system",
+		Content:        content,
+		Timestamp:      timestamp,
+		IDConversation: idConversation,
+		UUIDSender:     ev.Actor,
+		System:         &ev,
+	}
+	s.nextMessageID++
+	s.messages[msg.ID] = msg
+	s.touchConversation(idConversation, timestamp)
+}
+
+// describe costruisce la descrizione di un evento di sistema; fallisce se uno degli utenti non esiste
+func (s *state) describe(ev database.SystemEvent) (string, error) {
+	return database.DescribeSystemEvent(ev, func(uuid string) (string, error) {
+		u, ok := s.users[uuid]
+		if !ok {
+			return "", newError(database.ErrNotFound, "utente inesistente")
+		}
+		return u.Username, nil
+	})
+}
+
+func (s *state) touchConversation(id int64, timestamp string) {
+	conv := s.conversations[id]
+	conv.TimestampLastMessage = timestamp
+	s.conversations[id] = conv
+}
+
+// sortedMessages restituisce i messaggi che soddisfano filter ordinati per timestamp e ID
+func (s *state) sortedMessages(filter func(m database.Message) bool) []database.Message {
+	var messages []database.Message
+	for _, m := range s.messages {
+		if filter(m) {
+			messages = append(messages, m)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if a.Timestamp != b.Timestamp {
+			return a.Timestamp < b.Timestamp
+		}
+		return a.ID < b.ID
+	})
+	return messages
+}
+
+// messagesWithReactions è come sortedMessages, ma aggiunge a ogni messaggio le sue reazioni
+func (s *state) messagesWithReactions(filter func(m database.Message) bool) []database.Message {
+	messages := s.sortedMessages(filter)
+	for i := range messages {
+		messages[i].Reactions = s.reactionsWithUser(messages[i].ID)
+	}
+	return messages
+}
+
+func (s *state) lastMessage(idConversation int64) (database.Message, bool) {
+	messages := s.sortedMessages(func(m database.Message) bool { return m.IDConversation == idConversation })
+	if len(messages) == 0 {
+		return database.Message{}, false
+	}
+	return messages[len(messages)-1], true
+}
+
+// deleteMessage elimina il messaggio con le reazioni e gli stati (ON DELETE CASCADE) e rimuove i riferimenti delle
+// risposte e degli inoltri (ON DELETE SET NULL)
+func (s *state) deleteMessage(id int64) {
+	delete(s.messages, id)
+	for key := range s.reactions {
+		if key.idMessage == id {
+			delete(s.reactions, key)
+		}
+	}
+	for key := range s.statuses {
+		if key.idMessage == id {
+			delete(s.statuses, key)
+		}
+	}
+	for msgID, m := range s.messages {
+		changed := false
+		if m.IDRepliesTo != nil && *m.IDRepliesTo == id {
+			m.IDRepliesTo = nil
+			changed = true
+		}
+		if m.IDForwardedFrom != nil && *m.IDForwardedFrom == id {
+			m.IDForwardedFrom = nil
+			changed = true
+		}
+		if changed {
+			s.messages[msgID] = m
+		}
+	}
+}
+
+// requireConversation controlla il vincolo di FOREIGN KEY verso una conversazione
+func (s *state) requireConversation(id int64) error {
+	if _, ok := s.conversations[id]; !ok {
+		return newError(database.ErrNotFound, "conversazione inesistente")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package memdb
+
+import (
+	"context"
+	"sort"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+func (db *memdb) SetDelivered(ctx context.Context, uuidUser string, idMessage int64) error {
+	return db.setStatus(ctx, uuidUser, idMessage, func(st *statusRow) {
+		st.delivered = true
+	})
+}
+
+func (db *memdb) SetSeen(ctx context.Context, uuidUser string, idMessage int64) error {
+	return db.setStatus(ctx, uuidUser, idMessage, func(st *statusRow) {
+		st.seen = true
+	})
+}
+
+// setStatus applica update allo stato del messaggio per l'utente, creandolo (consegnato e non letto) se manca
+func (db *memdb) setStatus(ctx context.Context, uuidUser string, idMessage int64, update func(st *statusRow)) error {
+	return db.write(ctx, func(s *state) error {
+		key := userMessageKey{uuidUser, idMessage}
+		st, ok := s.statuses[key]
+		if !ok {
+			if err := s.requireUser(uuidUser); err != nil {
+				return err
+			}
+			if err := s.requireMessage(idMessage); err != nil {
+				return err
+			}
+			st = statusRow{delivered: true, seq: s.nextSeq()}
+		}
+		update(&st)
+		s.statuses[key] = st
+		return nil
+	})
+}
+
+func (db *memdb) GetMessageStatus(ctx context.Context, uuidUser string, idMessage int64) (database.MessageStatus, error) {
+	var status database.MessageStatus
+	err := db.read(ctx, func(s *state) error {
+		st, ok := s.statuses[userMessageKey{uuidUser, idMessage}]
+		if !ok {
+			return newError(database.ErrNotFound, "stato del messaggio inesistente")
+		}
+		status = database.MessageStatus{UUIDUser: uuidUser, IDMessage: idMessage, Delivered: st.delivered, Seen: st.seen}
+		return nil
+	})
+	return status, err
+}
+
+func (db *memdb) GetAllStatusesByMessage(ctx context.Context, idMessage int64) ([]database.MessageStatus, error) {
+	var statuses []database.MessageStatus
+	err := db.read(ctx, func(s *state) error {
+		var keys []userMessageKey
+		for key := range s.statuses {
+			if key.idMessage == idMessage {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return s.statuses[keys[i]].seq < s.statuses[keys[j]].seq })
+
+		for _, key := range keys {
+			st := s.statuses[key]
+			statuses = append(statuses, database.MessageStatus{
+				UUIDUser:  key.uuidUser,
+				IDMessage: idMessage,
+				Delivered: st.delivered,
+				Seen:      st.seen,
+			})
+		}
+		return nil
+	})
+	return statuses, err
+}
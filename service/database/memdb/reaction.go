@@ -0,0 +1,89 @@
+package memdb
+
+import (
+	"context"
+	"sort"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+func (db *memdb) AddReaction(ctx context.Context, messageID int64, uuid string, emoji string) error {
+	return db.write(ctx, func(s *state) error {
+		if err := s.requireMessage(messageID); err != nil {
+			return err
+		}
+		if err := s.requireUser(uuid); err != nil {
+			return err
+		}
+		key := userMessageKey{uuid, messageID}
+		if _, ok := s.reactions[key]; ok {
+			return newError(database.ErrConflict, "reazione già presente")
+		}
+		s.reactions[key] = reactionRow{emoji: emoji, seq: s.nextSeq()}
+		return nil
+	})
+}
+
+func (db *memdb) RemoveReaction(ctx context.Context, messageID int64, uuid string) error {
+	return db.write(ctx, func(s *state) error {
+		delete(s.reactions, userMessageKey{uuid, messageID})
+		return nil
+	})
+}
+
+func (db *memdb) GetReactionsByMessageID(ctx context.Context, messageID int64) ([]database.Reaction, error) {
+	var reactions []database.Reaction
+	err := db.read(ctx, func(s *state) error {
+		for _, r := range s.sortedReactions(messageID) {
+			reactions = append(reactions, database.Reaction{UUIDUser: r.key.uuidUser, IDMessage: messageID, Emoji: r.emoji})
+		}
+		return nil
+	})
+	return reactions, err
+}
+
+func (db *memdb) GetReactionsWithUserByMessageID(ctx context.Context, messageID int64) ([]database.ReactionWithUser, error) {
+	var reactions []database.ReactionWithUser
+	err := db.read(ctx, func(s *state) error {
+		reactions = s.reactionsWithUser(messageID)
+		return nil
+	})
+	return reactions, err
+}
+
+type keyedReaction struct {
+	reactionRow
+	key userMessageKey
+}
+
+// sortedReactions restituisce le reazioni al messaggio in ordine di inserimento
+func (s *state) sortedReactions(messageID int64) []keyedReaction {
+	var reactions []keyedReaction
+	for key, r := range s.reactions {
+		if key.idMessage == messageID {
+			reactions = append(reactions, keyedReaction{r, key})
+		}
+	}
+	sort.Slice(reactions, func(i, j int) bool { return reactions[i].seq < reactions[j].seq })
+	return reactions
+}
+
+func (s *state) reactionsWithUser(messageID int64) []database.ReactionWithUser {
+	var reactions []database.ReactionWithUser
+	for _, r := range s.sortedReactions(messageID) {
+		reactions = append(reactions, database.ReactionWithUser{
+			UUIDUser: r.key.uuidUser,
+			Username: s.users[r.key.uuidUser].Username,
+			Emoji:    r.emoji,
+		})
+	}
+	return reactions
+}
+
+// requireMessage controlla il vincolo di FOREIGN KEY verso un messaggio
+func (s *state) requireMessage(id int64) error {
+	if _, ok := s.messages[id]; !ok {
+		return newError(database.ErrNotFound, "messaggio inesistente")
+	}
+	return nil
+}
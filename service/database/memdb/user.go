@@ -0,0 +1,153 @@
+package memdb
+
+import (
+	"context"
+	"sort"
+
+	"github.com/albyma98/WASAText/service/database"
+)
+
+func (db *memdb) CreateUser(ctx context.Context, uuid string, username string, photoUrl string) error {
+	return db.write(ctx, func(s *state) error {
+		if _, ok := s.users[uuid]; ok {
+			return newError(database.ErrConflict, "uuid già esistente")
+		}
+		if _, ok := s.userByUsername(username); ok {
+			return newError(database.ErrConflict, "username già esistente")
+		}
+		s.users[uuid] = userRow{
+			User: database.User{UUID: uuid, Username: username, PhotoUrl: &photoUrl},
+			seq:  s.nextSeq(),
+		}
+		return nil
+	})
+}
+
+func (db *memdb) GetUserByUUID(ctx context.Context, uuid string) (database.User, error) {
+	var user database.User
+	err := db.read(ctx, func(s *state) error {
+		u, ok := s.users[uuid]
+		if !ok {
+			return newError(database.ErrNotFound, "utente inesistente")
+		}
+		user = u.User
+		return nil
+	})
+	return user, err
+}
+
+func (db *memdb) GetUserByUsername(ctx context.Context, username string) (database.User, error) {
+	var user database.User
+	err := db.read(ctx, func(s *state) error {
+		u, ok := s.userByUsername(username)
+		if !ok {
+			return newError(database.ErrNotFound, "utente inesistente")
+		}
+		user = u.User
+		return nil
+	})
+	return user, err
+}
+
+func (db *memdb) SetUserName(ctx context.Context, uuid string, newUsername string) error {
+	return db.write(ctx, func(s *state) error {
+		u, ok := s.users[uuid]
+		if !ok {
+			return nil
+		}
+		if other, ok := s.userByUsername(newUsername); ok && other.UUID != uuid {
+			return newError(database.ErrConflict, "username già esistente")
+		}
+		u.Username = newUsername
+		s.users[uuid] = u
+		return nil
+	})
+}
+
+func (db *memdb) SetPhotoUrl(ctx context.Context, uuid string, newPhotoUrl string) error {
+	return db.write(ctx, func(s *state) error {
+		if u, ok := s.users[uuid]; ok {
+			u.PhotoUrl = &newPhotoUrl
+			s.users[uuid] = u
+		}
+		return nil
+	})
+}
+
+func (db *memdb) SearchUsersByPrefix(ctx context.Context, prefix string) ([]database.User, error) {
+	var users []database.User
+	err := db.read(ctx, func(s *state) error {
+		for _, u := range s.sortedUsers() {
+			if like(u.Username, prefix+"%", false) {
+				users = append(users, u.User)
+			}
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (db *memdb) GetAllUsers(ctx context.Context) ([]database.User, error) {
+	var users []database.User
+	err := db.read(ctx, func(s *state) error {
+		for _, u := range s.sortedUsers() {
+			users = append(users, u.User)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (db *memdb) UserExists(ctx context.Context, uuid string) (bool, error) {
+	var exists bool
+	err := db.read(ctx, func(s *state) error {
+		_, exists = s.users[uuid]
+		return nil
+	})
+	return exists, err
+}
+
+func (db *memdb) GetPeerData(ctx context.Context, convID int64, uuidMe string) (database.User, error) {
+	var peer database.User
+	err := db.read(ctx, func(s *state) error {
+		for _, m := range s.sortedMembers(convID) {
+			if m.UUIDUser != uuidMe {
+				u, ok := s.users[m.UUIDUser]
+				if !ok {
+					return newError(database.ErrNotFound, "utente inesistente")
+				}
+				peer = u.User
+				return nil
+			}
+		}
+		return newError(database.ErrNotFound, "nessun altro membro nella conversazione")
+	})
+	return peer, err
+}
+
+func (s *state) userByUsername(username string) (userRow, bool) {
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, true
+		}
+	}
+	return userRow{}, false
+}
+
+// sortedUsers restituisce gli utenti in ordine di inserimento
+func (s *state) sortedUsers() []userRow {
+	users := make([]userRow, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].seq < users[j].seq })
+	return users
+}
+
+// requireUser controlla il vincolo di FOREIGN KEY verso un utente
+func (s *state) requireUser(uuid string) error {
+	if _, ok := s.users[uuid]; !ok {
+		return newError(database.ErrNotFound, "utente inesistente")
+	}
+	return nil
+}
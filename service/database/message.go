@@ -1,11 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"log"
-	"time"
+	"strings"
 )
 
 type Message struct {
@@ -19,38 +19,40 @@ type Message struct {
 	IDRepliesTo     *int64
 	IDForwardedFrom *int64             `json:"idForwardedFrom"`
 	Reactions       []ReactionWithUser `json:"reactions"`
+	System          *SystemEvent       `json:"system,omitempty"`
 }
 
 // 1. CreateMessage
-func (db *appdbimpl) CreateMessage(msg Message) (int64, error) {
-	timestamp := time.Now().Format(time.RFC3339)
-	result, err := db.c.Exec(
-		`INSERT INTO message (type, content, mediaUrl, timestamp, idRepliesTo, idForwardedFrom, idConversation ,uuidSender)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		msg.Type, msg.Content, msg.MediaUrl, timestamp, msg.IDRepliesTo, msg.IDForwardedFrom, msg.IDConversation, msg.UUIDSender,
-	)
-
-	if err != nil {
-		return 0, err
-	}
-	// 3. Recupera ID del messaggio appena creato
-	messageID, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
+// Il messaggio, l'aggiornamento di timestampLastMessage e gli stati di consegna dei destinatari sono salvati nella
+// stessa transazione.
+func (db *appdbimpl) CreateMessage(ctx context.Context, msg Message) (int64, error) {
+	var messageID int64
+	err := db.withTx(ctx, func(tx *appdbimpl) error {
+		timestamp := now()
+		// 3. Recupera ID del messaggio appena creato
+		err := tx.c.QueryRowContext(ctx,
+			`INSERT INTO message (type, content, mediaUrl, timestamp, idRepliesTo, idForwardedFrom, idConversation ,uuidSender)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			msg.Type, msg.Content, msg.MediaUrl, timestamp, msg.IDRepliesTo, msg.IDForwardedFrom, msg.IDConversation, msg.UUIDSender,
+		).Scan(&messageID)
+		if err != nil {
+			return classify(err)
+		}
 
-	// Aggiorna il timestamp dell'ultima modifica nella conversazione
-	_, err = db.c.Exec(`
-            UPDATE conversation
-            SET timestampLastMessage = ?
-            WHERE id = ?
-    `, timestamp, msg.IDConversation)
-	if err != nil {
-		return 0, err
-	}
+		// Aggiorna il timestamp dell'ultima modifica nella conversazione
+		_, err = tx.c.ExecContext(ctx, `
+			UPDATE conversation
+			SET timestampLastMessage = ?
+			WHERE id = ?
+		`, timestamp, msg.IDConversation)
+		if err != nil {
+			return err
+		}
 
-	// 4. Inserisci record in message_status
-	err = db.InsertMessageStatusForRecipients(messageID, msg.IDConversation, msg.UUIDSender)
+		// 4. Inserisci record in message_status
+		return tx.InsertMessageStatusForRecipients(ctx, messageID, msg.IDConversation, msg.UUIDSender)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -58,16 +60,16 @@ func (db *appdbimpl) CreateMessage(msg Message) (int64, error) {
 	return messageID, nil
 }
 
-func (db *appdbimpl) InsertMessageStatusForRecipients(messageID int64, conversationID int64, senderUUID string) error {
+func (db *appdbimpl) InsertMessageStatusForRecipients(ctx context.Context, messageID int64, conversationID int64, senderUUID string) error {
 	// Verifica se è una conversazione diretta
 	var isDirect bool
-	err := db.c.QueryRow(`SELECT isDirect FROM conversation WHERE id = ?`, conversationID).Scan(&isDirect)
+	err := db.c.QueryRowContext(ctx, `SELECT isDirect FROM conversation WHERE id = ?`, conversationID).Scan(&isDirect)
 	if err != nil {
 		return err
 	}
 
 	// Recupera tutti gli utenti tranne il mittente
-	rows, err := db.c.Query(`
+	rows, err := db.c.QueryContext(ctx, `
 		SELECT uuidUser FROM member WHERE idConversation = ? AND uuidUser != ?
 	`, conversationID, senderUUID)
 	if err != nil {
@@ -75,8 +77,20 @@ func (db *appdbimpl) InsertMessageStatusForRecipients(messageID int64, conversat
 	}
 	defer rows.Close()
 
+	var recipients []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return err
+		}
+		recipients = append(recipients, uuid)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
 	// Inizia transazione
-	tx, err := db.c.Begin()
+	tx, err := db.begin(ctx)
 	if err != nil {
 		return err
 	}
@@ -89,7 +103,7 @@ func (db *appdbimpl) InsertMessageStatusForRecipients(messageID int64, conversat
 	}()
 
 	// Prepara l'inserimento dei messageStatus
-	stmt, err := tx.Prepare(`
+	stmt, err := tx.PrepareContext(ctx, `
 		INSERT INTO messageStatus (uuidUser, idMessage, delivered, seen)
 		VALUES (?, ?, true, false)
 	`)
@@ -98,18 +112,11 @@ func (db *appdbimpl) InsertMessageStatusForRecipients(messageID int64, conversat
 	}
 	defer stmt.Close()
 
-	for rows.Next() {
-		var uuid string
-		if err := rows.Scan(&uuid); err != nil {
-			return err
-		}
-		if _, err := stmt.Exec(uuid, messageID); err != nil {
+	for _, uuid := range recipients {
+		if _, err := stmt.ExecContext(ctx, uuid, messageID); err != nil {
 			return err
 		}
 	}
-	if err := rows.Err(); err != nil {
-		return err
-	}
 
 	// Commit finale
 	if err := tx.Commit(); err != nil {
@@ -120,20 +127,51 @@ func (db *appdbimpl) InsertMessageStatusForRecipients(messageID int64, conversat
 }
 
 // 2. GetMessageByID
-func (db *appdbimpl) GetMessageByID(id int64) (Message, error) {
+func (db *appdbimpl) GetMessageByID(ctx context.Context, id int64) (Message, error) {
 
 	var msg Message
-	err := db.c.QueryRow(
-		`SELECT id, type, content, mediaUrl, timestamp, idConversation, uuidSender, idRepliesTo, idForwardedFrom FROM message WHERE id = ?`, id,
+	var payload sql.NullString
+	err := db.c.QueryRowContext(ctx,
+		`SELECT id, type, content, mediaUrl, timestamp, idConversation, uuidSender, idRepliesTo, idForwardedFrom, systemPayload FROM message WHERE id = ?`, id,
 	).Scan(
-		&msg.ID, &msg.Type, &msg.Content, &msg.MediaUrl, &msg.Timestamp, &msg.IDConversation, &msg.UUIDSender, &msg.IDRepliesTo, &msg.IDForwardedFrom,
+		&msg.ID, &msg.Type, &msg.Content, &msg.MediaUrl, &msg.Timestamp, &msg.IDConversation, senderColumn{&msg.UUIDSender}, &msg.IDRepliesTo, &msg.IDForwardedFrom, &payload,
 	)
+	if err != nil {
+		return msg, classify(err)
+	}
+	msg.System, err = parseSystemPayload(payload)
 	return msg, err
 }
 
-// 3. GetMessagesByConversationID
-func (db *appdbimpl) GetMessagesByConversationID(convoID int64) ([]Message, error) {
-	rows, err := db.c.Query(`SELECT id, type, content, mediaUrl, timestamp, idConversation, uuidSender, idRepliesTo, idForwardedFrom FROM message WHERE idConversation = ? ORDER BY timestamp ASC`, convoID)
+// 3. GetMessagesByConversationID (solo i messaggi nella finestra di cronologia visibile)
+func (db *appdbimpl) GetMessagesByConversationID(ctx context.Context, convoID int64, window HistoryWindow) ([]Message, error) {
+	return db.queryMessagesWithReactions(ctx, `
+		SELECT id, type, content, mediaUrl, timestamp, idConversation, uuidSender, idRepliesTo, idForwardedFrom, systemPayload
+		FROM message
+		WHERE idConversation = ?
+		  AND (CAST(? AS TEXT) = '' OR timestamp >= ?) AND (CAST(? AS TEXT) = '' OR timestamp <= ?)
+		ORDER BY timestamp ASC, id ASC`,
+		convoID, window.Since, window.Since, window.Until, window.Until,
+	)
+}
+
+// SearchMessages cerca i messaggi di una conversazione il cui testo contiene `query`, limitandosi alla finestra di
+// cronologia visibile. I messaggi di sistema sono esclusi.
+func (db *appdbimpl) SearchMessages(ctx context.Context, convoID int64, query string, window HistoryWindow) ([]Message, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
+	return db.queryMessagesWithReactions(ctx, `
+		SELECT id, type, content, mediaUrl, timestamp, idConversation, uuidSender, idRepliesTo, idForwardedFrom, systemPayload
+		FROM message
+		WHERE idConversation = ? AND type != 'system'
+		  AND LOWER(content) LIKE LOWER(?) ESCAPE '\'
+		  AND (CAST(? AS TEXT) = '' OR timestamp >= ?) AND (CAST(? AS TEXT) = '' OR timestamp <= ?)
+		ORDER BY timestamp ASC, id ASC`,
+		convoID, "%"+escaped+"%", window.Since, window.Since, window.Until, window.Until,
+	)
+}
+
+func (db *appdbimpl) queryMessagesWithReactions(ctx context.Context, query string, args ...interface{}) ([]Message, error) {
+	rows, err := db.c.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -142,55 +180,65 @@ func (db *appdbimpl) GetMessagesByConversationID(convoID int64) ([]Message, erro
 	var messages []Message
 	for rows.Next() {
 		var msg Message
-		err := rows.Scan(&msg.ID, &msg.Type, &msg.Content, &msg.MediaUrl, &msg.Timestamp, &msg.IDConversation, &msg.UUIDSender, &msg.IDRepliesTo, &msg.IDForwardedFrom)
+		var payload sql.NullString
+		err := rows.Scan(&msg.ID, &msg.Type, &msg.Content, &msg.MediaUrl, &msg.Timestamp, &msg.IDConversation, senderColumn{&msg.UUIDSender}, &msg.IDRepliesTo, &msg.IDForwardedFrom, &payload)
 		if err != nil {
 			return nil, err
 		}
-		// Recupera le reazioni associate a questo messaggio con i rispettivi utenti
-		reactions, err := db.GetReactionsWithUserByMessageID(msg.ID)
+		msg.System, err = parseSystemPayload(payload)
 		if err != nil {
 			return nil, err
 		}
-		msg.Reactions = reactions
-
 		messages = append(messages, msg)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	// Le reazioni vengono lette dopo aver chiuso rows: dentro una transazione PostgreSQL la connessione è una sola e
+	// non può eseguire una query mentre un'altra è ancora aperta
+	rows.Close()
+
+	for i := range messages {
+		// Recupera le reazioni associate a questo messaggio con i rispettivi utenti
+		reactions, err := db.GetReactionsWithUserByMessageID(ctx, messages[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		messages[i].Reactions = reactions
+	}
 	return messages, nil
 }
 
 // 4. DeleteMessageByID (solo se lo ha mandato l'utente)
-func (db *appdbimpl) DeleteMessageByID(id int64, uuidSender string) error {
-	result, err := db.c.Exec(`DELETE FROM message WHERE id = ? AND uuidSender = ?`, id, uuidSender)
+func (db *appdbimpl) DeleteMessageByID(ctx context.Context, id int64, uuidSender string) error {
+	result, err := db.c.ExecContext(ctx, `DELETE FROM message WHERE id = ? AND uuidSender = ? AND type != 'system'`, id, uuidSender)
 	if err != nil {
 		return err
 	}
 	af, err := result.RowsAffected()
 	if err == nil && af == 0 {
-		return fmt.Errorf("nessun messaggio eliminato (ID non esistente o UUID non corrispondente)")
+		return newError(ErrForbidden, "nessun messaggio eliminato (ID non esistente o UUID non corrispondente)")
 	}
 	return err
 }
 
-func (db *appdbimpl) ForwardMessage(originalMsgID int64, destConversationID int64, senderUUID string) (int64, error) {
+func (db *appdbimpl) ForwardMessage(ctx context.Context, originalMsgID int64, destConversationID int64, senderUUID string) (int64, error) {
 	// 1. Recupera i dati del messaggio originale
 	var msgType, content string
 	var mediaUrl *string
 
-	err := db.c.QueryRow(`
+	err := db.c.QueryRowContext(ctx, `
 		SELECT type, content, mediaUrl
 		FROM message
 		WHERE id = ?
 	`, originalMsgID).Scan(&msgType, &content, &mediaUrl)
 	if err != nil {
-		return 0, fmt.Errorf("messaggio originale non trovato")
+		return 0, &Error{Kind: ErrNotFound, Msg: "messaggio originale non trovato", Err: err}
 	}
 
 	// 2. Verifica che il sender sia membro della conversazione di destinazione
 	var count int
-	err = db.c.QueryRow(`
+	err = db.c.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM member
 		WHERE idConversation = ? AND uuidUser = ?
 	`, destConversationID, senderUUID).Scan(&count)
@@ -198,7 +246,7 @@ func (db *appdbimpl) ForwardMessage(originalMsgID int64, destConversationID int6
 		return 0, err
 	}
 	if count == 0 {
-		return 0, fmt.Errorf("utente non autorizzato")
+		return 0, newError(ErrForbidden, "utente non autorizzato")
 	}
 
 	finalContent := content
@@ -216,7 +264,7 @@ func (db *appdbimpl) ForwardMessage(originalMsgID int64, destConversationID int6
 	}
 
 	// 5. Crea il nuovo messaggio nel DB
-	newID, err := db.CreateMessage(newMsg)
+	newID, err := db.CreateMessage(ctx, newMsg)
 	if err != nil {
 		return 0, err
 	}
@@ -224,14 +272,15 @@ func (db *appdbimpl) ForwardMessage(originalMsgID int64, destConversationID int6
 	return newID, nil
 }
 
-func (db *appdbimpl) GetLastMessage(convID int64) (Message, error) {
+func (db *appdbimpl) GetLastMessage(ctx context.Context, convID int64) (Message, error) {
 	var msg Message
+	var payload sql.NullString
 
-	err := db.c.QueryRow(`
-		SELECT id, type, content, mediaUrl, timestamp, idConversation, uuidSender, idRepliesTo, idForwardedFrom
+	err := db.c.QueryRowContext(ctx, `
+		SELECT id, type, content, mediaUrl, timestamp, idConversation, uuidSender, idRepliesTo, idForwardedFrom, systemPayload
 		FROM message
 		WHERE idConversation = ?
-		ORDER BY timestamp DESC
+		ORDER BY timestamp DESC, id DESC
 		LIMIT 1
 	`, convID).Scan(
 		&msg.ID,
@@ -240,11 +289,17 @@ func (db *appdbimpl) GetLastMessage(convID int64) (Message, error) {
 		&msg.MediaUrl,
 		&msg.Timestamp,
 		&msg.IDConversation,
-		&msg.UUIDSender,
+		senderColumn{&msg.UUIDSender},
 		&msg.IDRepliesTo,
 		&msg.IDForwardedFrom,
+		&payload,
 	)
 
+	if err != nil {
+		return Message{}, classify(err)
+	}
+
+	msg.System, err = parseSystemPayload(payload)
 	if err != nil {
 		return Message{}, err
 	}
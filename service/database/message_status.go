@@ -1,5 +1,7 @@
 package database
 
+import "context"
+
 type MessageStatus struct {
 	UUIDUser  string
 	IDMessage int64
@@ -7,29 +9,29 @@ type MessageStatus struct {
 	Seen      bool
 }
 
-func (db *appdbimpl) SetDelivered(uuidUser string, idMessage int64) error {
-	_, err := db.c.Exec(`
+func (db *appdbimpl) SetDelivered(ctx context.Context, uuidUser string, idMessage int64) error {
+	_, err := db.c.ExecContext(ctx, `
 		INSERT INTO messageStatus (uuidUser, idMessage, delivered, seen)
 		VALUES (?, ?, TRUE, FALSE)
 		ON CONFLICT(uuidUser, idMessage)
 		DO UPDATE SET delivered = TRUE;
 	`, uuidUser, idMessage)
-	return err
+	return classify(err)
 }
 
-func (db *appdbimpl) SetSeen(uuidUser string, idMessage int64) error {
-	_, err := db.c.Exec(`
+func (db *appdbimpl) SetSeen(ctx context.Context, uuidUser string, idMessage int64) error {
+	_, err := db.c.ExecContext(ctx, `
 		INSERT INTO messageStatus (uuidUser, idMessage, delivered, seen)
 		VALUES (?, ?, TRUE, TRUE)
 		ON CONFLICT(uuidUser, idMessage)
 		DO UPDATE SET seen = TRUE;
 	`, uuidUser, idMessage)
-	return err
+	return classify(err)
 }
 
-func (db *appdbimpl) GetMessageStatus(uuidUser string, idMessage int64) (MessageStatus, error) {
+func (db *appdbimpl) GetMessageStatus(ctx context.Context, uuidUser string, idMessage int64) (MessageStatus, error) {
 	var status MessageStatus
-	err := db.c.QueryRow(`
+	err := db.c.QueryRowContext(ctx, `
 		SELECT uuidUser, idMessage, delivered, seen
 		FROM messageStatus
 		WHERE uuidUser = ? AND idMessage = ?;
@@ -40,13 +42,13 @@ func (db *appdbimpl) GetMessageStatus(uuidUser string, idMessage int64) (Message
 		&status.Seen,
 	)
 	if err != nil {
-		return MessageStatus{}, err
+		return MessageStatus{}, classify(err)
 	}
 	return status, nil
 }
 
-func (db *appdbimpl) GetAllStatusesByMessage(idMessage int64) ([]MessageStatus, error) {
-	rows, err := db.c.Query(`
+func (db *appdbimpl) GetAllStatusesByMessage(ctx context.Context, idMessage int64) ([]MessageStatus, error) {
+	rows, err := db.c.QueryContext(ctx, `
 		SELECT uuidUser, delivered, seen
 		FROM messageStatus
 		WHERE idMessage = ?;
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"time"
+)
+
+// Contatori delle query, pubblicati con expvar (su /debug/vars del server di debug). Le chiavi sono le operazioni del
+// querier: "exec", "query", "queryRow" e "prepare".
+var (
+	// queryCount è il numero di query eseguite
+	queryCount = expvar.NewMap("db_queries")
+
+	// queryErrors è il numero di query terminate con un errore
+	queryErrors = expvar.NewMap("db_query_errors")
+
+	// querySeconds è il tempo totale speso nelle query, in secondi: diviso per db_queries dà la latenza media
+	querySeconds = expvar.NewMap("db_query_seconds")
+)
+
+// instrumentedQuerier conta le query passate a q e ne misura la durata. Per le query che restituiscono righe la durata
+// arriva fino alla prima risposta del database, esclusa la lettura delle righe.
+type instrumentedQuerier struct {
+	q querier
+}
+
+func (i instrumentedQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := i.q.ExecContext(ctx, query, args...)
+	observeQuery("exec", start, err)
+	return res, err
+}
+
+func (i instrumentedQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := i.q.QueryContext(ctx, query, args...)
+	observeQuery("query", start, err)
+	return rows, err
+}
+
+func (i instrumentedQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := i.q.QueryRowContext(ctx, query, args...)
+	observeQuery("queryRow", start, row.Err())
+	return row
+}
+
+func (i instrumentedQuerier) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	start := time.Now()
+	stmt, err := i.q.PrepareContext(ctx, query)
+	observeQuery("prepare", start, err)
+	return stmt, err
+}
+
+// observeQuery aggiorna i contatori di op con una query iniziata in start
+func observeQuery(op string, start time.Time, err error) {
+	queryCount.Add(op, 1)
+	querySeconds.AddFloat(op, time.Since(start).Seconds())
+	if err != nil {
+		queryErrors.Add(op, 1)
+	}
+}
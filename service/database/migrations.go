@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations contiene, in ordine, le modifiche allo schema successive a schema.sql. La posizione nella lista (partendo
+// da 1) è la versione dello schema, salvata nel database tramite `PRAGMA user_version`: all'avvio vengono eseguite solo
+// le migrazioni non ancora applicate.
+var migrations = []string{
+	// 1: gruppi con approvazione e richieste di ingresso
+	`ALTER TABLE conversation ADD COLUMN requiresApproval BOOLEAN NOT NULL DEFAULT FALSE;
+
+	CREATE TABLE joinRequest (
+	  uuidUser TEXT NOT NULL,
+	  idConversation INTEGER NOT NULL,
+	  status TEXT NOT NULL CHECK(status IN ('pending', 'approved', 'denied')),
+	  timestampCreated TEXT NOT NULL,
+	  timestampDecided TEXT,
+	  uuidDecidedBy TEXT,
+	  PRIMARY KEY (uuidUser, idConversation),
+	  FOREIGN KEY (uuidUser) REFERENCES user(uuid) ON DELETE CASCADE,
+	  FOREIGN KEY (idConversation) REFERENCES conversation(id) ON DELETE CASCADE,
+	  FOREIGN KEY (uuidDecidedBy) REFERENCES user(uuid) ON DELETE SET NULL
+	);`,
+
+	// 2: amministratori dei gruppi, ex membri e messaggi di sistema
+	`ALTER TABLE member ADD COLUMN isAdmin BOOLEAN NOT NULL DEFAULT FALSE;
+
+	-- Nei gruppi già esistenti il primo membro inserito (il creatore) diventa amministratore
+	UPDATE member SET isAdmin = TRUE
+	WHERE idConversation IN (SELECT id FROM conversation WHERE isDirect = FALSE)
+	  AND rowid = (SELECT MIN(m2.rowid) FROM member m2 WHERE m2.idConversation = member.idConversation);
+
+	CREATE TABLE formerMember (
+	  uuidUser TEXT NOT NULL,
+	  idConversation INTEGER NOT NULL,
+	  timestampJoined TEXT NOT NULL,
+	  timestampRemoved TEXT NOT NULL,
+	  uuidRemovedBy TEXT,
+	  PRIMARY KEY (uuidUser, idConversation),
+	  FOREIGN KEY (uuidUser) REFERENCES user(uuid) ON DELETE CASCADE,
+	  FOREIGN KEY (idConversation) REFERENCES conversation(id) ON DELETE CASCADE,
+	  FOREIGN KEY (uuidRemovedBy) REFERENCES user(uuid) ON DELETE SET NULL
+	);
+
+	CREATE TABLE message_new (
+	  id INTEGER PRIMARY KEY AUTOINCREMENT,
+	  type TEXT NOT NULL CHECK(type IN ('text', 'photo', 'system')),
+	  content TEXT,
+	  mediaUrl TEXT,
+	  timestamp TEXT NOT NULL,
+	  idRepliesTo INTEGER,
+	  idForwardedFrom INTEGER,
+	  idConversation INTEGER NOT NULL,
+	  uuidSender TEXT,
+	  FOREIGN KEY (idRepliesTo) REFERENCES message(id) ON DELETE SET NULL,
+	  FOREIGN KEY (idForwardedFrom) REFERENCES message(id) ON DELETE SET NULL,
+	  FOREIGN KEY (idConversation) REFERENCES conversation(id) ON DELETE CASCADE,
+	  FOREIGN KEY (uuidSender) REFERENCES user(uuid) ON DELETE SET NULL
+	);
+	INSERT INTO message_new (id, type, content, mediaUrl, timestamp, idRepliesTo, idForwardedFrom, idConversation, uuidSender)
+	SELECT id, type, content, mediaUrl, timestamp, idRepliesTo, idForwardedFrom, idConversation, uuidSender FROM message;
+	DROP TABLE message;
+	ALTER TABLE message_new RENAME TO message;`,
+
+	// 3: payload strutturato dei messaggi di sistema
+	`ALTER TABLE message ADD COLUMN systemPayload TEXT;`,
+
+	// 4: visibilità della cronologia per i nuovi membri dei gruppi
+	`ALTER TABLE conversation ADD COLUMN historyVisibility TEXT NOT NULL DEFAULT 'full'
+	  CHECK(historyVisibility IN ('full', 'since_join'));`,
+
+	// 5: codici di invito dei gruppi
+	`CREATE TABLE groupInvite (
+	  idConversation INTEGER PRIMARY KEY,
+	  code TEXT NOT NULL UNIQUE,
+	  uuidCreatedBy TEXT,
+	  timestampCreated TEXT NOT NULL,
+	  FOREIGN KEY (idConversation) REFERENCES conversation(id) ON DELETE CASCADE,
+	  FOREIGN KEY (uuidCreatedBy) REFERENCES user(uuid) ON DELETE SET NULL
+	);`,
+
+	// 6: timestamp in UTC con i nanosecondi (vedi TimestampFormat). strftime converte in UTC i timestamp RFC3339 salvati
+	// con il fuso orario locale; quelli esistenti non hanno frazioni di secondo.
+	`UPDATE conversation SET
+	  timestampCreated = strftime('%Y-%m-%dT%H:%M:%S', timestampCreated) || '.000000000Z',
+	  timestampLastMessage = strftime('%Y-%m-%dT%H:%M:%S', timestampLastMessage) || '.000000000Z';
+	UPDATE member SET timestampJoined = strftime('%Y-%m-%dT%H:%M:%S', timestampJoined) || '.000000000Z';
+	UPDATE formerMember SET
+	  timestampJoined = strftime('%Y-%m-%dT%H:%M:%S', timestampJoined) || '.000000000Z',
+	  timestampRemoved = strftime('%Y-%m-%dT%H:%M:%S', timestampRemoved) || '.000000000Z';
+	UPDATE message SET timestamp = strftime('%Y-%m-%dT%H:%M:%S', timestamp) || '.000000000Z';
+	UPDATE joinRequest SET
+	  timestampCreated = strftime('%Y-%m-%dT%H:%M:%S', timestampCreated) || '.000000000Z',
+	  timestampDecided = strftime('%Y-%m-%dT%H:%M:%S', timestampDecided) || '.000000000Z';
+	UPDATE groupInvite SET timestampCreated = strftime('%Y-%m-%dT%H:%M:%S', timestampCreated) || '.000000000Z';`,
+}
+
+// migrate applica le migrazioni mancanti, ognuna nella propria transazione. Le foreign key vengono disattivate durante
+// la migrazione (su una connessione dedicata) così da poter ricostruire le tabelle senza attivare i DELETE a cascata.
+func migrate(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var version int
+	if err := conn.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("lettura versione schema: %w", err)
+	}
+	if version >= len(migrations) {
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	}()
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrazione %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrazione %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrazione %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// LatestSchemaVersion restituisce la versione dello schema SQLite prodotta dall'ultima migrazione nota a questo eseguibile
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// SchemaVersion legge la versione dello schema salvata dalle migrazioni, vedi AppDatabase.SchemaVersion
+func (db *appdbimpl) SchemaVersion(ctx context.Context) (int, int, error) {
+	query, latest := `PRAGMA user_version`, LatestSchemaVersion()
+	if db.dialect == dialectPostgres {
+		query, latest = `SELECT version FROM schemaVersion`, len(postgresMigrations)
+	}
+
+	var current int
+	if err := db.c.QueryRowContext(ctx, query).Scan(&current); err != nil {
+		return 0, latest, fmt.Errorf("lettura versione schema: %w", err)
+	}
+	return current, latest, nil
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// NewPostgres returns a new instance of AppDatabase based on the PostgreSQL connection `db` (opened with the
+// "postgres" driver of github.com/lib/pq). Lo schema viene creato o aggiornato all'ultima versione prima di restituire
+// l'istanza.
+func NewPostgres(db *sql.DB) (AppDatabase, error) {
+	if db == nil {
+		return nil, errors.New("database is required when building a AppDatabase")
+	}
+
+	if err := migratePostgres(db); err != nil {
+		return nil, fmt.Errorf("errore durante la migrazione del DB: %w", err)
+	}
+
+	return &appdbimpl{
+		c:       dialectPostgres.wrap(db),
+		conn:    db,
+		dialect: dialectPostgres,
+	}, nil
+}
+
+// postgresMigrations contiene, in ordine, lo schema PostgreSQL e le sue modifiche successive, come migrations per
+// SQLite. La versione dello schema è salvata nella tabella schemaVersion. Lo schema della prima versione equivale a
+// schema.sql con tutte le migrazioni SQLite applicate: le modifiche future vanno aggiunte a entrambe le liste.
+var postgresMigrations = []string{
+	// 1: schema iniziale
+	`CREATE TABLE "user" (
+	  uuid TEXT PRIMARY KEY,
+	  username TEXT NOT NULL UNIQUE,
+	  photoUrl TEXT
+	);
+
+	CREATE TABLE conversation (
+	  id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	  isDirect BOOLEAN NOT NULL,
+	  groupName TEXT,
+	  groupPhoto TEXT,
+	  timestampCreated TEXT NOT NULL,
+	  timestampLastMessage TEXT NOT NULL,
+	  requiresApproval BOOLEAN NOT NULL DEFAULT FALSE,
+	  historyVisibility TEXT NOT NULL DEFAULT 'full' CHECK(historyVisibility IN ('full', 'since_join'))
+	);
+
+	CREATE TABLE member (
+	  uuidUser TEXT NOT NULL REFERENCES "user"(uuid) ON DELETE CASCADE,
+	  idConversation BIGINT NOT NULL REFERENCES conversation(id) ON DELETE CASCADE,
+	  timestampJoined TEXT NOT NULL,
+	  isAdmin BOOLEAN NOT NULL DEFAULT FALSE,
+	  PRIMARY KEY (uuidUser, idConversation)
+	);
+
+	CREATE TABLE formerMember (
+	  uuidUser TEXT NOT NULL REFERENCES "user"(uuid) ON DELETE CASCADE,
+	  idConversation BIGINT NOT NULL REFERENCES conversation(id) ON DELETE CASCADE,
+	  timestampJoined TEXT NOT NULL,
+	  timestampRemoved TEXT NOT NULL,
+	  uuidRemovedBy TEXT REFERENCES "user"(uuid) ON DELETE SET NULL,
+	  PRIMARY KEY (uuidUser, idConversation)
+	);
+
+	CREATE TABLE message (
+	  id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	  type TEXT NOT NULL CHECK(type IN ('text', 'photo', 'system')),
+	  content TEXT,
+	  mediaUrl TEXT,
+	  timestamp TEXT NOT NULL,
+	  idRepliesTo BIGINT REFERENCES message(id) ON DELETE SET NULL,
+	  idForwardedFrom BIGINT REFERENCES message(id) ON DELETE SET NULL,
+	  idConversation BIGINT NOT NULL REFERENCES conversation(id) ON DELETE CASCADE,
+	  uuidSender TEXT REFERENCES "user"(uuid) ON DELETE SET NULL,
+	  systemPayload TEXT
+	);
+
+	CREATE TABLE reaction (
+	  uuidUser TEXT NOT NULL REFERENCES "user"(uuid) ON DELETE CASCADE,
+	  idMessage BIGINT NOT NULL REFERENCES message(id) ON DELETE CASCADE,
+	  emoji TEXT NOT NULL,
+	  PRIMARY KEY (uuidUser, idMessage)
+	);
+
+	CREATE TABLE messageStatus (
+	  uuidUser TEXT NOT NULL REFERENCES "user"(uuid) ON DELETE CASCADE,
+	  idMessage BIGINT NOT NULL REFERENCES message(id) ON DELETE CASCADE,
+	  delivered BOOLEAN NOT NULL,
+	  seen BOOLEAN NOT NULL,
+	  PRIMARY KEY (uuidUser, idMessage)
+	);
+
+	CREATE TABLE joinRequest (
+	  uuidUser TEXT NOT NULL REFERENCES "user"(uuid) ON DELETE CASCADE,
+	  idConversation BIGINT NOT NULL REFERENCES conversation(id) ON DELETE CASCADE,
+	  status TEXT NOT NULL CHECK(status IN ('pending', 'approved', 'denied')),
+	  timestampCreated TEXT NOT NULL,
+	  timestampDecided TEXT,
+	  uuidDecidedBy TEXT REFERENCES "user"(uuid) ON DELETE SET NULL,
+	  PRIMARY KEY (uuidUser, idConversation)
+	);`,
+
+	// 2: codici di invito dei gruppi
+	`CREATE TABLE groupInvite (
+	  idConversation BIGINT PRIMARY KEY REFERENCES conversation(id) ON DELETE CASCADE,
+	  code TEXT NOT NULL UNIQUE,
+	  uuidCreatedBy TEXT REFERENCES "user"(uuid) ON DELETE SET NULL,
+	  timestampCreated TEXT NOT NULL
+	);`,
+
+	// 3: timestamp in UTC con i nanosecondi, come la migrazione SQLite 6
+	`UPDATE conversation SET
+	  timestampCreated = to_char(timestampCreated::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z',
+	  timestampLastMessage = to_char(timestampLastMessage::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z';
+	UPDATE member SET
+	  timestampJoined = to_char(timestampJoined::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z';
+	UPDATE formerMember SET
+	  timestampJoined = to_char(timestampJoined::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z',
+	  timestampRemoved = to_char(timestampRemoved::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z';
+	UPDATE message SET
+	  timestamp = to_char(timestamp::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z';
+	UPDATE joinRequest SET
+	  timestampCreated = to_char(timestampCreated::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z',
+	  timestampDecided = to_char(timestampDecided::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z';
+	UPDATE groupInvite SET
+	  timestampCreated = to_char(timestampCreated::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS') || '.000000000Z';`,
+}
+
+// migratePostgres applica le migrazioni PostgreSQL mancanti, ognuna nella propria transazione. Un advisory lock evita
+// che più istanze del server migrino lo stesso database contemporaneamente.
+func migratePostgres(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext('wasatext-migrations'))`); err != nil {
+		return fmt.Errorf("lock migrazioni: %w", err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext('wasatext-migrations'))`)
+	}()
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schemaVersion (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("creazione tabella versione: %w", err)
+	}
+
+	var version int
+	err = conn.QueryRowContext(ctx, `SELECT version FROM schemaVersion`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := conn.ExecContext(ctx, `INSERT INTO schemaVersion (version) VALUES (0)`); err != nil {
+			return fmt.Errorf("inizializzazione versione schema: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("lettura versione schema: %w", err)
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(postgresMigrations[i]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrazione %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`UPDATE schemaVersion SET version = $1`, i+1); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrazione %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrazione %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
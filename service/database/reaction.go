@@ -1,5 +1,7 @@
 package database
 
+import "context"
+
 type Reaction struct {
 	UUIDUser  string
 	IDMessage int64
@@ -13,20 +15,20 @@ type ReactionWithUser struct {
 }
 
 // 5. AddReaction
-func (db *appdbimpl) AddReaction(messageID int64, uuid string, emoji string) error {
-	_, err := db.c.Exec(`INSERT INTO reaction (uuidUser, idMessage, emoji) VALUES (?, ?, ?)`, uuid, messageID, emoji)
-	return err
+func (db *appdbimpl) AddReaction(ctx context.Context, messageID int64, uuid string, emoji string) error {
+	_, err := db.c.ExecContext(ctx, `INSERT INTO reaction (uuidUser, idMessage, emoji) VALUES (?, ?, ?)`, uuid, messageID, emoji)
+	return classify(err)
 }
 
 // 6. RemoveReaction
-func (db *appdbimpl) RemoveReaction(messageID int64, uuid string) error {
-	_, err := db.c.Exec(`DELETE FROM reaction WHERE idMessage = ? AND uuidUser = ?`, messageID, uuid)
+func (db *appdbimpl) RemoveReaction(ctx context.Context, messageID int64, uuid string) error {
+	_, err := db.c.ExecContext(ctx, `DELETE FROM reaction WHERE idMessage = ? AND uuidUser = ?`, messageID, uuid)
 	return err
 }
 
 // 7. GetReactionsByMessageID
-func (db *appdbimpl) GetReactionsByMessageID(messageID int64) ([]Reaction, error) {
-	rows, err := db.c.Query(`SELECT uuidUser, idMessage, emoji FROM reaction WHERE idMessage = ?`, messageID)
+func (db *appdbimpl) GetReactionsByMessageID(ctx context.Context, messageID int64) ([]Reaction, error) {
+	rows, err := db.c.QueryContext(ctx, `SELECT uuidUser, idMessage, emoji FROM reaction WHERE idMessage = ?`, messageID)
 	if err != nil {
 		return nil, err
 	}
@@ -48,11 +50,11 @@ func (db *appdbimpl) GetReactionsByMessageID(messageID int64) ([]Reaction, error
 	return reactions, nil
 }
 
-func (db *appdbimpl) GetReactionsWithUserByMessageID(messageID int64) ([]ReactionWithUser, error) {
-	rows, err := db.c.Query(`
+func (db *appdbimpl) GetReactionsWithUserByMessageID(ctx context.Context, messageID int64) ([]ReactionWithUser, error) {
+	rows, err := db.c.QueryContext(ctx, `
                 SELECT r.uuidUser, u.username, r.emoji
                 FROM reaction r
-                JOIN user u ON r.uuidUser = u.uuid
+                JOIN "user" u ON r.uuidUser = u.uuid
                 WHERE r.idMessage = ?`, messageID)
 	if err != nil {
 		return nil, err
@@ -1,6 +1,8 @@
 -- Attivazione FK in SQLite
 PRAGMA foreign_keys = ON;
 
+-- Le modifiche successive a questo schema sono in migrations.go
+
 -- Tabella user
 CREATE TABLE user (
   uuid TEXT PRIMARY KEY,
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Snapshot copia il database SQLite in un nuovo file in path con l'API di backup online di SQLite. La copia avviene in
+// un solo passo, tenendo il lock di lettura fino alla fine: il file ottenuto è consistente anche mentre il server
+// riceve richieste, le cui scritture attendono la fine della copia. Con PostgreSQL restituisce un errore: in quel caso
+// i backup si fanno con pg_dump.
+func (db *appdbimpl) Snapshot(ctx context.Context, path string) error {
+	if db.dialect != dialectSQLite {
+		return errors.New("snapshots are supported only with SQLite, use pg_dump with PostgreSQL")
+	}
+
+	dest, err := sql.Open("sqlite3", "file:"+path+"?mode=rwc")
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			destSQLite, ok1 := d.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := s.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return errors.New("snapshot: not a go-sqlite3 connection")
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				_ = backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// VerifySnapshot controlla che il file SQLite in path sia integro e che il suo schema sia noto a questo eseguibile
+// (non più recente delle migrazioni incluse), e ne restituisce la versione. Il file viene aperto in sola lettura.
+func VerifySnapshot(ctx context.Context, path string) (int, error) {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return 0, fmt.Errorf("integrity check: %w", err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	var version int
+	if err := conn.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("lettura versione schema: %w", err)
+	}
+	if version > len(migrations) {
+		return version, fmt.Errorf("schema version %d is newer than this executable (%d)", version, len(migrations))
+	}
+	return version, nil
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tipi di evento registrati nei messaggi di sistema dei gruppi
+const (
+	EventMemberAdded       = "member_added"
+	EventMemberJoined      = "member_joined"
+	EventMemberLeft        = "member_left"
+	EventMemberRemoved     = "member_removed"
+	EventGroupRenamed      = "group_renamed"
+	EventGroupPhotoChanged = "group_photo_changed"
+)
+
+// DeletedUser è l'UUID con cui un utente eliminato compare nei messaggi di sistema rimasti nella cronologia dei gruppi,
+// come mittente e nel payload
+const DeletedUser = "00000000-0000-0000-0000-000000000000"
+
+// SystemEvent è il contenuto strutturato di un messaggio di sistema: chi ha fatto cosa, su quali utenti e, per le
+// modifiche al gruppo, il valore precedente e quello nuovo.
+type SystemEvent struct {
+	Kind     string   `json:"kind"`
+	Actor    string   `json:"actor"`
+	Targets  []string `json:"targets,omitempty"`
+	OldValue *string  `json:"oldValue,omitempty"`
+	NewValue *string  `json:"newValue,omitempty"`
+}
+
+// insertSystemMessage registra nella conversazione un messaggio di sistema per l'evento `ev` all'interno della
+// transazione `tx`, così che l'evento sia salvato insieme alla modifica che lo ha generato. Il campo content contiene
+// una descrizione testuale per i client che non interpretano il payload. I messaggi di sistema non hanno stati di
+// consegna/lettura.
+func insertSystemMessage(ctx context.Context, tx querier, idConversation int64, ev SystemEvent, timestamp string) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	content, err := describeSystemEvent(ctx, tx, ev)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO message (type, content, timestamp, idConversation, uuidSender, systemPayload)
+		VALUES ('system', ?, ?, ?, ?, ?)`,
+		content, timestamp, idConversation, ev.Actor, string(payload),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversation
+		SET timestampLastMessage = ?
+		WHERE id = ?
+	`, timestamp, idConversation)
+	return err
+}
+
+// describeSystemEvent costruisce la descrizione testuale di un evento usando gli username attuali.
+func describeSystemEvent(ctx context.Context, tx querier, ev SystemEvent) (string, error) {
+	return DescribeSystemEvent(ev, func(uuid string) (string, error) {
+		var name string
+		err := tx.QueryRowContext(ctx, `SELECT username FROM "user" WHERE uuid = ?`, uuid).Scan(&name)
+		return name, err
+	})
+}
+
+// DescribeSystemEvent costruisce la descrizione testuale di un evento; `username` restituisce lo username attuale di
+// un utente. È esportata perché tutte le implementazioni di AppDatabase descrivano gli eventi allo stesso modo.
+func DescribeSystemEvent(ev SystemEvent, username func(uuid string) (string, error)) (string, error) {
+	actor, err := username(ev.Actor)
+	if err != nil {
+		return "", err
+	}
+	var targets []string
+	for _, t := range ev.Targets {
+		name, err := username(t)
+		if err != nil {
+			return "", err
+		}
+		targets = append(targets, name)
+	}
+
+	value := func(v *string) string {
+		if v == nil {
+			return ""
+		}
+		return *v
+	}
+
+	switch ev.Kind {
+	case EventMemberAdded:
+		return fmt.Sprintf("%s ha aggiunto %s", actor, strings.Join(targets, ", ")), nil
+	case EventMemberJoined:
+		return fmt.Sprintf("%s ha approvato l'ingresso di %s", actor, strings.Join(targets, ", ")), nil
+	case EventMemberLeft:
+		return fmt.Sprintf("%s ha lasciato il gruppo", actor), nil
+	case EventMemberRemoved:
+		return fmt.Sprintf("%s ha rimosso %s", actor, strings.Join(targets, ", ")), nil
+	case EventGroupRenamed:
+		return fmt.Sprintf("%s ha cambiato il nome del gruppo da \"%s\" a \"%s\"", actor, value(ev.OldValue), value(ev.NewValue)), nil
+	case EventGroupPhotoChanged:
+		return fmt.Sprintf("%s ha cambiato la foto del gruppo", actor), nil
+	default:
+		return "", fmt.Errorf("evento di sistema sconosciuto: %s", ev.Kind)
+	}
+}
+
+// parseSystemPayload decodifica il payload di un messaggio di sistema letto dal DB (NULL per gli altri messaggi).
+func parseSystemPayload(payload sql.NullString) (*SystemEvent, error) {
+	if !payload.Valid {
+		return nil, nil
+	}
+	var ev SystemEvent
+	if err := json.Unmarshal([]byte(payload.String), &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// anonymizeSystemEvents sostituisce uuidUser con DeletedUser nei payload dei messaggi di sistema in cui compare. La
+// descrizione testuale resta quella registrata al momento dell'evento.
+func anonymizeSystemEvents(ctx context.Context, tx querier, uuidUser string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, systemPayload
+		FROM message
+		WHERE type = 'system' AND systemPayload LIKE ?
+	`, "%\""+uuidUser+"\"%")
+	if err != nil {
+		return err
+	}
+	payloads := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return err
+		}
+		payloads[id] = payload
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, payload := range payloads {
+		var ev SystemEvent
+		if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+			return err
+		}
+		if ev.Actor == uuidUser {
+			ev.Actor = DeletedUser
+		}
+		for i, t := range ev.Targets {
+			if t == uuidUser {
+				ev.Targets[i] = DeletedUser
+			}
+		}
+		anonymized, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE message SET systemPayload = ? WHERE id = ?`, string(anonymized), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// senderColumn legge la colonna uuidSender, che è NULL per i messaggi di sistema di un utente eliminato
+type senderColumn struct {
+	uuid *string
+}
+
+func (s senderColumn) Scan(src interface{}) error {
+	var v sql.NullString
+	if err := v.Scan(src); err != nil {
+		return err
+	}
+	*s.uuid = DeletedUser
+	if v.Valid {
+		*s.uuid = v.String
+	}
+	return nil
+}
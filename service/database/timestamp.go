@@ -0,0 +1,18 @@
+package database
+
+import "time"
+
+// TimestampFormat è il formato dei timestamp salvati nel database: RFC3339 in UTC, con i nanosecondi sempre presenti.
+// La larghezza fissa fa sì che l'ordine delle stringhe coincida con quello temporale, anche tra cambi di fuso orario
+// o di ora legale, così che i timestamp possano essere confrontati direttamente nelle query (vedi HistoryWindow).
+const TimestampFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Timestamp converte t nel formato TimestampFormat
+func Timestamp(t time.Time) string {
+	return t.UTC().Format(TimestampFormat)
+}
+
+// now restituisce il momento attuale nel formato TimestampFormat
+func now() string {
+	return Timestamp(time.Now())
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// maxTxAttempts è il numero massimo di tentativi di una transazione di WithTx quando il database la rifiuta per un
+	// conflitto con un'altra transazione (vedi isRetryable)
+	maxTxAttempts = 5
+
+	// txRetryDelay è l'attesa prima del secondo tentativo; raddoppia a ogni tentativo successivo
+	txRetryDelay = 20 * time.Millisecond
+)
+
+// querier raccoglie i metodi comuni a *sql.DB e *sql.Tx. Le query di appdbimpl passano da qui, così gli stessi metodi
+// funzionano sia sulla connessione sia dentro una transazione.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// dbTx è una transazione aperta da appdbimpl.begin
+type dbTx interface {
+	querier
+	Commit() error
+	Rollback() error
+}
+
+// openTx è una transazione aperta da begin fuori da WithTx
+type openTx struct {
+	querier
+	tx *sql.Tx
+}
+
+func (t openTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t openTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// nestedTx è la transazione di WithTx vista da un metodo che ne apre una propria: Commit e Rollback non fanno nulla,
+// perché l'esito è deciso da WithTx in base all'errore restituito da fn.
+type nestedTx struct {
+	querier
+}
+
+func (nestedTx) Commit() error {
+	return nil
+}
+
+func (nestedTx) Rollback() error {
+	return nil
+}
+
+// begin apre una transazione, oppure riusa quella in corso se db è il `tx` passato da WithTx
+func (db *appdbimpl) begin(ctx context.Context) (dbTx, error) {
+	if db.tx != nil {
+		return nestedTx{db.c}, nil
+	}
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return openTx{querier: db.dialect.wrap(tx), tx: tx}, nil
+}
+
+// WithTx esegue fn in una transazione: i metodi chiamati sul `tx` ricevuto fanno parte della stessa transazione, che
+// viene confermata se fn ritorna nil e annullata altrimenti. Dentro fn va usato solo `tx`: il database originale apre
+// un'altra connessione, che resterebbe bloccata dalla transazione. Le chiamate a WithTx dentro fn riusano la transazione
+// in corso.
+//
+// Se il database rifiuta la transazione per un conflitto con un'altra (SQLITE_BUSY con SQLite, errore di serializzazione
+// con PostgreSQL) la transazione viene ripetuta da capo, fino a maxTxAttempts volte: fn deve quindi poter essere
+// eseguita più volte, senza effetti al di fuori del database.
+func (db *appdbimpl) WithTx(ctx context.Context, fn func(tx AppDatabase) error) error {
+	return db.withTx(ctx, func(tx *appdbimpl) error {
+		return fn(tx)
+	})
+}
+
+// withTx è come WithTx, ma passa a fn l'implementazione concreta, per i metodi di questo package
+func (db *appdbimpl) withTx(ctx context.Context, fn func(tx *appdbimpl) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := db.runTx(ctx, fn)
+		if !isRetryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// runTx esegue un singolo tentativo di withTx
+func (db *appdbimpl) runTx(ctx context.Context, fn func(tx *appdbimpl) error) error {
+	tx, err := db.conn.BeginTx(ctx, db.dialect.txOptions())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+			log.Printf("rollback fallito: %v", rErr)
+		}
+	}()
+
+	if err := fn(&appdbimpl{c: db.dialect.wrap(tx), conn: db.conn, tx: tx, dialect: db.dialect}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isRetryable indica se err è dovuto a un conflitto con un'altra transazione, che si risolve ripetendo la transazione:
+// un lock di SQLite (SQLITE_BUSY o SQLITE_LOCKED) oppure un errore di serializzazione o un deadlock di PostgreSQL.
+func isRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+	}
+	return false
+}
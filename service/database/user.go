@@ -1,52 +1,66 @@
 package database
 
+import "context"
+
 type User struct {
 	UUID     string  `json:"uuid"`
 	Username string  `json:"username"`
 	PhotoUrl *string `json:"photoUrl"`
 }
 
-func (db *appdbimpl) CreateUser(uuid string, username string, photoUrl string) error {
-	_, err := db.c.Exec(`
-		INSERT INTO user (uuid, username, photoUrl)
+func (db *appdbimpl) CreateUser(ctx context.Context, uuid string, username string, photoUrl string) error {
+	_, err := db.c.ExecContext(ctx, `
+		INSERT INTO "user" (uuid, username, photoUrl)
 		VALUES (?, ?, ?)`,
 		uuid, username, photoUrl,
 	)
-	return err
+	return classify(err)
 }
 
-func (db *appdbimpl) GetUserByUUID(uuid string) (User, error) {
+func (db *appdbimpl) GetUserByUUID(ctx context.Context, uuid string) (User, error) {
 	var user User
-	err := db.c.QueryRow(`
+	err := db.c.QueryRowContext(ctx, `
 		SELECT uuid, username, photoUrl
-		FROM user
+		FROM "user"
 		WHERE uuid = ?`,
 		uuid,
 	).Scan(&user.UUID, &user.Username, &user.PhotoUrl)
 
-	return user, err
+	return user, classify(err)
+}
+
+func (db *appdbimpl) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	var user User
+	err := db.c.QueryRowContext(ctx, `
+		SELECT uuid, username, photoUrl
+		FROM "user"
+		WHERE username = ?`,
+		username,
+	).Scan(&user.UUID, &user.Username, &user.PhotoUrl)
+
+	return user, classify(err)
 }
 
-func (db *appdbimpl) SetUserName(uuid string, newUsername string) error {
-	_, err := db.c.Exec(`
-		UPDATE user
+func (db *appdbimpl) SetUserName(ctx context.Context, uuid string, newUsername string) error {
+	_, err := db.c.ExecContext(ctx, `
+		UPDATE "user"
 		SET username = ?
 		WHERE uuid = ?`,
 		newUsername, uuid,
 	)
-	return err
+	return classify(err)
 }
 
-func (db *appdbimpl) SetPhotoUrl(uuid string, newPhotoUrl string) error {
-	_, err := db.c.Exec("UPDATE user SET photoUrl = ? WHERE uuid = ?", newPhotoUrl, uuid)
+func (db *appdbimpl) SetPhotoUrl(ctx context.Context, uuid string, newPhotoUrl string) error {
+	_, err := db.c.ExecContext(ctx, `UPDATE "user" SET photoUrl = ? WHERE uuid = ?`, newPhotoUrl, uuid)
 	return err
 }
 
-func (db *appdbimpl) SearchUsersByPrefix(prefix string) ([]User, error) {
-	rows, err := db.c.Query(`
+func (db *appdbimpl) SearchUsersByPrefix(ctx context.Context, prefix string) ([]User, error) {
+	rows, err := db.c.QueryContext(ctx, `
 		SELECT uuid, username, photoUrl
-		FROM user
-		WHERE username LIKE ?`,
+		FROM "user"
+		WHERE LOWER(username) LIKE LOWER(?)`,
 		prefix+"%",
 	)
 	if err != nil {
@@ -69,8 +83,8 @@ func (db *appdbimpl) SearchUsersByPrefix(prefix string) ([]User, error) {
 	return users, nil
 }
 
-func (db *appdbimpl) GetAllUsers() ([]User, error) {
-	rows, err := db.c.Query("SELECT * FROM user")
+func (db *appdbimpl) GetAllUsers(ctx context.Context) ([]User, error) {
+	rows, err := db.c.QueryContext(ctx, `SELECT * FROM "user"`)
 	if err != nil {
 		return nil, err
 	}
@@ -92,29 +106,29 @@ func (db *appdbimpl) GetAllUsers() ([]User, error) {
 	return users, nil
 }
 
-func (db *appdbimpl) UserExists(uuid string) (bool, error) {
+func (db *appdbimpl) UserExists(ctx context.Context, uuid string) (bool, error) {
 	var exists bool
-	query := `SELECT EXISTS(SELECT 1 FROM user WHERE uuid = ?)`
-	err := db.c.QueryRow(query, uuid).Scan(&exists)
+	query := `SELECT EXISTS(SELECT 1 FROM "user" WHERE uuid = ?)`
+	err := db.c.QueryRowContext(ctx, query, uuid).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func (db *appdbimpl) GetPeerData(convID int64, uuidMe string) (User, error) {
+func (db *appdbimpl) GetPeerData(ctx context.Context, convID int64, uuidMe string) (User, error) {
 	var peerUUID string
-	err := db.c.QueryRow(`
+	err := db.c.QueryRowContext(ctx, `
 		SELECT uuidUser
 		FROM member
 		WHERE idConversation = ? AND uuidUser != ?
 		LIMIT 1;
 	`, convID, uuidMe).Scan(&peerUUID)
 	if err != nil {
-		return User{}, err
+		return User{}, classify(err)
 	}
 
-	peer, err := db.GetUserByUUID(peerUUID)
+	peer, err := db.GetUserByUUID(ctx, peerUUID)
 	if err != nil {
 		return User{}, err
 	}
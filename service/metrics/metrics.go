@@ -0,0 +1,340 @@
+/*
+Package metrics raccoglie metriche e le espone nel formato testuale di Prometheus (text exposition format 0.0.4), senza
+dipendere dalla libreria client di Prometheus.
+
+Le metriche vengono create da un Registry, che le scrive tutte con WriteTo o le serve via HTTP come http.Handler:
+
+	reg := metrics.NewRegistry()
+	requests := reg.NewCounter("app_requests_total", "Requests served.", "route", "status")
+	requests.Inc("/v1/session", "201")
+
+I tipi supportati sono contatori, istogrammi e gauge calcolati al momento della lettura. Ogni metrica ha un insieme fisso
+di etichette, i cui valori vanno passati nello stesso ordine a ogni chiamata.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets sono i limiti superiori, in secondi, dei bucket degli istogrammi di durata
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry contiene le metriche da esporre
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric è una metrica del registry, capace di scrivere le proprie serie
+type metric interface {
+	header() (name string, help string, kind string)
+	writeSeries(w *bufio.Writer)
+}
+
+// NewRegistry crea un registry vuoto
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register aggiunge m al registry. Registrare due metriche con lo stesso nome è un errore di programmazione.
+func (r *Registry) register(m metric) {
+	name, _, _ := m.header()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo scrive tutte le metriche su w, nell'ordine di registrazione
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		name, help, kind := m.header()
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(help))
+		_, _ = fmt.Fprintf(bw, "# TYPE %s %s\n", name, kind)
+		m.writeSeries(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP risponde con tutte le metriche, nel formato letto da Prometheus
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// Counter è un contatore, che può solo crescere
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// NewCounter crea e registra un contatore con le etichette labels
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+	r.register(c)
+	return c
+}
+
+// Inc incrementa di uno la serie con i valori di etichetta values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add aggiunge v, che non può essere negativo, alla serie con i valori di etichetta values
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %q decreased", c.name))
+	}
+	key := seriesKey(c.name, c.labels, values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: values}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Value restituisce il valore della serie con i valori di etichetta values
+func (c *Counter) Value(values ...string) float64 {
+	key := seriesKey(c.name, c.labels, values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) header() (string, string, string) {
+	return c.name, c.help, "counter"
+}
+
+func (c *Counter) writeSeries(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		writeSample(w, c.name, c.labels, s.values, "", "", s.value)
+	}
+}
+
+// Histogram conta le osservazioni per bucket, come i tempi di risposta
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+
+	// counts[i] è il numero di osservazioni comprese tra buckets[i-1] (escluso) e buckets[i]; l'ultimo elemento conta
+	// quelle oltre l'ultimo bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram crea e registra un istogramma con i limiti superiori buckets, in ordine crescente, e le etichette labels
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %q are not sorted", name))
+	}
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.register(h)
+	return h
+}
+
+// Observe aggiunge l'osservazione v alla serie con i valori di etichetta values
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := seriesKey(h.name, h.labels, values)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// Count restituisce il numero di osservazioni della serie con i valori di etichetta values
+func (h *Histogram) Count(values ...string) uint64 {
+	key := seriesKey(h.name, h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) header() (string, string, string) {
+	return h.name, h.help, "histogram"
+}
+
+func (h *Histogram) writeSeries(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.values, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.values, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.values, "", "", s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.values, "", "", float64(s.count))
+	}
+}
+
+// gaugeFunc è un gauge senza etichette, il cui valore è calcolato da fn a ogni lettura
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc registra un gauge il cui valore è restituito da fn al momento della lettura. fn può essere chiamata da
+// più goroutine insieme.
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) header() (string, string, string) {
+	return g.name, g.help, "gauge"
+}
+
+func (g *gaugeFunc) writeSeries(w *bufio.Writer) {
+	writeSample(w, g.name, nil, nil, "", "", g.fn())
+}
+
+// seriesKey identifica la serie con i valori di etichetta values. Il numero di valori deve corrispondere a quello delle
+// etichette della metrica.
+func seriesKey(name string, labels []string, values []string) string {
+	if len(values) != len(labels) {
+		panic(fmt.Sprintf("metrics: %q has %d labels, got %d values", name, len(labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// writeSample scrive una riga del formato di esposizione. extraLabel, se non vuota, è un'etichetta aggiunta in fondo
+// (la "le" dei bucket).
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, extraLabel string, extraValue string, v float64) {
+	_, _ = w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		_ = w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		_ = w.WriteByte('}')
+	}
+	_ = w.WriteByte(' ')
+	_, _ = w.WriteString(formatFloat(v))
+	_ = w.WriteByte('\n')
+}
+
+// formatFloat scrive v come previsto dal formato di esposizione
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// sortedKeys restituisce le chiavi di m in ordine, così che l'output sia sempre lo stesso
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*counterSeries:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*histogramSeries:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter conta i byte scritti, per il valore restituito da WriteTo
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
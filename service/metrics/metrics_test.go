@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("test_requests_total", "Requests served.", "route", "status")
+	duration := reg.NewHistogram("test_duration_seconds", "Request duration.", []float64{0.1, 1}, "route")
+	reg.NewGaugeFunc("test_active", "Active things.", func() float64 { return 3 })
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "404")
+	requests.Inc(`/c"\`, "500")
+	duration.Observe(0.05, "/a")
+	duration.Observe(0.1, "/a")
+	duration.Observe(2, "/a")
+
+	var out strings.Builder
+	if _, err := reg.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{route="/a",status="404"} 2
+test_requests_total{route="/b",status="200"} 1
+test_requests_total{route="/c\"\\",status="500"} 1
+# HELP test_duration_seconds Request duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/a",le="0.1"} 2
+test_duration_seconds_bucket{route="/a",le="1"} 2
+test_duration_seconds_bucket{route="/a",le="+Inf"} 3
+test_duration_seconds_sum{route="/a"} 2.15
+test_duration_seconds_count{route="/a"} 3
+# HELP test_active Active things.
+# TYPE test_active gauge
+test_active 3
+`
+	if out.String() != want {
+		t.Fatalf("output inatteso:\n%s\natteso:\n%s", out.String(), want)
+	}
+}
+
+func TestDuplicateMetric(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("test_total", "Test.")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("nessun panic registrando due volte la stessa metrica")
+		}
+	}()
+	reg.NewCounter("test_total", "Test.")
+}
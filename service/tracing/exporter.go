@@ -0,0 +1,209 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WriterExporter scrive ogni span su w come una riga JSON. È pensato per il debug, con w uguale a os.Stdout.
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter crea un WriterExporter che scrive su w
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// writerSpan è la forma JSON di uno span scritto da WriterExporter
+type writerSpan struct {
+	Service    string                 `json:"service"`
+	TraceID    string                 `json:"traceId"`
+	SpanID     string                 `json:"spanId"`
+	ParentID   string                 `json:"parentSpanId,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	DurationMs float64                `json:"durationMs"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Export scrive gli span, uno per riga
+func (e *WriterExporter) Export(_ context.Context, service string, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		out := writerSpan{
+			Service:    service,
+			TraceID:    s.TraceID.String(),
+			SpanID:     s.SpanID.String(),
+			Name:       s.Name,
+			Start:      s.Start,
+			DurationMs: float64(s.End.Sub(s.Start)) / float64(time.Millisecond),
+			Attributes: s.Attributes,
+			Error:      s.Error,
+		}
+		if s.Parent != (SpanID{}) {
+			out.ParentID = s.Parent.String()
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter invia gli span a un collector OpenTelemetry con OTLP su HTTP, codificati in JSON
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewOTLPExporter crea un OTLPExporter che invia gli span a endpoint, l'URL completo del collector (di solito
+// http://localhost:4318/v1/traces). Ogni invio può durare al massimo timeout.
+func NewOTLPExporter(endpoint string, timeout time.Duration) *OTLPExporter {
+	return &OTLPExporter{endpoint: endpoint, client: &http.Client{Timeout: timeout}}
+}
+
+// Export invia gli span in una singola richiesta
+func (e *OTLPExporter) Export(ctx context.Context, service string, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(service, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector replied %s", resp.Status)
+	}
+	return nil
+}
+
+// Tipi della codifica JSON di ExportTraceServiceRequest (OTLP). Gli ID sono in esadecimale e i tempi, interi a 64 bit,
+// sono stringhe, come previsto dalla mappatura JSON di OTLP.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		// Code è 0 (non impostato) o 2 (errore)
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// otlpRequest costruisce il corpo della richiesta OTLP per gli span del servizio service
+func otlpRequest(service string, spans []SpanData) otlpTraces {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.Parent != (SpanID{}) {
+			span.ParentSpanID = s.Parent.String()
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		out = append(out, span)
+	}
+
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(map[string]interface{}{"service.name": service})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/albyma98/WASAText/service/tracing"}, Spans: out}},
+	}}}
+}
+
+// otlpAttributes converte gli attributi di uno span; i valori di tipo non supportato sono scritti come stringhe
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, key := range keys {
+		var v otlpValue
+		switch value := attrs[key].(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int:
+			s := strconv.Itoa(value)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		out = append(out, otlpKeyValue{Key: key, Value: v})
+	}
+	return out
+}
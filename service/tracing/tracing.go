@@ -0,0 +1,365 @@
+/*
+Package tracing registra gli span delle richieste e delle operazioni sul database e li esporta in un formato compatibile
+con OpenTelemetry, senza dipendere dal suo SDK.
+
+Un Tracer crea gli span con Start; lo span figlio di quello presente nel context, oppure del contesto remoto letto da
+Extract (l'header W3C `traceparent`). Alla chiusura con End gli span vengono raccolti e inviati periodicamente
+all'Exporter: WriterExporter li scrive come JSON (ad esempio su stdout), OTLPExporter li invia a un collector
+OpenTelemetry con OTLP su HTTP.
+
+Un *Tracer nil è valido e non registra nulla, così come gli span che restituisce: il codice instrumentato non deve
+controllare se il tracing è attivo.
+*/
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Parametri della raccolta degli span
+const (
+	// flushInterval è ogni quanto gli span chiusi vengono inviati all'Exporter
+	flushInterval = 5 * time.Second
+
+	// maxQueue è il numero massimo di span in attesa di essere esportati: oltre questo numero i nuovi span vengono
+	// scartati, così che un exporter lento o irraggiungibile non faccia crescere la memoria senza limite
+	maxQueue = 4096
+)
+
+// TraceID identifica una traccia
+type TraceID [16]byte
+
+// SpanID identifica uno span all'interno di una traccia
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanKind è il ruolo dello span, con i valori di OpenTelemetry
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// SpanContext identifica uno span, locale o ricevuto da un altro servizio
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+
+	// Sampled indica che la traccia viene registrata; se falso gli span vengono comunque propagati, ma non esportati
+	Sampled bool
+}
+
+// IsValid indica se sc identifica uno span (gli ID tutti a zero non sono validi)
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// SpanData è uno span concluso, come viene passato all'Exporter
+type SpanData struct {
+	SpanContext
+	Parent     SpanID
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+
+	// Error è il messaggio dell'errore che ha fatto fallire l'operazione, vuoto se è riuscita
+	Error string
+}
+
+// Exporter invia gli span conclusi a una destinazione
+type Exporter interface {
+	// Export invia un gruppo di span
+	Export(ctx context.Context, service string, spans []SpanData) error
+}
+
+// Tracer crea gli span e li esporta
+type Tracer struct {
+	service  string
+	exporter Exporter
+
+	// OnError riceve gli errori dell'esportazione; se nil vengono ignorati. Va impostato prima di usare il Tracer.
+	OnError func(err error)
+
+	mu      sync.Mutex
+	queue   []SpanData
+	dropped int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New crea un Tracer per il servizio service, che esporta gli span con exporter in background fino a Shutdown
+func New(service string, exporter Exporter) *Tracer {
+	t := &Tracer{
+		service:  service,
+		exporter: exporter,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// run esporta periodicamente gli span conclusi, finché Shutdown non chiude t.stop
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush(context.Background())
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// flush invia all'exporter gli span in coda
+func (t *Tracer) flush(ctx context.Context) {
+	t.mu.Lock()
+	spans, dropped := t.queue, t.dropped
+	t.queue, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		t.reportError(fmt.Errorf("tracing queue full, %d spans dropped", dropped))
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.exporter.Export(ctx, t.service, spans); err != nil {
+		t.reportError(fmt.Errorf("exporting %d spans: %w", len(spans), err))
+	}
+}
+
+func (t *Tracer) reportError(err error) {
+	if t.OnError != nil {
+		t.OnError(err)
+	}
+}
+
+// Shutdown ferma l'esportazione periodica e invia gli span rimasti, entro la scadenza di ctx
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	close(t.stop)
+	<-t.done
+	t.flush(ctx)
+	return ctx.Err()
+}
+
+// spanKey è la chiave del context sotto cui è salvato lo span corrente
+type spanKey struct{}
+
+// remoteKey è la chiave del context sotto cui Extract salva lo span remoto
+type remoteKey struct{}
+
+// Start crea uno span figlio dello span di ctx (o dello span remoto letto da Extract) e restituisce un context che lo
+// contiene. Lo span va chiuso con End.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID, sc.Sampled = parent.TraceID, parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+	}
+	sc.SpanID = newSpanID()
+
+	span := &Span{tracer: t, data: SpanData{
+		SpanContext: sc,
+		Parent:      parent.SpanID,
+		Name:        name,
+		Kind:        kind,
+		Start:       time.Now(),
+	}}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanContextFromContext restituisce lo span corrente di ctx: quello creato da Start o, se non c'è, quello remoto
+// letto da Extract
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return span.data.SpanContext
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		return sc
+	}
+	return SpanContext{}
+}
+
+// Span è un'operazione in corso
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext restituisce l'identificativo dello span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute aggiunge allo span un attributo, di tipo string, bool, int, int64 o float64
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]interface{})
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError segna lo span come fallito per err; con err nil non fa nulla
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End conclude lo span e lo mette in coda per l'esportazione. Le chiamate successive non fanno nulla.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if !data.Sampled {
+		return
+	}
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= maxQueue {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, data)
+}
+
+// TraceParentHeader è l'header W3C Trace Context che identifica lo span chiamante
+const TraceParentHeader = "traceparent"
+
+// TraceResponseHeader è l'header W3C Trace Context (livello 2) con cui il server comunica al client lo span che ha
+// servito la richiesta
+const TraceResponseHeader = "traceresponse"
+
+// Extract legge lo span remoto dall'header traceparent di h e lo salva in ctx, come genitore degli span creati da
+// Start. Un header assente o non valido viene ignorato.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceParent(h.Get(TraceParentHeader))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject scrive in h, sotto header, lo span corrente di ctx nel formato traceparent. Si usa con TraceParentHeader
+// nelle richieste verso altri servizi e con TraceResponseHeader nelle risposte.
+func Inject(ctx context.Context, h http.Header, header string) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		h.Set(header, FormatTraceParent(sc))
+	}
+}
+
+// FormatTraceParent scrive sc nel formato traceparent: versione-traceid-spanid-flag
+func FormatTraceParent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceParent legge un header traceparent. Sono accettate anche le versioni successive alla 00, leggendone solo
+// i campi della 00 come previsto dalla specifica.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent version in %q", s)
+	}
+	for _, p := range parts[:4] {
+		if strings.ToLower(p) != p {
+			return sc, fmt.Errorf("invalid traceparent %q: uppercase hex", s)
+		}
+	}
+
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("invalid trace id in %q", s)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("invalid span id in %q", s)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("invalid trace flags in %q", s)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q: zero id", s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
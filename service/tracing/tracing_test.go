@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraceParent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Fatalf("traceparent letto male: %+v", sc)
+	}
+	if got := FormatTraceParent(sc); got != valid {
+		t.Fatalf("FormatTraceParent = %q, atteso %q", got, valid)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceParent(invalid); err == nil {
+			t.Errorf("traceparent %q accettato", invalid)
+		}
+	}
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-futuro"); err != nil {
+		t.Errorf("versione successiva non accettata: %v", err)
+	}
+}
+
+// TestOTLPExport invia gli span a un collector finto e ne controlla la codifica
+func TestOTLPExport(t *testing.T) {
+	received := make(chan otlpTraces, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body otlpTraces
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("richiesta inattesa: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		received <- body
+	}))
+	defer collector.Close()
+
+	tracer := New("test", NewOTLPExporter(collector.URL+"/v1/traces", time.Second))
+	tracer.OnError = func(err error) { t.Error(err) }
+
+	remote, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := Extract(context.Background(), http.Header{"Traceparent": {FormatTraceParent(remote)}})
+	ctx, server := tracer.Start(ctx, "GET /v1/conversations/:id", KindServer)
+	server.SetAttribute("http.status_code", 200)
+	_, db := tracer.Start(ctx, "db.GetConversationByID", KindInternal)
+	db.SetError(errors.New("boom"))
+	db.End()
+	server.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	body := <-received
+
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("%d span esportati invece di 2", len(spans))
+	}
+	dbSpan, serverSpan := spans[0], spans[1]
+	if serverSpan.TraceID != remote.TraceID.String() || serverSpan.ParentSpanID != remote.SpanID.String() {
+		t.Errorf("lo span del server non continua la traccia remota: %+v", serverSpan)
+	}
+	if dbSpan.TraceID != serverSpan.TraceID || dbSpan.ParentSpanID != serverSpan.SpanID {
+		t.Errorf("lo span del database non è figlio di quello del server: %+v", dbSpan)
+	}
+	if dbSpan.Status.Code != 2 || dbSpan.Status.Message != "boom" {
+		t.Errorf("stato dello span fallito: %+v", dbSpan.Status)
+	}
+	if attr := serverSpan.Attributes; len(attr) != 1 || attr[0].Key != "http.status_code" || *attr[0].Value.IntValue != "200" {
+		t.Errorf("attributi inattesi: %+v", attr)
+	}
+	if name := body.ResourceSpans[0].Resource.Attributes[0]; name.Key != "service.name" || *name.Value.StringValue != "test" {
+		t.Errorf("risorsa inattesa: %+v", name)
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := New("test", exporter)
+
+	ctx := Extract(context.Background(), http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}})
+	_, span := tracer.Start(ctx, "non registrato", KindServer)
+	span.End()
+	_ = tracer.Shutdown(context.Background())
+
+	if len(exporter.spans) != 0 {
+		t.Fatalf("esportati %d span di una traccia non campionata", len(exporter.spans))
+	}
+
+	// Un Tracer nil non registra nulla
+	var nilTracer *Tracer
+	_, nilSpan := nilTracer.Start(context.Background(), "nulla", KindInternal)
+	nilSpan.SetAttribute("a", 1)
+	nilSpan.End()
+}
+
+type recordingExporter struct {
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(_ context.Context, _ string, spans []SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
@@ -1,7 +1,7 @@
 import axios from "axios";
 
 const instance = axios.create({
-	baseURL: __API_URL__,
+	baseURL: __API_URL__ + "/v1",
 	timeout: 1000 * 5
 });
 